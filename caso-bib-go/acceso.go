@@ -0,0 +1,432 @@
+package main
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ==========================================
+// ACCESO: CREDENCIALES Y PERMISOS
+// ==========================================
+// Cada persona del personal entra con una Credencial: un nombre, una clave y
+// un Rol que dice qué puede hacer. Los usuarios pueden tener también una
+// credencial de rol usuario para operar por su cuenta (autopréstamo,
+// reservas), siempre sobre sí mismos.
+//
+// Las operaciones reciben el Actor que las pide y lo autorizan con
+// autorizar; si el rol no alcanza retornan un *ErrorPermiso. Quién es el
+// actor lo comprueba antes la línea de comandos o la API con Autenticar.
+//
+// Mientras no haya ninguna credencial la biblioteca está abierta: cualquier
+// actor puede hacer cualquier operación, como antes de existir los permisos.
+// La primera credencial debe ser de administrador y desde ese momento todas
+// las operaciones se autorizan.
+
+// Rol agrupa los permisos de una credencial
+type Rol string
+
+const (
+	RolAdmin         Rol = "admin"
+	RolBibliotecario Rol = "bibliotecario"
+	RolVoluntario    Rol = "voluntario"
+	// RolUsuario es el de los usuarios que operan por su cuenta
+	RolUsuario Rol = "usuario"
+)
+
+// Permiso es un grupo de operaciones que se autoriza junto
+type Permiso string
+
+const (
+	// PermisoCatalogo: libros y ejemplares
+	PermisoCatalogo Permiso = "catalogo"
+	// PermisoCirculacion: préstamos, devoluciones, renovaciones y reservas
+	PermisoCirculacion Permiso = "circulacion"
+	// PermisoUsuarios: alta, datos, categoría, activación y cierre de usuarios
+	PermisoUsuarios Permiso = "usuarios"
+	// PermisoCobrar: cobrar multas
+	PermisoCobrar Permiso = "cobrar"
+	// PermisoCondonar: perdonar multas sin cobrarlas
+	PermisoCondonar Permiso = "condonar"
+	// PermisoDatos: importaciones, exportaciones, informes y auditoría
+	PermisoDatos Permiso = "datos"
+	// PermisoAdministrar: credenciales, sucursales y anonimización
+	PermisoAdministrar Permiso = "administrar"
+)
+
+// permisosRol dice qué permisos da cada rol. Los de RolUsuario valen solo
+// sobre el propio usuario
+var permisosRol = map[Rol][]Permiso{
+	RolAdmin: {PermisoCatalogo, PermisoCirculacion, PermisoUsuarios, PermisoCobrar, PermisoCondonar,
+		PermisoDatos, PermisoAdministrar},
+	RolBibliotecario: {PermisoCatalogo, PermisoCirculacion, PermisoUsuarios, PermisoCobrar, PermisoCondonar,
+		PermisoDatos},
+	RolVoluntario: {PermisoCirculacion, PermisoCobrar},
+	RolUsuario:    {PermisoCirculacion, PermisoUsuarios},
+}
+
+// Validar revisa que el rol exista
+func (r Rol) Validar() error {
+	if _, ok := permisosRol[r]; !ok {
+		return nuevoError(ErrDatosInvalidos, "Rol '%s' no válido: use admin, bibliotecario, voluntario o usuario", r)
+	}
+	return nil
+}
+
+// Permite indica si el rol da el permiso
+func (r Rol) Permite(permiso Permiso) bool {
+	return slices.Contains(permisosRol[r], permiso)
+}
+
+// Credencial es la cuenta de acceso de alguien del personal o de un usuario.
+// El nombre es con el que se identifica el personal como actor; la de un
+// usuario se reconoce por UsuarioID
+type Credencial struct {
+	Nombre    string `json:"nombre"`
+	Rol       Rol    `json:"rol"`
+	UsuarioID int    `json:"usuario_id,omitempty"` // solo en RolUsuario
+	Clave     string `json:"clave,omitempty"`      // hash, ver hashClave
+	Activa    bool   `json:"activa"`
+}
+
+// ErrorPermiso es un error de categoría ErrSinPermiso que además dice quién
+// pidió la operación y qué permiso le faltó
+type ErrorPermiso struct {
+	Actor   Actor
+	Permiso Permiso
+	Mensaje string
+}
+
+func (e *ErrorPermiso) Error() string {
+	return e.Mensaje
+}
+
+func (e *ErrorPermiso) Unwrap() error {
+	return ErrSinPermiso
+}
+
+func nuevoErrorPermiso(actor Actor, permiso Permiso, formato string, args ...any) error {
+	return &ErrorPermiso{Actor: actor, Permiso: permiso, Mensaje: fmt.Sprintf(formato, args...)}
+}
+
+// ==========================================
+// CLAVES
+// ==========================================
+// Las claves se guardan como PBKDF2-HMAC-SHA256 con sal aleatoria, en el
+// formato "pbkdf2-sha256$ITERACIONES$SAL$HASH" con sal y hash en base64.
+// Las iteraciones van en el texto para poder subirlas sin invalidar las
+// claves ya guardadas.
+
+const (
+	prefijoClave     = "pbkdf2-sha256"
+	largoSal         = 16
+	largoHash        = 32
+	largoMinimoClave = 8
+)
+
+// iteracionesClave son las de las claves nuevas. Es variable para que las
+// pruebas puedan bajarlas
+var iteracionesClave = 600_000
+
+// hashClave calcula el texto a guardar para clave
+func hashClave(clave string) (string, error) {
+	if len([]rune(clave)) < largoMinimoClave {
+		return "", nuevoError(ErrDatosInvalidos, "La clave debe tener al menos %d caracteres", largoMinimoClave)
+	}
+	sal := make([]byte, largoSal)
+	if _, err := rand.Read(sal); err != nil {
+		return "", err
+	}
+	hash, err := pbkdf2.Key(sha256.New, clave, sal, iteracionesClave, largoHash)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join([]string{prefijoClave, strconv.Itoa(iteracionesClave),
+		base64.RawStdEncoding.EncodeToString(sal), base64.RawStdEncoding.EncodeToString(hash)}, "$"), nil
+}
+
+// claveCorrecta compara clave con un hash de hashClave en tiempo constante.
+// Un hash mal formado nunca coincide
+func claveCorrecta(guardada, clave string) bool {
+	partes := strings.Split(guardada, "$")
+	if len(partes) != 4 || partes[0] != prefijoClave {
+		return false
+	}
+	iteraciones, err := strconv.Atoi(partes[1])
+	if err != nil || iteraciones <= 0 {
+		return false
+	}
+	sal, err := base64.RawStdEncoding.DecodeString(partes[2])
+	if err != nil {
+		return false
+	}
+	esperado, err := base64.RawStdEncoding.DecodeString(partes[3])
+	if err != nil || len(esperado) == 0 {
+		return false
+	}
+	hash, err := pbkdf2.Key(sha256.New, clave, sal, iteraciones, len(esperado))
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(hash, esperado) == 1
+}
+
+// claveFalsa se compara cuando el actor no tiene credencial, para que
+// Autenticar tarde lo mismo exista o no. Se calcula la primera vez que hace
+// falta, porque cuesta lo mismo que una clave de verdad
+var claveFalsa = sync.OnceValue(func() string {
+	hash, err := hashClave("clave-que-nunca-coincide")
+	if err != nil {
+		panic(err)
+	}
+	return hash
+})
+
+// ==========================================
+// AUTENTICACIÓN Y AUTORIZACIÓN
+// ==========================================
+
+// Protegida indica si la biblioteca tiene credenciales y por lo tanto
+// autoriza las operaciones
+func (b *Biblioteca) Protegida() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.credenciales) > 0
+}
+
+// credencialDe busca la credencial del actor: la del personal por nombre y
+// la de un usuario por su ID
+func (b *Biblioteca) credencialDe(actor Actor) (Credencial, bool) {
+	switch actor.Tipo {
+	case ActorPersonal:
+		credencial, ok := b.credenciales[actor.Nombre]
+		return credencial, ok && credencial.Rol != RolUsuario
+	case ActorUsuario:
+		for _, credencial := range b.credenciales {
+			if credencial.Rol == RolUsuario && credencial.UsuarioID == actor.ID {
+				return credencial, true
+			}
+		}
+	}
+	return Credencial{}, false
+}
+
+// ActorDeCredencial retorna el actor que opera con la credencial nombre: el
+// usuario si es de RolUsuario y si no alguien del personal con ese nombre,
+// exista o no la credencial
+func (b *Biblioteca) ActorDeCredencial(nombre string) Actor {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if credencial, ok := b.credenciales[nombre]; ok && credencial.Rol == RolUsuario {
+		return Actor{Tipo: ActorUsuario, ID: credencial.UsuarioID}
+	}
+	return Actor{Tipo: ActorPersonal, Nombre: nombre}
+}
+
+// Autenticar comprueba la clave del actor. El error no dice si falló el
+// actor o la clave
+func (b *Biblioteca) Autenticar(actor Actor, clave string) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	credencial, ok := b.credencialDe(actor)
+	guardada := credencial.Clave
+	if !ok {
+		guardada = claveFalsa()
+	}
+	if !claveCorrecta(guardada, clave) || !ok || !credencial.Activa {
+		return nuevoError(ErrNoAutenticado, "Actor o clave incorrectos")
+	}
+	return nil
+}
+
+// Autorizar indica con un *ErrorPermiso si el actor no puede hacer las
+// operaciones de permiso. usuarioID es el usuario sobre el que se opera, o
+// 0 si la operación no es sobre un usuario: un actor usuario solo tiene
+// permisos sobre sí mismo
+func (b *Biblioteca) Autorizar(actor Actor, permiso Permiso, usuarioID int) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.autorizar(actor, permiso, usuarioID)
+}
+
+// autorizar es Autorizar para quien ya tiene el bloqueo
+func (b *Biblioteca) autorizar(actor Actor, permiso Permiso, usuarioID int) error {
+	if len(b.credenciales) == 0 || actor.Tipo == ActorSistema {
+		return nil
+	}
+	credencial, ok := b.credencialDe(actor)
+	switch {
+	case !ok:
+		return nuevoErrorPermiso(actor, permiso, "%s no tiene credencial en la biblioteca", actor)
+	case !credencial.Activa:
+		return nuevoErrorPermiso(actor, permiso, "La credencial de %s está desactivada", actor)
+	case !credencial.Rol.Permite(permiso):
+		return nuevoErrorPermiso(actor, permiso, "El rol %s de %s no tiene el permiso %s", credencial.Rol, actor, permiso)
+	case credencial.Rol == RolUsuario && usuarioID != actor.ID:
+		return nuevoErrorPermiso(actor, permiso, "%s solo puede operar sobre su propia cuenta", actor)
+	}
+	return nil
+}
+
+// ==========================================
+// GESTIÓN DE CREDENCIALES
+// ==========================================
+
+// CrearCredencial da de alta una credencial activa. Las de RolUsuario
+// necesitan el usuarioID, que no puede tener otra; las del personal no
+// llevan usuario. La primera credencial de la biblioteca tiene que ser de
+// administrador
+func (b *Biblioteca) CrearCredencial(actor Actor, nombre string, rol Rol, clave string, usuarioID int) (*Credencial, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.autorizar(actor, PermisoAdministrar, 0); err != nil {
+		return nil, err
+	}
+	tx := b.iniciar(actor, EventoCredencialCreada)
+	nombre = strings.TrimSpace(nombre)
+	if nombre == "" {
+		return nil, nuevoError(ErrDatosInvalidos, "Debe indicar el nombre de la credencial")
+	}
+	if strings.Contains(nombre, ":") {
+		// Con dos puntos ParsearActor lo leería como tipo:valor
+		return nil, nuevoError(ErrDatosInvalidos, "El nombre '%s' no puede tener ':'", nombre)
+	}
+	if err := rol.Validar(); err != nil {
+		return nil, err
+	}
+	if len(b.credenciales) == 0 && rol != RolAdmin {
+		return nil, nuevoError(ErrNoPermitido, "La primera credencial debe ser de %s", RolAdmin)
+	}
+	if _, existe := tx.credencial(nombre); existe {
+		return nil, nuevoError(ErrConflicto, "Ya existe una credencial '%s'", nombre)
+	}
+	switch {
+	case rol == RolUsuario:
+		if _, ok := tx.usuario(usuarioID); !ok {
+			return nil, nuevoError(ErrNoEncontrado, "No existe un usuario con ID '%d'", usuarioID)
+		}
+		if otra, ok := b.credencialDe(Actor{Tipo: ActorUsuario, ID: usuarioID}); ok {
+			return nil, nuevoError(ErrConflicto, "El usuario %d ya tiene la credencial '%s'", usuarioID, otra.Nombre)
+		}
+	case usuarioID != 0:
+		return nil, nuevoError(ErrDatosInvalidos, "Solo las credenciales de rol %s llevan usuario", RolUsuario)
+	}
+	hash, err := hashClave(clave)
+	if err != nil {
+		return nil, err
+	}
+
+	credencial := Credencial{Nombre: nombre, Rol: rol, UsuarioID: usuarioID, Clave: hash, Activa: true}
+	tx.guardarCredencial(credencial)
+	if err := tx.confirmar(); err != nil {
+		return nil, err
+	}
+	credencial.Clave = ""
+	return &credencial, nil
+}
+
+// CambiarClave reemplaza la clave de una credencial. Cada uno puede cambiar
+// la suya; las de otros, solo quien puede administrar
+func (b *Biblioteca) CambiarClave(actor Actor, nombre, clave string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	tx := b.iniciar(actor, EventoClaveCambiada)
+	credencial, ok := tx.credencial(nombre)
+	if !ok {
+		return nuevoError(ErrNoEncontrado, "No existe una credencial '%s'", nombre)
+	}
+	if propia, ok := b.credencialDe(actor); !ok || propia.Nombre != nombre {
+		if err := b.autorizar(actor, PermisoAdministrar, 0); err != nil {
+			return err
+		}
+	}
+	hash, err := hashClave(clave)
+	if err != nil {
+		return err
+	}
+	credencial.Clave = hash
+	tx.guardarCredencial(credencial)
+	return tx.confirmar()
+}
+
+// DesactivarCredencial impide entrar con una credencial. No se puede
+// desactivar el último administrador activo, porque nadie podría volver a
+// administrar
+func (b *Biblioteca) DesactivarCredencial(actor Actor, nombre string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.autorizar(actor, PermisoAdministrar, 0); err != nil {
+		return err
+	}
+	tx := b.iniciar(actor, EventoCredencialDesactivada)
+	credencial, ok := tx.credencial(nombre)
+	if !ok {
+		return nuevoError(ErrNoEncontrado, "No existe una credencial '%s'", nombre)
+	}
+	if !credencial.Activa {
+		return nuevoError(ErrNoPermitido, "La credencial '%s' ya está desactivada", nombre)
+	}
+	if credencial.Rol == RolAdmin {
+		admins := 0
+		for _, otra := range b.credenciales {
+			if otra.Rol == RolAdmin && otra.Activa {
+				admins++
+			}
+		}
+		if admins == 1 {
+			return nuevoError(ErrNoPermitido, "'%s' es el único administrador activo", nombre)
+		}
+	}
+	credencial.Activa = false
+	tx.guardarCredencial(credencial)
+	return tx.confirmar()
+}
+
+// ListarCredenciales retorna las credenciales ordenadas por nombre, sin sus
+// claves
+func (b *Biblioteca) ListarCredenciales() []Credencial {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	credenciales := b.listarCredenciales()
+	for i := range credenciales {
+		credenciales[i].Clave = ""
+	}
+	return credenciales
+}
+
+// BuscarCredencial retorna la credencial sin su clave, o nil si no existe
+func (b *Biblioteca) BuscarCredencial(nombre string) *Credencial {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	credencial, ok := b.credenciales[nombre]
+	if !ok {
+		return nil
+	}
+	credencial.Clave = ""
+	return &credencial
+}
+
+// listarCredenciales retorna las credenciales ordenadas por nombre, con sus
+// claves, para guardarlas. Quien llama debe tener el bloqueo
+func (b *Biblioteca) listarCredenciales() []Credencial {
+	credenciales := make([]Credencial, 0, len(b.credenciales))
+	for _, nombre := range slices.Sorted(maps.Keys(b.credenciales)) {
+		credenciales = append(credenciales, b.credenciales[nombre])
+	}
+	return credenciales
+}
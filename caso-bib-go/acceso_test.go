@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+const clavePrueba = "clave-de-prueba"
+
+// bibliotecaProtegida crea una biblioteca con una credencial por rol: admin,
+// bibliotecario, voluntario y la de usuario de ana, y retorna a ana
+func bibliotecaProtegida(t *testing.T) (*Biblioteca, *Usuario) {
+	t.Helper()
+	b, _ := nuevaBibliotecaPrueba(t)
+	ana := registrarUsuarioPrueba(t, b, "ana")
+	for _, c := range []struct {
+		nombre    string
+		rol       Rol
+		usuarioID int
+	}{
+		{"admin", RolAdmin, 0},
+		{"bibliotecario", RolBibliotecario, 0},
+		{"voluntario", RolVoluntario, 0},
+		{"ana", RolUsuario, ana.ID},
+	} {
+		if _, err := b.CrearCredencial(Sistema, c.nombre, c.rol, clavePrueba, c.usuarioID); err != nil {
+			t.Fatalf("CrearCredencial(%s): %v", c.nombre, err)
+		}
+	}
+	return b, ana
+}
+
+func personal(nombre string) Actor {
+	return Actor{Tipo: ActorPersonal, Nombre: nombre}
+}
+
+func TestPermisosPorRol(t *testing.T) {
+	b, ana := bibliotecaProtegida(t)
+	todos := []Permiso{PermisoCatalogo, PermisoCirculacion, PermisoUsuarios, PermisoCobrar,
+		PermisoCondonar, PermisoDatos, PermisoAdministrar}
+	casos := []struct {
+		actor      Actor
+		permitidos []Permiso
+	}{
+		{personal("admin"), todos},
+		{personal("bibliotecario"), []Permiso{PermisoCatalogo, PermisoCirculacion, PermisoUsuarios,
+			PermisoCobrar, PermisoCondonar, PermisoDatos}},
+		{personal("voluntario"), []Permiso{PermisoCirculacion, PermisoCobrar}},
+		{Actor{Tipo: ActorUsuario, ID: ana.ID}, []Permiso{PermisoCirculacion, PermisoUsuarios}},
+		{personal("desconocido"), nil},
+		{Actor{Tipo: ActorAnonimo}, nil},
+	}
+	for _, caso := range casos {
+		for _, permiso := range todos {
+			err := b.Autorizar(caso.actor, permiso, ana.ID)
+			permitido := false
+			for _, p := range caso.permitidos {
+				permitido = permitido || p == permiso
+			}
+			if permitido && err != nil {
+				t.Errorf("%s con %s: %v", caso.actor, permiso, err)
+			}
+			var errPermiso *ErrorPermiso
+			if !permitido && (!errors.As(err, &errPermiso) || errPermiso.Permiso != permiso) {
+				t.Errorf("%s con %s: err = %v, se esperaba un *ErrorPermiso", caso.actor, permiso, err)
+			}
+		}
+	}
+	// El sistema no necesita credencial
+	if err := b.Autorizar(Sistema, PermisoAdministrar, 0); err != nil {
+		t.Errorf("Autorizar(Sistema): %v", err)
+	}
+}
+
+func TestUsuarioSoloOperaSobreSuCuenta(t *testing.T) {
+	b, ana := bibliotecaProtegida(t)
+	beto := registrarUsuarioPrueba(t, b, "beto")
+	libro := agregarLibroPrueba(t, b, "Rayuela")
+	comoAna := Actor{Tipo: ActorUsuario, ID: ana.ID}
+
+	if _, err := b.PrestarLibro(comoAna, libro.ID, beto.ID); !errors.Is(err, ErrSinPermiso) {
+		t.Errorf("ana presta a beto: err = %v, se esperaba ErrSinPermiso", err)
+	}
+	if err := b.Autorizar(comoAna, PermisoCirculacion, 0); !errors.Is(err, ErrSinPermiso) {
+		t.Errorf("ana sin usuario: err = %v, se esperaba ErrSinPermiso", err)
+	}
+	if _, err := b.PrestarLibro(comoAna, libro.ID, ana.ID); err != nil {
+		t.Errorf("ana se presta a sí misma: %v", err)
+	}
+	// beto no tiene credencial
+	if err := b.Autorizar(Actor{Tipo: ActorUsuario, ID: beto.ID}, PermisoCirculacion, beto.ID); !errors.Is(err, ErrSinPermiso) {
+		t.Errorf("beto sin credencial: err = %v, se esperaba ErrSinPermiso", err)
+	}
+	verificar(t, b)
+}
+
+func TestAutenticar(t *testing.T) {
+	b, ana := bibliotecaProtegida(t)
+	casos := []struct {
+		actor Actor
+		clave string
+		ok    bool
+	}{
+		{personal("admin"), clavePrueba, true},
+		{Actor{Tipo: ActorUsuario, ID: ana.ID}, clavePrueba, true},
+		{personal("admin"), "otra-clave", false},
+		{personal("nadie"), clavePrueba, false},
+		// ana es una credencial de usuario: no entra como personal
+		{personal("ana"), clavePrueba, false},
+	}
+	for _, caso := range casos {
+		err := b.Autenticar(caso.actor, caso.clave)
+		if caso.ok && err != nil {
+			t.Errorf("Autenticar(%s): %v", caso.actor, err)
+		}
+		if !caso.ok && !errors.Is(err, ErrNoAutenticado) {
+			t.Errorf("Autenticar(%s, %q): err = %v, se esperaba ErrNoAutenticado", caso.actor, caso.clave, err)
+		}
+	}
+}
+
+func TestCredencialDesactivada(t *testing.T) {
+	b, _ := bibliotecaProtegida(t)
+	if err := b.DesactivarCredencial(personal("voluntario"), "bibliotecario"); !errors.Is(err, ErrSinPermiso) {
+		t.Errorf("el voluntario desactiva una credencial: err = %v, se esperaba ErrSinPermiso", err)
+	}
+	if err := b.DesactivarCredencial(personal("admin"), "voluntario"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Autenticar(personal("voluntario"), clavePrueba); !errors.Is(err, ErrNoAutenticado) {
+		t.Errorf("Autenticar desactivada: err = %v, se esperaba ErrNoAutenticado", err)
+	}
+	if err := b.Autorizar(personal("voluntario"), PermisoCirculacion, 0); !errors.Is(err, ErrSinPermiso) {
+		t.Errorf("Autorizar desactivada: err = %v, se esperaba ErrSinPermiso", err)
+	}
+	if err := b.DesactivarCredencial(personal("admin"), "voluntario"); !errors.Is(err, ErrNoPermitido) {
+		t.Errorf("desactivar dos veces: err = %v, se esperaba ErrNoPermitido", err)
+	}
+}
+
+func TestPrimeraCredencialEsDeAdministrador(t *testing.T) {
+	b, _ := nuevaBibliotecaPrueba(t)
+	// Sin credenciales la biblioteca está abierta
+	if err := b.Autorizar(Actor{Tipo: ActorAnonimo}, PermisoAdministrar, 0); err != nil {
+		t.Errorf("Autorizar sin credenciales: %v", err)
+	}
+	if _, err := b.CrearCredencial(Sistema, "bibliotecario", RolBibliotecario, clavePrueba, 0); !errors.Is(err, ErrNoPermitido) {
+		t.Fatalf("primera credencial de bibliotecario: err = %v, se esperaba ErrNoPermitido", err)
+	}
+	if b.Protegida() {
+		t.Fatal("la credencial rechazada protegió la biblioteca")
+	}
+	if _, err := b.CrearCredencial(Actor{Tipo: ActorAnonimo}, "admin", RolAdmin, clavePrueba, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !b.Protegida() {
+		t.Fatal("la biblioteca sigue abierta con un administrador")
+	}
+	if _, err := b.CrearCredencial(Actor{Tipo: ActorAnonimo}, "otro", RolAdmin, clavePrueba, 0); !errors.Is(err, ErrSinPermiso) {
+		t.Errorf("un anónimo crea credenciales en una biblioteca protegida: err = %v", err)
+	}
+}
+
+func TestNoSeDesactivaElUltimoAdministrador(t *testing.T) {
+	b, _ := bibliotecaProtegida(t)
+	admin := personal("admin")
+	if err := b.DesactivarCredencial(admin, "admin"); !errors.Is(err, ErrNoPermitido) {
+		t.Fatalf("desactivar el único administrador: err = %v, se esperaba ErrNoPermitido", err)
+	}
+	if _, err := b.CrearCredencial(admin, "admin2", RolAdmin, clavePrueba, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.DesactivarCredencial(admin, "admin"); err != nil {
+		t.Fatalf("desactivar con otro administrador activo: %v", err)
+	}
+	if err := b.DesactivarCredencial(personal("admin2"), "admin2"); !errors.Is(err, ErrNoPermitido) {
+		t.Errorf("desactivar el último administrador activo: err = %v, se esperaba ErrNoPermitido", err)
+	}
+}
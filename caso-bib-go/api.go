@@ -0,0 +1,1452 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ==========================================
+// API REST
+// ==========================================
+// ServidorAPI expone la biblioteca como JSON sobre HTTP:
+//
+//	GET  /libros?q=texto              buscar libros (todos si no hay q)
+//	GET  /libros?isbn=ISBN            buscar libro por ISBN-10 o ISBN-13
+//	GET  /metadatos/{isbn}            datos que propone el servicio de metadatos
+//	GET  /catalogo                    consultar el catálogo por páginas (ver
+//	                                  filtroLibrosDe; 20 libros por defecto)
+//	POST /libros                      agregar libro (?completar=true toma del
+//	                                  servicio de metadatos lo que falte)
+//	GET  /libros/{id}                 ver libro
+//	PUT  /libros/{id}                 actualizar título, autor y páginas
+//	PUT  /libros/{id}/tipo            clasificar libro (general, novedad, referencia)
+//	PUT  /libros/{id}/etiquetas       reemplazar las etiquetas del libro
+//	POST /libros/{id}/retiro          retirar libro del catálogo
+//	POST /libros/{id}/devolucion      devolver libro (un solo ejemplar prestado;
+//	                                  ?sucursal= si se devuelve en otra)
+//	GET  /libros/{id}/disponibilidad  ejemplares por estado y dónde está cada uno
+//	GET  /libros/{id}/ejemplares      listar ejemplares del libro
+//	POST /libros/{id}/ejemplares      agregar ejemplar
+//	GET  /libros/{id}/reservas        cola de reservas del libro
+//	GET  /libros/{id}/eventos         historial del libro
+//	GET  /libros/{id}/etiqueta        EAN-13 del ISBN (?formato=, ?escala=)
+//	POST /libros/importacion          importar libros (?formato=, CSV por defecto)
+//	GET  /libros/exportacion          exportar libros (?formato=, CSV por defecto)
+//	GET  /ejemplares/{id}             ver ejemplar
+//	POST /ejemplares/{id}/devolucion  devolver ejemplar (?sucursal= como arriba)
+//	PUT  /ejemplares/{id}/estado      cambiar estado (reparación, perdido...)
+//	GET  /ejemplares/{id}/historial   cambios de estado del ejemplar
+//	GET  /ejemplares/{id}/etiqueta    código de barras del ejemplar (?codigo=code128
+//	                                  o ean13, ?formato=svg o png, ?escala=)
+//	POST /usuarios                    registrar usuario
+//	GET  /usuarios/{id}               ver usuario
+//	POST /usuarios/{id}/activar       activar usuario
+//	POST /usuarios/{id}/desactivar    desactivar usuario
+//	PUT  /usuarios/{id}/contacto      actualizar email y teléfono
+//	PUT  /usuarios/{id}/categoria     cambiar categoría del usuario
+//	GET  /usuarios/{id}/reservas      reservas pendientes del usuario
+//	GET  /usuarios/{id}/eventos       historial del usuario
+//	GET  /usuarios/{id}/carnet        carné con QR (?formato=svg o png, solo el QR)
+//	POST /usuarios/{id}/pagos         pagar multas
+//	POST /usuarios/{id}/condonaciones condonar multas
+//	POST /usuarios/{id}/cierre        cerrar la cuenta del usuario
+//	POST /usuarios/anonimizacion      anonimizar las cuentas cerradas vencidas
+//	POST /usuarios/importacion        importar usuarios desde CSV
+//	GET  /usuarios/exportacion        exportar usuarios como CSV
+//	GET  /prestamos?activos=true      listar préstamos (o ?vencidos=true)
+//	POST /prestamos                   prestar libro o ejemplar
+//	POST /prestamos/escaneo           prestar por códigos escaneados
+//	                                  ({"item": "...", "usuario": "..."})
+//	POST /prestamos/{id}/renovacion   renovar préstamo
+//	GET  /prestamos/exportacion       exportar el historial de préstamos como CSV
+//	POST /reservas                    reservar libro
+//	GET  /reservas/{id}               ver reserva y su posición en la cola
+//	POST /reservas/{id}/cancelacion   cancelar reserva
+//	GET  /estadisticas                estadísticas
+//	GET  /informes/circulacion        informe de circulación (?mes=AAAA-MM o
+//	                                  ?desde=&hasta=, ?top=, ?inactividad=,
+//	                                  ?formato=json, texto, csv o markdown)
+//	GET  /eventos                     registro de auditoría (?libro=, ?usuario=,
+//	                                  ?actor=, ?tipo=, ?desde=, ?hasta=)
+//	GET  /credenciales                listar credenciales de acceso
+//	POST /credenciales                crear credencial
+//	PUT  /credenciales/{nombre}/clave cambiar la clave
+//	POST /credenciales/{nombre}/desactivar  desactivar credencial
+//	GET  /sucursales                  listar sucursales
+//	POST /sucursales                  agregar sucursal
+//	GET  /sucursales/{codigo}/ejemplares  ejemplares del fondo o presentes
+//	GET  /traslados                   listar traslados (?abiertos=true,
+//	                                  ?sucursal=)
+//	POST /traslados                   solicitar traslado de un ejemplar
+//	POST /traslados/{id}/envio        enviar traslado
+//	POST /traslados/{id}/recepcion    recibir traslado
+//	POST /traslados/{id}/cancelacion  cancelar traslado
+//	GET  /calendario                  días de atención (?sucursal=,
+//	                                  ?desde=AAAA-MM-DD, ?dias=N)
+//	GET  /etiquetas                   hoja de etiquetas en PDF (?ejemplares=,
+//	                                  ?libros=, ?usuarios= con IDs separados
+//	                                  por coma, ?hoja=)
+//	GET  /codigos/{codigo}            a qué corresponde un código escaneado
+//
+// Quien hace la petición se indica en el encabezado X-Actor, como
+// "usuario:ID", "personal:NOMBRE" o el nombre solo; queda en el registro de
+// auditoría. Sin encabezado la operación se registra como anónima.
+//
+// Si la biblioteca tiene credenciales (ver acceso.go), X-Actor no se usa:
+// cada petición se autentica con HTTP Basic, con el nombre de la credencial
+// como usuario y su clave, y sin credenciales válidas se responde 401. Además de los permisos
+// de cada operación, las consultas de préstamos piden circulación; las de
+// un usuario, sus reservas y su historial, ser ese usuario o tener permiso
+// sobre usuarios; los traslados y la lectura de códigos, circulación; los
+// metadatos, catálogo; los informes, exportaciones y
+// eventos, permiso de datos, y las credenciales, permiso de administrar. Un permiso que falta se responde
+// 403 con "permiso" en el error.
+//
+// Si el servicio de metadatos no responde y no hay nada guardado del ISBN
+// se responde 503.
+//
+// Los errores se responden con el código HTTP de su categoría y un cuerpo
+// {"error": {"codigo": "...", "mensaje": "..."}}. Si lo que falló es una regla
+// de la política de préstamos, el error trae también "regla".
+//
+// Las importaciones reciben el archivo como cuerpo de la petición y aceptan
+// ?simular=true y ?columnas=campo=COLUMNA,... (ver OpcionesImportacion). Los
+// libros aceptan además ?formato=marc, marcxml, dc o bibtex, también al
+// exportar.
+
+// limiteCuerpo es el tamaño máximo aceptado para el cuerpo de una petición
+const limiteCuerpo = 1 << 20
+
+// limiteImportacion es el tamaño máximo de un archivo a importar
+const limiteImportacion = 32 << 20
+
+// Libros por página del catálogo: los que se entregan si no se pide un
+// límite y el máximo que se puede pedir
+const (
+	limiteCatalogoPorDefecto = 20
+	limiteCatalogoMaximo     = 100
+)
+
+// ServidorAPI atiende las peticiones HTTP sobre una biblioteca
+type ServidorAPI struct {
+	biblioteca *Biblioteca
+	mux        *http.ServeMux
+}
+
+// NuevoServidorAPI crea el servidor y registra sus rutas
+func NuevoServidorAPI(b *Biblioteca) *ServidorAPI {
+	s := &ServidorAPI{biblioteca: b, mux: http.NewServeMux()}
+
+	s.mux.HandleFunc("GET /libros", s.buscarLibros)
+	s.mux.HandleFunc("GET /catalogo", s.consultarCatalogo)
+	s.mux.HandleFunc("GET /metadatos/{isbn}", s.proponerLibro)
+	s.mux.HandleFunc("POST /libros", s.agregarLibro)
+	s.mux.HandleFunc("GET /libros/{id}", s.verLibro)
+	s.mux.HandleFunc("PUT /libros/{id}", s.actualizarLibro)
+	s.mux.HandleFunc("PUT /libros/{id}/tipo", s.clasificarLibro)
+	s.mux.HandleFunc("PUT /libros/{id}/etiquetas", s.etiquetarLibro)
+	s.mux.HandleFunc("POST /libros/{id}/retiro", s.retirarLibro)
+	s.mux.HandleFunc("POST /libros/{id}/devolucion", s.devolverLibro)
+	s.mux.HandleFunc("GET /libros/{id}/disponibilidad", s.disponibilidad)
+	s.mux.HandleFunc("GET /libros/{id}/ejemplares", s.listarEjemplares)
+	s.mux.HandleFunc("POST /libros/{id}/ejemplares", s.agregarEjemplar)
+	s.mux.HandleFunc("GET /libros/{id}/reservas", s.reservasDeLibro)
+	s.mux.HandleFunc("GET /libros/{id}/eventos", s.eventosDe("libro"))
+	s.mux.HandleFunc("GET /libros/{id}/etiqueta", s.etiquetaLibro)
+	s.mux.HandleFunc("POST /libros/importacion", s.importar((*Biblioteca).ImportarLibros))
+	s.mux.HandleFunc("GET /libros/exportacion", s.exportarLibros)
+
+	s.mux.HandleFunc("GET /ejemplares/{id}", s.verEjemplar)
+	s.mux.HandleFunc("POST /ejemplares/{id}/devolucion", s.devolverEjemplar)
+	s.mux.HandleFunc("PUT /ejemplares/{id}/estado", s.cambiarEstadoEjemplar)
+	s.mux.HandleFunc("GET /ejemplares/{id}/historial", s.historialEjemplar)
+	s.mux.HandleFunc("GET /ejemplares/{id}/etiqueta", s.etiquetaEjemplar)
+
+	s.mux.HandleFunc("POST /usuarios", s.registrarUsuario)
+	s.mux.HandleFunc("GET /usuarios/{id}", s.verUsuario)
+	s.mux.HandleFunc("POST /usuarios/{id}/activar", s.activarUsuario)
+	s.mux.HandleFunc("POST /usuarios/{id}/desactivar", s.desactivarUsuario)
+	s.mux.HandleFunc("PUT /usuarios/{id}/contacto", s.actualizarContacto)
+	s.mux.HandleFunc("PUT /usuarios/{id}/categoria", s.cambiarCategoria)
+	s.mux.HandleFunc("GET /usuarios/{id}/reservas", s.reservasDeUsuario)
+	s.mux.HandleFunc("GET /usuarios/{id}/eventos", s.eventosDe("usuario"))
+	s.mux.HandleFunc("GET /usuarios/{id}/carnet", s.carnet)
+	s.mux.HandleFunc("POST /usuarios/{id}/pagos", s.pagarMulta)
+	s.mux.HandleFunc("POST /usuarios/{id}/condonaciones", s.condonarMulta)
+	s.mux.HandleFunc("POST /usuarios/{id}/cierre", s.cerrarCuenta)
+	s.mux.HandleFunc("POST /usuarios/anonimizacion", s.anonimizarCuentas)
+	s.mux.HandleFunc("POST /usuarios/importacion", s.importar((*Biblioteca).ImportarUsuariosCSV))
+	s.mux.HandleFunc("GET /usuarios/exportacion", s.exportar("usuarios", (*Biblioteca).ExportarUsuariosCSV))
+
+	s.mux.HandleFunc("GET /prestamos", s.listarPrestamos)
+	s.mux.HandleFunc("POST /prestamos", s.prestarLibro)
+	s.mux.HandleFunc("POST /prestamos/escaneo", s.prestarPorCodigos)
+	s.mux.HandleFunc("POST /prestamos/{id}/renovacion", s.renovarPrestamo)
+	s.mux.HandleFunc("GET /prestamos/exportacion", s.exportar("prestamos", (*Biblioteca).ExportarPrestamosCSV))
+
+	s.mux.HandleFunc("POST /reservas", s.reservarLibro)
+	s.mux.HandleFunc("GET /reservas/{id}", s.verReserva)
+	s.mux.HandleFunc("POST /reservas/{id}/cancelacion", s.cancelarReserva)
+
+	s.mux.HandleFunc("GET /estadisticas", s.estadisticas)
+	s.mux.HandleFunc("GET /informes/circulacion", s.informeCirculacion)
+	s.mux.HandleFunc("GET /eventos", s.eventosDe(""))
+
+	s.mux.HandleFunc("GET /credenciales", s.listarCredenciales)
+	s.mux.HandleFunc("POST /credenciales", s.crearCredencial)
+	s.mux.HandleFunc("PUT /credenciales/{nombre}/clave", s.cambiarClave)
+	s.mux.HandleFunc("POST /credenciales/{nombre}/desactivar", s.desactivarCredencial)
+
+	s.mux.HandleFunc("GET /sucursales", s.listarSucursales)
+	s.mux.HandleFunc("POST /sucursales", s.agregarSucursal)
+	s.mux.HandleFunc("GET /sucursales/{codigo}/ejemplares", s.ejemplaresDeSucursal)
+	s.mux.HandleFunc("GET /traslados", s.listarTraslados)
+	s.mux.HandleFunc("POST /traslados", s.solicitarTraslado)
+	s.mux.HandleFunc("POST /traslados/{id}/envio", s.operarTraslado((*Biblioteca).EnviarTraslado))
+	s.mux.HandleFunc("POST /traslados/{id}/recepcion", s.operarTraslado((*Biblioteca).RecibirTraslado))
+	s.mux.HandleFunc("POST /traslados/{id}/cancelacion", s.operarTraslado((*Biblioteca).CancelarTraslado))
+	s.mux.HandleFunc("GET /calendario", s.calendario)
+	s.mux.HandleFunc("GET /etiquetas", s.hojaEtiquetas)
+	s.mux.HandleFunc("GET /codigos/{codigo}", s.leerCodigo)
+	return s
+}
+
+// ServeHTTP identifica al actor de la petición y la atiende
+func (s *ServidorAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	actor, err := s.identificar(r)
+	if err != nil {
+		if errors.Is(err, ErrNoAutenticado) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="biblioteca", charset="UTF-8"`)
+		}
+		responderError(w, err)
+		return
+	}
+	s.mux.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), claveActor{}, actor)))
+}
+
+// identificar retorna el actor de la petición. Sin credenciales en la
+// biblioteca es el del encabezado X-Actor (ver ParsearActor), anónimo si no
+// viene; con credenciales, el de la credencial de la autenticación HTTP
+// Basic
+func (s *ServidorAPI) identificar(r *http.Request) (Actor, error) {
+	if !s.biblioteca.Protegida() {
+		return ParsearActor(r.Header.Get("X-Actor"))
+	}
+	nombre, clave, ok := r.BasicAuth()
+	if !ok {
+		return Actor{}, nuevoError(ErrNoAutenticado, "Debe autenticarse con su credencial y su clave")
+	}
+	actor := s.biblioteca.ActorDeCredencial(nombre)
+	if err := s.biblioteca.Autenticar(actor, clave); err != nil {
+		return Actor{}, err
+	}
+	return actor, nil
+}
+
+// claveActor guarda en el contexto de la petición el actor que la hace
+type claveActor struct{}
+
+// actorDe retorna el actor de una petición que pasó por ServeHTTP
+func actorDe(r *http.Request) Actor {
+	if actor, ok := r.Context().Value(claveActor{}).(Actor); ok {
+		return actor
+	}
+	return Actor{Tipo: ActorAnonimo}
+}
+
+// autorizar responde el error y retorna false si el actor de la petición no
+// tiene el permiso, para las consultas; las operaciones se autorizan solas
+func (s *ServidorAPI) autorizar(w http.ResponseWriter, r *http.Request, permiso Permiso, usuarioID int) bool {
+	if err := s.biblioteca.Autorizar(actorDe(r), permiso, usuarioID); err != nil {
+		responderError(w, err)
+		return false
+	}
+	return true
+}
+
+// ==========================================
+// LIBROS
+// ==========================================
+
+type peticionLibro struct {
+	Titulo  string `json:"titulo"`
+	Autor   string `json:"autor"`
+	ISBN    string `json:"isbn"`
+	Paginas int    `json:"paginas"`
+}
+
+func (s *ServidorAPI) buscarLibros(w http.ResponseWriter, r *http.Request) {
+	if isbn := r.URL.Query().Get("isbn"); isbn != "" {
+		libro, err := s.biblioteca.BuscarLibroPorISBN(isbn)
+		if err != nil {
+			responderError(w, err)
+			return
+		}
+		responder(w, http.StatusOK, []Libro{*libro})
+		return
+	}
+	libros := s.biblioteca.ListarLibros()
+	if q := r.URL.Query().Get("q"); q != "" {
+		libros = s.biblioteca.BuscarLibros(q)
+	}
+	responder(w, http.StatusOK, libros)
+}
+
+func (s *ServidorAPI) consultarCatalogo(w http.ResponseWriter, r *http.Request) {
+	filtro, err := filtroLibrosDe(r.URL.Query().Get)
+	if err != nil {
+		responderError(w, err)
+		return
+	}
+	if filtro.Limite == 0 {
+		filtro.Limite = limiteCatalogoPorDefecto
+	}
+	if filtro.Limite > limiteCatalogoMaximo {
+		responderError(w, nuevoError(ErrDatosInvalidos, "El límite máximo es %d libros por página", limiteCatalogoMaximo))
+		return
+	}
+	pagina, err := s.biblioteca.ConsultarLibros(filtro)
+	if err != nil {
+		responderError(w, err)
+		return
+	}
+	responder(w, http.StatusOK, pagina)
+}
+
+func (s *ServidorAPI) agregarLibro(w http.ResponseWriter, r *http.Request) {
+	var p peticionLibro
+	if !leerJSON(w, r, &p) {
+		return
+	}
+	if r.URL.Query().Get("completar") == "true" && p.ISBN != "" && (p.Titulo == "" || p.Autor == "" || p.Paginas == 0) {
+		propuesta, err := s.biblioteca.ProponerLibro(r.Context(), actorDe(r), p.ISBN)
+		if err != nil {
+			responderError(w, err)
+			return
+		}
+		p.Titulo, p.Autor, p.Paginas = propuesta.Completar(p.Titulo, p.Autor, p.Paginas)
+	}
+	libro, err := s.biblioteca.AgregarLibro(actorDe(r), p.Titulo, p.Autor, p.ISBN, p.Paginas)
+	if err != nil {
+		responderError(w, err)
+		return
+	}
+	responder(w, http.StatusCreated, libro)
+}
+
+func (s *ServidorAPI) proponerLibro(w http.ResponseWriter, r *http.Request) {
+	propuesta, err := s.biblioteca.ProponerLibro(r.Context(), actorDe(r), r.PathValue("isbn"))
+	if err != nil {
+		responderError(w, err)
+		return
+	}
+	responder(w, http.StatusOK, propuesta)
+}
+
+func (s *ServidorAPI) verLibro(w http.ResponseWriter, r *http.Request) {
+	id, ok := leerID(w, r)
+	if !ok {
+		return
+	}
+	libro := s.biblioteca.BuscarLibro(id)
+	if libro == nil {
+		responderError(w, nuevoError(ErrNoEncontrado, "No existe un libro con ID '%d'", id))
+		return
+	}
+	responder(w, http.StatusOK, libro)
+}
+
+func (s *ServidorAPI) actualizarLibro(w http.ResponseWriter, r *http.Request) {
+	id, ok := leerID(w, r)
+	if !ok {
+		return
+	}
+	var p peticionLibro
+	if !leerJSON(w, r, &p) {
+		return
+	}
+	if err := s.biblioteca.ActualizarLibro(actorDe(r), id, p.Titulo, p.Autor, p.Paginas); err != nil {
+		responderError(w, err)
+		return
+	}
+	responder(w, http.StatusOK, s.biblioteca.BuscarLibro(id))
+}
+
+type peticionTipo struct {
+	Tipo TipoLibro `json:"tipo"`
+}
+
+func (s *ServidorAPI) clasificarLibro(w http.ResponseWriter, r *http.Request) {
+	id, ok := leerID(w, r)
+	if !ok {
+		return
+	}
+	var p peticionTipo
+	if !leerJSON(w, r, &p) {
+		return
+	}
+	if err := s.biblioteca.ClasificarLibro(actorDe(r), id, p.Tipo); err != nil {
+		responderError(w, err)
+		return
+	}
+	responder(w, http.StatusOK, s.biblioteca.BuscarLibro(id))
+}
+
+type peticionEtiquetas struct {
+	Etiquetas []string `json:"etiquetas"`
+}
+
+func (s *ServidorAPI) etiquetarLibro(w http.ResponseWriter, r *http.Request) {
+	id, ok := leerID(w, r)
+	if !ok {
+		return
+	}
+	var p peticionEtiquetas
+	if !leerJSON(w, r, &p) {
+		return
+	}
+	if err := s.biblioteca.EtiquetarLibro(actorDe(r), id, p.Etiquetas); err != nil {
+		responderError(w, err)
+		return
+	}
+	responder(w, http.StatusOK, s.biblioteca.BuscarLibro(id))
+}
+
+type peticionRetiro struct {
+	Motivo string `json:"motivo"`
+}
+
+func (s *ServidorAPI) retirarLibro(w http.ResponseWriter, r *http.Request) {
+	id, ok := leerID(w, r)
+	if !ok {
+		return
+	}
+	var p peticionRetiro
+	if !leerJSON(w, r, &p) {
+		return
+	}
+	libro, err := s.biblioteca.RetirarLibro(actorDe(r), id, p.Motivo)
+	if err != nil {
+		responderError(w, err)
+		return
+	}
+	responder(w, http.StatusOK, libro)
+}
+
+func (s *ServidorAPI) devolverLibro(w http.ResponseWriter, r *http.Request) {
+	id, ok := leerID(w, r)
+	if !ok {
+		return
+	}
+	prestamo, err := s.biblioteca.DevolverLibro(actorDe(r), id, r.URL.Query().Get("sucursal"))
+	if err != nil {
+		responderError(w, err)
+		return
+	}
+	responder(w, http.StatusOK, prestamo)
+}
+
+func (s *ServidorAPI) disponibilidad(w http.ResponseWriter, r *http.Request) {
+	id, ok := leerID(w, r)
+	if !ok {
+		return
+	}
+	d, err := s.biblioteca.Disponibilidad(id)
+	if err != nil {
+		responderError(w, err)
+		return
+	}
+	responder(w, http.StatusOK, d)
+}
+
+// ==========================================
+// EJEMPLARES
+// ==========================================
+
+type peticionEjemplar struct {
+	CodigoBarras string `json:"codigo_barras"`
+	Ubicacion    string `json:"ubicacion"`
+	Sucursal     string `json:"sucursal"`
+}
+
+func (s *ServidorAPI) listarEjemplares(w http.ResponseWriter, r *http.Request) {
+	id, ok := leerID(w, r)
+	if !ok {
+		return
+	}
+	ejemplares, err := s.biblioteca.ListarEjemplares(id)
+	if err != nil {
+		responderError(w, err)
+		return
+	}
+	responder(w, http.StatusOK, ejemplares)
+}
+
+func (s *ServidorAPI) agregarEjemplar(w http.ResponseWriter, r *http.Request) {
+	id, ok := leerID(w, r)
+	if !ok {
+		return
+	}
+	var p peticionEjemplar
+	if !leerJSON(w, r, &p) {
+		return
+	}
+	ejemplar, err := s.biblioteca.AgregarEjemplar(actorDe(r), id, p.CodigoBarras, p.Ubicacion, p.Sucursal)
+	if err != nil {
+		responderError(w, err)
+		return
+	}
+	responder(w, http.StatusCreated, ejemplar)
+}
+
+func (s *ServidorAPI) verEjemplar(w http.ResponseWriter, r *http.Request) {
+	id, ok := leerID(w, r)
+	if !ok {
+		return
+	}
+	ejemplar := s.biblioteca.BuscarEjemplar(id)
+	if ejemplar == nil {
+		responderError(w, nuevoError(ErrNoEncontrado, "No existe un ejemplar con ID '%d'", id))
+		return
+	}
+	responder(w, http.StatusOK, ejemplar)
+}
+
+func (s *ServidorAPI) devolverEjemplar(w http.ResponseWriter, r *http.Request) {
+	id, ok := leerID(w, r)
+	if !ok {
+		return
+	}
+	prestamo, err := s.biblioteca.DevolverEjemplar(actorDe(r), id, r.URL.Query().Get("sucursal"))
+	if err != nil {
+		responderError(w, err)
+		return
+	}
+	responder(w, http.StatusOK, prestamo)
+}
+
+type peticionEstadoEjemplar struct {
+	Estado EstadoEjemplar `json:"estado"`
+	Motivo string         `json:"motivo"`
+}
+
+func (s *ServidorAPI) cambiarEstadoEjemplar(w http.ResponseWriter, r *http.Request) {
+	id, ok := leerID(w, r)
+	if !ok {
+		return
+	}
+	var p peticionEstadoEjemplar
+	if !leerJSON(w, r, &p) {
+		return
+	}
+	ejemplar, err := s.biblioteca.CambiarEstadoEjemplar(actorDe(r), id, p.Estado, p.Motivo)
+	if err != nil {
+		responderError(w, err)
+		return
+	}
+	responder(w, http.StatusOK, ejemplar)
+}
+
+func (s *ServidorAPI) historialEjemplar(w http.ResponseWriter, r *http.Request) {
+	id, ok := leerID(w, r)
+	if !ok {
+		return
+	}
+	historial, err := s.biblioteca.HistorialEjemplar(id)
+	if err != nil {
+		responderError(w, err)
+		return
+	}
+	responder(w, http.StatusOK, historial)
+}
+
+// ==========================================
+// USUARIOS
+// ==========================================
+
+type peticionUsuario struct {
+	Nombre    string           `json:"nombre"`
+	Email     string           `json:"email"`
+	Telefono  string           `json:"telefono"`
+	Categoria CategoriaUsuario `json:"categoria"`
+}
+
+func (s *ServidorAPI) registrarUsuario(w http.ResponseWriter, r *http.Request) {
+	var p peticionUsuario
+	if !leerJSON(w, r, &p) {
+		return
+	}
+	usuario, err := s.biblioteca.RegistrarUsuario(actorDe(r), p.Nombre, p.Email, p.Telefono, p.Categoria)
+	if err != nil {
+		responderError(w, err)
+		return
+	}
+	responder(w, http.StatusCreated, usuario)
+}
+
+func (s *ServidorAPI) verUsuario(w http.ResponseWriter, r *http.Request) {
+	id, ok := leerID(w, r)
+	if !ok || !s.autorizar(w, r, PermisoUsuarios, id) {
+		return
+	}
+	usuario := s.biblioteca.BuscarUsuario(id)
+	if usuario == nil {
+		responderError(w, nuevoError(ErrNoEncontrado, "No existe un usuario con ID '%d'", id))
+		return
+	}
+	responder(w, http.StatusOK, usuario)
+}
+
+func (s *ServidorAPI) activarUsuario(w http.ResponseWriter, r *http.Request) {
+	s.modificarUsuario(w, r, s.biblioteca.ActivarUsuario)
+}
+
+func (s *ServidorAPI) desactivarUsuario(w http.ResponseWriter, r *http.Request) {
+	s.modificarUsuario(w, r, s.biblioteca.DesactivarUsuario)
+}
+
+func (s *ServidorAPI) actualizarContacto(w http.ResponseWriter, r *http.Request) {
+	var p peticionUsuario
+	if !leerJSON(w, r, &p) {
+		return
+	}
+	s.modificarUsuario(w, r, func(actor Actor, id int) error {
+		return s.biblioteca.ActualizarContactoUsuario(actor, id, p.Email, p.Telefono)
+	})
+}
+
+func (s *ServidorAPI) cambiarCategoria(w http.ResponseWriter, r *http.Request) {
+	var p peticionUsuario
+	if !leerJSON(w, r, &p) {
+		return
+	}
+	s.modificarUsuario(w, r, func(actor Actor, id int) error {
+		return s.biblioteca.CambiarCategoriaUsuario(actor, id, p.Categoria)
+	})
+}
+
+type peticionPago struct {
+	Monto float64 `json:"monto"`
+}
+
+func (s *ServidorAPI) pagarMulta(w http.ResponseWriter, r *http.Request) {
+	var p peticionPago
+	if !leerJSON(w, r, &p) {
+		return
+	}
+	s.modificarUsuario(w, r, func(actor Actor, id int) error {
+		return s.biblioteca.PagarMulta(actor, id, p.Monto)
+	})
+}
+
+func (s *ServidorAPI) condonarMulta(w http.ResponseWriter, r *http.Request) {
+	var p peticionPago
+	if !leerJSON(w, r, &p) {
+		return
+	}
+	s.modificarUsuario(w, r, func(actor Actor, id int) error {
+		return s.biblioteca.CondonarMulta(actor, id, p.Monto)
+	})
+}
+
+func (s *ServidorAPI) cerrarCuenta(w http.ResponseWriter, r *http.Request) {
+	id, ok := leerID(w, r)
+	if !ok {
+		return
+	}
+	usuario, err := s.biblioteca.CerrarCuenta(actorDe(r), id)
+	if err != nil {
+		responderError(w, err)
+		return
+	}
+	responder(w, http.StatusOK, usuario)
+}
+
+func (s *ServidorAPI) anonimizarCuentas(w http.ResponseWriter, r *http.Request) {
+	anonimizadas, err := s.biblioteca.AnonimizarCuentas(actorDe(r))
+	if err != nil {
+		responderError(w, err)
+		return
+	}
+	responder(w, http.StatusOK, map[string]int{"anonimizadas": anonimizadas})
+}
+
+// modificarUsuario aplica cambio al usuario de la ruta y responde con el
+// usuario actualizado
+func (s *ServidorAPI) modificarUsuario(w http.ResponseWriter, r *http.Request, cambio func(actor Actor, id int) error) {
+	id, ok := leerID(w, r)
+	if !ok {
+		return
+	}
+	if err := cambio(actorDe(r), id); err != nil {
+		responderError(w, err)
+		return
+	}
+	responder(w, http.StatusOK, s.biblioteca.BuscarUsuario(id))
+}
+
+// ==========================================
+// PRÉSTAMOS Y ESTADÍSTICAS
+// ==========================================
+
+// peticionPrestamo indica el libro (se presta su primer ejemplar
+// disponible) o el ejemplar concreto a prestar
+type peticionPrestamo struct {
+	LibroID    int `json:"libro_id"`
+	EjemplarID int `json:"ejemplar_id"`
+	UsuarioID  int `json:"usuario_id"`
+}
+
+func (s *ServidorAPI) listarPrestamos(w http.ResponseWriter, r *http.Request) {
+	if !s.autorizar(w, r, PermisoCirculacion, 0) {
+		return
+	}
+	if r.URL.Query().Get("vencidos") == "true" {
+		responder(w, http.StatusOK, s.biblioteca.ListarPrestamosVencidos())
+		return
+	}
+	prestamos := s.biblioteca.ListarPrestamos()
+	if r.URL.Query().Get("activos") == "true" {
+		activos := make([]Prestamo, 0, len(prestamos))
+		for _, prestamo := range prestamos {
+			if !prestamo.Devuelto {
+				activos = append(activos, prestamo)
+			}
+		}
+		prestamos = activos
+	}
+	responder(w, http.StatusOK, prestamos)
+}
+
+func (s *ServidorAPI) prestarLibro(w http.ResponseWriter, r *http.Request) {
+	var p peticionPrestamo
+	if !leerJSON(w, r, &p) {
+		return
+	}
+	if (p.LibroID == 0) == (p.EjemplarID == 0) {
+		responderError(w, nuevoError(ErrDatosInvalidos, "Debe indicar libro_id o ejemplar_id"))
+		return
+	}
+	var prestamo *Prestamo
+	var err error
+	if p.EjemplarID != 0 {
+		prestamo, err = s.biblioteca.PrestarEjemplar(actorDe(r), p.EjemplarID, p.UsuarioID)
+	} else {
+		prestamo, err = s.biblioteca.PrestarLibro(actorDe(r), p.LibroID, p.UsuarioID)
+	}
+	if err != nil {
+		responderError(w, err)
+		return
+	}
+	responder(w, http.StatusCreated, prestamo)
+}
+
+type peticionEscaneo struct {
+	Item    string `json:"item"`
+	Usuario string `json:"usuario"`
+}
+
+func (s *ServidorAPI) prestarPorCodigos(w http.ResponseWriter, r *http.Request) {
+	var p peticionEscaneo
+	if !leerJSON(w, r, &p) {
+		return
+	}
+	prestamo, err := s.biblioteca.PrestarPorCodigos(actorDe(r), p.Item, p.Usuario)
+	if err != nil {
+		responderError(w, err)
+		return
+	}
+	responder(w, http.StatusCreated, prestamo)
+}
+
+func (s *ServidorAPI) renovarPrestamo(w http.ResponseWriter, r *http.Request) {
+	id, ok := leerID(w, r)
+	if !ok {
+		return
+	}
+	prestamo, err := s.biblioteca.RenovarPrestamo(actorDe(r), id)
+	if err != nil {
+		responderError(w, err)
+		return
+	}
+	responder(w, http.StatusOK, prestamo)
+}
+
+func (s *ServidorAPI) estadisticas(w http.ResponseWriter, r *http.Request) {
+	responder(w, http.StatusOK, s.biblioteca.Estadisticas())
+}
+
+// ==========================================
+// RESERVAS
+// ==========================================
+
+type peticionReserva struct {
+	LibroID   int `json:"libro_id"`
+	UsuarioID int `json:"usuario_id"`
+}
+
+// respuestaReserva agrega a la reserva su posición en la cola mientras está
+// pendiente (0 si ya tiene un ejemplar apartado)
+type respuestaReserva struct {
+	Reserva
+	Posicion *int `json:"posicion,omitempty"`
+}
+
+func (s *ServidorAPI) reservarLibro(w http.ResponseWriter, r *http.Request) {
+	var p peticionReserva
+	if !leerJSON(w, r, &p) {
+		return
+	}
+	reserva, err := s.biblioteca.ReservarLibro(actorDe(r), p.LibroID, p.UsuarioID)
+	if err != nil {
+		responderError(w, err)
+		return
+	}
+	responder(w, http.StatusCreated, s.conPosicion(*reserva))
+}
+
+func (s *ServidorAPI) verReserva(w http.ResponseWriter, r *http.Request) {
+	id, ok := leerID(w, r)
+	if !ok {
+		return
+	}
+	reserva := s.biblioteca.BuscarReserva(id)
+	if reserva == nil {
+		responderError(w, nuevoError(ErrNoEncontrado, "No existe una reserva con ID '%d'", id))
+		return
+	}
+	responder(w, http.StatusOK, s.conPosicion(*reserva))
+}
+
+func (s *ServidorAPI) cancelarReserva(w http.ResponseWriter, r *http.Request) {
+	id, ok := leerID(w, r)
+	if !ok {
+		return
+	}
+	reserva, err := s.biblioteca.CancelarReserva(actorDe(r), id)
+	if err != nil {
+		responderError(w, err)
+		return
+	}
+	responder(w, http.StatusOK, reserva)
+}
+
+func (s *ServidorAPI) reservasDeLibro(w http.ResponseWriter, r *http.Request) {
+	id, ok := leerID(w, r)
+	if !ok {
+		return
+	}
+	reservas, err := s.biblioteca.ReservasDeLibro(id)
+	if err != nil {
+		responderError(w, err)
+		return
+	}
+	responder(w, http.StatusOK, reservas)
+}
+
+func (s *ServidorAPI) reservasDeUsuario(w http.ResponseWriter, r *http.Request) {
+	id, ok := leerID(w, r)
+	if !ok || !s.autorizar(w, r, PermisoUsuarios, id) {
+		return
+	}
+	reservas, err := s.biblioteca.ReservasDeUsuario(id)
+	if err != nil {
+		responderError(w, err)
+		return
+	}
+	responder(w, http.StatusOK, reservas)
+}
+
+func (s *ServidorAPI) conPosicion(reserva Reserva) respuestaReserva {
+	respuesta := respuestaReserva{Reserva: reserva}
+	if posicion, err := s.biblioteca.PosicionEnCola(reserva.ID); err == nil {
+		respuesta.Posicion = &posicion
+	}
+	return respuesta
+}
+
+func (s *ServidorAPI) informeCirculacion(w http.ResponseWriter, r *http.Request) {
+	if !s.autorizar(w, r, PermisoDatos, 0) {
+		return
+	}
+	query := r.URL.Query()
+	opciones, err := opcionesInformeDe(query.Get)
+	if err != nil {
+		responderError(w, err)
+		return
+	}
+	formato := InformeJSON
+	if texto := query.Get("formato"); texto != "" {
+		if formato, err = ParsearFormatoInforme(texto); err != nil {
+			responderError(w, err)
+			return
+		}
+	}
+	informe, err := s.biblioteca.InformeCirculacion(opciones)
+	if err != nil {
+		responderError(w, err)
+		return
+	}
+	if formato == InformeJSON {
+		responder(w, http.StatusOK, informe)
+		return
+	}
+	w.Header().Set("Content-Type", formato.TipoContenido())
+	if err := EscribirInforme(w, formato, informe); err != nil {
+		log.Printf("no se pudo escribir el informe: %v", err)
+	}
+}
+
+// ==========================================
+// AUDITORÍA
+// ==========================================
+
+// eventosDe arma el handler que lista eventos con los filtros de la query.
+// Si entidad no es vacía ("libro" o "usuario"), el {id} de la ruta filtra
+// por esa entidad
+func (s *ServidorAPI) eventosDe(entidad string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		filtro, err := filtroEventosDe(func(clave string) string {
+			if clave == entidad {
+				return r.PathValue("id")
+			}
+			return query.Get(clave)
+		})
+		if err != nil {
+			responderError(w, err)
+			return
+		}
+		// El historial de un usuario lo puede ver él mismo
+		permiso, usuarioID := PermisoDatos, 0
+		if entidad == "usuario" {
+			permiso, usuarioID = PermisoUsuarios, filtro.UsuarioID
+		}
+		if !s.autorizar(w, r, permiso, usuarioID) {
+			return
+		}
+		responder(w, http.StatusOK, s.biblioteca.Eventos(filtro))
+	}
+}
+
+// ==========================================
+// CREDENCIALES
+// ==========================================
+
+type peticionCredencial struct {
+	Nombre    string `json:"nombre"`
+	Rol       Rol    `json:"rol"`
+	Clave     string `json:"clave"`
+	UsuarioID int    `json:"usuario_id"`
+}
+
+type peticionClave struct {
+	Clave string `json:"clave"`
+}
+
+func (s *ServidorAPI) listarCredenciales(w http.ResponseWriter, r *http.Request) {
+	if !s.autorizar(w, r, PermisoAdministrar, 0) {
+		return
+	}
+	responder(w, http.StatusOK, s.biblioteca.ListarCredenciales())
+}
+
+func (s *ServidorAPI) crearCredencial(w http.ResponseWriter, r *http.Request) {
+	var p peticionCredencial
+	if !leerJSON(w, r, &p) {
+		return
+	}
+	credencial, err := s.biblioteca.CrearCredencial(actorDe(r), p.Nombre, p.Rol, p.Clave, p.UsuarioID)
+	if err != nil {
+		responderError(w, err)
+		return
+	}
+	responder(w, http.StatusCreated, credencial)
+}
+
+func (s *ServidorAPI) cambiarClave(w http.ResponseWriter, r *http.Request) {
+	var p peticionClave
+	if !leerJSON(w, r, &p) {
+		return
+	}
+	if err := s.biblioteca.CambiarClave(actorDe(r), r.PathValue("nombre"), p.Clave); err != nil {
+		responderError(w, err)
+		return
+	}
+	responder(w, http.StatusOK, s.biblioteca.BuscarCredencial(r.PathValue("nombre")))
+}
+
+func (s *ServidorAPI) desactivarCredencial(w http.ResponseWriter, r *http.Request) {
+	if err := s.biblioteca.DesactivarCredencial(actorDe(r), r.PathValue("nombre")); err != nil {
+		responderError(w, err)
+		return
+	}
+	responder(w, http.StatusOK, s.biblioteca.BuscarCredencial(r.PathValue("nombre")))
+}
+
+// ==========================================
+// SUCURSALES Y TRASLADOS
+// ==========================================
+
+type peticionSucursal struct {
+	Codigo    string `json:"codigo"`
+	Nombre    string `json:"nombre"`
+	Direccion string `json:"direccion"`
+}
+
+type peticionTraslado struct {
+	EjemplarID int    `json:"ejemplar_id"`
+	Destino    string `json:"destino"`
+	Motivo     string `json:"motivo"`
+}
+
+func (s *ServidorAPI) listarSucursales(w http.ResponseWriter, r *http.Request) {
+	responder(w, http.StatusOK, s.biblioteca.ListarSucursales())
+}
+
+func (s *ServidorAPI) agregarSucursal(w http.ResponseWriter, r *http.Request) {
+	var p peticionSucursal
+	if !leerJSON(w, r, &p) {
+		return
+	}
+	sucursal, err := s.biblioteca.AgregarSucursal(actorDe(r), p.Codigo, p.Nombre, p.Direccion)
+	if err != nil {
+		responderError(w, err)
+		return
+	}
+	responder(w, http.StatusCreated, sucursal)
+}
+
+func (s *ServidorAPI) ejemplaresDeSucursal(w http.ResponseWriter, r *http.Request) {
+	paraderos, err := s.biblioteca.EjemplaresDeSucursal(r.PathValue("codigo"))
+	if err != nil {
+		responderError(w, err)
+		return
+	}
+	responder(w, http.StatusOK, paraderos)
+}
+
+func (s *ServidorAPI) listarTraslados(w http.ResponseWriter, r *http.Request) {
+	if !s.autorizar(w, r, PermisoCirculacion, 0) {
+		return
+	}
+	filtro := FiltroTraslados{
+		Abiertos: r.URL.Query().Get("abiertos") == "true",
+		Sucursal: r.URL.Query().Get("sucursal"),
+	}
+	responder(w, http.StatusOK, s.biblioteca.ListarTraslados(filtro))
+}
+
+func (s *ServidorAPI) solicitarTraslado(w http.ResponseWriter, r *http.Request) {
+	var p peticionTraslado
+	if !leerJSON(w, r, &p) {
+		return
+	}
+	traslado, err := s.biblioteca.SolicitarTraslado(actorDe(r), p.EjemplarID, p.Destino, p.Motivo)
+	if err != nil {
+		responderError(w, err)
+		return
+	}
+	responder(w, http.StatusCreated, traslado)
+}
+
+// operarTraslado arma el handler de un paso de un traslado ya solicitado
+func (s *ServidorAPI) operarTraslado(operacion func(*Biblioteca, Actor, int) (*Traslado, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := leerID(w, r)
+		if !ok {
+			return
+		}
+		traslado, err := operacion(s.biblioteca, actorDe(r), id)
+		if err != nil {
+			responderError(w, err)
+			return
+		}
+		responder(w, http.StatusOK, traslado)
+	}
+}
+
+func (s *ServidorAPI) calendario(w http.ResponseWriter, r *http.Request) {
+	consulta := r.URL.Query()
+	desde := s.biblioteca.Reloj.Ahora()
+	if texto := consulta.Get("desde"); texto != "" {
+		fecha, err := time.ParseInLocation(time.DateOnly, texto, time.Local)
+		if err != nil {
+			responderError(w, nuevoError(ErrDatosInvalidos, "Fecha '%s' no válida: use AAAA-MM-DD", texto))
+			return
+		}
+		desde = fecha
+	}
+	dias := 14
+	if texto := consulta.Get("dias"); texto != "" {
+		var err error
+		if dias, err = strconv.Atoi(texto); err != nil {
+			responderError(w, nuevoError(ErrDatosInvalidos, "Cantidad de días no válida '%s'", texto))
+			return
+		}
+	}
+	calendario, err := s.biblioteca.DiasDeAtencion(consulta.Get("sucursal"), desde, dias)
+	if err != nil {
+		responderError(w, err)
+		return
+	}
+	responder(w, http.StatusOK, calendario)
+}
+
+// ==========================================
+// ETIQUETAS
+// ==========================================
+
+func (s *ServidorAPI) etiquetaEjemplar(w http.ResponseWriter, r *http.Request) {
+	id, ok := leerID(w, r)
+	if !ok {
+		return
+	}
+	codigo := CodigoCode128
+	if texto := r.URL.Query().Get("codigo"); texto != "" {
+		codigo = TipoCodigo(texto)
+	}
+	simbolo, err := s.biblioteca.SimboloEjemplar(id, codigo)
+	s.enviarSimbolo(w, r, fmt.Sprintf("ejemplar-%d", id), simbolo, err)
+}
+
+func (s *ServidorAPI) etiquetaLibro(w http.ResponseWriter, r *http.Request) {
+	id, ok := leerID(w, r)
+	if !ok {
+		return
+	}
+	simbolo, err := s.biblioteca.SimboloLibro(id)
+	s.enviarSimbolo(w, r, fmt.Sprintf("libro-%d", id), simbolo, err)
+}
+
+// enviarSimbolo responde con la imagen del símbolo en ?formato= y ?escala=,
+// o con err si no se pudo armar
+func (s *ServidorAPI) enviarSimbolo(w http.ResponseWriter, r *http.Request, nombre string, simbolo Simbolo, err error) {
+	if err != nil {
+		responderError(w, err)
+		return
+	}
+	formato, err := ParsearFormatoImagen(r.URL.Query().Get("formato"))
+	if err != nil {
+		responderError(w, err)
+		return
+	}
+	escala := 3
+	if texto := r.URL.Query().Get("escala"); texto != "" {
+		if escala, err = strconv.Atoi(texto); err != nil {
+			responderError(w, nuevoError(ErrDatosInvalidos, "Escala no válida '%s'", texto))
+			return
+		}
+	}
+	var imagen bytes.Buffer
+	if err := EscribirImagen(&imagen, simbolo, formato, escala); err != nil {
+		responderError(w, err)
+		return
+	}
+	s.enviarArchivo(w, nombre, formato, func(w io.Writer) error {
+		_, err := imagen.WriteTo(w)
+		return err
+	})
+}
+
+func (s *ServidorAPI) carnet(w http.ResponseWriter, r *http.Request) {
+	id, ok := leerID(w, r)
+	if !ok {
+		return
+	}
+	if !s.autorizar(w, r, PermisoUsuarios, id) {
+		return
+	}
+	formato, err := ParsearFormatoImagen(r.URL.Query().Get("formato"))
+	if err != nil {
+		responderError(w, err)
+		return
+	}
+	carnet, err := s.biblioteca.Carnet(id)
+	if err != nil {
+		responderError(w, err)
+		return
+	}
+	var imagen bytes.Buffer
+	if formato == ImagenPNG {
+		err = EscribirPNG(&imagen, carnet.Simbolo, 8)
+	} else {
+		tarjeta := FormatosHoja["a4-2x5"]
+		err = EscribirEtiquetaSVG(&imagen, carnet, tarjeta.AnchoEtiqueta, tarjeta.AltoEtiqueta)
+	}
+	if err != nil {
+		responderError(w, err)
+		return
+	}
+	s.enviarArchivo(w, fmt.Sprintf("carnet-%d", id), formato, func(w io.Writer) error {
+		_, err := imagen.WriteTo(w)
+		return err
+	})
+}
+
+func (s *ServidorAPI) hojaEtiquetas(w http.ResponseWriter, r *http.Request) {
+	consulta := r.URL.Query()
+	nombre := consulta.Get("hoja")
+	if nombre == "" {
+		nombre = HojaPorDefecto
+	}
+	hoja, err := BuscarFormatoHoja(nombre)
+	if err != nil {
+		responderError(w, err)
+		return
+	}
+	var pedido PedidoEtiquetas
+	for _, lista := range []struct {
+		parametro string
+		ids       *[]int
+	}{{"ejemplares", &pedido.Ejemplares}, {"libros", &pedido.Libros}, {"usuarios", &pedido.Usuarios}} {
+		if *lista.ids, err = ParsearIDs(consulta.Get(lista.parametro)); err != nil {
+			responderError(w, err)
+			return
+		}
+	}
+	if len(pedido.Usuarios) > 0 && !s.autorizar(w, r, PermisoUsuarios, 0) {
+		return
+	}
+	etiquetas, err := s.biblioteca.Etiquetas(pedido)
+	if err != nil {
+		responderError(w, err)
+		return
+	}
+	var pdf bytes.Buffer
+	if err := EscribirHojaPDF(&pdf, hoja, etiquetas); err != nil {
+		responderError(w, err)
+		return
+	}
+	s.enviarArchivo(w, "etiquetas", documentoPDF{}, func(w io.Writer) error {
+		_, err := pdf.WriteTo(w)
+		return err
+	})
+}
+
+// documentoPDF es el tipo de archivo de las hojas de etiquetas
+type documentoPDF struct{}
+
+func (documentoPDF) TipoContenido() string { return "application/pdf" }
+func (documentoPDF) Extension() string     { return ".pdf" }
+
+func (s *ServidorAPI) leerCodigo(w http.ResponseWriter, r *http.Request) {
+	if !s.autorizar(w, r, PermisoCirculacion, 0) {
+		return
+	}
+	lectura, err := s.biblioteca.LeerCodigo(r.PathValue("codigo"))
+	if err != nil {
+		responderError(w, err)
+		return
+	}
+	responder(w, http.StatusOK, lectura)
+}
+
+// ==========================================
+// IMPORTACIÓN Y EXPORTACIÓN
+// ==========================================
+
+// importar arma el handler de una importación. Responde el informe aunque
+// haya filas con errores; solo falla si el archivo entero no se puede leer
+func (s *ServidorAPI) importar(importacion func(*Biblioteca, Actor, io.Reader, OpcionesImportacion) (*InformeImportacion, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		columnas, err := ParsearColumnas(r.URL.Query().Get("columnas"))
+		if err != nil {
+			responderError(w, err)
+			return
+		}
+		opciones := OpcionesImportacion{
+			Formato:  FormatoBibliografico(r.URL.Query().Get("formato")),
+			Columnas: columnas,
+			Simular:  r.URL.Query().Get("simular") == "true",
+		}
+		informe, err := importacion(s.biblioteca, actorDe(r), http.MaxBytesReader(w, r.Body, limiteImportacion), opciones)
+		if err != nil {
+			var demasiado *http.MaxBytesError
+			if errors.As(err, &demasiado) {
+				err = nuevoError(ErrDatosInvalidos, "El archivo supera el máximo de %d bytes", demasiado.Limit)
+			}
+			responderError(w, err)
+			return
+		}
+		responder(w, http.StatusOK, informe)
+	}
+}
+
+// exportar arma el handler de una exportación CSV
+func (s *ServidorAPI) exportar(nombre string, exportacion func(*Biblioteca, io.Writer) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.autorizar(w, r, PermisoDatos, 0) {
+			return
+		}
+		s.enviarArchivo(w, nombre, FormatoCSV, func(w io.Writer) error {
+			return exportacion(s.biblioteca, w)
+		})
+	}
+}
+
+func (s *ServidorAPI) exportarLibros(w http.ResponseWriter, r *http.Request) {
+	if !s.autorizar(w, r, PermisoDatos, 0) {
+		return
+	}
+	formato, err := ParsearFormato(r.URL.Query().Get("formato"))
+	if err != nil {
+		responderError(w, err)
+		return
+	}
+	s.enviarArchivo(w, "libros", formato, func(w io.Writer) error {
+		return s.biblioteca.ExportarLibros(w, formato)
+	})
+}
+
+// formatoArchivo es lo que enviarArchivo necesita saber del formato
+type formatoArchivo interface {
+	TipoContenido() string
+	Extension() string
+}
+
+// enviarArchivo responde con el archivo que escribe escribir, como adjunto
+func (s *ServidorAPI) enviarArchivo(w http.ResponseWriter, nombre string, formato formatoArchivo, escribir func(io.Writer) error) {
+	w.Header().Set("Content-Type", formato.TipoContenido())
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s%s"`, nombre, formato.Extension()))
+	if err := escribir(w); err != nil {
+		log.Printf("no se pudo exportar %s: %v", nombre, err)
+	}
+}
+
+// ==========================================
+// UTILIDADES
+// ==========================================
+
+// cuerpoError es el cuerpo JSON de una respuesta de error
+type cuerpoError struct {
+	Error detalleError `json:"error"`
+}
+
+type detalleError struct {
+	Codigo  string  `json:"codigo"`
+	Mensaje string  `json:"mensaje"`
+	Regla   string  `json:"regla,omitempty"`   // regla de la política incumplida
+	Permiso Permiso `json:"permiso,omitempty"` // permiso que le falta al actor
+}
+
+// estadoDeError traduce la categoría de un error a código HTTP y código de
+// error para el cliente
+func estadoDeError(err error) (int, string) {
+	switch {
+	case errors.Is(err, ErrDatosInvalidos):
+		return http.StatusBadRequest, "datos_invalidos"
+	case errors.Is(err, ErrNoEncontrado):
+		return http.StatusNotFound, "no_encontrado"
+	case errors.Is(err, ErrConflicto):
+		return http.StatusConflict, "conflicto"
+	case errors.Is(err, ErrNoPermitido):
+		return http.StatusUnprocessableEntity, "no_permitido"
+	case errors.Is(err, ErrNoAutenticado):
+		return http.StatusUnauthorized, "no_autenticado"
+	case errors.Is(err, ErrSinPermiso):
+		return http.StatusForbidden, "sin_permiso"
+	case errors.Is(err, ErrSinServicio):
+		return http.StatusServiceUnavailable, "sin_servicio"
+	default:
+		return http.StatusInternalServerError, "error_interno"
+	}
+}
+
+func responderError(w http.ResponseWriter, err error) {
+	estado, codigo := estadoDeError(err)
+	mensaje := err.Error()
+	if estado == http.StatusInternalServerError {
+		// Los detalles internos van al log, no al cliente
+		log.Printf("error interno: %v", err)
+		mensaje = "Error interno del servidor"
+	}
+	detalle := detalleError{Codigo: codigo, Mensaje: mensaje}
+	var politica *ErrorPolitica
+	if errors.As(err, &politica) {
+		detalle.Regla = politica.Regla
+	}
+	var permiso *ErrorPermiso
+	if errors.As(err, &permiso) {
+		detalle.Permiso = permiso.Permiso
+	}
+	responder(w, estado, cuerpoError{Error: detalle})
+}
+
+func responder(w http.ResponseWriter, estado int, cuerpo any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(estado)
+	if err := json.NewEncoder(w).Encode(cuerpo); err != nil {
+		log.Printf("no se pudo escribir la respuesta: %v", err)
+	}
+}
+
+// leerJSON decodifica el cuerpo en destino; si falla responde 400 y
+// retorna false
+func leerJSON(w http.ResponseWriter, r *http.Request, destino any) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, limiteCuerpo))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(destino); err != nil {
+		responderError(w, nuevoError(ErrDatosInvalidos, "JSON no válido: %v", err))
+		return false
+	}
+	return true
+}
+
+// leerID obtiene el {id} de la ruta; si no es un número responde 400 y
+// retorna false
+func leerID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		responderError(w, nuevoError(ErrDatosInvalidos, "ID no válido '%s'", r.PathValue("id")))
+		return 0, false
+	}
+	return id, true
+}
+
+// servirAPI atiende la API en direccion con la política de préstamos, los
+// calendarios de atención y el servicio de metadatos dados.
+// Si ruta no está vacía la biblioteca se carga desde ese archivo (o se crea)
+// y cada cambio queda en su diario.
+func servirAPI(direccion, ruta string, politica Politica, calendarios map[string]*Calendario, metadatos *ServicioMetadatos) error {
+	biblioteca := NuevaBiblioteca("Biblioteca Central", "Av. Principal 123")
+	if ruta != "" {
+		var err error
+		biblioteca, err = abrirOCrear(ruta, biblioteca)
+		if err != nil {
+			return err
+		}
+		defer biblioteca.Cerrar()
+	}
+	biblioteca.Politica = politica
+	biblioteca.Calendarios = calendarios
+	biblioteca.Metadatos = metadatos
+
+	// Las reservas apartadas vencen aunque nadie toque su libro
+	go func() {
+		for range time.Tick(time.Minute) {
+			if _, err := biblioteca.VencerReservas(Sistema); err != nil {
+				log.Printf("no se pudieron vencer las reservas: %v", err)
+			}
+		}
+	}()
+	// Y las cuentas cerradas se anonimizan al cumplir la retención
+	go func() {
+		for range time.Tick(time.Hour) {
+			if _, err := biblioteca.AnonimizarCuentas(Sistema); err != nil {
+				log.Printf("no se pudieron anonimizar las cuentas: %v", err)
+			}
+		}
+	}()
+
+	fmt.Printf("🌐 API de %s escuchando en %s\n", biblioteca.Nombre, direccion)
+	return http.ListenAndServe(direccion, NuevoServidorAPI(biblioteca))
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// pedir hace una petición al servidor y decodifica el cuerpo en destino si
+// no es nil
+func pedir(t *testing.T, s http.Handler, peticion *http.Request, destino any) *httptest.ResponseRecorder {
+	t.Helper()
+	respuesta := httptest.NewRecorder()
+	s.ServeHTTP(respuesta, peticion)
+	if destino != nil {
+		if err := json.Unmarshal(respuesta.Body.Bytes(), destino); err != nil {
+			t.Fatalf("%s %s: cuerpo %q: %v", peticion.Method, peticion.URL, respuesta.Body, err)
+		}
+	}
+	return respuesta
+}
+
+func TestAPIAutenticacionBasic(t *testing.T) {
+	b, _ := bibliotecaProtegida(t)
+	s := NuevoServidorAPI(b)
+	casos := []struct {
+		nombre  string
+		usuario string
+		clave   string
+		ruta    string
+		estado  int
+		codigo  string
+	}{
+		{"sin autenticación", "", "", "/estadisticas", http.StatusUnauthorized, "no_autenticado"},
+		{"clave incorrecta", "admin", "otra-clave", "/estadisticas", http.StatusUnauthorized, "no_autenticado"},
+		{"credencial inexistente", "nadie", clavePrueba, "/estadisticas", http.StatusUnauthorized, "no_autenticado"},
+		{"admin", "admin", clavePrueba, "/eventos", http.StatusOK, ""},
+		{"voluntario sin permiso de datos", "voluntario", clavePrueba, "/eventos", http.StatusForbidden, "sin_permiso"},
+	}
+	for _, caso := range casos {
+		t.Run(caso.nombre, func(t *testing.T) {
+			peticion := httptest.NewRequest(http.MethodGet, caso.ruta, nil)
+			// Con credenciales el encabezado X-Actor no identifica a nadie
+			peticion.Header.Set("X-Actor", "personal:admin")
+			if caso.usuario != "" {
+				peticion.SetBasicAuth(caso.usuario, caso.clave)
+			}
+			var cuerpo cuerpoError
+			destino := any(&cuerpo)
+			if caso.estado == http.StatusOK {
+				destino = nil
+			}
+			respuesta := pedir(t, s, peticion, destino)
+			if respuesta.Code != caso.estado || cuerpo.Error.Codigo != caso.codigo {
+				t.Fatalf("estado %d, error %+v; se esperaba %d %q", respuesta.Code, cuerpo.Error, caso.estado, caso.codigo)
+			}
+			if desafio := respuesta.Header().Get("WWW-Authenticate"); (caso.estado == http.StatusUnauthorized) != (desafio != "") {
+				t.Errorf("WWW-Authenticate = %q con estado %d", desafio, respuesta.Code)
+			}
+			if caso.estado == http.StatusForbidden && cuerpo.Error.Permiso != PermisoDatos {
+				t.Errorf("permiso = %q, se esperaba %q", cuerpo.Error.Permiso, PermisoDatos)
+			}
+		})
+	}
+}
+
+func TestAPIErroresPorCategoria(t *testing.T) {
+	b, _ := nuevaBibliotecaPrueba(t)
+	s := NuevoServidorAPI(b)
+	diccionario := agregarLibroPrueba(t, b, "Diccionario")
+	if err := b.ClasificarLibro(Sistema, diccionario.ID, TipoReferencia); err != nil {
+		t.Fatal(err)
+	}
+	ana := registrarUsuarioPrueba(t, b, "ana")
+
+	casos := []struct {
+		nombre string
+		metodo string
+		ruta   string
+		cuerpo string
+		estado int
+		codigo string
+		regla  string
+	}{
+		{"alta", http.MethodPost, "/libros", `{"titulo": "Rayuela", "autor": "Julio Cortázar", "paginas": 600}`,
+			http.StatusCreated, "", ""},
+		{"JSON con un campo desconocido", http.MethodPost, "/libros", `{"titulo": "Rayuela", "editorial": "Sudamericana"}`,
+			http.StatusBadRequest, "datos_invalidos", ""},
+		{"validación del libro", http.MethodPost, "/libros", `{"titulo": "", "autor": "Nadie", "paginas": 10}`,
+			http.StatusBadRequest, "datos_invalidos", ""},
+		{"ID que no es número", http.MethodGet, "/libros/uno", "", http.StatusBadRequest, "datos_invalidos", ""},
+		{"libro inexistente", http.MethodGet, "/libros/999", "", http.StatusNotFound, "no_encontrado", ""},
+		{"email repetido", http.MethodPost, "/usuarios", `{"nombre": "Otra Ana", "email": "ana@prueba.org"}`,
+			http.StatusConflict, "conflicto", ""},
+		{"regla de la política", http.MethodPost, "/prestamos",
+			`{"libro_id": ` + strconv.Itoa(diccionario.ID) + `, "usuario_id": ` + strconv.Itoa(ana.ID) + `}`,
+			http.StatusUnprocessableEntity, "no_permitido", ReglaPrestaReferencia},
+		{"sin servicio de metadatos", http.MethodGet, "/metadatos/9780134190440", "",
+			http.StatusServiceUnavailable, "sin_servicio", ""},
+	}
+	for _, caso := range casos {
+		t.Run(caso.nombre, func(t *testing.T) {
+			peticion := httptest.NewRequest(caso.metodo, caso.ruta, strings.NewReader(caso.cuerpo))
+			var cuerpo cuerpoError
+			destino := any(&cuerpo)
+			if caso.codigo == "" {
+				destino = nil
+			}
+			respuesta := pedir(t, s, peticion, destino)
+			if respuesta.Code != caso.estado || cuerpo.Error.Codigo != caso.codigo || cuerpo.Error.Regla != caso.regla {
+				t.Fatalf("estado %d, error %+v; se esperaba %d %q con regla %q",
+					respuesta.Code, cuerpo.Error, caso.estado, caso.codigo, caso.regla)
+			}
+			if caso.codigo != "" && cuerpo.Error.Mensaje == "" {
+				t.Error("el error no trae mensaje")
+			}
+			if tipo := respuesta.Header().Get("Content-Type"); !strings.HasPrefix(tipo, "application/json") {
+				t.Errorf("Content-Type = %q", tipo)
+			}
+		})
+	}
+	verificar(t, b)
+}
+
+func TestAPIErrorInternoNoMuestraDetalles(t *testing.T) {
+	respuesta := httptest.NewRecorder()
+	responderError(respuesta, errors.New("no se pudo escribir /var/lib/biblioteca/diario.jsonl"))
+	var cuerpo cuerpoError
+	if err := json.Unmarshal(respuesta.Body.Bytes(), &cuerpo); err != nil {
+		t.Fatal(err)
+	}
+	if respuesta.Code != http.StatusInternalServerError || cuerpo.Error.Codigo != "error_interno" ||
+		strings.Contains(cuerpo.Error.Mensaje, "diario") {
+		t.Errorf("estado %d, error %+v", respuesta.Code, cuerpo.Error)
+	}
+	// Un error envuelto conserva su categoría
+	envuelto := fmt.Errorf("al importar: %w", nuevoError(ErrConflicto, "repetido"))
+	if estado, codigo := estadoDeError(envuelto); estado != http.StatusConflict || codigo != "conflicto" {
+		t.Errorf("estadoDeError(envuelto) = %d, %q", estado, codigo)
+	}
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ==========================================
+// AUDITORÍA: REGISTRO DE EVENTOS
+// ==========================================
+// Cada operación confirmada deja un Evento inmutable: qué pasó, cuándo,
+// quién lo hizo y cómo quedaron las entidades que cambió. Los eventos nunca
+// se reescriben ni se descartan (a diferencia del diario, que se vacía en
+// cada Guardar), así que aplicarlos en orden sobre una biblioteca vacía
+// reconstruye el estado actual; ver Reconstruir. Los datos personales de
+// los usuarios van cifrados en cada evento, para poder borrarlos sin tocar
+// el registro; ver retencion.go.
+//
+// Cada operación que modifica la biblioteca recibe el Actor que la pide, la
+// autoriza según su credencial (ver acceso.go) y lo registra tal como llega.
+
+// TipoActor distingue quién origina una operación
+type TipoActor string
+
+const (
+	// ActorPersonal es alguien del personal de la biblioteca
+	ActorPersonal TipoActor = "personal"
+	// ActorUsuario es un usuario operando por su cuenta (autopréstamo,
+	// reservas desde la web)
+	ActorUsuario TipoActor = "usuario"
+	// ActorSistema son los procesos automáticos y las migraciones
+	ActorSistema TipoActor = "sistema"
+	// ActorAnonimo es una petición que no dijo quién la hacía
+	ActorAnonimo TipoActor = "anonimo"
+)
+
+// Actor es quien hizo una operación. ID es el del usuario cuando Tipo es
+// ActorUsuario; para el personal alcanza con el Nombre
+type Actor struct {
+	Tipo   TipoActor `json:"tipo"`
+	ID     int       `json:"id,omitempty"`
+	Nombre string    `json:"nombre,omitempty"`
+}
+
+// Sistema es el actor de los procesos automáticos
+var Sistema = Actor{Tipo: ActorSistema, Nombre: "sistema"}
+
+// TipoEvento dice qué operación produjo un evento
+type TipoEvento string
+
+const (
+	EventoEstadoInicial         TipoEvento = "biblioteca.estado_inicial"
+	EventoMigracion             TipoEvento = "biblioteca.migrada"
+	EventoLibroAgregado         TipoEvento = "libro.agregado"
+	EventoLibroActualizado      TipoEvento = "libro.actualizado"
+	EventoLibroClasificado      TipoEvento = "libro.clasificado"
+	EventoLibroEtiquetado       TipoEvento = "libro.etiquetado"
+	EventoLibroRetirado         TipoEvento = "libro.retirado"
+	EventoEjemplarAgregado      TipoEvento = "ejemplar.agregado"
+	EventoEjemplarEstado        TipoEvento = "ejemplar.estado_cambiado"
+	EventoUsuarioRegistrado     TipoEvento = "usuario.registrado"
+	EventoUsuarioActivado       TipoEvento = "usuario.activado"
+	EventoUsuarioDesactivado    TipoEvento = "usuario.desactivado"
+	EventoCuentaCerrada         TipoEvento = "usuario.cuenta_cerrada"
+	EventoCuentasAnonimizadas   TipoEvento = "usuario.anonimizadas"
+	EventoContactoActualizado   TipoEvento = "usuario.contacto_actualizado"
+	EventoCategoriaCambiada     TipoEvento = "usuario.categoria_cambiada"
+	EventoMultaPagada           TipoEvento = "usuario.multa_pagada"
+	EventoPrestamoCreado        TipoEvento = "prestamo.creado"
+	EventoPrestamoDevuelto      TipoEvento = "prestamo.devuelto"
+	EventoPrestamoRenovado      TipoEvento = "prestamo.renovado"
+	EventoReservaCreada         TipoEvento = "reserva.creada"
+	EventoReservaCancelada      TipoEvento = "reserva.cancelada"
+	EventoReservasVencidas      TipoEvento = "reserva.vencidas"
+	EventoMultaCondonada        TipoEvento = "usuario.multa_condonada"
+	EventoCredencialCreada      TipoEvento = "acceso.credencial_creada"
+	EventoClaveCambiada         TipoEvento = "acceso.clave_cambiada"
+	EventoCredencialDesactivada TipoEvento = "acceso.credencial_desactivada"
+	EventoSucursalAgregada      TipoEvento = "sucursal.agregada"
+	EventoTrasladoSolicitado    TipoEvento = "traslado.solicitado"
+	EventoTrasladoEnviado       TipoEvento = "traslado.enviado"
+	EventoTrasladoRecibido      TipoEvento = "traslado.recibido"
+	EventoTrasladoCancelado     TipoEvento = "traslado.cancelado"
+)
+
+// Evento es el registro de una operación confirmada. Cambios trae el estado
+// en que quedaron las entidades que la operación creó o modificó
+type Evento struct {
+	Secuencia int           `json:"secuencia"` // 1, 2, 3... sin huecos
+	Fecha     time.Time     `json:"fecha"`
+	Tipo      TipoEvento    `json:"tipo"`
+	Actor     Actor         `json:"actor"`
+	Cambios   entradaDiario `json:"cambios"`
+	// DatosPersonales son el nombre, email y teléfono cifrados de cada
+	// usuario de Cambios, por ID; ver ocultarDatos
+	DatosPersonales map[int]string `json:"datos_personales,omitempty"`
+}
+
+// copia retorna el evento con sus propias listas, para que quien lo recibe
+// no pueda modificar el registro
+func (e Evento) copia() Evento {
+	e.Cambios.Libros = slices.Clone(e.Cambios.Libros)
+	e.Cambios.Ejemplares = slices.Clone(e.Cambios.Ejemplares)
+	e.Cambios.Usuarios = slices.Clone(e.Cambios.Usuarios)
+	e.Cambios.Prestamos = slices.Clone(e.Cambios.Prestamos)
+	e.Cambios.Reservas = slices.Clone(e.Cambios.Reservas)
+	e.Cambios.Credenciales = slices.Clone(e.Cambios.Credenciales)
+	e.Cambios.Sucursales = slices.Clone(e.Cambios.Sucursales)
+	e.Cambios.Traslados = slices.Clone(e.Cambios.Traslados)
+	e.DatosPersonales = maps.Clone(e.DatosPersonales)
+	return e
+}
+
+// sinClaves retorna el evento sin las claves de las credenciales ni las de
+// los datos personales. El registro de eventos nunca guarda claves:
+// agregarEvento las quita antes de escribirlo
+func (e Evento) sinClaves() Evento {
+	e.Cambios.ClavesDatos = nil
+	e.Cambios.Credenciales = slices.Clone(e.Cambios.Credenciales)
+	for i := range e.Cambios.Credenciales {
+		e.Cambios.Credenciales[i].Clave = ""
+	}
+	return e
+}
+
+// vacia indica si la entrada no cambia ninguna entidad
+func (e entradaDiario) vacia() bool {
+	return len(e.Libros) == 0 && len(e.Ejemplares) == 0 && len(e.Usuarios) == 0 &&
+		len(e.Prestamos) == 0 && len(e.Reservas) == 0 && len(e.Credenciales) == 0 &&
+		len(e.Sucursales) == 0 && len(e.Traslados) == 0
+}
+
+// agregarEvento numera el evento, le quita las claves, cifra los datos
+// personales y lo guarda en memoria y, si la biblioteca tiene uno, en el
+// archivo de eventos. Quien llama debe tener el bloqueo exclusivo
+func (b *Biblioteca) agregarEvento(e Evento) error {
+	e, err := b.ocultarDatos(e.sinClaves())
+	if err != nil {
+		return err
+	}
+	e.Secuencia = len(b.eventos) + 1
+	if b.archivoEventos != nil {
+		if err := b.archivoEventos.escribir(e); err != nil {
+			return err
+		}
+	}
+	b.eventos = append(b.eventos, e)
+	return nil
+}
+
+// marcaEventos es la posición del registro de eventos antes de una
+// operación, para descartar su evento si la operación se revierte
+type marcaEventos struct {
+	cantidad int
+	tamano   int64
+}
+
+// marcarEventos retorna la posición actual del registro de eventos
+func (b *Biblioteca) marcarEventos() (marcaEventos, error) {
+	marca := marcaEventos{cantidad: len(b.eventos)}
+	if b.archivoEventos != nil {
+		tamano, err := b.archivoEventos.tamano()
+		if err != nil {
+			return marcaEventos{}, err
+		}
+		marca.tamano = tamano
+	}
+	return marca, nil
+}
+
+// descartarEventos quita los eventos agregados después de marca, en
+// memoria y en el archivo. Quien llama debe tener el bloqueo exclusivo
+func (b *Biblioteca) descartarEventos(marca marcaEventos) error {
+	b.eventos = b.eventos[:marca.cantidad]
+	if b.archivoEventos == nil {
+		return nil
+	}
+	return b.archivoEventos.truncar(marca.tamano)
+}
+
+// eventoEstado arma un evento con el estado completo de la biblioteca,
+// con el que empieza el registro de una biblioteca que ya tenía datos
+func (b *Biblioteca) eventoEstado(tipo TipoEvento) Evento {
+	return Evento{
+		Fecha: b.Reloj.Ahora(),
+		Tipo:  tipo,
+		Actor: Sistema,
+		Cambios: entradaDiario{
+			ProximoID:    b.proximoID,
+			Libros:       b.libros.Listar(),
+			Ejemplares:   b.ejemplares.Listar(),
+			Usuarios:     b.usuarios.Listar(),
+			Prestamos:    b.prestamos.Listar(),
+			Reservas:     b.reservas.Listar(),
+			Credenciales: b.listarCredenciales(),
+			Sucursales:   b.listarSucursales(),
+			Traslados:    b.listarTraslados(),
+		},
+	}
+}
+
+// ==========================================
+// CONSULTAS
+// ==========================================
+
+// FiltroEventos elige qué eventos retorna Eventos. Los campos en cero no
+// filtran
+type FiltroEventos struct {
+	// Desde y Hasta acotan la fecha; Hasta no se incluye
+	Desde, Hasta time.Time
+	Tipos        []TipoEvento
+	// LibroID deja los eventos que cambiaron el libro, sus ejemplares o sus
+	// préstamos y reservas
+	LibroID int
+	// UsuarioID deja los eventos que cambiaron al usuario o sus préstamos y
+	// reservas, y los que hizo él mismo como ActorUsuario
+	UsuarioID int
+	// Actor deja los eventos hechos por ese actor: mismo Tipo y, si los
+	// tiene, mismo ID y Nombre
+	Actor *Actor
+}
+
+func (f FiltroEventos) acepta(e Evento) bool {
+	if !f.Desde.IsZero() && e.Fecha.Before(f.Desde) {
+		return false
+	}
+	if !f.Hasta.IsZero() && !e.Fecha.Before(f.Hasta) {
+		return false
+	}
+	if len(f.Tipos) > 0 && !slices.Contains(f.Tipos, e.Tipo) {
+		return false
+	}
+	if f.Actor != nil && !f.Actor.coincide(e.Actor) {
+		return false
+	}
+	if f.LibroID != 0 && !e.tocaLibro(f.LibroID) {
+		return false
+	}
+	if f.UsuarioID != 0 && !e.tocaUsuario(f.UsuarioID) &&
+		!(e.Actor.Tipo == ActorUsuario && e.Actor.ID == f.UsuarioID) {
+		return false
+	}
+	return true
+}
+
+// coincide compara a como patrón: los campos vacíos no se comparan
+func (a Actor) coincide(otro Actor) bool {
+	return a.Tipo == otro.Tipo && (a.ID == 0 || a.ID == otro.ID) && (a.Nombre == "" || a.Nombre == otro.Nombre)
+}
+
+func (e Evento) tocaLibro(id int) bool {
+	c := e.Cambios
+	return slices.ContainsFunc(c.Libros, func(l Libro) bool { return l.ID == id }) ||
+		slices.ContainsFunc(c.Ejemplares, func(ej Ejemplar) bool { return ej.LibroID == id }) ||
+		slices.ContainsFunc(c.Prestamos, func(p Prestamo) bool { return p.LibroID == id }) ||
+		slices.ContainsFunc(c.Reservas, func(r Reserva) bool { return r.LibroID == id }) ||
+		slices.ContainsFunc(c.Traslados, func(t Traslado) bool { return t.LibroID == id })
+}
+
+func (e Evento) tocaUsuario(id int) bool {
+	c := e.Cambios
+	return slices.ContainsFunc(c.Usuarios, func(u Usuario) bool { return u.ID == id }) ||
+		slices.ContainsFunc(c.Prestamos, func(p Prestamo) bool { return p.UsuarioID == id }) ||
+		slices.ContainsFunc(c.Reservas, func(r Reserva) bool { return r.UsuarioID == id })
+}
+
+// Eventos retorna los eventos que cumplen filtro, del más viejo al más
+// nuevo. Los eventos de estado inicial y de migración cambian todo, así que
+// aparecen en cualquier historial de un libro o usuario que traigan. Los
+// datos personales de las cuentas ya anonimizadas aparecen anonimizados
+func (b *Biblioteca) Eventos(filtro FiltroEventos) []Evento {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	eventos := make([]Evento, 0)
+	for _, e := range b.eventos {
+		if filtro.acepta(e) {
+			eventos = append(eventos, b.mostrarDatos(e.copia()))
+		}
+	}
+	return eventos
+}
+
+// HistorialLibro retorna todos los eventos de un libro
+func (b *Biblioteca) HistorialLibro(id int) []Evento {
+	return b.Eventos(FiltroEventos{LibroID: id})
+}
+
+// filtroEventosDe arma un filtro con los parámetros de texto que usan la API
+// y la línea de comandos: libro, usuario, actor (como en ParsearActor), tipo
+// (uno o varios separados por comas) y desde/hasta como AAAA-MM-DD. Hasta
+// incluye ese día entero
+func filtroEventosDe(valor func(clave string) string) (FiltroEventos, error) {
+	var filtro FiltroEventos
+	for clave, destino := range map[string]*int{"libro": &filtro.LibroID, "usuario": &filtro.UsuarioID} {
+		if texto := valor(clave); texto != "" {
+			id, err := strconv.Atoi(texto)
+			if err != nil || id <= 0 {
+				return FiltroEventos{}, nuevoError(ErrDatosInvalidos, "El %s debe ser un ID, no '%s'", clave, texto)
+			}
+			*destino = id
+		}
+	}
+	if texto := valor("actor"); texto != "" {
+		actor, err := ParsearActor(texto)
+		if err != nil {
+			return FiltroEventos{}, err
+		}
+		filtro.Actor = &actor
+	}
+	for _, tipo := range strings.Split(valor("tipo"), ",") {
+		if tipo = strings.TrimSpace(tipo); tipo != "" {
+			filtro.Tipos = append(filtro.Tipos, TipoEvento(tipo))
+		}
+	}
+	for clave, destino := range map[string]*time.Time{"desde": &filtro.Desde, "hasta": &filtro.Hasta} {
+		if texto := valor(clave); texto != "" {
+			fecha, err := time.ParseInLocation(time.DateOnly, texto, time.Local)
+			if err != nil {
+				return FiltroEventos{}, nuevoError(ErrDatosInvalidos, "Fecha '%s' no válida: use AAAA-MM-DD", texto)
+			}
+			*destino = fecha
+		}
+	}
+	if !filtro.Hasta.IsZero() {
+		filtro.Hasta = filtro.Hasta.AddDate(0, 0, 1)
+	}
+	return filtro, nil
+}
+
+// ==========================================
+// RECONSTRUCCIÓN
+// ==========================================
+
+// Reconstruir arma una biblioteca nueva aplicando eventos en orden. Sirve
+// para auditar que el registro explica el estado actual, o para ver cómo
+// estaba la biblioteca hasta cierto evento. Espera los eventos como los
+// retorna Eventos, con los datos personales descifrados. Los eventos no
+// traen las claves, así que las credenciales reconstruidas no sirven para
+// iniciar sesión
+func Reconstruir(nombre, direccion string, eventos []Evento) (*Biblioteca, error) {
+	b := NuevaBiblioteca(nombre, direccion)
+	for i, e := range eventos {
+		if e.Secuencia != i+1 {
+			return nil, nuevoError(ErrDatosInvalidos, "Falta el evento %d: el siguiente es el %d", i+1, e.Secuencia)
+		}
+		if err := b.aplicar(e.Cambios); err != nil {
+			return nil, err
+		}
+		b.eventos = append(b.eventos, e.copia())
+	}
+	return b, nil
+}
+
+// ParsearActor interpreta un actor escrito como "usuario:ID",
+// "personal:NOMBRE" o solo el nombre de alguien del personal
+func ParsearActor(texto string) (Actor, error) {
+	texto = strings.TrimSpace(texto)
+	tipo, valor, ok := strings.Cut(texto, ":")
+	if !ok {
+		if texto == "" {
+			return Actor{Tipo: ActorAnonimo}, nil
+		}
+		return Actor{Tipo: ActorPersonal, Nombre: texto}, nil
+	}
+	valor = strings.TrimSpace(valor)
+	switch TipoActor(strings.TrimSpace(tipo)) {
+	case ActorUsuario:
+		id, err := strconv.Atoi(valor)
+		if err != nil || id <= 0 {
+			return Actor{}, nuevoError(ErrDatosInvalidos, "Actor no válido '%s': el usuario se indica por su ID", texto)
+		}
+		return Actor{Tipo: ActorUsuario, ID: id}, nil
+	case ActorPersonal:
+		if valor == "" {
+			return Actor{}, nuevoError(ErrDatosInvalidos, "Actor no válido '%s': falta el nombre", texto)
+		}
+		return Actor{Tipo: ActorPersonal, Nombre: valor}, nil
+	}
+	return Actor{}, nuevoError(ErrDatosInvalidos, "Actor no válido '%s': use usuario:ID o personal:NOMBRE", texto)
+}
+
+// String retorna el actor como lo acepta ParsearActor
+func (a Actor) String() string {
+	switch a.Tipo {
+	case ActorUsuario:
+		return fmt.Sprintf("usuario:%d", a.ID)
+	case ActorPersonal:
+		return "personal:" + a.Nombre
+	}
+	return string(a.Tipo)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRegistroDeEventosSinClaves(t *testing.T) {
+	b, _ := nuevaBibliotecaPrueba(t)
+	ruta := filepath.Join(t.TempDir(), "biblioteca.json")
+	if err := b.Guardar(ruta); err != nil {
+		t.Fatal(err)
+	}
+	admin := Actor{Tipo: ActorPersonal, Nombre: "admin"}
+	if _, err := b.CrearCredencial(Sistema, "admin", RolAdmin, "clave-de-prueba", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.CambiarClave(admin, "admin", "otra-clave-de-prueba"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range b.eventos {
+		for _, credencial := range e.Cambios.Credenciales {
+			if credencial.Clave != "" {
+				t.Errorf("el evento %d (%s) guarda la clave de %s", e.Secuencia, e.Tipo, credencial.Nombre)
+			}
+		}
+	}
+	datos, err := os.ReadFile(ruta + extensionEventos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(datos), prefijoClave) {
+		t.Errorf("el archivo de eventos contiene un hash de clave:\n%s", datos)
+	}
+
+	// La clave sigue en el diario y el snapshot, así que se puede iniciar
+	// sesión después de cargar
+	if err := b.Guardar(ruta); err != nil {
+		t.Fatal(err)
+	}
+	cargada, err := Cargar(ruta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cargada.Autenticar(admin, "otra-clave-de-prueba"); err != nil {
+		t.Errorf("Autenticar después de Cargar: %v", err)
+	}
+}
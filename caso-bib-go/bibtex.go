@@ -0,0 +1,455 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ==========================================
+// BIBTEX
+// ==========================================
+// Los docentes piden la bibliografía en BibTeX. Cada libro se exporta como
+// una entrada @book cuya clave es "libro" y el ID (ver claveBibTeX para los
+// libros sin ID):
+//
+//	@book{libro12,
+//	  title = {Cien años de soledad},
+//	  author = {García Márquez, Gabriel},
+//	  isbn = {978-0-06-088328-7},
+//	  pagetotal = {471},
+//	}
+//
+// Al importar se acepta cualquier tipo de entrada, con valores entre
+// llaves, entre comillas o sin delimitar, concatenados con #, y las
+// abreviaturas de @string. Se ignoran @comment y @preamble. Las páginas se
+// toman de pagetotal o, si es un solo número, de pages. Los acentos en
+// notación LaTeX ({\'a}, \~n) se convierten a UTF-8.
+
+// LectorBibTeX lee las entradas de un archivo BibTeX
+type LectorBibTeX struct {
+	r            *bufio.Reader
+	linea        int
+	abreviaturas map[string]string
+}
+
+func NuevoLectorBibTeX(r io.Reader) *LectorBibTeX {
+	return &LectorBibTeX{r: bufio.NewReader(r), linea: 1, abreviaturas: make(map[string]string)}
+}
+
+// errorBibTeX es un error de sintaxis en una entrada
+func (l *LectorBibTeX) errorBibTeX(formato string, args ...any) error {
+	return nuevoError(ErrDatosInvalidos, "BibTeX no válido en la línea %d: %s", l.linea, fmt.Sprintf(formato, args...))
+}
+
+// Leer retorna el libro de la próxima entrada. Una entrada mal escrita se
+// saltea hasta la próxima @
+func (l *LectorBibTeX) Leer() (Libro, error) {
+	for {
+		// Fuera de las entradas todo es comentario
+		if err := l.saltarHasta('@'); err != nil {
+			return Libro{}, err
+		}
+		tipo, err := l.identificador()
+		if err != nil {
+			return Libro{}, err
+		}
+		switch strings.ToLower(tipo) {
+		case "comment":
+			continue
+		case "preamble":
+			if _, err := l.cuerpo(); err != nil {
+				return Libro{}, err
+			}
+			continue
+		case "string":
+			campos, err := l.cuerpo()
+			if err != nil {
+				return Libro{}, err
+			}
+			for nombre, valor := range campos {
+				l.abreviaturas[nombre] = valor
+			}
+			continue
+		}
+		campos, err := l.cuerpo()
+		if err != nil {
+			return Libro{}, err
+		}
+		return libroDeBibTeX(campos), nil
+	}
+}
+
+func libroDeBibTeX(campos map[string]string) Libro {
+	libro := Libro{
+		Titulo: desdeLaTeX(campos["title"]),
+		Autor:  desdeLaTeX(campos["author"]),
+		ISBN:   ISBN(strings.TrimSpace(campos["isbn"])),
+	}
+	if paginas, err := strconv.Atoi(strings.TrimSpace(campos["pagetotal"])); err == nil {
+		libro.Paginas = paginas
+	} else if paginas, err := strconv.Atoi(strings.TrimSpace(campos["pages"])); err == nil {
+		libro.Paginas = paginas
+	}
+	return libro
+}
+
+// cuerpo lee "{clave, campo = valor, ...}" o lo mismo entre paréntesis y
+// retorna los campos con el nombre en minúsculas. En @string y @preamble no
+// hay clave
+func (l *LectorBibTeX) cuerpo() (map[string]string, error) {
+	l.saltarEspacios()
+	apertura, err := l.leer()
+	if err != nil {
+		return nil, l.incompleta(err)
+	}
+	cierre := '}'
+	switch apertura {
+	case '(':
+		cierre = ')'
+	case '{':
+	default:
+		return nil, l.errorBibTeX("se esperaba { después del tipo de entrada")
+	}
+
+	campos := make(map[string]string)
+	primero := true
+	for {
+		l.saltarEspacios()
+		c, err := l.mirar()
+		if err != nil {
+			return nil, l.incompleta(err)
+		}
+		if c == cierre {
+			l.leer()
+			return campos, nil
+		}
+		if c == ',' {
+			l.leer()
+			continue
+		}
+		if c == '{' || c == '"' {
+			// @preamble{"..."}: un valor sin nombre
+			if _, err := l.valor(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		nombre, err := l.identificador()
+		if err != nil {
+			return nil, err
+		}
+		l.saltarEspacios()
+		c, err = l.mirar()
+		if err != nil {
+			return nil, l.incompleta(err)
+		}
+		if c != '=' {
+			if primero && (c == ',' || c == cierre) {
+				// La clave de la entrada
+				primero = false
+				continue
+			}
+			return nil, l.errorBibTeX("se esperaba = después de '%s'", nombre)
+		}
+		l.leer()
+		primero = false
+		valor, err := l.valor()
+		if err != nil {
+			return nil, err
+		}
+		campos[strings.ToLower(nombre)] = valor
+	}
+}
+
+// valor lee partes concatenadas con #
+func (l *LectorBibTeX) valor() (string, error) {
+	var valor strings.Builder
+	for {
+		l.saltarEspacios()
+		c, err := l.mirar()
+		if err != nil {
+			return "", l.incompleta(err)
+		}
+		switch {
+		case c == '{':
+			l.leer()
+			parte, err := l.hastaCierre('}')
+			if err != nil {
+				return "", err
+			}
+			valor.WriteString(parte)
+		case c == '"':
+			l.leer()
+			parte, err := l.hastaCierre('"')
+			if err != nil {
+				return "", err
+			}
+			valor.WriteString(parte)
+		case unicode.IsDigit(c):
+			numero, _ := l.identificador()
+			valor.WriteString(numero)
+		case esCaracterIdentificador(c):
+			nombre, _ := l.identificador()
+			valor.WriteString(l.abreviaturas[strings.ToLower(nombre)])
+		default:
+			return "", l.errorBibTeX("valor no válido que empieza con '%c'", c)
+		}
+		l.saltarEspacios()
+		if c, err := l.mirar(); err != nil || c != '#' {
+			return valor.String(), nil
+		}
+		l.leer()
+	}
+}
+
+// hastaCierre lee hasta fin, respetando las llaves anidadas. Las llaves
+// internas se conservan para que desdeLaTeX vea los comandos completos
+func (l *LectorBibTeX) hastaCierre(fin rune) (string, error) {
+	var texto strings.Builder
+	nivel := 0
+	for {
+		c, err := l.leer()
+		if err != nil {
+			return "", l.incompleta(err)
+		}
+		switch {
+		case c == '\\':
+			texto.WriteRune(c)
+			siguiente, err := l.leer()
+			if err != nil {
+				return "", l.incompleta(err)
+			}
+			texto.WriteRune(siguiente)
+			continue
+		case c == fin && nivel == 0:
+			return texto.String(), nil
+		case c == '{':
+			nivel++
+		case c == '}':
+			if nivel == 0 {
+				return "", l.errorBibTeX("llave } sin abrir")
+			}
+			nivel--
+		}
+		texto.WriteRune(c)
+	}
+}
+
+func (l *LectorBibTeX) identificador() (string, error) {
+	l.saltarEspacios()
+	var nombre strings.Builder
+	for {
+		c, err := l.mirar()
+		if err != nil || !esCaracterIdentificador(c) {
+			break
+		}
+		l.leer()
+		nombre.WriteRune(c)
+	}
+	if nombre.Len() == 0 {
+		return "", l.errorBibTeX("se esperaba un nombre")
+	}
+	return nombre.String(), nil
+}
+
+func esCaracterIdentificador(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c) || strings.ContainsRune("_-:.+/", c)
+}
+
+// incompleta traduce el fin del archivo dentro de una entrada
+func (l *LectorBibTeX) incompleta(err error) error {
+	if errors.Is(err, io.EOF) {
+		return l.errorBibTeX("el archivo termina dentro de una entrada")
+	}
+	return err
+}
+
+func (l *LectorBibTeX) leer() (rune, error) {
+	c, _, err := l.r.ReadRune()
+	if c == '\n' {
+		l.linea++
+	}
+	return c, err
+}
+
+func (l *LectorBibTeX) mirar() (rune, error) {
+	c, _, err := l.r.ReadRune()
+	if err != nil {
+		return 0, err
+	}
+	return c, l.r.UnreadRune()
+}
+
+func (l *LectorBibTeX) saltarEspacios() {
+	for {
+		c, err := l.mirar()
+		if err != nil || !unicode.IsSpace(c) {
+			return
+		}
+		l.leer()
+	}
+}
+
+func (l *LectorBibTeX) saltarHasta(objetivo rune) error {
+	for {
+		c, err := l.leer()
+		if err != nil {
+			return err
+		}
+		if c == objetivo {
+			return nil
+		}
+	}
+}
+
+// ==========================================
+// LATEX
+// ==========================================
+
+// acentosLaTeX son los acentos de LaTeX con la letra ya acentuada que
+// producen
+var acentosLaTeX = map[string]string{
+	`'a`: "á", `'e`: "é", `'i`: "í", `'o`: "ó", `'u`: "ú", `'y`: "ý",
+	`'A`: "Á", `'E`: "É", `'I`: "Í", `'O`: "Ó", `'U`: "Ú", `'\i`: "í",
+	"`a": "à", "`e": "è", "`i": "ì", "`o": "ò", "`u": "ù",
+	`^a`: "â", `^e`: "ê", `^i`: "î", `^o`: "ô", `^u`: "û",
+	`"a`: "ä", `"e`: "ë", `"i`: "ï", `"o`: "ö", `"u`: "ü", `"U`: "Ü", `"O`: "Ö", `"A`: "Ä",
+	`~n`: "ñ", `~N`: "Ñ", `~a`: "ã", `~o`: "õ", `cc`: "ç", `cC`: "Ç",
+	`ss`: "ß", `o`: "ø", `O`: "Ø", `ae`: "æ", `oe`: "œ", `i`: "i",
+	`textbackslash`: `\`, `textasciitilde`: "~", `textasciicircum`: "^",
+}
+
+// desdeLaTeX convierte un valor BibTeX a texto: resuelve acentos y
+// caracteres escapados y quita las llaves que protegen mayúsculas
+func desdeLaTeX(valor string) string {
+	var texto strings.Builder
+	runas := []rune(valor)
+	for i := 0; i < len(runas); i++ {
+		c := runas[i]
+		switch c {
+		case '{', '}':
+			continue
+		case '~':
+			texto.WriteRune(' ')
+			continue
+		case '\\':
+		default:
+			texto.WriteRune(c)
+			continue
+		}
+		if i+1 == len(runas) {
+			break
+		}
+		siguiente := runas[i+1]
+		if strings.ContainsRune(`&%$#_{}\`, siguiente) {
+			texto.WriteRune(siguiente)
+			i++
+			continue
+		}
+		// \'a, \'{a}, \~n, \c{c}, \ss, \i...: el comando y, si es de
+		// acento, la letra
+		j := i + 1
+		comando := string(siguiente)
+		if unicode.IsLetter(siguiente) {
+			for j+1 < len(runas) && unicode.IsLetter(runas[j+1]) {
+				j++
+			}
+			comando = string(runas[i+1 : j+1])
+		}
+		// El espacio que sigue a un comando de letras solo lo termina
+		finComando := j
+		if unicode.IsLetter(siguiente) && j+1 < len(runas) && runas[j+1] == ' ' {
+			finComando++
+		}
+		if resultado, ok := acentosLaTeX[comando]; ok && len(comando) > 1 {
+			texto.WriteString(resultado)
+			i = finComando
+			continue
+		}
+		k := j + 1
+		for k < len(runas) && (runas[k] == '{' || runas[k] == ' ') {
+			k++
+		}
+		if k < len(runas) {
+			letra := string(runas[k])
+			if k+1 < len(runas) && runas[k] == '\\' {
+				letra = string(runas[k : k+2])
+				k++
+			}
+			if resultado, ok := acentosLaTeX[comando+letra]; ok {
+				texto.WriteString(resultado)
+				i = k
+				continue
+			}
+		}
+		if resultado, ok := acentosLaTeX[comando]; ok {
+			texto.WriteString(resultado)
+		}
+		i = finComando
+	}
+	return strings.Join(strings.Fields(texto.String()), " ")
+}
+
+// escaparLaTeX escapa los caracteres especiales de LaTeX. Los acentos se
+// dejan en UTF-8, que BibLaTeX y los BibTeX actuales leen sin problemas
+func escaparLaTeX(texto string) string {
+	return strings.NewReplacer(
+		`\`, `\textbackslash{}`, `{`, `\{`, `}`, `\}`, `&`, `\&`, `%`, `\%`,
+		`$`, `\$`, `#`, `\#`, `_`, `\_`, `~`, `\textasciitilde{}`, `^`, `\textasciicircum{}`,
+	).Replace(texto)
+}
+
+// claveBibTeX retorna una clave que no esté en usadas para el n-ésimo libro:
+// "libro" y el ID o, si el libro todavía no tiene ID, "isbn" y el ISBN-13 o
+// "entrada" y su posición. Si la clave ya se usó se le agrega un número,
+// como "libro12-2"
+func claveBibTeX(libro Libro, n int, usadas map[string]bool) string {
+	base := fmt.Sprintf("entrada%d", n+1)
+	switch {
+	case libro.ID > 0:
+		base = fmt.Sprintf("libro%d", libro.ID)
+	case libro.ISBN != "":
+		base = "isbn" + libro.ISBN.ISBN13()
+	}
+	clave := base
+	for n := 2; usadas[clave]; n++ {
+		clave = fmt.Sprintf("%s-%d", base, n)
+	}
+	usadas[clave] = true
+	return clave
+}
+
+// CodificarBibTeX escribe libros como entradas @book
+func CodificarBibTeX(w io.Writer, libros []Libro) error {
+	bw := bufio.NewWriter(w)
+	usadas := make(map[string]bool, len(libros))
+	for i, libro := range libros {
+		if i > 0 {
+			bw.WriteString("\n")
+		}
+		fmt.Fprintf(bw, "@book{%s,\n", claveBibTeX(libro, i, usadas))
+		campo := func(nombre, valor string) {
+			if valor != "" {
+				fmt.Fprintf(bw, "  %s = {%s},\n", nombre, valor)
+			}
+		}
+		campo("title", escaparLaTeX(libro.Titulo))
+		campo("author", escaparLaTeX(libro.Autor))
+		if libro.ISBN != "" {
+			campo("isbn", libro.ISBN.String())
+		}
+		if libro.Paginas > 0 {
+			campo("pagetotal", strconv.Itoa(libro.Paginas))
+		}
+		bw.WriteString("}\n")
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("No se pudo escribir el BibTeX: %w", err)
+	}
+	return nil
+}
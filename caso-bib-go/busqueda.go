@@ -0,0 +1,270 @@
+package main
+
+import (
+	"cmp"
+	"math"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// ==========================================
+// BÚSQUEDA DE TEXTO COMPLETO
+// ==========================================
+// El catálogo se busca por palabras ("garcia marquez soledad") con un índice
+// invertido: cada término apunta a los libros que lo contienen y al peso del
+// campo donde aparece. Los términos se normalizan sin mayúsculas ni acentos
+// (García == garcia) y se reducen a una raíz aproximada para que plurales y
+// variantes simples coincidan. Una palabra de la consulta coincide con un
+// término igual o, con menos puntaje, con uno que empiece igual, así que
+// "garc" ya encuentra "García". Un libro aparece en los resultados si
+// coincide con todas las palabras, ordenado de más a menos relevante.
+
+// campoBusqueda es un texto del libro que se indexa, con su peso relativo
+type campoBusqueda struct {
+	texto string
+	peso  float64
+}
+
+// camposBusqueda retorna los textos indexados de un libro. Un campo nuevo
+// (materias, editorial...) se vuelve buscable agregándolo acá
+func camposBusqueda(libro Libro) []campoBusqueda {
+	return []campoBusqueda{
+		{libro.Titulo, 3},
+		{libro.Autor, 2},
+		{strings.Join(libro.Etiquetas, " "), 1},
+	}
+}
+
+const (
+	// largoMinimoPrefijo evita que una o dos letras coincidan con medio
+	// catálogo
+	largoMinimoPrefijo = 3
+	// factorPrefijo reduce el puntaje de una coincidencia por prefijo frente
+	// a una exacta
+	factorPrefijo = 0.5
+)
+
+// indiceBusqueda es el índice invertido del catálogo. No es seguro para uso
+// concurrente: la Biblioteca lo protege con su mutex
+type indiceBusqueda struct {
+	// apariciones guarda, por término, el peso acumulado en cada libro
+	apariciones map[string]map[int]float64
+	// terminosDe recuerda los términos de cada libro para poder reindexarlo
+	terminosDe map[int][]string
+	// vocabulario son los términos ordenados, para buscar por prefijo
+	vocabulario []string
+}
+
+func nuevoIndiceBusqueda() *indiceBusqueda {
+	return &indiceBusqueda{
+		apariciones: make(map[string]map[int]float64),
+		terminosDe:  make(map[int][]string),
+	}
+}
+
+// indexar agrega libro al índice, reemplazando lo que hubiera de él
+func (ix *indiceBusqueda) indexar(libro Libro) {
+	ix.quitar(libro.ID)
+	pesos := make(map[string]float64)
+	for _, campo := range camposBusqueda(libro) {
+		for _, palabra := range tokenizar(campo.texto) {
+			if esVacia(palabra) {
+				continue
+			}
+			pesos[raiz(palabra)] += campo.peso
+		}
+	}
+	terminos := make([]string, 0, len(pesos))
+	for termino, peso := range pesos {
+		libros, ok := ix.apariciones[termino]
+		if !ok {
+			libros = make(map[int]float64)
+			ix.apariciones[termino] = libros
+			posicion, _ := slices.BinarySearch(ix.vocabulario, termino)
+			ix.vocabulario = slices.Insert(ix.vocabulario, posicion, termino)
+		}
+		libros[libro.ID] = peso
+		terminos = append(terminos, termino)
+	}
+	ix.terminosDe[libro.ID] = terminos
+}
+
+// quitar saca del índice el libro con ese ID
+func (ix *indiceBusqueda) quitar(id int) {
+	for _, termino := range ix.terminosDe[id] {
+		libros := ix.apariciones[termino]
+		delete(libros, id)
+		if len(libros) == 0 {
+			delete(ix.apariciones, termino)
+			if posicion, ok := slices.BinarySearch(ix.vocabulario, termino); ok {
+				ix.vocabulario = slices.Delete(ix.vocabulario, posicion, posicion+1)
+			}
+		}
+	}
+	delete(ix.terminosDe, id)
+}
+
+// coincidencia es un libro encontrado y su relevancia para la consulta
+type coincidencia struct {
+	libroID int
+	puntaje float64
+}
+
+// buscar retorna los libros que coinciden con todas las palabras de
+// consulta, de más a menos relevante. Las palabras vacías ("de", "the"...)
+// se ignoran
+func (ix *indiceBusqueda) buscar(consulta string) []coincidencia {
+	var puntajes map[int]float64
+	for _, palabra := range tokenizar(consulta) {
+		if esVacia(palabra) {
+			continue
+		}
+		dePalabra := ix.puntuarPalabra(palabra)
+		if puntajes == nil {
+			puntajes = dePalabra
+			continue
+		}
+		for id, puntaje := range puntajes {
+			if extra, ok := dePalabra[id]; ok {
+				puntajes[id] = puntaje + extra
+			} else {
+				delete(puntajes, id)
+			}
+		}
+	}
+
+	resultados := make([]coincidencia, 0, len(puntajes))
+	for id, puntaje := range puntajes {
+		resultados = append(resultados, coincidencia{libroID: id, puntaje: puntaje})
+	}
+	slices.SortFunc(resultados, func(a, c coincidencia) int {
+		if r := cmp.Compare(c.puntaje, a.puntaje); r != 0 {
+			return r
+		}
+		return cmp.Compare(a.libroID, c.libroID)
+	})
+	return resultados
+}
+
+// puntuarPalabra retorna el puntaje de cada libro que coincide con una
+// palabra de la consulta: el de su mejor término, según el peso del campo,
+// lo raro que es el término en el catálogo y si la coincidencia es exacta
+func (ix *indiceBusqueda) puntuarPalabra(palabra string) map[int]float64 {
+	puntajes := make(map[int]float64)
+	sumar := func(termino string, factor float64) {
+		libros := ix.apariciones[termino]
+		idf := math.Log(1 + float64(len(ix.terminosDe))/float64(len(libros)))
+		for id, peso := range libros {
+			puntajes[id] = max(puntajes[id], peso*idf*factor)
+		}
+	}
+
+	exacto := raiz(palabra)
+	sumar(exacto, 1)
+	if len([]rune(palabra)) < largoMinimoPrefijo {
+		return puntajes
+	}
+	desde, _ := slices.BinarySearch(ix.vocabulario, palabra)
+	for _, termino := range ix.vocabulario[desde:] {
+		if !strings.HasPrefix(termino, palabra) {
+			break
+		}
+		if termino != exacto {
+			sumar(termino, factorPrefijo)
+		}
+	}
+	return puntajes
+}
+
+// ==========================================
+// NORMALIZACIÓN DEL TEXTO
+// ==========================================
+
+// tokenizar separa texto en palabras en minúsculas y sin acentos
+func tokenizar(texto string) []string {
+	return strings.FieldsFunc(plegar(texto), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// plegado reemplaza las letras con diacríticos por su forma sin ellos
+var plegado = strings.NewReplacer(
+	"á", "a", "à", "a", "â", "a", "ä", "a", "ã", "a", "å", "a",
+	"é", "e", "è", "e", "ê", "e", "ë", "e",
+	"í", "i", "ì", "i", "î", "i", "ï", "i",
+	"ó", "o", "ò", "o", "ô", "o", "ö", "o", "õ", "o", "ø", "o",
+	"ú", "u", "ù", "u", "û", "u", "ü", "u",
+	"ñ", "n", "ç", "c", "ý", "y", "ÿ", "y",
+	"æ", "ae", "œ", "oe", "ß", "ss",
+)
+
+// plegar pasa texto a minúsculas y le quita los acentos. Los acentos
+// escritos como marcas combinantes (la forma NFD, "e\u0301") se descartan
+// antes del reemplazo, que solo conoce las letras precompuestas
+func plegar(texto string) string {
+	texto = strings.Map(func(r rune) rune {
+		if unicode.Is(unicode.Mn, r) {
+			return -1
+		}
+		return r
+	}, strings.ToLower(texto))
+	return plegado.Replace(texto)
+}
+
+// palabrasVacias son artículos, preposiciones y conjunciones del español y
+// del inglés, que aparecen en casi todos los títulos y no ayudan a buscar
+var palabrasVacias = map[string]bool{
+	"a": true, "al": true, "con": true, "de": true, "del": true, "el": true,
+	"en": true, "la": true, "las": true, "lo": true, "los": true, "o": true,
+	"para": true, "por": true, "que": true, "se": true, "su": true, "un": true,
+	"una": true, "y": true,
+	"an": true, "and": true, "for": true, "in": true, "of": true, "on": true,
+	"or": true, "the": true, "to": true, "with": true,
+}
+
+func esVacia(palabra string) bool {
+	return palabrasVacias[palabra]
+}
+
+// pluralEnEs indica si base forma el plural agregando "es" entero, sin que
+// la "e" sea parte del singular: "mes", "box", "ley", "church"
+func pluralEnEs(base string) bool {
+	return strings.HasSuffix(base, "ch") || strings.HasSuffix(base, "sh") ||
+		strings.ContainsAny(base[len(base)-1:], "sxzy")
+}
+
+// raiz reduce una palabra ya plegada a una raíz aproximada, quitando los
+// sufijos de plural, género y derivación más comunes del español y del
+// inglés: "novelas" y "novela" quedan en "novel", "stories" en "story". No
+// busca raíces lingüísticamente correctas, solo que las variantes de una
+// palabra coincidan
+func raiz(palabra string) string {
+	largo := len(palabra)
+	switch {
+	case largo <= 3:
+		return palabra
+	case strings.HasSuffix(palabra, "ciones") && largo > 7:
+		palabra = strings.TrimSuffix(palabra, "es")
+	case strings.HasSuffix(palabra, "mente") && largo > 7:
+		palabra = strings.TrimSuffix(palabra, "mente")
+	case strings.HasSuffix(palabra, "ies") && largo > 4:
+		palabra = strings.TrimSuffix(palabra, "ies") + "y"
+	case strings.HasSuffix(palabra, "ing") && largo > 5:
+		palabra = strings.TrimSuffix(palabra, "ing")
+	case strings.HasSuffix(palabra, "es") && largo > 4 && pluralEnEs(palabra[:largo-2]):
+		palabra = strings.TrimSuffix(palabra, "es")
+	case strings.HasSuffix(palabra, "s") && !strings.HasSuffix(palabra, "ss") &&
+		!strings.HasSuffix(palabra, "us") && !strings.HasSuffix(palabra, "is"):
+		// Los demás plurales en "es" pierden solo la "s", igual que en
+		// "games": la regla de la vocal final los lleva a la misma raíz que
+		// el singular, sea "game" o "flor" ("flores")
+		palabra = strings.TrimSuffix(palabra, "s")
+	}
+	// La vocal final distingue género ("amigo", "amiga") o es la "e" muda
+	// del inglés ("stone", que así coincide con "stones")
+	if len(palabra) > 4 && strings.ContainsAny(palabra[len(palabra)-1:], "aoe") {
+		palabra = palabra[:len(palabra)-1]
+	}
+	return palabra
+}
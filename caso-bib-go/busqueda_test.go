@@ -0,0 +1,91 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestPlegar(t *testing.T) {
+	casos := []struct {
+		texto, plegado string
+	}{
+		{"García Márquez", "garcia marquez"},
+		{"Garci\u0301a Ma\u0301rquez", "garcia marquez"},
+		{"ESPAN\u0303A", "espana"},
+		{"Straße", "strasse"},
+		{"N\u0303andu\u0301", "nandu"},
+	}
+	for _, c := range casos {
+		if got := plegar(c.texto); got != c.plegado {
+			t.Errorf("plegar(%q) = %q; se esperaba %q", c.texto, got, c.plegado)
+		}
+	}
+}
+
+func TestBuscarLibrosIgnoraLaFormaDeLosAcentos(t *testing.T) {
+	b, _ := nuevaBibliotecaPrueba(t)
+	compuesto, err := b.AgregarLibro(Sistema, "Cien años de soledad", "Gabriel García Márquez", "", 471)
+	if err != nil {
+		t.Fatal(err)
+	}
+	descompuesto, err := b.AgregarLibro(Sistema, "El amor en los tiempos del co\u0301lera", "Gabriel Garci\u0301a Ma\u0301rquez", "", 348)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := func(libros []Libro) []int {
+		var ids []int
+		for _, libro := range libros {
+			ids = append(ids, libro.ID)
+		}
+		slices.Sort(ids)
+		return ids
+	}
+	want := []int{compuesto.ID, descompuesto.ID}
+	for _, consulta := range []string{"garcia marquez", "García Márquez", "Garci\u0301a Ma\u0301rquez"} {
+		if got := ids(b.BuscarLibros(consulta)); !slices.Equal(got, want) {
+			t.Errorf("BuscarLibros(%q) = %v; se esperaba %v", consulta, got, want)
+		}
+	}
+	if got := ids(b.BuscarLibros("cólera")); !slices.Equal(got, []int{descompuesto.ID}) {
+		t.Errorf("BuscarLibros(\"cólera\") = %v", got)
+	}
+}
+
+func TestRaizIgualEnSingularYPlural(t *testing.T) {
+	pares := [][2]string{
+		{"game", "games"},
+		{"time", "times"},
+		{"name", "names"},
+		{"stone", "stones"},
+		{"hero", "heroes"},
+		{"box", "boxes"},
+		{"church", "churches"},
+		{"story", "stories"},
+		{"novela", "novelas"},
+		{"flor", "flores"},
+		{"ciudad", "ciudades"},
+		{"autor", "autores"},
+		{"mes", "meses"},
+		{"ley", "leyes"},
+		{"cancion", "canciones"},
+		{"amigo", "amigas"},
+	}
+	for _, par := range pares {
+		if singular, plural := raiz(par[0]), raiz(par[1]); singular != plural {
+			t.Errorf("raiz(%q) = %q pero raiz(%q) = %q", par[0], singular, par[1], plural)
+		}
+	}
+}
+
+func TestBuscarLibrosEnSingularEncuentraPlural(t *testing.T) {
+	b, _ := nuevaBibliotecaPrueba(t)
+	libro := agregarLibroPrueba(t, b, "The Hunger Games")
+	agregarLibroPrueba(t, b, "Cien años de soledad")
+	for _, consulta := range []string{"game", "hunger game", "games", "hunger"} {
+		encontrados := b.BuscarLibros(consulta)
+		if len(encontrados) != 1 || encontrados[0].ID != libro.ID {
+			t.Errorf("BuscarLibros(%q) = %+v; se esperaba solo %q", consulta, encontrados, libro.Titulo)
+		}
+	}
+}
@@ -0,0 +1,543 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// ==========================================
+// CALENDARIO DE ATENCIÓN
+// ==========================================
+// Cada sucursal abre ciertos días de la semana y cierra en feriados y por
+// cierres puntuales. Un préstamo no vence en un día cerrado: la fecha de
+// devolución se corre al siguiente día abierto, y los días cerrados no
+// cuentan como atraso.
+//
+// El calendario se carga desde un archivo iCalendar (.ics). Cada VEVENT es
+// una de estas dos cosas:
+//
+//   - con hora y RRULE:FREQ=WEEKLY, el horario de atención de los días de
+//     BYDAY (o del día de DTSTART), desde DTSTART y hasta UNTIL si lo hay
+//   - de día entero (DTSTART;VALUE=DATE), un cierre desde DTSTART hasta el
+//     día anterior a DTEND; con RRULE:FREQ=YEARLY es un feriado que se
+//     repite todos los años. SUMMARY es el motivo
+//
+// Cualquier otro evento se rechaza en vez de ignorarse, para que un
+// calendario mal exportado no cambie los vencimientos sin que se note. Los
+// eventos STATUS:CANCELLED no cuentan. Si el calendario no tiene horarios,
+// todos los días sin cierre están abiertos.
+//
+//	BEGIN:VEVENT
+//	SUMMARY:Atención
+//	DTSTART:20260105T090000
+//	DTEND:20260105T190000
+//	RRULE:FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR
+//	END:VEVENT
+//	BEGIN:VEVENT
+//	SUMMARY:Navidad
+//	DTSTART;VALUE=DATE:20261225
+//	RRULE:FREQ=YEARLY
+//	END:VEVENT
+
+// diasCalendario es hasta dónde se busca un día abierto: un calendario sin
+// ninguno en un año está mal armado y la fecha se deja como estaba
+const diasCalendario = 366
+
+// Franja es el horario de atención de un día de la semana. Abre y Cierra se
+// cuentan desde la medianoche; Desde y Hasta limitan las fechas en que vale
+// y en cero no limitan
+type Franja struct {
+	Dia    time.Weekday  `json:"dia"`
+	Abre   time.Duration `json:"abre"`
+	Cierra time.Duration `json:"cierra"`
+	Desde  time.Time     `json:"desde,omitzero"`
+	Hasta  time.Time     `json:"hasta,omitzero"`
+}
+
+// String muestra la franja como "09:00-19:00"
+func (f Franja) String() string {
+	hora := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return hora(f.Abre) + "-" + hora(f.Cierra)
+}
+
+// vale indica si la franja atiende en fecha
+func (f Franja) vale(fecha time.Time) bool {
+	return fecha.Weekday() == f.Dia && !fecha.Before(f.Desde) && (f.Hasta.IsZero() || !fecha.After(f.Hasta))
+}
+
+// Cierre son uno o más días seguidos, Desde y Hasta inclusive, en que la
+// sucursal no abre. Si es Anual se repite en las mismas fechas cada año
+type Cierre struct {
+	Desde  time.Time `json:"desde"`
+	Hasta  time.Time `json:"hasta"`
+	Motivo string    `json:"motivo,omitempty"`
+	Anual  bool      `json:"anual,omitempty"`
+}
+
+// incluye indica si fecha cae en el cierre. Uno anual que cruza el fin de
+// año se prueba también corrido al año anterior
+func (c Cierre) incluye(fecha time.Time) bool {
+	if !c.Anual {
+		return !fecha.Before(c.Desde) && !fecha.After(c.Hasta)
+	}
+	for _, anio := range []int{fecha.Year(), fecha.Year() - 1} {
+		corrido := Cierre{Desde: c.Desde.AddDate(anio-c.Desde.Year(), 0, 0)}
+		corrido.Hasta = c.Hasta.AddDate(anio-c.Desde.Year(), 0, 0)
+		if corrido.incluye(fecha) {
+			return true
+		}
+	}
+	return false
+}
+
+// Calendario dice qué días atiende una sucursal. Un calendario nil está
+// abierto todos los días
+type Calendario struct {
+	Horario []Franja `json:"horario"`
+	Cierres []Cierre `json:"cierres"`
+}
+
+// DiaCalendario es cómo atiende una sucursal en una fecha
+type DiaCalendario struct {
+	Fecha   time.Time `json:"fecha"`
+	Abierto bool      `json:"abierto"`
+	Horario []string  `json:"horario,omitempty"`
+	Motivo  string    `json:"motivo,omitempty"` // por qué está cerrado
+}
+
+// soloFecha deja de t el día del calendario, a la medianoche en UTC, para
+// comparar fechas sin que importe la zona
+func soloFecha(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// Dia retorna cómo se atiende el día de t
+func (c *Calendario) Dia(t time.Time) DiaCalendario {
+	fecha := soloFecha(t)
+	dia := DiaCalendario{Fecha: fecha, Abierto: true}
+	if c == nil {
+		return dia
+	}
+	for _, cierre := range c.Cierres {
+		if cierre.incluye(fecha) {
+			dia.Abierto = false
+			dia.Motivo = cierre.Motivo
+			if dia.Motivo == "" {
+				dia.Motivo = "cerrado"
+			}
+			return dia
+		}
+	}
+	if len(c.Horario) == 0 {
+		return dia
+	}
+	for _, franja := range c.Horario {
+		if franja.vale(fecha) {
+			dia.Horario = append(dia.Horario, franja.String())
+		}
+	}
+	if len(dia.Horario) == 0 {
+		dia.Abierto = false
+		dia.Motivo = "sin atención"
+	}
+	return dia
+}
+
+// Abierto indica si se atiende el día de t
+func (c *Calendario) Abierto(t time.Time) bool {
+	return c.Dia(t).Abierto
+}
+
+// SiguienteAbierto corre t de a un día, con la misma hora, hasta que caiga
+// en un día abierto. Si no encuentra ninguno en un año retorna t
+func (c *Calendario) SiguienteAbierto(t time.Time) time.Time {
+	for i := range diasCalendario {
+		if dia := t.AddDate(0, 0, i); c.Abierto(dia) {
+			return dia
+		}
+	}
+	return t
+}
+
+// Vencimiento retorna la fecha de devolución de un préstamo de dias días
+// que empieza en desde, corrida al siguiente día abierto
+func (c *Calendario) Vencimiento(desde time.Time, dias int) time.Time {
+	return c.SiguienteAbierto(desde.AddDate(0, 0, dias))
+}
+
+// DiasAtraso es Prestamo.DiasAtraso sin los días cerrados: de cada día de
+// atraso, o fracción, se mira la fecha en que empezó
+func (c *Calendario) DiasAtraso(p Prestamo, hasta time.Time) int {
+	dias := p.DiasAtraso(hasta)
+	if c == nil {
+		return dias
+	}
+	atraso := 0
+	for i := range dias {
+		if c.Abierto(p.FechaDevolucion.AddDate(0, 0, i)) {
+			atraso++
+		}
+	}
+	return atraso
+}
+
+// ==========================================
+// CALENDARIOS DE LA BIBLIOTECA
+// ==========================================
+
+// calendarioDe retorna el calendario de una sucursal: el suyo o, si no
+// tiene, el de la biblioteca
+func (b *Biblioteca) calendarioDe(sucursal string) *Calendario {
+	if calendario, ok := b.Calendarios[sucursal]; ok {
+		return calendario
+	}
+	return b.Calendarios[""]
+}
+
+// diasAtraso cuenta el atraso de un préstamo según el calendario de la
+// sucursal donde se prestó, que es donde se esperaba la devolución
+func (b *Biblioteca) diasAtraso(prestamo Prestamo, hasta time.Time) int {
+	return b.calendarioDe(prestamo.Sucursal).DiasAtraso(prestamo, hasta)
+}
+
+// DiasDeAtencion retorna cómo atiende la sucursal cada día desde la fecha
+// de desde, por dias días. Con sucursal vacía usa el calendario de la
+// biblioteca
+func (b *Biblioteca) DiasDeAtencion(sucursal string, desde time.Time, dias int) ([]DiaCalendario, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	sucursal = strings.TrimSpace(sucursal)
+	if _, ok := b.sucursales[sucursal]; sucursal != "" && !ok {
+		return nil, nuevoError(ErrNoEncontrado, "No existe una sucursal '%s'", sucursal)
+	}
+	if dias <= 0 || dias > diasCalendario {
+		return nil, nuevoError(ErrDatosInvalidos, "La cantidad de días debe estar entre 1 y %d", diasCalendario)
+	}
+	calendario := b.calendarioDe(sucursal)
+	resultado := make([]DiaCalendario, 0, dias)
+	for i := range dias {
+		resultado = append(resultado, calendario.Dia(desde.AddDate(0, 0, i)))
+	}
+	return resultado, nil
+}
+
+// ==========================================
+// ICALENDAR
+// ==========================================
+
+// CargarCalendario lee un calendario desde un archivo .ics
+func CargarCalendario(ruta string) (*Calendario, error) {
+	archivo, err := os.Open(ruta)
+	if err != nil {
+		return nil, fmt.Errorf("No se pudo leer el calendario: %w", err)
+	}
+	defer archivo.Close()
+	calendario, err := LeerCalendario(archivo)
+	if err != nil {
+		return nil, fmt.Errorf("Calendario '%s' no válido: %w", ruta, err)
+	}
+	return calendario, nil
+}
+
+// propiedadICS es una línea de contenido ya desplegada: NOMBRE;PARAM=V:VALOR
+type propiedadICS struct {
+	linea  int
+	nombre string
+	params map[string]string
+	valor  string
+}
+
+// LeerCalendario interpreta un iCalendar. Solo usa los VEVENT; los demás
+// componentes (VTIMEZONE, VTODO...) se saltean
+func LeerCalendario(r io.Reader) (*Calendario, error) {
+	propiedades, err := lineasICS(r)
+	if err != nil {
+		return nil, err
+	}
+	calendario := &Calendario{}
+	var errs []error
+	var evento []propiedadICS
+	dentro := false
+	for _, p := range propiedades {
+		switch {
+		case p.nombre == "BEGIN" && strings.EqualFold(p.valor, "VEVENT"):
+			dentro, evento = true, nil
+		case p.nombre == "END" && strings.EqualFold(p.valor, "VEVENT"):
+			if !dentro {
+				errs = append(errs, fmt.Errorf("línea %d: END:VEVENT sin BEGIN", p.linea))
+				continue
+			}
+			dentro = false
+			if err := calendario.agregarEvento(evento); err != nil {
+				errs = append(errs, err)
+			}
+		case dentro:
+			evento = append(evento, p)
+		}
+	}
+	if dentro {
+		errs = append(errs, errors.New("el último VEVENT no termina"))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return calendario, nil
+}
+
+// lineasICS despliega las líneas continuadas (las que empiezan con espacio
+// o tabulador siguen a la anterior) y separa cada una en sus partes
+func lineasICS(r io.Reader) ([]propiedadICS, error) {
+	var propiedades []propiedadICS
+	var actual strings.Builder
+	inicio, numero := 0, 0
+	cerrar := func() error {
+		if actual.Len() == 0 {
+			return nil
+		}
+		p, err := parsearLineaICS(actual.String())
+		if err != nil {
+			return fmt.Errorf("línea %d: %w", inicio, err)
+		}
+		p.linea = inicio
+		propiedades = append(propiedades, p)
+		actual.Reset()
+		return nil
+	}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		numero++
+		linea := strings.TrimRight(scanner.Text(), "\r")
+		if strings.HasPrefix(linea, " ") || strings.HasPrefix(linea, "\t") {
+			actual.WriteString(linea[1:])
+			continue
+		}
+		if err := cerrar(); err != nil {
+			return nil, err
+		}
+		inicio = numero
+		actual.WriteString(linea)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := cerrar(); err != nil {
+		return nil, err
+	}
+	return propiedades, nil
+}
+
+// parsearLineaICS separa nombre, parámetros y valor. Los dos puntos dentro
+// de un parámetro entre comillas no cortan el valor
+func parsearLineaICS(linea string) (propiedadICS, error) {
+	comillas := false
+	corte := -1
+	for i, r := range linea {
+		if r == '"' {
+			comillas = !comillas
+		} else if r == ':' && !comillas {
+			corte = i
+			break
+		}
+	}
+	if corte < 0 {
+		return propiedadICS{}, fmt.Errorf("falta ':' en '%s'", linea)
+	}
+	partes := strings.Split(linea[:corte], ";")
+	p := propiedadICS{
+		nombre: strings.ToUpper(partes[0]),
+		params: make(map[string]string),
+		valor:  linea[corte+1:],
+	}
+	for _, param := range partes[1:] {
+		clave, valor, _ := strings.Cut(param, "=")
+		p.params[strings.ToUpper(clave)] = strings.Trim(valor, `"`)
+	}
+	return p, nil
+}
+
+// agregarEvento suma al calendario el horario o el cierre que declara un
+// VEVENT
+func (c *Calendario) agregarEvento(propiedades []propiedadICS) error {
+	evento := make(map[string]propiedadICS)
+	linea := 0
+	for _, p := range propiedades {
+		if linea == 0 {
+			linea = p.linea
+		}
+		if _, repetida := evento[p.nombre]; repetida && (p.nombre == "RRULE" || p.nombre == "DTSTART") {
+			return fmt.Errorf("línea %d: %s repetido en el mismo evento", p.linea, p.nombre)
+		}
+		evento[p.nombre] = p
+	}
+	if strings.EqualFold(evento["STATUS"].valor, "CANCELLED") {
+		return nil
+	}
+	falla := func(formato string, args ...any) error {
+		return fmt.Errorf("evento de la línea %d: %s", linea, fmt.Sprintf(formato, args...))
+	}
+	for _, nombre := range []string{"EXDATE", "RDATE", "DURATION"} {
+		if _, ok := evento[nombre]; ok {
+			return falla("%s no está soportado", nombre)
+		}
+	}
+	inicio, ok := evento["DTSTART"]
+	if !ok {
+		return falla("falta DTSTART")
+	}
+	desde, diaEntero, err := fechaICS(inicio)
+	if err != nil {
+		return falla("%v", err)
+	}
+	var hasta time.Time
+	fin, tieneFin := evento["DTEND"]
+	if tieneFin {
+		var finDiaEntero bool
+		if hasta, finDiaEntero, err = fechaICS(fin); err != nil {
+			return falla("%v", err)
+		}
+		if finDiaEntero != diaEntero {
+			return falla("DTSTART y DTEND deben ser los dos fechas o los dos fechas con hora")
+		}
+		if !hasta.After(desde) {
+			return falla("DTEND debe ser posterior a DTSTART")
+		}
+	}
+	regla, err := reglaICS(evento["RRULE"].valor)
+	if err != nil {
+		return falla("%v", err)
+	}
+	motivo := textoICS(evento["SUMMARY"].valor)
+
+	if diaEntero {
+		cierre := Cierre{Desde: soloFecha(desde), Hasta: soloFecha(desde), Motivo: motivo}
+		if tieneFin {
+			// DTEND de un evento de día entero es el primer día después
+			cierre.Hasta = soloFecha(hasta).AddDate(0, 0, -1)
+		}
+		switch regla["FREQ"] {
+		case "":
+		case "YEARLY":
+			if len(regla) > 1 {
+				return falla("un cierre anual solo admite RRULE:FREQ=YEARLY")
+			}
+			cierre.Anual = true
+		default:
+			return falla("un cierre de día entero solo se repite con FREQ=YEARLY, no %s", regla["FREQ"])
+		}
+		c.Cierres = append(c.Cierres, cierre)
+		return nil
+	}
+
+	if regla["FREQ"] != "WEEKLY" {
+		return falla("un evento con hora es un horario y debe tener RRULE:FREQ=WEEKLY")
+	}
+	if !tieneFin {
+		return falla("un horario necesita DTEND")
+	}
+	if soloFecha(hasta) != soloFecha(desde) {
+		return falla("un horario debe terminar el mismo día en que empieza")
+	}
+	for clave, valor := range regla {
+		switch {
+		case clave == "FREQ", clave == "BYDAY", clave == "UNTIL", clave == "WKST":
+		case clave == "INTERVAL" && valor == "1":
+		default:
+			return falla("%s=%s no está soportado en un horario", clave, valor)
+		}
+	}
+	base := Franja{
+		Abre:   desde.Sub(time.Date(desde.Year(), desde.Month(), desde.Day(), 0, 0, 0, 0, desde.Location())),
+		Cierra: hasta.Sub(time.Date(hasta.Year(), hasta.Month(), hasta.Day(), 0, 0, 0, 0, hasta.Location())),
+		Desde:  soloFecha(desde),
+	}
+	if texto := regla["UNTIL"]; texto != "" {
+		limite, _, err := fechaICS(propiedadICS{valor: texto})
+		if err != nil {
+			return falla("UNTIL: %v", err)
+		}
+		base.Hasta = soloFecha(limite)
+	}
+	dias := []time.Weekday{desde.Weekday()}
+	if texto := regla["BYDAY"]; texto != "" {
+		dias = nil
+		for _, codigo := range strings.Split(texto, ",") {
+			dia, ok := diasICS[codigo]
+			if !ok {
+				return falla("día '%s' no válido en BYDAY", codigo)
+			}
+			dias = append(dias, dia)
+		}
+	}
+	for _, dia := range dias {
+		franja := base
+		franja.Dia = dia
+		c.Horario = append(c.Horario, franja)
+	}
+	return nil
+}
+
+var diasICS = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+// reglaICS separa una RRULE en sus partes, con las claves en mayúsculas
+func reglaICS(texto string) (map[string]string, error) {
+	regla := make(map[string]string)
+	if texto == "" {
+		return regla, nil
+	}
+	for _, parte := range strings.Split(texto, ";") {
+		clave, valor, ok := strings.Cut(parte, "=")
+		if !ok {
+			return nil, fmt.Errorf("RRULE no válida '%s'", texto)
+		}
+		regla[strings.ToUpper(clave)] = strings.ToUpper(valor)
+	}
+	return regla, nil
+}
+
+// fechaICS interpreta una fecha (AAAAMMDD) o fecha con hora
+// (AAAAMMDDTHHMMSS, en UTC si termina en Z o en la zona de TZID) e indica
+// si era solo fecha. Las horas sin zona son locales
+func fechaICS(p propiedadICS) (time.Time, bool, error) {
+	if len(p.valor) == 8 || p.params["VALUE"] == "DATE" {
+		fecha, err := time.ParseInLocation("20060102", p.valor, time.Local)
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("fecha '%s' no válida", p.valor)
+		}
+		return fecha, true, nil
+	}
+	zona := time.Local
+	if tzid := p.params["TZID"]; tzid != "" {
+		var err error
+		if zona, err = time.LoadLocation(tzid); err != nil {
+			return time.Time{}, false, fmt.Errorf("zona horaria '%s' desconocida", tzid)
+		}
+	}
+	if texto, ok := strings.CutSuffix(p.valor, "Z"); ok {
+		fecha, err := time.ParseInLocation("20060102T150405", texto, time.UTC)
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("fecha y hora '%s' no válida", p.valor)
+		}
+		return fecha.In(time.Local), false, nil
+	}
+	fecha, err := time.ParseInLocation("20060102T150405", p.valor, zona)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("fecha y hora '%s' no válida", p.valor)
+	}
+	return fecha, false, nil
+}
+
+// textoICS quita los escapes de un valor de texto
+func textoICS(valor string) string {
+	return strings.NewReplacer(`\n`, " ", `\N`, " ", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(valor)
+}
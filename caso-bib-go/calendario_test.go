@@ -0,0 +1,167 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// calendarioPrueba abre de lunes a viernes todo el año y los sábados hasta
+// marzo, cierra el 25 de mayo de cada año, del 24 de diciembre al 1 de enero
+// de cada año y del 6 al 8 de julio de 2026. Las líneas largas vienen
+// plegadas como las exportan los calendarios
+const calendarioPrueba = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"SUMMARY:Atención\r\n" +
+	"DTSTART:20260105T090000\r\n" +
+	"DTEND:20260105T190000\r\n" +
+	"RRULE:FREQ=WEE\r\n" +
+	" KLY;BYDAY=MO,TU,WE,TH,FR\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"SUMMARY:Sábados de verano\r\n" +
+	"DTSTART:20260103T100000\r\n" +
+	"DTEND:20260103T130000\r\n" +
+	"RRULE:FREQ=WEEKLY;BYDAY=SA;UNTIL=20260331\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"SUMMARY:Revolución\r\n" +
+	"\t de Mayo\r\n" +
+	"DTSTART;VALUE=DATE:20250525\r\n" +
+	"RRULE:FREQ=YEARLY\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"SUMMARY:Receso de fin de año\r\n" +
+	"DTSTART;VALUE=DATE:20251224\r\n" +
+	"DTEND;VALUE=DATE:20260102\r\n" +
+	"RRULE:FREQ=YEARLY\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"SUMMARY:Inventario\\, sala\r\n" +
+	"  general\r\n" +
+	"DTSTART;VALUE=DATE:20260706\r\n" +
+	"DTEND;VALUE=DATE:20260709\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"SUMMARY:Suspendido\r\n" +
+	"STATUS:CANCELLED\r\n" +
+	"DTSTART;VALUE=DATE:20260415\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func leerCalendarioPrueba(t *testing.T) *Calendario {
+	t.Helper()
+	calendario, err := LeerCalendario(strings.NewReader(calendarioPrueba))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return calendario
+}
+
+func fecha(anio int, mes time.Month, dia int) time.Time {
+	return time.Date(anio, mes, dia, 10, 0, 0, 0, time.Local)
+}
+
+func TestLeerCalendario(t *testing.T) {
+	calendario := leerCalendarioPrueba(t)
+	// Cinco días de semana, los sábados y tres cierres: el cancelado no
+	// cuenta
+	if len(calendario.Horario) != 6 || len(calendario.Cierres) != 3 {
+		t.Fatalf("horario %v, cierres %+v", calendario.Horario, calendario.Cierres)
+	}
+	casos := []struct {
+		fecha   time.Time
+		abierto bool
+		motivo  string
+	}{
+		{fecha(2026, time.May, 22), true, ""},
+		// Los sábados atienden hasta el último de marzo, UNTIL incluido
+		{fecha(2026, time.March, 28), true, ""},
+		{fecha(2026, time.April, 4), false, "sin atención"},
+		{fecha(2026, time.May, 24), false, "sin atención"},
+		// El plegado puede partir una palabra: se une sin el espacio inicial
+		{fecha(2026, time.May, 25), false, "Revolución de Mayo"},
+		{fecha(2027, time.May, 25), false, "Revolución de Mayo"},
+		{fecha(2026, time.July, 6), false, "Inventario, sala general"},
+		{fecha(2026, time.July, 8), false, "Inventario, sala general"},
+		// DTEND de un día entero es el primer día que no cierra
+		{fecha(2026, time.July, 9), true, ""},
+		// El receso anual cruza el fin de año, también en los años que siguen
+		{fecha(2026, time.January, 1), false, "Receso de fin de año"},
+		{fecha(2027, time.January, 4), true, ""},
+		{fecha(2027, time.December, 24), false, "Receso de fin de año"},
+		{fecha(2028, time.January, 1), false, "Receso de fin de año"},
+		{fecha(2026, time.April, 15), true, ""},
+	}
+	for _, caso := range casos {
+		dia := calendario.Dia(caso.fecha)
+		if dia.Abierto != caso.abierto || dia.Motivo != caso.motivo {
+			t.Errorf("Dia(%s) = %+v; se esperaba abierto %v, motivo %q",
+				caso.fecha.Format(time.DateOnly), dia, caso.abierto, caso.motivo)
+		}
+	}
+	if dia := calendario.Dia(fecha(2026, time.March, 7)); len(dia.Horario) != 1 || dia.Horario[0] != "10:00-13:00" {
+		t.Errorf("horario del sábado = %v", dia.Horario)
+	}
+}
+
+func TestLeerCalendarioRechazaLoQueNoEntiende(t *testing.T) {
+	evento := func(lineas ...string) string {
+		return "BEGIN:VEVENT\n" + strings.Join(lineas, "\n") + "\nEND:VEVENT\n"
+	}
+	for nombre, ics := range map[string]string{
+		"horario sin RRULE":     evento("DTSTART:20260105T090000", "DTEND:20260105T190000"),
+		"cierre mensual":        evento("DTSTART;VALUE=DATE:20260105", "RRULE:FREQ=MONTHLY"),
+		"EXDATE":                evento("DTSTART;VALUE=DATE:20260105", "EXDATE;VALUE=DATE:20260105"),
+		"día desconocido":       evento("DTSTART:20260105T090000", "DTEND:20260105T190000", "RRULE:FREQ=WEEKLY;BYDAY=XX"),
+		"DTEND igual al inicio": evento("DTSTART;VALUE=DATE:20260105", "DTEND;VALUE=DATE:20260105"),
+		"VEVENT sin cerrar":     "BEGIN:VEVENT\nDTSTART;VALUE=DATE:20260105\n",
+	} {
+		if _, err := LeerCalendario(strings.NewReader(ics)); err == nil {
+			t.Errorf("%s: LeerCalendario no retornó error", nombre)
+		}
+	}
+}
+
+func TestVencimientoYAtrasoSaltanDiasCerrados(t *testing.T) {
+	calendario := leerCalendarioPrueba(t)
+	// 14 días desde el sábado 9 de mayo caen el sábado 23: sin atención el
+	// fin de semana y feriado el lunes 25, vence el martes 26
+	if got := calendario.Vencimiento(fecha(2026, time.May, 9), 14); !got.Equal(fecha(2026, time.May, 26)) {
+		t.Errorf("Vencimiento = %s, se esperaba el martes 26 de mayo", got)
+	}
+	// En marzo el sábado atiende y no se corre
+	if got := calendario.Vencimiento(fecha(2026, time.March, 7), 14); !got.Equal(fecha(2026, time.March, 21)) {
+		t.Errorf("Vencimiento = %s, se esperaba el sábado 21 de marzo", got)
+	}
+
+	b, _ := nuevaBibliotecaPrueba(t)
+	b.Calendarios = map[string]*Calendario{"": calendario}
+	reloj := NuevoRelojFijo(fecha(2026, time.May, 9))
+	b.Reloj = reloj
+	libro := agregarLibroPrueba(t, b, "Rayuela")
+	ana := registrarUsuarioPrueba(t, b, "ana")
+	prestamo, err := b.PrestarLibro(Sistema, libro.ID, ana.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !prestamo.FechaDevolucion.Equal(fecha(2026, time.May, 26)) {
+		t.Fatalf("FechaDevolucion = %s, se esperaba el martes 26 de mayo", prestamo.FechaDevolucion)
+	}
+
+	// Devuelto el lunes 1 de junio: de seis días de atraso, el sábado y el
+	// domingo no cuentan. Con un día de gracia se cobran tres a 0.50
+	reloj.Avanzar(fecha(2026, time.June, 1).Sub(fecha(2026, time.May, 9)))
+	if dias := calendario.DiasAtraso(*prestamo, reloj.Ahora()); dias != 4 {
+		t.Errorf("DiasAtraso = %d, se esperaban 4", dias)
+	}
+	devuelto, err := b.DevolverLibro(Sistema, libro.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if devuelto.Multa != 1.5 {
+		t.Errorf("Multa = %.2f, se esperaba 1.50", devuelto.Multa)
+	}
+	verificar(t, b)
+}
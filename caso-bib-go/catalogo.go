@@ -0,0 +1,432 @@
+package main
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ==========================================
+// CONSULTA DEL CATÁLOGO
+// ==========================================
+// ConsultarLibros es la consulta del catálogo web: combina la búsqueda de
+// texto con filtros por autor, disponibilidad, páginas, ISBN y etiquetas,
+// ordena por uno o más campos y entrega los resultados por páginas.
+//
+// Las páginas se piden con un cursor y no con un número de página: el
+// cursor guarda la clave de orden del último libro entregado, y la página
+// siguiente empieza en el primer libro posterior a esa clave. Así un libro
+// agregado o retirado mientras alguien recorre el catálogo no hace que se
+// repitan ni se salteen los demás resultados. Para que la clave sea única
+// el ID se agrega siempre como último criterio.
+//
+// Esto vale mientras no cambie la clave de un libro durante el recorrido.
+// Ordenando por disponibles o por relevancia no está garantizado: un
+// préstamo, una devolución o una edición puede pasar un libro al otro lado
+// del cursor, y ese libro aparece dos veces o ninguna.
+
+// CampoOrden es un campo por el que se ordena el catálogo
+type CampoOrden string
+
+const (
+	OrdenID          CampoOrden = "id"
+	OrdenTitulo      CampoOrden = "titulo"
+	OrdenAutor       CampoOrden = "autor"
+	OrdenPaginas     CampoOrden = "paginas"
+	OrdenDisponibles CampoOrden = "disponibles"
+	// OrdenRelevancia solo tiene sentido con una búsqueda de texto
+	OrdenRelevancia CampoOrden = "relevancia"
+)
+
+// CriterioOrden es un campo de orden y su sentido
+type CriterioOrden struct {
+	Campo       CampoOrden
+	Descendente bool
+}
+
+// FiltroLibros elige, ordena y pagina los libros de ConsultarLibros. Los
+// campos en cero no filtran
+type FiltroLibros struct {
+	// Texto busca por palabras como BuscarLibros
+	Texto string
+	// Autor deja los libros cuyo autor contiene el texto, sin distinguir
+	// mayúsculas ni acentos
+	Autor string
+	// Disponible deja los libros con (true) o sin (false) algún ejemplar
+	// disponible
+	Disponible *bool
+	// PaginasMin y PaginasMax acotan la cantidad de páginas, incluidas
+	PaginasMin, PaginasMax int
+	// Grande deja los libros extensos (true) o los que no lo son (false),
+	// según Libro.EsGrande
+	Grande *bool
+	// PrefijoISBN deja los libros cuyo ISBN-13 o ISBN-10 empieza así; se
+	// ignoran guiones y espacios
+	PrefijoISBN string
+	// Etiquetas deja los libros que tienen todas estas etiquetas
+	Etiquetas []string
+
+	// Orden son los criterios de orden, del más al menos importante. Sin
+	// orden, una búsqueda de texto se ordena por relevancia y el resto por ID
+	Orden []CriterioOrden
+	// Limite es el tamaño de la página; 0 retorna todos los resultados
+	Limite int
+	// Cursor es el Siguiente de la página anterior
+	Cursor string
+}
+
+// PaginaLibros es una página de resultados
+type PaginaLibros struct {
+	Libros []Libro `json:"libros"`
+	// Total cuenta los libros que cumplen el filtro, en todas las páginas
+	Total int `json:"total"`
+	// Siguiente es el cursor de la página siguiente; vacío en la última
+	Siguiente string `json:"siguiente,omitempty"`
+}
+
+// claveLibro son los valores de un libro por los que se puede ordenar.
+// Título y autor van plegados, como se comparan
+type claveLibro struct {
+	ID          int     `json:"id"`
+	Titulo      string  `json:"t,omitempty"`
+	Autor       string  `json:"a,omitempty"`
+	Paginas     int     `json:"p,omitempty"`
+	Disponibles int     `json:"d,omitempty"`
+	Relevancia  float64 `json:"r,omitempty"`
+}
+
+// cursorLibros es el contenido de un cursor. Orden evita usar un cursor con
+// otro orden que el de la consulta que lo generó
+type cursorLibros struct {
+	Orden string     `json:"o"`
+	Clave claveLibro `json:"c"`
+}
+
+// ConsultarLibros retorna la página de libros que pide filtro
+func (b *Biblioteca) ConsultarLibros(filtro FiltroLibros) (*PaginaLibros, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	orden, err := ordenConsulta(filtro)
+	if err != nil {
+		return nil, err
+	}
+	if filtro.Limite < 0 {
+		return nil, nuevoError(ErrDatosInvalidos, "El límite no puede ser negativo")
+	}
+	var desde *claveLibro
+	if filtro.Cursor != "" {
+		cursor, err := leerCursor(filtro.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		if cursor.Orden != textoOrden(orden) {
+			return nil, nuevoError(ErrDatosInvalidos, "El cursor es de una consulta con otro orden")
+		}
+		desde = &cursor.Clave
+	}
+
+	type candidato struct {
+		libro Libro
+		clave claveLibro
+	}
+	var candidatos []candidato
+	agregar := func(libro Libro, relevancia float64) {
+		d := b.disponibilidad(libro.ID)
+		if !filtro.acepta(libro, d) {
+			return
+		}
+		candidatos = append(candidatos, candidato{libro, claveLibro{
+			ID:          libro.ID,
+			Titulo:      plegar(libro.Titulo),
+			Autor:       plegar(libro.Autor),
+			Paginas:     libro.Paginas,
+			Disponibles: d.Disponibles,
+			Relevancia:  relevancia,
+		}})
+	}
+	if strings.TrimSpace(filtro.Texto) != "" {
+		for _, c := range b.busqueda.buscar(filtro.Texto) {
+			if libro, ok := b.libros.PorID(c.libroID); ok && !libro.Retirado {
+				agregar(libro, c.puntaje)
+			}
+		}
+	} else {
+		for _, libro := range b.librosEnCatalogo() {
+			agregar(libro, 0)
+		}
+	}
+
+	slices.SortFunc(candidatos, func(x, y candidato) int {
+		return compararClaves(orden, x.clave, y.clave)
+	})
+	inicio := 0
+	if desde != nil {
+		inicio, _ = slices.BinarySearchFunc(candidatos, *desde, func(c candidato, clave claveLibro) int {
+			// El primero estrictamente posterior al cursor
+			if compararClaves(orden, c.clave, clave) <= 0 {
+				return -1
+			}
+			return 1
+		})
+	}
+	fin := len(candidatos)
+	if filtro.Limite > 0 {
+		fin = min(fin, inicio+filtro.Limite)
+	}
+
+	pagina := &PaginaLibros{Libros: make([]Libro, 0, fin-inicio), Total: len(candidatos)}
+	for _, c := range candidatos[inicio:fin] {
+		pagina.Libros = append(pagina.Libros, c.libro)
+	}
+	if fin < len(candidatos) {
+		pagina.Siguiente = escribirCursor(cursorLibros{Orden: textoOrden(orden), Clave: candidatos[fin-1].clave})
+	}
+	return pagina, nil
+}
+
+// acepta aplica los filtros de f a un libro y su disponibilidad
+func (f FiltroLibros) acepta(libro Libro, d Disponibilidad) bool {
+	if f.Autor != "" && !strings.Contains(plegar(libro.Autor), plegar(strings.TrimSpace(f.Autor))) {
+		return false
+	}
+	if f.Disponible != nil && *f.Disponible != (d.Disponibles > 0) {
+		return false
+	}
+	if f.PaginasMin > 0 && libro.Paginas < f.PaginasMin {
+		return false
+	}
+	if f.PaginasMax > 0 && libro.Paginas > f.PaginasMax {
+		return false
+	}
+	if f.Grande != nil && *f.Grande != libro.EsGrande() {
+		return false
+	}
+	if f.PrefijoISBN != "" && !tienePrefijoISBN(libro.ISBN, f.PrefijoISBN) {
+		return false
+	}
+	for _, etiqueta := range f.Etiquetas {
+		if !libro.TieneEtiqueta(etiqueta) {
+			return false
+		}
+	}
+	return true
+}
+
+func tienePrefijoISBN(isbn ISBN, prefijo string) bool {
+	if isbn == "" {
+		return false
+	}
+	prefijo = strings.ToUpper(prefijo)
+	prefijo = strings.TrimPrefix(strings.TrimSpace(prefijo), "ISBN")
+	prefijo = strings.NewReplacer("-", "", " ", "", ":", "").Replace(prefijo)
+	if strings.HasPrefix(isbn.ISBN13(), prefijo) {
+		return true
+	}
+	isbn10, ok := isbn.ISBN10()
+	return ok && strings.HasPrefix(isbn10, prefijo)
+}
+
+// ordenConsulta valida el orden del filtro, le pone el orden por defecto si
+// no tiene y le agrega el ID como último criterio
+func ordenConsulta(filtro FiltroLibros) ([]CriterioOrden, error) {
+	busca := strings.TrimSpace(filtro.Texto) != ""
+	orden := slices.Clone(filtro.Orden)
+	if len(orden) == 0 && busca {
+		orden = []CriterioOrden{{Campo: OrdenRelevancia, Descendente: true}}
+	}
+	for _, criterio := range orden {
+		switch criterio.Campo {
+		case OrdenID, OrdenTitulo, OrdenAutor, OrdenPaginas, OrdenDisponibles:
+		case OrdenRelevancia:
+			if !busca {
+				return nil, nuevoError(ErrDatosInvalidos, "Solo se ordena por relevancia al buscar un texto")
+			}
+		default:
+			return nil, nuevoError(ErrDatosInvalidos,
+				"No se puede ordenar por '%s': use id, titulo, autor, paginas, disponibles o relevancia", criterio.Campo)
+		}
+	}
+	if !slices.ContainsFunc(orden, func(c CriterioOrden) bool { return c.Campo == OrdenID }) {
+		orden = append(orden, CriterioOrden{Campo: OrdenID})
+	}
+	return orden, nil
+}
+
+func compararClaves(orden []CriterioOrden, x, y claveLibro) int {
+	for _, criterio := range orden {
+		var r int
+		switch criterio.Campo {
+		case OrdenID:
+			r = cmp.Compare(x.ID, y.ID)
+		case OrdenTitulo:
+			r = strings.Compare(x.Titulo, y.Titulo)
+		case OrdenAutor:
+			r = strings.Compare(x.Autor, y.Autor)
+		case OrdenPaginas:
+			r = cmp.Compare(x.Paginas, y.Paginas)
+		case OrdenDisponibles:
+			r = cmp.Compare(x.Disponibles, y.Disponibles)
+		case OrdenRelevancia:
+			r = cmp.Compare(x.Relevancia, y.Relevancia)
+		}
+		if criterio.Descendente {
+			r = -r
+		}
+		if r != 0 {
+			return r
+		}
+	}
+	return 0
+}
+
+// ParsearOrden interpreta una lista de campos separados por comas, como
+// "autor,-paginas". Un "-" adelante ordena de mayor a menor
+func ParsearOrden(texto string) ([]CriterioOrden, error) {
+	var orden []CriterioOrden
+	for _, campo := range strings.Split(texto, ",") {
+		campo = strings.TrimSpace(campo)
+		if campo == "" {
+			continue
+		}
+		criterio := CriterioOrden{}
+		if resto, ok := strings.CutPrefix(campo, "-"); ok {
+			criterio.Descendente, campo = true, resto
+		}
+		criterio.Campo = CampoOrden(strings.ToLower(campo))
+		if slices.ContainsFunc(orden, func(c CriterioOrden) bool { return c.Campo == criterio.Campo }) {
+			return nil, nuevoError(ErrDatosInvalidos, "El campo '%s' aparece dos veces en el orden", campo)
+		}
+		orden = append(orden, criterio)
+	}
+	return orden, nil
+}
+
+// textoOrden escribe orden como lo lee ParsearOrden
+func textoOrden(orden []CriterioOrden) string {
+	campos := make([]string, len(orden))
+	for i, criterio := range orden {
+		campos[i] = string(criterio.Campo)
+		if criterio.Descendente {
+			campos[i] = "-" + campos[i]
+		}
+	}
+	return strings.Join(campos, ",")
+}
+
+// El cursor es JSON en base64 apto para URLs. No es secreto ni está
+// firmado: uno alterado solo puede dar otra página de la misma consulta
+
+func escribirCursor(cursor cursorLibros) string {
+	datos, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(datos)
+}
+
+func leerCursor(texto string) (cursorLibros, error) {
+	var cursor cursorLibros
+	datos, err := base64.RawURLEncoding.DecodeString(texto)
+	if err != nil || json.Unmarshal(datos, &cursor) != nil {
+		return cursorLibros{}, nuevoError(ErrDatosInvalidos, "Cursor no válido")
+	}
+	return cursor, nil
+}
+
+// filtroLibrosDe arma un filtro con los parámetros de texto que usan la API
+// y la línea de comandos: q, autor, disponible y grande (true o false),
+// paginas_min, paginas_max, isbn_prefijo, etiquetas (separadas por comas),
+// orden (ver ParsearOrden), limite y cursor
+func filtroLibrosDe(valor func(clave string) string) (FiltroLibros, error) {
+	filtro := FiltroLibros{
+		Texto:       valor("q"),
+		Autor:       valor("autor"),
+		PrefijoISBN: valor("isbn_prefijo"),
+		Etiquetas:   separarEtiquetas(valor("etiquetas")),
+		Cursor:      valor("cursor"),
+	}
+	for clave, destino := range map[string]**bool{"disponible": &filtro.Disponible, "grande": &filtro.Grande} {
+		if texto := valor(clave); texto != "" {
+			si, err := strconv.ParseBool(texto)
+			if err != nil {
+				return FiltroLibros{}, nuevoError(ErrDatosInvalidos, "El valor de %s debe ser true o false, no '%s'", clave, texto)
+			}
+			*destino = &si
+		}
+	}
+	for clave, destino := range map[string]*int{
+		"paginas_min": &filtro.PaginasMin, "paginas_max": &filtro.PaginasMax, "limite": &filtro.Limite,
+	} {
+		if texto := valor(clave); texto != "" {
+			numero, err := strconv.Atoi(texto)
+			if err != nil || numero < 0 {
+				return FiltroLibros{}, nuevoError(ErrDatosInvalidos, "El valor de %s debe ser un número, no '%s'", clave, texto)
+			}
+			*destino = numero
+		}
+	}
+	orden, err := ParsearOrden(valor("orden"))
+	if err != nil {
+		return FiltroLibros{}, err
+	}
+	filtro.Orden = orden
+	return filtro, nil
+}
+
+// ==========================================
+// ETIQUETAS
+// ==========================================
+// Las etiquetas son palabras libres para agrupar libros ("infantil",
+// "club de lectura"). Se guardan en minúsculas, sin repetir y ordenadas, y
+// se comparan sin acentos.
+
+// TieneEtiqueta indica si el libro tiene la etiqueta
+func (l Libro) TieneEtiqueta(etiqueta string) bool {
+	buscada := plegar(strings.TrimSpace(etiqueta))
+	return slices.ContainsFunc(l.Etiquetas, func(e string) bool { return plegar(e) == buscada })
+}
+
+// normalizarEtiquetas deja las etiquetas como se guardan
+func normalizarEtiquetas(etiquetas []string) []string {
+	normalizadas := make([]string, 0, len(etiquetas))
+	for _, etiqueta := range etiquetas {
+		etiqueta = strings.Join(strings.Fields(strings.ToLower(etiqueta)), " ")
+		if etiqueta == "" || slices.ContainsFunc(normalizadas, func(e string) bool { return plegar(e) == plegar(etiqueta) }) {
+			continue
+		}
+		normalizadas = append(normalizadas, etiqueta)
+	}
+	slices.Sort(normalizadas)
+	if len(normalizadas) == 0 {
+		return nil
+	}
+	return normalizadas
+}
+
+// separarEtiquetas separa una lista de etiquetas escrita con comas
+func separarEtiquetas(texto string) []string {
+	if strings.TrimSpace(texto) == "" {
+		return nil
+	}
+	return normalizarEtiquetas(strings.Split(texto, ","))
+}
+
+// EtiquetarLibro reemplaza las etiquetas de un libro
+func (b *Biblioteca) EtiquetarLibro(actor Actor, id int, etiquetas []string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.autorizar(actor, PermisoCatalogo, 0); err != nil {
+		return err
+	}
+
+	tx := b.iniciar(actor, EventoLibroEtiquetado)
+	libro, ok := tx.libro(id)
+	if !ok {
+		return nuevoError(ErrNoEncontrado, "No existe un libro con ID '%d'", id)
+	}
+	libro.Etiquetas = normalizarEtiquetas(etiquetas)
+	tx.guardarLibro(libro)
+	return tx.confirmar()
+}
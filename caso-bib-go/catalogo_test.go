@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+// catalogoPrueba agrega libros con autores y páginas repetidos, para que el
+// orden necesite desempatar
+func catalogoPrueba(t *testing.T, b *Biblioteca) {
+	t.Helper()
+	for _, libro := range []struct {
+		titulo, autor string
+		paginas       int
+	}{
+		{"Rayuela", "Julio Cortázar", 600},
+		{"Ficciones", "Jorge Luis Borges", 200},
+		{"El Aleph", "Jorge Luis Borges", 200},
+		{"Bestiario", "Julio Cortázar", 150},
+		{"Pedro Páramo", "Juan Rulfo", 130},
+		{"El llano en llamas", "Juan Rulfo", 170},
+		{"Historia universal de la infamia", "Jorge Luis Borges", 140},
+	} {
+		if _, err := b.AgregarLibro(Sistema, libro.titulo, libro.autor, "", libro.paginas); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// recorrerCatalogo pide todas las páginas de filtro y retorna los IDs en el
+// orden en que llegaron. antes se llama antes de pedir cada página
+func recorrerCatalogo(t *testing.T, b *Biblioteca, filtro FiltroLibros, antes func(pagina int)) []int {
+	t.Helper()
+	var ids []int
+	for pagina := 0; ; pagina++ {
+		if antes != nil {
+			antes(pagina)
+		}
+		resultado, err := b.ConsultarLibros(filtro)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resultado.Libros) > filtro.Limite {
+			t.Fatalf("página de %d libros con límite %d", len(resultado.Libros), filtro.Limite)
+		}
+		ids = append(ids, idsLibros(resultado.Libros)...)
+		if resultado.Siguiente == "" {
+			return ids
+		}
+		filtro.Cursor = resultado.Siguiente
+	}
+}
+
+func idsLibros(libros []Libro) []int {
+	return idsDe(libros, func(l Libro) int { return l.ID })
+}
+
+func titulosDe(b *Biblioteca, ids []int) []string {
+	titulos := make([]string, len(ids))
+	for i, id := range ids {
+		titulos[i] = b.BuscarLibro(id).Titulo
+	}
+	return titulos
+}
+
+func TestConsultarLibrosOrdenPorVariosCampos(t *testing.T) {
+	b, _ := nuevaBibliotecaPrueba(t)
+	catalogoPrueba(t, b)
+	orden, err := ParsearOrden("autor,-paginas")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pagina, err := b.ConsultarLibros(FiltroLibros{Orden: orden})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Ficciones y El Aleph empatan en autor y páginas: desempata el ID
+	want := []string{
+		"Ficciones", "El Aleph", "Historia universal de la infamia",
+		"El llano en llamas", "Pedro Páramo",
+		"Rayuela", "Bestiario",
+	}
+	if got := titulosDe(b, idsLibros(pagina.Libros)); !slices.Equal(got, want) {
+		t.Errorf("orden autor,-paginas = %v, se esperaba %v", got, want)
+	}
+	if pagina.Total != 7 || pagina.Siguiente != "" {
+		t.Errorf("Total %d, Siguiente %q sin límite", pagina.Total, pagina.Siguiente)
+	}
+
+	if _, err := ParsearOrden("autor,-autor"); !errors.Is(err, ErrDatosInvalidos) {
+		t.Errorf("campo repetido: err = %v, se esperaba ErrDatosInvalidos", err)
+	}
+	for _, orden := range [][]CriterioOrden{{{Campo: "editorial"}}, {{Campo: OrdenRelevancia}}} {
+		if _, err := b.ConsultarLibros(FiltroLibros{Orden: orden}); !errors.Is(err, ErrDatosInvalidos) {
+			t.Errorf("orden %v: err = %v, se esperaba ErrDatosInvalidos", orden, err)
+		}
+	}
+}
+
+func TestConsultarLibrosRecorrePorCursor(t *testing.T) {
+	b, _ := nuevaBibliotecaPrueba(t)
+	catalogoPrueba(t, b)
+	for _, texto := range []string{"id", "titulo", "-paginas,titulo", "autor,-paginas"} {
+		orden, err := ParsearOrden(texto)
+		if err != nil {
+			t.Fatal(err)
+		}
+		todos, err := b.ConsultarLibros(FiltroLibros{Orden: orden})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, limite := range []int{1, 2, 3, 7} {
+			got := recorrerCatalogo(t, b, FiltroLibros{Orden: orden, Limite: limite}, nil)
+			if want := idsLibros(todos.Libros); !slices.Equal(got, want) {
+				t.Errorf("orden %s, de a %d: %v; se esperaba %v", texto, limite, got, want)
+			}
+		}
+	}
+
+	// Un cursor no sirve para otro orden
+	pagina, err := b.ConsultarLibros(FiltroLibros{Limite: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	otroOrden := FiltroLibros{Orden: []CriterioOrden{{Campo: OrdenTitulo}}, Limite: 2, Cursor: pagina.Siguiente}
+	if _, err := b.ConsultarLibros(otroOrden); !errors.Is(err, ErrDatosInvalidos) {
+		t.Errorf("cursor con otro orden: err = %v, se esperaba ErrDatosInvalidos", err)
+	}
+	if _, err := b.ConsultarLibros(FiltroLibros{Cursor: "no es un cursor"}); !errors.Is(err, ErrDatosInvalidos) {
+		t.Errorf("cursor alterado: err = %v, se esperaba ErrDatosInvalidos", err)
+	}
+}
+
+func TestConsultarLibrosCursorConCambiosEntrePaginas(t *testing.T) {
+	b, _ := nuevaBibliotecaPrueba(t)
+	catalogoPrueba(t, b)
+	orden := []CriterioOrden{{Campo: OrdenTitulo}}
+	antes, err := b.ConsultarLibros(FiltroLibros{Orden: orden})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ana := registrarUsuarioPrueba(t, b, "ana")
+
+	// Entre página y página se agrega un libro que va antes del cursor, se
+	// presta otro y se retira uno que todavía no salió
+	var agregado, retirado int
+	got := recorrerCatalogo(t, b, FiltroLibros{Orden: orden, Limite: 2}, func(pagina int) {
+		if pagina != 1 {
+			return
+		}
+		libro, err := b.AgregarLibro(Sistema, "Aura", "Carlos Fuentes", "", 60)
+		if err != nil {
+			t.Fatal(err)
+		}
+		agregado = libro.ID
+		if _, err := b.PrestarLibro(Sistema, antes.Libros[3].ID, ana.ID); err != nil {
+			t.Fatal(err)
+		}
+		retirado = antes.Libros[5].ID
+		if _, err := b.RetirarLibro(Sistema, retirado, "deteriorado"); err != nil {
+			t.Fatal(err)
+		}
+	})
+	want := slices.DeleteFunc(idsLibros(antes.Libros), func(id int) bool { return id == retirado })
+	if !slices.Equal(got, want) {
+		t.Errorf("recorrido %v, se esperaba %v (agregado %d, retirado %d)",
+			titulosDe(b, got), titulosDe(b, want), agregado, retirado)
+	}
+}
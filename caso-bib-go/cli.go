@@ -0,0 +1,1807 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// ==========================================
+// LÍNEA DE COMANDOS
+// ==========================================
+
+const usoCLI = `Uso: biblioteca [opciones] <comando> [argumentos]
+
+Comandos:
+  libro agregar    --titulo T --autor A [--isbn I] --paginas N
+                   [--completar]   lo que falte sale del servicio de metadatos
+  libro metadatos  --isbn I   datos que propone el servicio de metadatos
+  libro editar     --id ID --titulo T --autor A --paginas N
+  libro listar     [--disponibles] [--buscar TEXTO] [--isbn ISBN] [--autor A]
+                   [--paginas-min N] [--paginas-max N] [--grandes]
+                   [--isbn-prefijo P] [--etiquetas E,...] [--orden titulo,-paginas]
+                   [--limite N] [--cursor C]
+  libro clasificar --id ID --tipo (general|novedad|referencia)
+  libro etiquetar  --id ID --etiquetas E,...
+  libro retirar    --id ID --motivo M   lo saca del catálogo sin borrar su historial
+  libro disponibilidad --id ID   dónde está ahora cada ejemplar
+  libro importar   --archivo F [--formato csv|marc|marcxml|dc|bibtex]
+                   [--columnas campo=COLUMNA,...] [--simular]
+  libro exportar   [--archivo F] [--formato csv|marc|marcxml|dc|bibtex]
+  ejemplar agregar --libro ID [--codigo C] [--ubicacion U] [--sucursal S]
+  ejemplar listar  --libro ID
+  ejemplar estado  --id ID --estado (disponible|en_reparacion|perdido|retirado|solo_consulta)
+                   --motivo M   un ejemplar prestado solo puede marcarse perdido
+  ejemplar historial --id ID
+  usuario registrar  --nombre N --email E [--telefono T] [--categoria C]
+  usuario categoria  --id ID --categoria C
+  usuario desactivar --id ID
+  usuario pagar      --id ID --monto M
+  usuario condonar   --id ID --monto M   perdona deuda sin cobrarla
+  usuario cerrar     --id ID   da de baja la cuenta; sus datos se anonimizan
+                               pasados los días de retención de la política
+  usuario anonimizar           borra los datos de las cuentas cerradas vencidas
+  usuario importar   --archivo F [--columnas campo=COLUMNA,...] [--simular]
+  usuario exportar   [--archivo F]
+  prestamo crear    (--libro ID | --ejemplar ID) --usuario ID
+  prestamo devolver (--libro ID | --ejemplar ID) [--sucursal S]
+                    en otra sucursal el ejemplar vuelve solo a la suya
+  prestamo escanear --item CODIGO --usuario CODIGO   presta lo que se escaneó:
+                    código de ejemplar o ISBN, y QR del carné
+  prestamo renovar  --id ID
+  prestamo listar   [--activos] [--vencidos]
+  prestamo exportar [--archivo F]   historial completo en CSV
+  reserva crear    --libro ID --usuario ID
+  reserva cancelar --id ID
+  reserva posicion --id ID
+  reserva listar   (--libro ID | --usuario ID)
+  reserva vencer   cierra las reservas apartadas que no se retiraron a tiempo
+  evento listar    [--libro ID] [--usuario ID] [--por ACTOR] [--tipo T,...]
+                   [--desde AAAA-MM-DD] [--hasta AAAA-MM-DD]
+  informe circulacion [--mes AAAA-MM | --desde F --hasta F] [--top N]
+                      [--inactividad DIAS] [--formato texto|json|csv|markdown]
+                      [--archivo F]   por defecto, el mes en curso
+  acceso crear      --nombre N --rol (admin|bibliotecario|voluntario|usuario)
+                    --nueva-clave C [--usuario ID]   la primera debe ser admin
+  acceso listar
+  acceso clave      --nombre N --nueva-clave C
+  acceso desactivar --nombre N
+  sucursal agregar  --codigo C --nombre N [--direccion D]   la primera es la
+                    principal y se queda con los ejemplares que ya había
+  sucursal listar
+  sucursal fondo    --codigo C   ejemplares de la sucursal y dónde están
+  traslado solicitar --ejemplar ID --destino S [--motivo M]
+  traslado enviar    --id ID
+  traslado recibir   --id ID
+  traslado cancelar  --id ID
+  traslado listar    [--abiertos] [--sucursal S]
+  etiqueta ejemplar --id ID [--codigo code128|ean13] [--formato svg|png]
+                    [--escala N] [--archivo F]
+  etiqueta libro    --id ID [--formato svg|png] [--escala N] [--archivo F]   EAN-13 del ISBN
+  etiqueta carnet   --usuario ID [--formato svg|png] [--archivo F]
+  etiqueta hoja     [--ejemplares ID,...] [--libros ID,...] [--usuarios ID,...]
+                    [--hoja a4-3x7|carta-3x10|a4-2x5] [--archivo F]   PDF para imprimir
+  etiqueta leer     --codigo C   a qué ejemplar, libro o usuario corresponde
+  calendario ver    [--sucursal S] [--desde AAAA-MM-DD] [--dias N]
+                    qué días abre la sucursal y por qué cierra
+  stats
+  compactar         reescribe el archivo de datos y vacía el diario
+  servir            [--addr :8080] levanta la API REST
+
+Opciones (antes o después del comando):
+  --datos ARCHIVO   archivo de datos (por defecto $BIBLIOTECA_DATOS o biblioteca.json)
+  --output FORMATO  table, json o csv (por defecto table)
+  --politica ARCHIVO  política de préstamos en JSON (por defecto $BIBLIOTECA_POLITICA
+                      o la política incorporada)
+  --calendario [SUC=]ARCHIVO,...  calendarios .ics de atención; sin SUC= es el
+                      de la biblioteca (por defecto $BIBLIOTECA_CALENDARIO)
+  --metadatos URL   servicio de metadatos con la API de Open Library (por
+                    defecto $BIBLIOTECA_METADATOS o https://openlibrary.org);
+                    "local" usa solo lo ya consultado, guardado junto a --datos
+  --actor ACTOR     quién hace la operación, para la auditoría: usuario:ID,
+                    personal:NOMBRE o NOMBRE (por defecto $BIBLIOTECA_ACTOR o $USER)
+  --clave CLAVE     clave del actor (por defecto $BIBLIOTECA_CLAVE); hace falta
+                    en cuanto la biblioteca tiene alguna credencial
+`
+
+// Códigos de salida
+const (
+	salidaOK    = 0
+	salidaError = 1
+	salidaUso   = 2
+)
+
+// errorUso indica que los argumentos no son válidos; se muestra la ayuda
+type errorUso struct {
+	mensaje string
+}
+
+func (e *errorUso) Error() string {
+	return e.mensaje
+}
+
+func nuevoErrorUso(formato string, args ...any) error {
+	return &errorUso{mensaje: fmt.Sprintf(formato, args...)}
+}
+
+// cli guarda las opciones comunes y dónde escribir
+type cli struct {
+	datos      string
+	formato    string
+	politica   string
+	calendario string
+	metadatos  string
+	actor      string
+	clave      string
+	salida     io.Writer
+
+	// quien es --actor ya interpretado, para pasarlo a las operaciones
+	quien Actor
+}
+
+// ejecutar corre el comando de args y retorna el código de salida
+func ejecutar(args []string, salida, errores io.Writer) int {
+	c := &cli{salida: salida}
+
+	globales := c.opciones("biblioteca")
+	globales.SetOutput(errores)
+	globales.Usage = func() { fmt.Fprint(errores, usoCLI) }
+	if err := globales.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return salidaOK
+		}
+		return salidaUso
+	}
+
+	err := c.despachar(globales.Args())
+	var uso *errorUso
+	switch {
+	case err == nil:
+		return salidaOK
+	case errors.Is(err, flag.ErrHelp):
+		return salidaOK
+	case errors.As(err, &uso):
+		fmt.Fprintf(errores, "❌ %s\n\n%s", err, usoCLI)
+		return salidaUso
+	default:
+		fmt.Fprintf(errores, "❌ %s\n", err)
+		return salidaError
+	}
+}
+
+// opciones crea un FlagSet con las opciones comunes ya registradas, para
+// que --datos, --output, --politica, --calendario, --metadatos, --actor y
+// --clave se acepten en cualquier posición
+func (c *cli) opciones(nombre string) *flag.FlagSet {
+	fs := flag.NewFlagSet(nombre, flag.ContinueOnError)
+	datos := os.Getenv("BIBLIOTECA_DATOS")
+	if datos == "" {
+		datos = "biblioteca.json"
+	}
+	if c.datos != "" {
+		datos = c.datos
+	}
+	formato := "table"
+	if c.formato != "" {
+		formato = c.formato
+	}
+	politica := os.Getenv("BIBLIOTECA_POLITICA")
+	if c.politica != "" {
+		politica = c.politica
+	}
+	calendario := os.Getenv("BIBLIOTECA_CALENDARIO")
+	if c.calendario != "" {
+		calendario = c.calendario
+	}
+	metadatos := os.Getenv("BIBLIOTECA_METADATOS")
+	if metadatos == "" {
+		metadatos = URLOpenLibrary
+	}
+	if c.metadatos != "" {
+		metadatos = c.metadatos
+	}
+	actor := os.Getenv("BIBLIOTECA_ACTOR")
+	if actor == "" {
+		actor = os.Getenv("USER")
+	}
+	if c.actor != "" {
+		actor = c.actor
+	}
+	clave := os.Getenv("BIBLIOTECA_CLAVE")
+	if c.clave != "" {
+		clave = c.clave
+	}
+	fs.StringVar(&c.datos, "datos", datos, "archivo de datos")
+	fs.StringVar(&c.formato, "output", formato, "formato de salida: table, json o csv")
+	fs.StringVar(&c.politica, "politica", politica, "archivo JSON con la política de préstamos")
+	fs.StringVar(&c.calendario, "calendario", calendario, "calendarios .ics de atención: [SUC=]ARCHIVO,...")
+	fs.StringVar(&c.metadatos, "metadatos", metadatos, "URL del servicio de metadatos, o local para usar solo la caché")
+	fs.StringVar(&c.actor, "actor", actor, "quién hace la operación: usuario:ID, personal:NOMBRE o NOMBRE")
+	fs.StringVar(&c.clave, "clave", clave, "clave del actor, si la biblioteca tiene credenciales")
+	return fs
+}
+
+// comando es una hoja del árbol de comandos
+type comando func(c *cli, args []string) error
+
+var comandos = map[string]map[string]comando{
+	"libro": {
+		"agregar":        (*cli).libroAgregar,
+		"metadatos":      (*cli).libroMetadatos,
+		"editar":         (*cli).libroEditar,
+		"listar":         (*cli).libroListar,
+		"clasificar":     (*cli).libroClasificar,
+		"etiquetar":      (*cli).libroEtiquetar,
+		"retirar":        (*cli).libroRetirar,
+		"importar":       (*cli).libroImportar,
+		"exportar":       (*cli).libroExportar,
+		"disponibilidad": (*cli).libroDisponibilidad,
+	},
+	"ejemplar": {
+		"agregar":   (*cli).ejemplarAgregar,
+		"listar":    (*cli).ejemplarListar,
+		"estado":    (*cli).ejemplarEstado,
+		"historial": (*cli).ejemplarHistorial,
+	},
+	"usuario": {
+		"registrar":  (*cli).usuarioRegistrar,
+		"desactivar": (*cli).usuarioDesactivar,
+		"categoria":  (*cli).usuarioCategoria,
+		"pagar":      (*cli).usuarioPagar,
+		"condonar":   (*cli).usuarioCondonar,
+		"cerrar":     (*cli).usuarioCerrar,
+		"anonimizar": (*cli).usuarioAnonimizar,
+		"importar":   (*cli).usuarioImportar,
+		"exportar":   (*cli).usuarioExportar,
+	},
+	"prestamo": {
+		"crear":    (*cli).prestamoCrear,
+		"devolver": (*cli).prestamoDevolver,
+		"renovar":  (*cli).prestamoRenovar,
+		"escanear": (*cli).prestamoEscanear,
+		"listar":   (*cli).prestamoListar,
+		"exportar": (*cli).prestamoExportar,
+	},
+	"reserva": {
+		"crear":    (*cli).reservaCrear,
+		"cancelar": (*cli).reservaCancelar,
+		"posicion": (*cli).reservaPosicion,
+		"listar":   (*cli).reservaListar,
+		"vencer":   (*cli).reservaVencer,
+	},
+	"evento": {
+		"listar": (*cli).eventoListar,
+	},
+	"informe": {
+		"circulacion": (*cli).informeCirculacion,
+	},
+	"acceso": {
+		"crear":      (*cli).accesoCrear,
+		"listar":     (*cli).accesoListar,
+		"clave":      (*cli).accesoClave,
+		"desactivar": (*cli).accesoDesactivar,
+	},
+	"sucursal": {
+		"agregar": (*cli).sucursalAgregar,
+		"listar":  (*cli).sucursalListar,
+		"fondo":   (*cli).sucursalFondo,
+	},
+	"etiqueta": {
+		"ejemplar": (*cli).etiquetaEjemplar,
+		"libro":    (*cli).etiquetaLibro,
+		"carnet":   (*cli).etiquetaCarnet,
+		"hoja":     (*cli).etiquetaHoja,
+		"leer":     (*cli).etiquetaLeer,
+	},
+	"calendario": {
+		"ver": (*cli).calendarioVer,
+	},
+	"traslado": {
+		"solicitar": (*cli).trasladoSolicitar,
+		"enviar":    pasoTraslado("traslado enviar", (*Biblioteca).EnviarTraslado),
+		"recibir":   pasoTraslado("traslado recibir", (*Biblioteca).RecibirTraslado),
+		"cancelar":  pasoTraslado("traslado cancelar", (*Biblioteca).CancelarTraslado),
+		"listar":    (*cli).trasladoListar,
+	},
+}
+
+func (c *cli) despachar(args []string) error {
+	if len(args) == 0 {
+		return nuevoErrorUso("falta el comando")
+	}
+	switch args[0] {
+	case "stats":
+		return c.stats(args[1:])
+	case "compactar":
+		return c.compactar(args[1:])
+	case "servir":
+		return c.servir(args[1:])
+	}
+
+	grupo, ok := comandos[args[0]]
+	if !ok {
+		return nuevoErrorUso("comando desconocido '%s'", args[0])
+	}
+	if len(args) < 2 {
+		return nuevoErrorUso("falta el subcomando de '%s'", args[0])
+	}
+	accion, ok := grupo[args[1]]
+	if !ok {
+		return nuevoErrorUso("subcomando desconocido '%s %s'", args[0], args[1])
+	}
+	return accion(c, args[2:])
+}
+
+// parsear interpreta los argumentos de un subcomando y valida el formato
+func (c *cli) parsear(fs *flag.FlagSet, args []string) error {
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			fs.SetOutput(c.salida)
+			fs.PrintDefaults()
+			return err
+		}
+		return nuevoErrorUso("%v", err)
+	}
+	if fs.NArg() > 0 {
+		return nuevoErrorUso("argumento inesperado '%s'", fs.Arg(0))
+	}
+	switch c.formato {
+	case "table", "json", "csv":
+	default:
+		return nuevoErrorUso("formato de salida desconocido '%s'", c.formato)
+	}
+	// Sin --actor ni variables de entorno la operación queda como anónima
+	quien, err := ParsearActor(c.actor)
+	if err != nil {
+		return nuevoErrorUso("%v", err)
+	}
+	c.quien = quien
+	return nil
+}
+
+// conBiblioteca abre el archivo de datos con la política, los calendarios y
+// el servicio de metadatos elegidos, ejecuta
+// accion y cierra el diario. Si la biblioteca tiene credenciales, antes
+// comprueba la clave de --actor
+func (c *cli) conBiblioteca(accion func(b *Biblioteca) error) error {
+	politica, err := c.cargarPolitica()
+	if err != nil {
+		return err
+	}
+	calendarios, err := c.cargarCalendarios()
+	if err != nil {
+		return err
+	}
+	b, err := abrirOCrear(c.datos, NuevaBiblioteca("Biblioteca Central", "Av. Principal 123"))
+	if err != nil {
+		return err
+	}
+	defer b.Cerrar()
+	b.Politica = politica
+	b.Calendarios = calendarios
+	b.Metadatos = c.servicioMetadatos()
+	if b.Protegida() {
+		if err := b.Autenticar(c.quien, c.clave); err != nil {
+			return err
+		}
+	}
+	return accion(b)
+}
+
+// cargarPolitica lee el archivo de --politica, o retorna la política por
+// defecto si no se indicó ninguno
+func (c *cli) cargarPolitica() (Politica, error) {
+	if c.politica == "" {
+		return PoliticaPorDefecto, nil
+	}
+	return CargarPolitica(c.politica)
+}
+
+// servicioMetadatos crea el servicio de --metadatos con la caché junto al
+// archivo de datos
+func (c *cli) servicioMetadatos() *ServicioMetadatos {
+	url := c.metadatos
+	if url == "local" {
+		url = ""
+	}
+	return NuevoServicioMetadatos(url, c.datos+extensionMetadatos)
+}
+
+// cargarCalendarios lee los archivos de --calendario. Cada uno es
+// SUC=ARCHIVO para una sucursal o ARCHIVO solo para la biblioteca
+func (c *cli) cargarCalendarios() (map[string]*Calendario, error) {
+	calendarios := make(map[string]*Calendario)
+	if c.calendario == "" {
+		return calendarios, nil
+	}
+	for _, parte := range strings.Split(c.calendario, ",") {
+		sucursal, ruta, ok := strings.Cut(strings.TrimSpace(parte), "=")
+		if !ok {
+			sucursal, ruta = "", sucursal
+		}
+		if _, repetido := calendarios[sucursal]; repetido {
+			return nil, nuevoErrorUso("calendario repetido para '%s' en --calendario", sucursal)
+		}
+		calendario, err := CargarCalendario(ruta)
+		if err != nil {
+			return nil, err
+		}
+		calendarios[sucursal] = calendario
+	}
+	return calendarios, nil
+}
+
+// ==========================================
+// LIBROS
+// ==========================================
+
+func (c *cli) libroAgregar(args []string) error {
+	fs := c.opciones("libro agregar")
+	titulo := fs.String("titulo", "", "título")
+	autor := fs.String("autor", "", "autor")
+	isbn := fs.String("isbn", "", "ISBN")
+	paginas := fs.Int("paginas", 0, "cantidad de páginas")
+	completar := fs.Bool("completar", false, "tomar del servicio de metadatos el título, autor o páginas que falten")
+	if err := c.parsear(fs, args); err != nil {
+		return err
+	}
+	return c.conBiblioteca(func(b *Biblioteca) error {
+		if *completar && *isbn != "" && (*titulo == "" || *autor == "" || *paginas == 0) {
+			propuesta, err := b.ProponerLibro(context.Background(), c.quien, *isbn)
+			if err != nil {
+				return err
+			}
+			*titulo, *autor, *paginas = propuesta.Completar(*titulo, *autor, *paginas)
+		}
+		libro, err := b.AgregarLibro(c.quien, *titulo, *autor, *isbn, *paginas)
+		if err != nil {
+			return err
+		}
+		return c.imprimirLibros(b, []Libro{*libro})
+	})
+}
+
+func (c *cli) libroMetadatos(args []string) error {
+	fs := c.opciones("libro metadatos")
+	isbn := fs.String("isbn", "", "ISBN-10 o ISBN-13, con o sin guiones")
+	if err := c.parsear(fs, args); err != nil {
+		return err
+	}
+	return c.conBiblioteca(func(b *Biblioteca) error {
+		propuesta, err := b.ProponerLibro(context.Background(), c.quien, *isbn)
+		if err != nil {
+			return err
+		}
+		origen := string(propuesta.Origen)
+		if propuesta.Vencida {
+			origen += " (vencida)"
+		}
+		filas := [][]string{
+			{"ISBN", propuesta.ISBN.ConGuiones()},
+			{"TITULO", propuesta.Titulo},
+			{"AUTOR", propuesta.Autor()},
+			{"PAGINAS", strconv.Itoa(propuesta.Paginas)},
+			{"EDITORIAL", propuesta.Editorial},
+			{"PORTADA", propuesta.Portada},
+			{"ORIGEN", origen},
+			{"CONSULTADA", propuesta.Consultada.Format(time.DateTime)},
+		}
+		return c.imprimir(propuesta, []string{"CAMPO", "VALOR"}, filas)
+	})
+}
+
+func (c *cli) libroEditar(args []string) error {
+	fs := c.opciones("libro editar")
+	id := fs.Int("id", 0, "ID del libro")
+	titulo := fs.String("titulo", "", "título")
+	autor := fs.String("autor", "", "autor")
+	paginas := fs.Int("paginas", 0, "cantidad de páginas")
+	if err := c.parsear(fs, args); err != nil {
+		return err
+	}
+	return c.conBiblioteca(func(b *Biblioteca) error {
+		if err := b.ActualizarLibro(c.quien, *id, *titulo, *autor, *paginas); err != nil {
+			return err
+		}
+		return c.imprimirLibros(b, []Libro{*b.BuscarLibro(*id)})
+	})
+}
+
+func (c *cli) libroListar(args []string) error {
+	fs := c.opciones("libro listar")
+	disponibles := fs.Bool("disponibles", false, "solo libros con algún ejemplar disponible")
+	grandes := fs.Bool("grandes", false, "solo libros extensos")
+	isbn := fs.String("isbn", "", "ISBN-10 o ISBN-13, con o sin guiones")
+	valores := map[string]*string{
+		"q":            fs.String("buscar", "", "texto a buscar en título, autor o etiquetas"),
+		"autor":        fs.String("autor", "", "texto que contiene el autor"),
+		"paginas_min":  fs.String("paginas-min", "", "mínimo de páginas"),
+		"paginas_max":  fs.String("paginas-max", "", "máximo de páginas"),
+		"isbn_prefijo": fs.String("isbn-prefijo", "", "comienzo del ISBN"),
+		"etiquetas":    fs.String("etiquetas", "", "etiquetas separadas por comas; deben estar todas"),
+		"orden":        fs.String("orden", "", "campos separados por comas, con - para invertir: titulo,-paginas"),
+		"limite":       fs.String("limite", "", "libros por página (todos si no se indica)"),
+		"cursor":       fs.String("cursor", "", "cursor de la página siguiente"),
+	}
+	if err := c.parsear(fs, args); err != nil {
+		return err
+	}
+	filtro, err := filtroLibrosDe(func(clave string) string {
+		switch {
+		case clave == "disponible" && *disponibles, clave == "grande" && *grandes:
+			return "true"
+		case valores[clave] != nil:
+			return *valores[clave]
+		}
+		return ""
+	})
+	if err != nil {
+		return nuevoErrorUso("%v", err)
+	}
+	return c.conBiblioteca(func(b *Biblioteca) error {
+		if *isbn != "" {
+			libro, err := b.BuscarLibroPorISBN(*isbn)
+			if err != nil {
+				return err
+			}
+			filtro.PrefijoISBN = libro.ISBN.ISBN13()
+		}
+		pagina, err := b.ConsultarLibros(filtro)
+		if err != nil {
+			return err
+		}
+		if err := c.imprimirLibros(b, pagina.Libros); err != nil {
+			return err
+		}
+		if pagina.Siguiente != "" && c.formato == "table" {
+			fmt.Fprintf(c.salida, "\n%d de %d libros. Siguiente página: --cursor %s\n", len(pagina.Libros), pagina.Total, pagina.Siguiente)
+		}
+		return nil
+	})
+}
+
+func (c *cli) libroEtiquetar(args []string) error {
+	fs := c.opciones("libro etiquetar")
+	id := fs.Int("id", 0, "ID del libro")
+	etiquetas := fs.String("etiquetas", "", "etiquetas separadas por comas; vacío las quita todas")
+	if err := c.parsear(fs, args); err != nil {
+		return err
+	}
+	return c.conBiblioteca(func(b *Biblioteca) error {
+		if err := b.EtiquetarLibro(c.quien, *id, separarEtiquetas(*etiquetas)); err != nil {
+			return err
+		}
+		return c.imprimirLibros(b, []Libro{*b.BuscarLibro(*id)})
+	})
+}
+
+func (c *cli) libroClasificar(args []string) error {
+	fs := c.opciones("libro clasificar")
+	id := fs.Int("id", 0, "ID del libro")
+	tipo := fs.String("tipo", "", "general, novedad o referencia")
+	if err := c.parsear(fs, args); err != nil {
+		return err
+	}
+	return c.conBiblioteca(func(b *Biblioteca) error {
+		if err := b.ClasificarLibro(c.quien, *id, TipoLibro(*tipo)); err != nil {
+			return err
+		}
+		return c.imprimirLibros(b, []Libro{*b.BuscarLibro(*id)})
+	})
+}
+
+func (c *cli) libroRetirar(args []string) error {
+	fs := c.opciones("libro retirar")
+	id := fs.Int("id", 0, "ID del libro")
+	motivo := fs.String("motivo", "", "por qué se retira")
+	if err := c.parsear(fs, args); err != nil {
+		return err
+	}
+	return c.conBiblioteca(func(b *Biblioteca) error {
+		libro, err := b.RetirarLibro(c.quien, *id, *motivo)
+		if err != nil {
+			return err
+		}
+		return c.imprimirLibros(b, []Libro{*libro})
+	})
+}
+
+func (c *cli) imprimirLibros(b *Biblioteca, libros []Libro) error {
+	filas := make([][]string, 0, len(libros))
+	for _, l := range libros {
+		d, _ := b.Disponibilidad(l.ID)
+		filas = append(filas, []string{
+			strconv.Itoa(l.ID), l.Titulo, l.Autor, l.ISBN.ConGuiones(), strconv.Itoa(l.Paginas), string(l.TipoEfectivo()),
+			fmt.Sprintf("%d/%d", d.Disponibles, d.Total), strings.Join(l.Etiquetas, ", "),
+		})
+	}
+	return c.imprimir(libros, []string{"ID", "TITULO", "AUTOR", "ISBN", "PAGINAS", "TIPO", "DISPONIBLES", "ETIQUETAS"}, filas)
+}
+
+// ==========================================
+// EJEMPLARES
+// ==========================================
+
+func (c *cli) ejemplarAgregar(args []string) error {
+	fs := c.opciones("ejemplar agregar")
+	libroID := fs.Int("libro", 0, "ID del libro")
+	codigo := fs.String("codigo", "", "código de barras (se genera si falta)")
+	ubicacion := fs.String("ubicacion", "", "ubicación en la estantería")
+	sucursal := fs.String("sucursal", "", "sucursal a cuyo fondo va (por defecto la principal)")
+	if err := c.parsear(fs, args); err != nil {
+		return err
+	}
+	return c.conBiblioteca(func(b *Biblioteca) error {
+		ejemplar, err := b.AgregarEjemplar(c.quien, *libroID, *codigo, *ubicacion, *sucursal)
+		if err != nil {
+			return err
+		}
+		return c.imprimirEjemplares([]Ejemplar{*ejemplar})
+	})
+}
+
+func (c *cli) ejemplarListar(args []string) error {
+	fs := c.opciones("ejemplar listar")
+	libroID := fs.Int("libro", 0, "ID del libro")
+	if err := c.parsear(fs, args); err != nil {
+		return err
+	}
+	return c.conBiblioteca(func(b *Biblioteca) error {
+		ejemplares, err := b.ListarEjemplares(*libroID)
+		if err != nil {
+			return err
+		}
+		return c.imprimirEjemplares(ejemplares)
+	})
+}
+
+func (c *cli) ejemplarEstado(args []string) error {
+	fs := c.opciones("ejemplar estado")
+	id := fs.Int("id", 0, "ID del ejemplar")
+	estado := fs.String("estado", "", "disponible, en_reparacion, perdido, retirado o solo_consulta")
+	motivo := fs.String("motivo", "", "por qué cambia de estado")
+	if err := c.parsear(fs, args); err != nil {
+		return err
+	}
+	return c.conBiblioteca(func(b *Biblioteca) error {
+		ejemplar, err := b.CambiarEstadoEjemplar(c.quien, *id, EstadoEjemplar(*estado), *motivo)
+		if err != nil {
+			return err
+		}
+		return c.imprimirEjemplares([]Ejemplar{*ejemplar})
+	})
+}
+
+func (c *cli) ejemplarHistorial(args []string) error {
+	fs := c.opciones("ejemplar historial")
+	id := fs.Int("id", 0, "ID del ejemplar")
+	if err := c.parsear(fs, args); err != nil {
+		return err
+	}
+	return c.conBiblioteca(func(b *Biblioteca) error {
+		historial, err := b.HistorialEjemplar(*id)
+		if err != nil {
+			return err
+		}
+		filas := make([][]string, 0, len(historial))
+		for _, t := range historial {
+			filas = append(filas, []string{
+				t.Fecha.Format(time.DateTime), string(t.Desde), string(t.Hasta), t.Motivo, t.Actor.String(),
+			})
+		}
+		return c.imprimir(historial, []string{"FECHA", "DESDE", "HASTA", "MOTIVO", "ACTOR"}, filas)
+	})
+}
+
+func (c *cli) imprimirEjemplares(ejemplares []Ejemplar) error {
+	filas := make([][]string, 0, len(ejemplares))
+	for _, e := range ejemplares {
+		filas = append(filas, []string{
+			strconv.Itoa(e.ID), strconv.Itoa(e.LibroID), e.CodigoBarras, e.SucursalActual, e.Ubicacion,
+			string(e.Estado), e.Motivo,
+		})
+	}
+	return c.imprimir(ejemplares, []string{"ID", "LIBRO", "CODIGO", "SUCURSAL", "UBICACION", "ESTADO", "MOTIVO"}, filas)
+}
+
+// ==========================================
+// USUARIOS
+// ==========================================
+
+func (c *cli) usuarioRegistrar(args []string) error {
+	fs := c.opciones("usuario registrar")
+	nombre := fs.String("nombre", "", "nombre")
+	email := fs.String("email", "", "email")
+	telefono := fs.String("telefono", "", "teléfono")
+	categoria := fs.String("categoria", "", "categoría (por defecto la de la política)")
+	if err := c.parsear(fs, args); err != nil {
+		return err
+	}
+	return c.conBiblioteca(func(b *Biblioteca) error {
+		usuario, err := b.RegistrarUsuario(c.quien, *nombre, *email, *telefono, CategoriaUsuario(*categoria))
+		if err != nil {
+			return err
+		}
+		return c.imprimirUsuarios(b, []Usuario{*usuario})
+	})
+}
+
+func (c *cli) usuarioDesactivar(args []string) error {
+	fs := c.opciones("usuario desactivar")
+	id := fs.Int("id", 0, "ID del usuario")
+	if err := c.parsear(fs, args); err != nil {
+		return err
+	}
+	return c.conBiblioteca(func(b *Biblioteca) error {
+		if err := b.DesactivarUsuario(c.quien, *id); err != nil {
+			return err
+		}
+		return c.imprimirUsuarios(b, []Usuario{*b.BuscarUsuario(*id)})
+	})
+}
+
+func (c *cli) usuarioCerrar(args []string) error {
+	fs := c.opciones("usuario cerrar")
+	id := fs.Int("id", 0, "ID del usuario")
+	if err := c.parsear(fs, args); err != nil {
+		return err
+	}
+	return c.conBiblioteca(func(b *Biblioteca) error {
+		usuario, err := b.CerrarCuenta(c.quien, *id)
+		if err != nil {
+			return err
+		}
+		return c.imprimirUsuarios(b, []Usuario{*usuario})
+	})
+}
+
+func (c *cli) usuarioAnonimizar(args []string) error {
+	fs := c.opciones("usuario anonimizar")
+	if err := c.parsear(fs, args); err != nil {
+		return err
+	}
+	return c.conBiblioteca(func(b *Biblioteca) error {
+		anonimizadas, err := b.AnonimizarCuentas(c.quien)
+		if err != nil {
+			return err
+		}
+		return c.imprimir(map[string]int{"anonimizadas": anonimizadas},
+			[]string{"ANONIMIZADAS"}, [][]string{{strconv.Itoa(anonimizadas)}})
+	})
+}
+
+func (c *cli) usuarioCategoria(args []string) error {
+	fs := c.opciones("usuario categoria")
+	id := fs.Int("id", 0, "ID del usuario")
+	categoria := fs.String("categoria", "", "categoría de la política")
+	if err := c.parsear(fs, args); err != nil {
+		return err
+	}
+	return c.conBiblioteca(func(b *Biblioteca) error {
+		if err := b.CambiarCategoriaUsuario(c.quien, *id, CategoriaUsuario(*categoria)); err != nil {
+			return err
+		}
+		return c.imprimirUsuarios(b, []Usuario{*b.BuscarUsuario(*id)})
+	})
+}
+
+func (c *cli) usuarioPagar(args []string) error {
+	fs := c.opciones("usuario pagar")
+	id := fs.Int("id", 0, "ID del usuario")
+	monto := fs.Float64("monto", 0, "monto a pagar de la deuda")
+	if err := c.parsear(fs, args); err != nil {
+		return err
+	}
+	return c.conBiblioteca(func(b *Biblioteca) error {
+		if err := b.PagarMulta(c.quien, *id, *monto); err != nil {
+			return err
+		}
+		return c.imprimirUsuarios(b, []Usuario{*b.BuscarUsuario(*id)})
+	})
+}
+
+func (c *cli) usuarioCondonar(args []string) error {
+	fs := c.opciones("usuario condonar")
+	id := fs.Int("id", 0, "ID del usuario")
+	monto := fs.Float64("monto", 0, "monto a perdonar de la deuda")
+	if err := c.parsear(fs, args); err != nil {
+		return err
+	}
+	return c.conBiblioteca(func(b *Biblioteca) error {
+		if err := b.CondonarMulta(c.quien, *id, *monto); err != nil {
+			return err
+		}
+		return c.imprimirUsuarios(b, []Usuario{*b.BuscarUsuario(*id)})
+	})
+}
+
+func (c *cli) imprimirUsuarios(b *Biblioteca, usuarios []Usuario) error {
+	filas := make([][]string, 0, len(usuarios))
+	for _, u := range usuarios {
+		estado := "Inactivo"
+		switch {
+		case u.Anonimizado:
+			estado = "Anonimizada"
+		case u.Cerrada:
+			estado = "Cerrada"
+		case u.Activo:
+			estado = "Activo"
+		}
+		filas = append(filas, []string{
+			strconv.Itoa(u.ID), u.Nombre, u.Email, u.Telefono, estado, string(b.Politica.categoriaDe(u)),
+			strconv.Itoa(u.PrestamosActivos), strconv.FormatFloat(u.Deuda, 'f', 2, 64),
+		})
+	}
+	return c.imprimir(usuarios, []string{"ID", "NOMBRE", "EMAIL", "TELEFONO", "ESTADO", "CATEGORIA", "PRESTAMOS", "DEUDA"}, filas)
+}
+
+// ==========================================
+// PRÉSTAMOS
+// ==========================================
+
+func (c *cli) prestamoCrear(args []string) error {
+	fs := c.opciones("prestamo crear")
+	libroID := fs.Int("libro", 0, "ID del libro (se presta el primer ejemplar disponible)")
+	ejemplarID := fs.Int("ejemplar", 0, "ID del ejemplar")
+	usuarioID := fs.Int("usuario", 0, "ID del usuario")
+	if err := c.parsear(fs, args); err != nil {
+		return err
+	}
+	if (*libroID == 0) == (*ejemplarID == 0) {
+		return nuevoErrorUso("indique --libro o --ejemplar")
+	}
+	return c.conBiblioteca(func(b *Biblioteca) error {
+		var prestamo *Prestamo
+		var err error
+		if *ejemplarID != 0 {
+			prestamo, err = b.PrestarEjemplar(c.quien, *ejemplarID, *usuarioID)
+		} else {
+			prestamo, err = b.PrestarLibro(c.quien, *libroID, *usuarioID)
+		}
+		if err != nil {
+			return err
+		}
+		return c.imprimirPrestamos(b, []Prestamo{*prestamo})
+	})
+}
+
+func (c *cli) prestamoEscanear(args []string) error {
+	fs := c.opciones("prestamo escanear")
+	item := fs.String("item", "", "código del ejemplar, o EAN-13/ISBN del libro")
+	usuario := fs.String("usuario", "", "código del carné del usuario")
+	if err := c.parsear(fs, args); err != nil {
+		return err
+	}
+	if *item == "" || *usuario == "" {
+		return nuevoErrorUso("indique --item y --usuario")
+	}
+	return c.conBiblioteca(func(b *Biblioteca) error {
+		prestamo, err := b.PrestarPorCodigos(c.quien, *item, *usuario)
+		if err != nil {
+			return err
+		}
+		return c.imprimirPrestamos(b, []Prestamo{*prestamo})
+	})
+}
+
+func (c *cli) prestamoDevolver(args []string) error {
+	fs := c.opciones("prestamo devolver")
+	libroID := fs.Int("libro", 0, "ID del libro (si tiene un solo ejemplar prestado)")
+	ejemplarID := fs.Int("ejemplar", 0, "ID del ejemplar")
+	sucursal := fs.String("sucursal", "", "sucursal donde se devuelve (por defecto donde se prestó)")
+	if err := c.parsear(fs, args); err != nil {
+		return err
+	}
+	if (*libroID == 0) == (*ejemplarID == 0) {
+		return nuevoErrorUso("indique --libro o --ejemplar")
+	}
+	return c.conBiblioteca(func(b *Biblioteca) error {
+		var prestamo *Prestamo
+		var err error
+		if *ejemplarID != 0 {
+			prestamo, err = b.DevolverEjemplar(c.quien, *ejemplarID, *sucursal)
+		} else {
+			prestamo, err = b.DevolverLibro(c.quien, *libroID, *sucursal)
+		}
+		if err != nil {
+			return err
+		}
+		return c.imprimirPrestamos(b, []Prestamo{*prestamo})
+	})
+}
+
+func (c *cli) prestamoRenovar(args []string) error {
+	fs := c.opciones("prestamo renovar")
+	id := fs.Int("id", 0, "ID del préstamo")
+	if err := c.parsear(fs, args); err != nil {
+		return err
+	}
+	return c.conBiblioteca(func(b *Biblioteca) error {
+		prestamo, err := b.RenovarPrestamo(c.quien, *id)
+		if err != nil {
+			return err
+		}
+		return c.imprimirPrestamos(b, []Prestamo{*prestamo})
+	})
+}
+
+func (c *cli) prestamoListar(args []string) error {
+	fs := c.opciones("prestamo listar")
+	activos := fs.Bool("activos", false, "solo préstamos no devueltos")
+	vencidos := fs.Bool("vencidos", false, "solo préstamos no devueltos con la fecha de devolución pasada")
+	if err := c.parsear(fs, args); err != nil {
+		return err
+	}
+	return c.conBiblioteca(func(b *Biblioteca) error {
+		if *vencidos {
+			return c.imprimirPrestamos(b, b.ListarPrestamosVencidos())
+		}
+		prestamos := make([]Prestamo, 0)
+		for _, p := range b.ListarPrestamos() {
+			if *activos && p.Devuelto {
+				continue
+			}
+			prestamos = append(prestamos, p)
+		}
+		return c.imprimirPrestamos(b, prestamos)
+	})
+}
+
+func (c *cli) imprimirPrestamos(b *Biblioteca, prestamos []Prestamo) error {
+	filas := make([][]string, 0, len(prestamos))
+	for _, p := range prestamos {
+		titulo, nombre := "", ""
+		if libro := b.BuscarLibro(p.LibroID); libro != nil {
+			titulo = libro.Titulo
+		}
+		if usuario := b.BuscarUsuario(p.UsuarioID); usuario != nil {
+			nombre = usuario.Nombre
+		}
+		estado := "Activo"
+		switch {
+		case p.Devuelto:
+			estado = "Devuelto"
+		case p.Vencido(b.Reloj.Ahora()):
+			estado = "Vencido"
+		}
+		filas = append(filas, []string{
+			strconv.Itoa(p.ID), strconv.Itoa(p.LibroID), titulo, strconv.Itoa(p.EjemplarID), strconv.Itoa(p.UsuarioID), nombre,
+			p.FechaPrestamo.Format(time.DateOnly), p.FechaDevolucion.Format(time.DateOnly), estado,
+			strconv.Itoa(len(p.Renovaciones)), strconv.FormatFloat(b.MultaAcumulada(p), 'f', 2, 64),
+		})
+	}
+	return c.imprimir(prestamos, []string{
+		"ID", "LIBRO", "TITULO", "EJEMPLAR", "USUARIO", "NOMBRE", "PRESTADO", "DEVOLUCION", "ESTADO", "RENOV", "MULTA",
+	}, filas)
+}
+
+// ==========================================
+// RESERVAS
+// ==========================================
+
+func (c *cli) reservaCrear(args []string) error {
+	fs := c.opciones("reserva crear")
+	libroID := fs.Int("libro", 0, "ID del libro")
+	usuarioID := fs.Int("usuario", 0, "ID del usuario")
+	if err := c.parsear(fs, args); err != nil {
+		return err
+	}
+	return c.conBiblioteca(func(b *Biblioteca) error {
+		reserva, err := b.ReservarLibro(c.quien, *libroID, *usuarioID)
+		if err != nil {
+			return err
+		}
+		return c.imprimirReservas(b, []Reserva{*reserva})
+	})
+}
+
+func (c *cli) reservaCancelar(args []string) error {
+	fs := c.opciones("reserva cancelar")
+	id := fs.Int("id", 0, "ID de la reserva")
+	if err := c.parsear(fs, args); err != nil {
+		return err
+	}
+	return c.conBiblioteca(func(b *Biblioteca) error {
+		reserva, err := b.CancelarReserva(c.quien, *id)
+		if err != nil {
+			return err
+		}
+		return c.imprimirReservas(b, []Reserva{*reserva})
+	})
+}
+
+func (c *cli) reservaPosicion(args []string) error {
+	fs := c.opciones("reserva posicion")
+	id := fs.Int("id", 0, "ID de la reserva")
+	if err := c.parsear(fs, args); err != nil {
+		return err
+	}
+	return c.conBiblioteca(func(b *Biblioteca) error {
+		posicion, err := b.PosicionEnCola(*id)
+		if err != nil {
+			return err
+		}
+		return c.imprimir(map[string]int{"reserva": *id, "posicion": posicion},
+			[]string{"RESERVA", "POSICION"}, [][]string{{strconv.Itoa(*id), strconv.Itoa(posicion)}})
+	})
+}
+
+func (c *cli) reservaListar(args []string) error {
+	fs := c.opciones("reserva listar")
+	libroID := fs.Int("libro", 0, "ID del libro")
+	usuarioID := fs.Int("usuario", 0, "ID del usuario")
+	if err := c.parsear(fs, args); err != nil {
+		return err
+	}
+	if (*libroID == 0) == (*usuarioID == 0) {
+		return nuevoErrorUso("indique --libro o --usuario")
+	}
+	return c.conBiblioteca(func(b *Biblioteca) error {
+		var reservas []Reserva
+		var err error
+		if *libroID != 0 {
+			reservas, err = b.ReservasDeLibro(*libroID)
+		} else {
+			reservas, err = b.ReservasDeUsuario(*usuarioID)
+		}
+		if err != nil {
+			return err
+		}
+		return c.imprimirReservas(b, reservas)
+	})
+}
+
+func (c *cli) reservaVencer(args []string) error {
+	fs := c.opciones("reserva vencer")
+	if err := c.parsear(fs, args); err != nil {
+		return err
+	}
+	return c.conBiblioteca(func(b *Biblioteca) error {
+		vencidas, err := b.VencerReservas(c.quien)
+		if err != nil {
+			return err
+		}
+		return c.imprimir(map[string]int{"vencidas": vencidas},
+			[]string{"VENCIDAS"}, [][]string{{strconv.Itoa(vencidas)}})
+	})
+}
+
+func (c *cli) imprimirReservas(b *Biblioteca, reservas []Reserva) error {
+	filas := make([][]string, 0, len(reservas))
+	for _, r := range reservas {
+		titulo, nombre, limite := "", "", ""
+		if libro := b.BuscarLibro(r.LibroID); libro != nil {
+			titulo = libro.Titulo
+		}
+		if usuario := b.BuscarUsuario(r.UsuarioID); usuario != nil {
+			nombre = usuario.Nombre
+		}
+		if !r.FechaLimite.IsZero() {
+			limite = r.FechaLimite.Format(time.DateOnly)
+		}
+		filas = append(filas, []string{
+			strconv.Itoa(r.ID), strconv.Itoa(r.LibroID), titulo, strconv.Itoa(r.UsuarioID), nombre,
+			r.FechaReserva.Format(time.DateOnly), string(r.Estado), limite,
+		})
+	}
+	return c.imprimir(reservas,
+		[]string{"ID", "LIBRO", "TITULO", "USUARIO", "NOMBRE", "RESERVADA", "ESTADO", "RETIRAR ANTES DE"}, filas)
+}
+
+// ==========================================
+// AUDITORÍA
+// ==========================================
+
+func (c *cli) eventoListar(args []string) error {
+	fs := c.opciones("evento listar")
+	valores := map[string]*string{
+		"libro":   fs.String("libro", "", "ID del libro"),
+		"usuario": fs.String("usuario", "", "ID del usuario"),
+		"actor":   fs.String("por", "", "quién hizo la operación: usuario:ID, personal:NOMBRE o NOMBRE"),
+		"tipo":    fs.String("tipo", "", "tipos de evento separados por comas, como prestamo.creado"),
+		"desde":   fs.String("desde", "", "primer día, AAAA-MM-DD"),
+		"hasta":   fs.String("hasta", "", "último día, AAAA-MM-DD"),
+	}
+	if err := c.parsear(fs, args); err != nil {
+		return err
+	}
+	filtro, err := filtroEventosDe(func(clave string) string { return *valores[clave] })
+	if err != nil {
+		return nuevoErrorUso("%v", err)
+	}
+	return c.conBiblioteca(func(b *Biblioteca) error {
+		eventos := b.Eventos(filtro)
+		filas := make([][]string, 0, len(eventos))
+		for _, e := range eventos {
+			filas = append(filas, []string{
+				strconv.Itoa(e.Secuencia), e.Fecha.Format(time.DateTime), string(e.Tipo), e.Actor.String(),
+				resumenCambios(e.Cambios),
+			})
+		}
+		return c.imprimir(eventos, []string{"SEC", "FECHA", "TIPO", "ACTOR", "CAMBIOS"}, filas)
+	})
+}
+
+// resumenCambios describe las entidades de un evento, como "libro 3,
+// préstamo 12"
+func resumenCambios(cambios entradaDiario) string {
+	var partes []string
+	agregar := func(nombre string, ids []int) {
+		if len(ids) > 3 {
+			partes = append(partes, fmt.Sprintf("%d %s", len(ids), nombre))
+			return
+		}
+		for _, id := range ids {
+			partes = append(partes, fmt.Sprintf("%s %d", nombre, id))
+		}
+	}
+	agregar("libro", idsDe(cambios.Libros, func(l Libro) int { return l.ID }))
+	agregar("ejemplar", idsDe(cambios.Ejemplares, func(e Ejemplar) int { return e.ID }))
+	agregar("usuario", idsDe(cambios.Usuarios, func(u Usuario) int { return u.ID }))
+	agregar("préstamo", idsDe(cambios.Prestamos, func(p Prestamo) int { return p.ID }))
+	agregar("reserva", idsDe(cambios.Reservas, func(r Reserva) int { return r.ID }))
+	agregar("traslado", idsDe(cambios.Traslados, func(t Traslado) int { return t.ID }))
+	for _, credencial := range cambios.Credenciales {
+		partes = append(partes, "credencial "+credencial.Nombre)
+	}
+	for _, sucursal := range cambios.Sucursales {
+		partes = append(partes, "sucursal "+sucursal.Codigo)
+	}
+	return strings.Join(partes, ", ")
+}
+
+func idsDe[T any](entidades []T, id func(T) int) []int {
+	resultado := make([]int, len(entidades))
+	for i, e := range entidades {
+		resultado[i] = id(e)
+	}
+	return resultado
+}
+
+// ==========================================
+// INFORMES
+// ==========================================
+
+func (c *cli) informeCirculacion(args []string) error {
+	fs := c.opciones("informe circulacion")
+	valores := map[string]*string{
+		"mes":         fs.String("mes", "", "mes del informe, AAAA-MM"),
+		"desde":       fs.String("desde", "", "primer día, AAAA-MM-DD"),
+		"hasta":       fs.String("hasta", "", "último día, AAAA-MM-DD"),
+		"top":         fs.String("top", "", "cuántos títulos y autores listar (10 por defecto)"),
+		"inactividad": fs.String("inactividad", "", "días sin préstamos de un usuario inactivo (90 por defecto)"),
+	}
+	formato := fs.String("formato", "texto", "texto, json, csv o markdown")
+	archivo := fs.String("archivo", "-", "archivo de destino (- para la salida estándar)")
+	if err := c.parsear(fs, args); err != nil {
+		return err
+	}
+	opciones, err := opcionesInformeDe(func(clave string) string { return *valores[clave] })
+	if err != nil {
+		return nuevoErrorUso("%v", err)
+	}
+	formatoInforme, err := ParsearFormatoInforme(*formato)
+	if err != nil {
+		return nuevoErrorUso("%v", err)
+	}
+	return c.conBiblioteca(func(b *Biblioteca) error {
+		informe, err := b.InformeCirculacion(opciones)
+		if err != nil {
+			return err
+		}
+		if *archivo == "-" {
+			return EscribirInforme(c.salida, formatoInforme, informe)
+		}
+		f, err := os.Create(*archivo)
+		if err != nil {
+			return err
+		}
+		if err := EscribirInforme(f, formatoInforme, informe); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	})
+}
+
+// ==========================================
+// OTROS COMANDOS
+// ==========================================
+
+func (c *cli) stats(args []string) error {
+	fs := c.opciones("stats")
+	if err := c.parsear(fs, args); err != nil {
+		return err
+	}
+	return c.conBiblioteca(func(b *Biblioteca) error {
+		e := b.Estadisticas()
+		filas := [][]string{
+			{"total_libros", strconv.Itoa(e.TotalLibros)},
+			{"total_ejemplares", strconv.Itoa(e.TotalEjemplares)},
+			{"ejemplares_prestados", strconv.Itoa(e.EjemplaresPrestados)},
+			{"ejemplares_disponibles", strconv.Itoa(e.EjemplaresDisponibles)},
+			{"usuarios_activos", strconv.Itoa(e.UsuariosActivos)},
+			{"prestamos_activos", strconv.Itoa(e.PrestamosActivos)},
+			{"prestamos_vencidos", strconv.Itoa(e.PrestamosVencidos)},
+		}
+		return c.imprimir(e, []string{"METRICA", "VALOR"}, filas)
+	})
+}
+
+func (c *cli) compactar(args []string) error {
+	fs := c.opciones("compactar")
+	if err := c.parsear(fs, args); err != nil {
+		return err
+	}
+	return c.conBiblioteca(func(b *Biblioteca) error {
+		return b.Guardar(c.datos)
+	})
+}
+
+func (c *cli) servir(args []string) error {
+	fs := c.opciones("servir")
+	direccion := fs.String("addr", ":8080", "dirección donde escuchar")
+	if err := c.parsear(fs, args); err != nil {
+		return err
+	}
+	politica, err := c.cargarPolitica()
+	if err != nil {
+		return err
+	}
+	calendarios, err := c.cargarCalendarios()
+	if err != nil {
+		return err
+	}
+	return servirAPI(*direccion, c.datos, politica, calendarios, c.servicioMetadatos())
+}
+
+// ==========================================
+// ACCESO
+// ==========================================
+
+func (c *cli) accesoCrear(args []string) error {
+	fs := c.opciones("acceso crear")
+	nombre := fs.String("nombre", "", "nombre con el que entra (como --actor)")
+	rol := fs.String("rol", "", "rol: admin, bibliotecario, voluntario o usuario")
+	nueva := fs.String("nueva-clave", "", "clave de la credencial")
+	usuario := fs.Int("usuario", 0, "ID del usuario, para el rol usuario")
+	if err := c.parsear(fs, args); err != nil {
+		return err
+	}
+	return c.conBiblioteca(func(b *Biblioteca) error {
+		credencial, err := b.CrearCredencial(c.quien, *nombre, Rol(*rol), *nueva, *usuario)
+		if err != nil {
+			return err
+		}
+		return c.imprimirCredenciales([]Credencial{*credencial})
+	})
+}
+
+func (c *cli) accesoListar(args []string) error {
+	fs := c.opciones("acceso listar")
+	if err := c.parsear(fs, args); err != nil {
+		return err
+	}
+	return c.conBiblioteca(func(b *Biblioteca) error {
+		if err := b.Autorizar(c.quien, PermisoAdministrar, 0); err != nil {
+			return err
+		}
+		return c.imprimirCredenciales(b.ListarCredenciales())
+	})
+}
+
+func (c *cli) accesoClave(args []string) error {
+	fs := c.opciones("acceso clave")
+	nombre := fs.String("nombre", "", "nombre de la credencial")
+	nueva := fs.String("nueva-clave", "", "clave nueva")
+	if err := c.parsear(fs, args); err != nil {
+		return err
+	}
+	return c.conBiblioteca(func(b *Biblioteca) error {
+		if err := b.CambiarClave(c.quien, *nombre, *nueva); err != nil {
+			return err
+		}
+		return c.imprimirCredenciales([]Credencial{*b.BuscarCredencial(*nombre)})
+	})
+}
+
+func (c *cli) accesoDesactivar(args []string) error {
+	fs := c.opciones("acceso desactivar")
+	nombre := fs.String("nombre", "", "nombre de la credencial")
+	if err := c.parsear(fs, args); err != nil {
+		return err
+	}
+	return c.conBiblioteca(func(b *Biblioteca) error {
+		if err := b.DesactivarCredencial(c.quien, *nombre); err != nil {
+			return err
+		}
+		return c.imprimirCredenciales([]Credencial{*b.BuscarCredencial(*nombre)})
+	})
+}
+
+func (c *cli) imprimirCredenciales(credenciales []Credencial) error {
+	filas := make([][]string, 0, len(credenciales))
+	for _, credencial := range credenciales {
+		usuario, estado := "", "Inactiva"
+		if credencial.UsuarioID != 0 {
+			usuario = strconv.Itoa(credencial.UsuarioID)
+		}
+		if credencial.Activa {
+			estado = "Activa"
+		}
+		filas = append(filas, []string{credencial.Nombre, string(credencial.Rol), usuario, estado})
+	}
+	return c.imprimir(credenciales, []string{"NOMBRE", "ROL", "USUARIO", "ESTADO"}, filas)
+}
+
+// ==========================================
+// SUCURSALES Y TRASLADOS
+// ==========================================
+
+func (c *cli) sucursalAgregar(args []string) error {
+	fs := c.opciones("sucursal agregar")
+	codigo := fs.String("codigo", "", "código corto de la sucursal")
+	nombre := fs.String("nombre", "", "nombre de la sucursal")
+	direccion := fs.String("direccion", "", "dirección")
+	if err := c.parsear(fs, args); err != nil {
+		return err
+	}
+	return c.conBiblioteca(func(b *Biblioteca) error {
+		sucursal, err := b.AgregarSucursal(c.quien, *codigo, *nombre, *direccion)
+		if err != nil {
+			return err
+		}
+		return c.imprimirSucursales([]Sucursal{*sucursal})
+	})
+}
+
+func (c *cli) sucursalListar(args []string) error {
+	fs := c.opciones("sucursal listar")
+	if err := c.parsear(fs, args); err != nil {
+		return err
+	}
+	return c.conBiblioteca(func(b *Biblioteca) error {
+		return c.imprimirSucursales(b.ListarSucursales())
+	})
+}
+
+func (c *cli) sucursalFondo(args []string) error {
+	fs := c.opciones("sucursal fondo")
+	codigo := fs.String("codigo", "", "código de la sucursal")
+	if err := c.parsear(fs, args); err != nil {
+		return err
+	}
+	return c.conBiblioteca(func(b *Biblioteca) error {
+		paraderos, err := b.EjemplaresDeSucursal(*codigo)
+		if err != nil {
+			return err
+		}
+		return c.imprimirParaderos(paraderos, paraderos)
+	})
+}
+
+func (c *cli) libroDisponibilidad(args []string) error {
+	fs := c.opciones("libro disponibilidad")
+	id := fs.Int("id", 0, "ID del libro")
+	if err := c.parsear(fs, args); err != nil {
+		return err
+	}
+	return c.conBiblioteca(func(b *Biblioteca) error {
+		d, err := b.Disponibilidad(*id)
+		if err != nil {
+			return err
+		}
+		return c.imprimirParaderos(d, d.Ejemplares)
+	})
+}
+
+func (c *cli) trasladoSolicitar(args []string) error {
+	fs := c.opciones("traslado solicitar")
+	ejemplarID := fs.Int("ejemplar", 0, "ID del ejemplar")
+	destino := fs.String("destino", "", "código de la sucursal de destino")
+	motivo := fs.String("motivo", "", "para qué se traslada")
+	if err := c.parsear(fs, args); err != nil {
+		return err
+	}
+	return c.conBiblioteca(func(b *Biblioteca) error {
+		traslado, err := b.SolicitarTraslado(c.quien, *ejemplarID, *destino, *motivo)
+		if err != nil {
+			return err
+		}
+		return c.imprimirTraslados([]Traslado{*traslado})
+	})
+}
+
+// pasoTraslado arma el subcomando de un paso de un traslado ya solicitado
+func pasoTraslado(nombre string, operacion func(*Biblioteca, Actor, int) (*Traslado, error)) comando {
+	return func(c *cli, args []string) error {
+		fs := c.opciones(nombre)
+		id := fs.Int("id", 0, "ID del traslado")
+		if err := c.parsear(fs, args); err != nil {
+			return err
+		}
+		return c.conBiblioteca(func(b *Biblioteca) error {
+			traslado, err := operacion(b, c.quien, *id)
+			if err != nil {
+				return err
+			}
+			return c.imprimirTraslados([]Traslado{*traslado})
+		})
+	}
+}
+
+func (c *cli) trasladoListar(args []string) error {
+	fs := c.opciones("traslado listar")
+	abiertos := fs.Bool("abiertos", false, "solo los que no se recibieron ni cancelaron")
+	sucursal := fs.String("sucursal", "", "solo los que salen de o llegan a esta sucursal")
+	if err := c.parsear(fs, args); err != nil {
+		return err
+	}
+	return c.conBiblioteca(func(b *Biblioteca) error {
+		if err := b.Autorizar(c.quien, PermisoCirculacion, 0); err != nil {
+			return err
+		}
+		return c.imprimirTraslados(b.ListarTraslados(FiltroTraslados{Abiertos: *abiertos, Sucursal: *sucursal}))
+	})
+}
+
+func (c *cli) imprimirSucursales(sucursales []Sucursal) error {
+	filas := make([][]string, 0, len(sucursales))
+	for _, s := range sucursales {
+		principal := ""
+		if s.Principal {
+			principal = "Sí"
+		}
+		filas = append(filas, []string{s.Codigo, s.Nombre, s.Direccion, principal})
+	}
+	return c.imprimir(sucursales, []string{"CODIGO", "NOMBRE", "DIRECCION", "PRINCIPAL"}, filas)
+}
+
+// imprimirParaderos muestra en tabla dónde está cada ejemplar; en JSON
+// muestra valor, que puede traer más datos
+func (c *cli) imprimirParaderos(valor any, paraderos []Paradero) error {
+	filas := make([][]string, 0, len(paraderos))
+	for _, p := range paraderos {
+		en := p.SucursalActual
+		if p.Destino != "" {
+			en = "→ " + p.Destino
+		}
+		usuario := ""
+		if p.UsuarioID != 0 {
+			usuario = strconv.Itoa(p.UsuarioID)
+		}
+		filas = append(filas, []string{
+			strconv.Itoa(p.EjemplarID), p.CodigoBarras, string(p.Estado), p.Sucursal, en, usuario,
+			p.Desde.Format(time.DateOnly),
+		})
+	}
+	return c.imprimir(valor, []string{"ID", "CODIGO", "ESTADO", "FONDO", "EN", "USUARIO", "DESDE"}, filas)
+}
+
+func (c *cli) imprimirTraslados(traslados []Traslado) error {
+	filas := make([][]string, 0, len(traslados))
+	for _, t := range traslados {
+		filas = append(filas, []string{
+			strconv.Itoa(t.ID), strconv.Itoa(t.EjemplarID), t.Origen, t.Destino, string(t.Estado),
+			t.FechaSolicitud.Format(time.DateOnly), t.Motivo,
+		})
+	}
+	return c.imprimir(traslados, []string{"ID", "EJEMPLAR", "ORIGEN", "DESTINO", "ESTADO", "SOLICITADO", "MOTIVO"}, filas)
+}
+
+// ==========================================
+// ETIQUETAS
+// ==========================================
+
+func (c *cli) etiquetaEjemplar(args []string) error {
+	fs := c.opciones("etiqueta ejemplar")
+	id := fs.Int("id", 0, "ID del ejemplar")
+	codigo := fs.String("codigo", string(CodigoCode128), "code128 (el código del ejemplar) o ean13 (el ISBN)")
+	return c.imagen(fs, args, func(b *Biblioteca) (Simbolo, error) {
+		return b.SimboloEjemplar(*id, TipoCodigo(*codigo))
+	})
+}
+
+func (c *cli) etiquetaLibro(args []string) error {
+	fs := c.opciones("etiqueta libro")
+	id := fs.Int("id", 0, "ID del libro")
+	return c.imagen(fs, args, func(b *Biblioteca) (Simbolo, error) {
+		return b.SimboloLibro(*id)
+	})
+}
+
+// imagen escribe en --archivo el símbolo que arma simbolo, en --formato.
+// fs trae las opciones propias del comando
+func (c *cli) imagen(fs *flag.FlagSet, args []string, simbolo func(b *Biblioteca) (Simbolo, error)) error {
+	formato := fs.String("formato", "svg", "formato de la imagen: svg o png")
+	escala := fs.Int("escala", 3, "píxeles por módulo")
+	return c.exportar(fs, args, func(b *Biblioteca, w io.Writer) error {
+		imagen, err := ParsearFormatoImagen(*formato)
+		if err != nil {
+			return err
+		}
+		s, err := simbolo(b)
+		if err != nil {
+			return err
+		}
+		return EscribirImagen(w, s, imagen, *escala)
+	})
+}
+
+func (c *cli) etiquetaCarnet(args []string) error {
+	fs := c.opciones("etiqueta carnet")
+	usuario := fs.Int("usuario", 0, "ID del usuario")
+	formato := fs.String("formato", "svg", "svg (el carné completo) o png (solo el QR)")
+	return c.exportar(fs, args, func(b *Biblioteca, w io.Writer) error {
+		imagen, err := ParsearFormatoImagen(*formato)
+		if err != nil {
+			return err
+		}
+		if err := b.Autorizar(c.quien, PermisoUsuarios, *usuario); err != nil {
+			return err
+		}
+		carnet, err := b.Carnet(*usuario)
+		if err != nil {
+			return err
+		}
+		if imagen == ImagenPNG {
+			return EscribirPNG(w, carnet.Simbolo, 8)
+		}
+		tarjeta := FormatosHoja["a4-2x5"]
+		return EscribirEtiquetaSVG(w, carnet, tarjeta.AnchoEtiqueta, tarjeta.AltoEtiqueta)
+	})
+}
+
+func (c *cli) etiquetaHoja(args []string) error {
+	fs := c.opciones("etiqueta hoja")
+	ejemplares := fs.String("ejemplares", "", "IDs de ejemplares separados por coma")
+	libros := fs.String("libros", "", "IDs de libros: todos sus ejemplares")
+	usuarios := fs.String("usuarios", "", "IDs de usuarios: sus carnés")
+	nombre := fs.String("hoja", HojaPorDefecto, "hoja de etiquetas: a4-3x7, carta-3x10 o a4-2x5")
+	return c.exportar(fs, args, func(b *Biblioteca, w io.Writer) error {
+		hoja, err := BuscarFormatoHoja(*nombre)
+		if err != nil {
+			return err
+		}
+		var pedido PedidoEtiquetas
+		if pedido.Ejemplares, err = ParsearIDs(*ejemplares); err != nil {
+			return err
+		}
+		if pedido.Libros, err = ParsearIDs(*libros); err != nil {
+			return err
+		}
+		if pedido.Usuarios, err = ParsearIDs(*usuarios); err != nil {
+			return err
+		}
+		if len(pedido.Usuarios) > 0 {
+			if err := b.Autorizar(c.quien, PermisoUsuarios, 0); err != nil {
+				return err
+			}
+		}
+		etiquetas, err := b.Etiquetas(pedido)
+		if err != nil {
+			return err
+		}
+		return EscribirHojaPDF(w, hoja, etiquetas)
+	})
+}
+
+func (c *cli) etiquetaLeer(args []string) error {
+	fs := c.opciones("etiqueta leer")
+	codigo := fs.String("codigo", "", "código escaneado")
+	if err := c.parsear(fs, args); err != nil {
+		return err
+	}
+	return c.conBiblioteca(func(b *Biblioteca) error {
+		if err := b.Autorizar(c.quien, PermisoCirculacion, 0); err != nil {
+			return err
+		}
+		lectura, err := b.LeerCodigo(*codigo)
+		if err != nil {
+			return err
+		}
+		id := func(n int) string {
+			if n == 0 {
+				return ""
+			}
+			return strconv.Itoa(n)
+		}
+		fila := []string{lectura.Codigo, string(lectura.Tipo), id(lectura.LibroID), id(lectura.EjemplarID), id(lectura.UsuarioID)}
+		return c.imprimir(lectura, []string{"CODIGO", "TIPO", "LIBRO", "EJEMPLAR", "USUARIO"}, [][]string{fila})
+	})
+}
+
+// ==========================================
+// CALENDARIO
+// ==========================================
+
+func (c *cli) calendarioVer(args []string) error {
+	fs := c.opciones("calendario ver")
+	sucursal := fs.String("sucursal", "", "código de la sucursal (por defecto el calendario general)")
+	desde := fs.String("desde", "", "primer día, AAAA-MM-DD (por defecto hoy)")
+	dias := fs.Int("dias", 14, "cuántos días mostrar")
+	if err := c.parsear(fs, args); err != nil {
+		return err
+	}
+	return c.conBiblioteca(func(b *Biblioteca) error {
+		inicio := b.Reloj.Ahora()
+		if *desde != "" {
+			fecha, err := time.ParseInLocation(time.DateOnly, *desde, time.Local)
+			if err != nil {
+				return nuevoError(ErrDatosInvalidos, "Fecha '%s' no válida: use AAAA-MM-DD", *desde)
+			}
+			inicio = fecha
+		}
+		calendario, err := b.DiasDeAtencion(*sucursal, inicio, *dias)
+		if err != nil {
+			return err
+		}
+		filas := make([][]string, 0, len(calendario))
+		for _, d := range calendario {
+			estado := "Abierto"
+			if !d.Abierto {
+				estado = "Cerrado"
+			}
+			filas = append(filas, []string{
+				d.Fecha.Format(time.DateOnly), diasSemana[d.Fecha.Weekday()], estado,
+				strings.Join(d.Horario, " "), d.Motivo,
+			})
+		}
+		return c.imprimir(calendario, []string{"FECHA", "DIA", "ESTADO", "HORARIO", "MOTIVO"}, filas)
+	})
+}
+
+var diasSemana = [...]string{"dom", "lun", "mar", "mié", "jue", "vie", "sáb"}
+
+// ==========================================
+// IMPORTACIÓN Y EXPORTACIÓN
+// ==========================================
+// Los libros se importan y exportan en CSV o, con --formato, en MARC21,
+// MARCXML, Dublin Core o BibTeX; el resto solo en CSV. Con --archivo - (o
+// sin --archivo al exportar) se usa la entrada o la salida estándar.
+
+func (c *cli) libroImportar(args []string) error {
+	fs := c.opciones("libro importar")
+	formato := fs.String("formato", "csv", "formato del archivo: csv, marc, marcxml, dc o bibtex")
+	return c.importar(fs, args, func(b *Biblioteca, actor Actor, r io.Reader, opciones OpcionesImportacion) (*InformeImportacion, error) {
+		opciones.Formato = FormatoBibliografico(*formato)
+		return b.ImportarLibros(actor, r, opciones)
+	})
+}
+
+func (c *cli) usuarioImportar(args []string) error {
+	return c.importar(c.opciones("usuario importar"), args, (*Biblioteca).ImportarUsuariosCSV)
+}
+
+func (c *cli) libroExportar(args []string) error {
+	fs := c.opciones("libro exportar")
+	formato := fs.String("formato", "csv", "formato del archivo: csv, marc, marcxml, dc o bibtex")
+	return c.exportar(fs, args, func(b *Biblioteca, w io.Writer) error {
+		return b.ExportarLibros(w, FormatoBibliografico(*formato))
+	})
+}
+
+func (c *cli) usuarioExportar(args []string) error {
+	return c.exportar(c.opciones("usuario exportar"), args, (*Biblioteca).ExportarUsuariosCSV)
+}
+
+func (c *cli) prestamoExportar(args []string) error {
+	return c.exportar(c.opciones("prestamo exportar"), args, (*Biblioteca).ExportarPrestamosCSV)
+}
+
+// importar lee el archivo de --archivo con importacion y muestra el informe.
+// Si alguna fila falló retorna un error, para que el código de salida lo
+// refleje. fs trae las opciones propias del comando
+func (c *cli) importar(fs *flag.FlagSet, args []string,
+	importacion func(*Biblioteca, Actor, io.Reader, OpcionesImportacion) (*InformeImportacion, error)) error {
+	archivo := fs.String("archivo", "", "archivo a importar (- para la entrada estándar)")
+	columnas := fs.String("columnas", "", "encabezados que no se llaman como el campo: campo=COLUMNA,...")
+	simular := fs.Bool("simular", false, "validar sin guardar")
+	if err := c.parsear(fs, args); err != nil {
+		return err
+	}
+	if *archivo == "" {
+		return nuevoErrorUso("indique --archivo")
+	}
+	mapeo, err := ParsearColumnas(*columnas)
+	if err != nil {
+		return nuevoErrorUso("%v", err)
+	}
+
+	entrada := io.Reader(os.Stdin)
+	if *archivo != "-" {
+		f, err := os.Open(*archivo)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		entrada = f
+	}
+
+	return c.conBiblioteca(func(b *Biblioteca) error {
+		informe, err := importacion(b, c.quien, entrada, OpcionesImportacion{Columnas: mapeo, Simular: *simular})
+		if err != nil {
+			return err
+		}
+		filas := make([][]string, 0, len(informe.Errores))
+		for _, e := range informe.Errores {
+			filas = append(filas, []string{strconv.Itoa(e.Fila), e.Mensaje})
+		}
+		if c.formato == "table" {
+			verbo := "importadas"
+			if informe.Simulacion {
+				verbo = "válidas (simulación, no se guardó nada)"
+			}
+			fmt.Fprintf(c.salida, "%d de %d filas %s\n", informe.Importadas, informe.Filas, verbo)
+		}
+		if c.formato != "table" || len(filas) > 0 {
+			if err := c.imprimir(informe, []string{"FILA", "ERROR"}, filas); err != nil {
+				return err
+			}
+		}
+		if len(informe.Errores) > 0 {
+			return fmt.Errorf("%d filas con errores", len(informe.Errores))
+		}
+		return nil
+	})
+}
+
+// exportar escribe con exportacion el archivo de --archivo o la salida. fs
+// trae las opciones propias del comando
+func (c *cli) exportar(fs *flag.FlagSet, args []string, exportacion func(*Biblioteca, io.Writer) error) error {
+	archivo := fs.String("archivo", "-", "archivo de destino (- para la salida estándar)")
+	if err := c.parsear(fs, args); err != nil {
+		return err
+	}
+	return c.conBiblioteca(func(b *Biblioteca) error {
+		if *archivo == "-" {
+			return exportacion(b, c.salida)
+		}
+		f, err := os.Create(*archivo)
+		if err != nil {
+			return err
+		}
+		if err := exportacion(b, f); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	})
+}
+
+// ==========================================
+// FORMATOS DE SALIDA
+// ==========================================
+
+// imprimir escribe valor como JSON, o encabezados y filas como tabla o CSV,
+// según el formato elegido
+func (c *cli) imprimir(valor any, encabezados []string, filas [][]string) error {
+	switch c.formato {
+	case "json":
+		encoder := json.NewEncoder(c.salida)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(valor)
+	case "csv":
+		w := csv.NewWriter(c.salida)
+		w.Write(encabezados)
+		w.WriteAll(filas)
+		return w.Error()
+	default:
+		w := tabwriter.NewWriter(c.salida, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, strings.Join(encabezados, "\t"))
+		for _, fila := range filas {
+			fmt.Fprintln(w, strings.Join(fila, "\t"))
+		}
+		return w.Flush()
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// datosCLI guarda una biblioteca con un préstamo vencido hace dos semanas y
+// otro que vence dentro de dos, y retorna la ruta del archivo. Los comandos
+// corren con el reloj del sistema, así que las fechas se arman desde hoy
+func datosCLI(t *testing.T) string {
+	t.Helper()
+	for _, variable := range []string{"BIBLIOTECA_DATOS", "BIBLIOTECA_POLITICA", "BIBLIOTECA_CALENDARIO",
+		"BIBLIOTECA_ACTOR", "BIBLIOTECA_CLAVE"} {
+		t.Setenv(variable, "")
+	}
+	t.Setenv("BIBLIOTECA_METADATOS", "local")
+
+	b, _ := nuevaBibliotecaPrueba(t)
+	reloj := NuevoRelojFijo(time.Now().AddDate(0, 0, -30))
+	b.Reloj = reloj
+	vencido := agregarLibroPrueba(t, b, "Rayuela")
+	activo := agregarLibroPrueba(t, b, "Ficciones")
+	ana := registrarUsuarioPrueba(t, b, "ana")
+	if _, err := b.PrestarLibro(Sistema, vencido.ID, ana.ID); err != nil {
+		t.Fatal(err)
+	}
+	reloj.Avanzar(28 * dia)
+	if _, err := b.PrestarLibro(Sistema, activo.ID, ana.ID); err != nil {
+		t.Fatal(err)
+	}
+	ruta := filepath.Join(t.TempDir(), "biblioteca.json")
+	if err := b.Guardar(ruta); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Cerrar(); err != nil {
+		t.Fatal(err)
+	}
+	return ruta
+}
+
+// correr ejecuta la línea de comandos sobre ruta y retorna el código de
+// salida, la salida y los errores
+func correr(ruta string, args ...string) (int, string, string) {
+	var salida, errores bytes.Buffer
+	codigo := ejecutar(append([]string{"--datos", ruta}, args...), &salida, &errores)
+	return codigo, salida.String(), errores.String()
+}
+
+func TestCLIFormatosDeSalida(t *testing.T) {
+	ruta := datosCLI(t)
+
+	codigo, salida, errores := correr(ruta, "prestamo", "listar", "--vencidos")
+	if codigo != salidaOK {
+		t.Fatalf("prestamo listar --vencidos: salida %d, %s", codigo, errores)
+	}
+	lineas := strings.Split(strings.TrimSpace(salida), "\n")
+	if len(lineas) != 2 || !strings.HasPrefix(lineas[0], "ID  LIBRO") ||
+		!strings.Contains(lineas[1], "Rayuela") || !strings.Contains(lineas[1], "Vencido") {
+		t.Errorf("tabla de vencidos:\n%s", salida)
+	}
+
+	codigo, salida, errores = correr(ruta, "--output", "json", "prestamo", "listar", "--vencidos")
+	var vencidos []Prestamo
+	if codigo != salidaOK {
+		t.Fatalf("--output json: salida %d, %s", codigo, errores)
+	}
+	if err := json.Unmarshal([]byte(salida), &vencidos); err != nil {
+		t.Fatalf("--output json: %v\n%s", err, salida)
+	}
+	if len(vencidos) != 1 || vencidos[0].Devuelto {
+		t.Errorf("vencidos en JSON = %+v", vencidos)
+	}
+
+	// La opción también va después del subcomando
+	codigo, salida, errores = correr(ruta, "prestamo", "listar", "--output", "csv")
+	if codigo != salidaOK {
+		t.Fatalf("--output csv: salida %d, %s", codigo, errores)
+	}
+	filas, err := csv.NewReader(strings.NewReader(salida)).ReadAll()
+	if err != nil {
+		t.Fatalf("--output csv: %v\n%s", err, salida)
+	}
+	if len(filas) != 3 || filas[0][0] != "ID" || filas[0][8] != "ESTADO" ||
+		filas[1][2] != "Rayuela" || filas[1][8] != "Vencido" || filas[2][2] != "Ficciones" || filas[2][8] != "Activo" {
+		t.Errorf("préstamos en CSV = %q", filas)
+	}
+}
+
+func TestCLIGuardaLosCambios(t *testing.T) {
+	ruta := datosCLI(t)
+	codigo, _, errores := correr(ruta, "libro", "agregar", "--titulo", "El Aleph", "--autor", "Jorge Luis Borges", "--paginas", "200")
+	if codigo != salidaOK {
+		t.Fatalf("libro agregar: salida %d, %s", codigo, errores)
+	}
+	_, salida, _ := correr(ruta, "--output", "json", "libro", "listar", "--orden", "titulo")
+	var libros []Libro
+	if err := json.Unmarshal([]byte(salida), &libros); err != nil {
+		t.Fatalf("libro listar: %v\n%s", err, salida)
+	}
+	if len(libros) != 3 || libros[0].Titulo != "El Aleph" {
+		t.Errorf("libros después de agregar = %+v", libros)
+	}
+}
+
+func TestCLICodigosDeSalida(t *testing.T) {
+	ruta := datosCLI(t)
+	casos := []struct {
+		nombre string
+		args   []string
+		codigo int
+	}{
+		{"formato desconocido", []string{"--output", "xml", "prestamo", "listar"}, salidaUso},
+		{"comando desconocido", []string{"prestamo", "borrar"}, salidaUso},
+		{"sin libro ni ejemplar", []string{"prestamo", "crear", "--usuario", "1"}, salidaUso},
+		{"libro inexistente", []string{"prestamo", "crear", "--libro", "999", "--usuario", "1"}, salidaError},
+		{"ayuda", []string{"--help"}, salidaOK},
+	}
+	for _, caso := range casos {
+		codigo, _, errores := correr(ruta, caso.args...)
+		if codigo != caso.codigo {
+			t.Errorf("%s: salida %d, se esperaba %d\n%s", caso.nombre, codigo, caso.codigo, errores)
+		}
+		if codigo == salidaError && !strings.HasPrefix(errores, "❌ ") {
+			t.Errorf("%s: errores = %q", caso.nombre, errores)
+		}
+	}
+}
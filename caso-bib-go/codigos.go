@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strings"
+)
+
+// ==========================================
+// CÓDIGOS DE BARRAS
+// ==========================================
+// Las etiquetas de lomo llevan el código de barras del ejemplar en Code128
+// y, si el libro tiene ISBN, el EAN-13 que ya trae impreso la contratapa
+// (el ISBN-13 es un EAN-13). Los carnés llevan un QR, ver qr.go. Los tres
+// se codifican a un Simbolo, una matriz de módulos claros y oscuros, que
+// después se dibuja en PNG, en SVG o en una hoja de etiquetas, ver
+// etiquetas.go.
+
+// TipoCodigo es la simbología de un Simbolo
+type TipoCodigo string
+
+const (
+	CodigoCode128 TipoCodigo = "code128"
+	CodigoEAN13   TipoCodigo = "ean13"
+	CodigoQR      TipoCodigo = "qr"
+)
+
+// Simbolo es un código ya codificado. Modulos tiene una fila por cada fila
+// de módulos (true es oscuro); los códigos lineales tienen una sola, que se
+// estira a lo alto al dibujarla. Margen es la zona clara que necesita
+// alrededor para que el lector lo encuentre, en módulos
+type Simbolo struct {
+	Tipo    TipoCodigo
+	Texto   string
+	Modulos [][]bool
+	Margen  int
+}
+
+// altoLineal es la altura en módulos de las barras de un código lineal
+const altoLineal = 50
+
+// lineal indica si el símbolo es un código de barras de una fila
+func (s Simbolo) lineal() bool {
+	return len(s.Modulos) == 1
+}
+
+// tamano retorna el ancho y el alto del símbolo en módulos, con el margen.
+// A lo alto un código lineal lleva solo un margen de cuatro módulos
+func (s Simbolo) tamano() (int, int) {
+	ancho := len(s.Modulos[0]) + 2*s.Margen
+	if s.lineal() {
+		return ancho, altoLineal + 8
+	}
+	return ancho, len(s.Modulos) + 2*s.Margen
+}
+
+// rectangulo es una zona oscura del símbolo, en módulos desde su esquina
+// superior izquierda con el margen incluido
+type rectangulo struct {
+	x, y, ancho, alto int
+}
+
+// rectangulos junta los módulos oscuros vecinos de cada fila en
+// rectángulos, para dibujar el símbolo con pocas figuras
+func (s Simbolo) rectangulos() []rectangulo {
+	var rects []rectangulo
+	for fila, modulos := range s.Modulos {
+		y, alto := fila+s.Margen, 1
+		if s.lineal() {
+			y, alto = 4, altoLineal
+		}
+		for x := 0; x < len(modulos); {
+			if !modulos[x] {
+				x++
+				continue
+			}
+			inicio := x
+			for x < len(modulos) && modulos[x] {
+				x++
+			}
+			rects = append(rects, rectangulo{x: inicio + s.Margen, y: y, ancho: x - inicio, alto: alto})
+		}
+	}
+	return rects
+}
+
+// ==========================================
+// CODE128
+// ==========================================
+
+// patronesCode128 son los anchos de barra y espacio, alternados y empezando
+// por barra, de cada valor de Code128. Los tres anteriores a la parada son
+// los inicios A, B y C
+var patronesCode128 = [...]string{
+	"212222", "222122", "222221", "121223", "121322", "131222", "122213", "122312", "132212", "221213",
+	"221312", "231212", "112232", "122132", "122231", "113222", "123122", "123221", "223211", "221132",
+	"221231", "213212", "223112", "312131", "311222", "321122", "321221", "312212", "322112", "322211",
+	"212123", "212321", "232121", "111323", "131123", "131321", "112313", "132113", "132311", "211313",
+	"231113", "231311", "112133", "112331", "132131", "113123", "113321", "133121", "313121", "211331",
+	"231131", "213113", "213311", "213131", "311123", "311321", "331121", "312113", "312311", "332111",
+	"314111", "221411", "431111", "111224", "111422", "121124", "121421", "141122", "141221", "112214",
+	"112412", "122114", "122411", "142112", "142211", "241211", "221114", "413111", "241112", "134111",
+	"111242", "121142", "121241", "114212", "124112", "124211", "411212", "421112", "421211", "212141",
+	"214121", "412121", "111143", "111341", "131141", "114113", "114311", "411113", "411311", "113141",
+	"114131", "311141", "411131", "211412", "211214", "211232", "2331112",
+}
+
+const (
+	inicioCode128B = 104
+	inicioCode128C = 105
+	paradaCode128  = 106
+)
+
+// Code128 codifica texto, que debe ser ASCII imprimible. Si son solo
+// dígitos y en cantidad par usa el juego C, que guarda dos por símbolo; si
+// no, el juego B
+func Code128(texto string) (Simbolo, error) {
+	if texto == "" {
+		return Simbolo{}, nuevoError(ErrDatosInvalidos, "No se puede codificar un código vacío")
+	}
+	var valores []int
+	if len(texto)%2 == 0 && soloDigitos(texto) {
+		valores = append(valores, inicioCode128C)
+		for i := 0; i < len(texto); i += 2 {
+			valores = append(valores, int(texto[i]-'0')*10+int(texto[i+1]-'0'))
+		}
+	} else {
+		valores = append(valores, inicioCode128B)
+		for i := 0; i < len(texto); i++ {
+			if texto[i] < ' ' || texto[i] > '~' {
+				return Simbolo{}, nuevoError(ErrDatosInvalidos,
+					"El código '%s' no se puede escribir en Code128: solo admite ASCII imprimible", texto)
+			}
+			valores = append(valores, int(texto[i]-' '))
+		}
+	}
+	control := valores[0]
+	for i, valor := range valores[1:] {
+		control += (i + 1) * valor
+	}
+	valores = append(valores, control%103, paradaCode128)
+
+	var modulos []bool
+	for _, valor := range valores {
+		oscuro := true
+		for _, ancho := range patronesCode128[valor] {
+			for range ancho - '0' {
+				modulos = append(modulos, oscuro)
+			}
+			oscuro = !oscuro
+		}
+	}
+	return Simbolo{Tipo: CodigoCode128, Texto: texto, Modulos: [][]bool{modulos}, Margen: 10}, nil
+}
+
+// ==========================================
+// EAN-13
+// ==========================================
+
+// codigosEAN13 son los dígitos de la mitad izquierda con paridad impar (L);
+// los de paridad par (G) y los de la derecha (R) se derivan de estos
+var codigosEAN13 = [10]string{
+	"0001101", "0011001", "0010011", "0111101", "0100011",
+	"0110001", "0101111", "0111011", "0110111", "0001011",
+}
+
+// paridadesEAN13 dice, según el primer dígito, qué paridad lleva cada uno
+// de los seis dígitos de la mitad izquierda. El primer dígito no se dibuja:
+// queda implícito en estas paridades
+var paridadesEAN13 = [10]string{
+	"LLLLLL", "LLGLGG", "LLGGLG", "LLGGGL", "LGLLGG",
+	"LGGLLG", "LGGGLG", "LGLGLG", "LGLGGL", "LGGLGL",
+}
+
+// EAN13 codifica trece dígitos, o doce a los que agrega el de control
+func EAN13(digitos string) (Simbolo, error) {
+	if !soloDigitos(digitos) || (len(digitos) != 12 && len(digitos) != 13) {
+		return Simbolo{}, nuevoError(ErrDatosInvalidos, "Un EAN-13 tiene 12 o 13 dígitos, no '%s'", digitos)
+	}
+	if len(digitos) == 12 {
+		digitos += string(digitoControl13(digitos))
+	} else if digitoControl13(digitos[:12]) != digitos[12] {
+		return Simbolo{}, nuevoError(ErrDatosInvalidos, "EAN-13 no válido '%s': el dígito de control no coincide", digitos)
+	}
+
+	var b strings.Builder
+	b.WriteString("101")
+	paridad := paridadesEAN13[digitos[0]-'0']
+	for i, d := range digitos[1:7] {
+		codigo := codigosEAN13[d-'0']
+		if paridad[i] == 'G' {
+			codigo = invertirBits(codigo)
+			codigo = revertir(codigo)
+		}
+		b.WriteString(codigo)
+	}
+	b.WriteString("01010")
+	for _, d := range digitos[7:] {
+		b.WriteString(invertirBits(codigosEAN13[d-'0']))
+	}
+	b.WriteString("101")
+
+	modulos := make([]bool, 0, b.Len())
+	for _, bit := range b.String() {
+		modulos = append(modulos, bit == '1')
+	}
+	return Simbolo{Tipo: CodigoEAN13, Texto: digitos, Modulos: [][]bool{modulos}, Margen: 11}, nil
+}
+
+// invertirBits cambia los ceros por unos y los unos por ceros
+func invertirBits(bits string) string {
+	return strings.Map(func(r rune) rune { return '0' + '1' - r }, bits)
+}
+
+// revertir da vuelta un texto ASCII
+func revertir(s string) string {
+	b := []byte(s)
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return string(b)
+}
+
+// ==========================================
+// PNG Y SVG
+// ==========================================
+
+// FormatoImagen es el formato en que se dibuja un símbolo
+type FormatoImagen string
+
+const (
+	ImagenPNG FormatoImagen = "png"
+	ImagenSVG FormatoImagen = "svg"
+)
+
+// ParsearFormatoImagen valida el nombre de un formato. Vacío es SVG
+func ParsearFormatoImagen(texto string) (FormatoImagen, error) {
+	formato := FormatoImagen(strings.ToLower(strings.TrimSpace(texto)))
+	switch formato {
+	case "":
+		return ImagenSVG, nil
+	case ImagenPNG, ImagenSVG:
+		return formato, nil
+	}
+	return "", nuevoError(ErrDatosInvalidos, "Formato de imagen desconocido '%s': use png o svg", texto)
+}
+
+// TipoContenido retorna el tipo MIME del formato
+func (f FormatoImagen) TipoContenido() string {
+	if f == ImagenPNG {
+		return "image/png"
+	}
+	return "image/svg+xml"
+}
+
+// Extension retorna la extensión de los archivos del formato
+func (f FormatoImagen) Extension() string {
+	return "." + string(f)
+}
+
+// EscribirImagen dibuja el símbolo en el formato pedido con escala píxeles
+// (o unidades SVG) por módulo
+func EscribirImagen(w io.Writer, s Simbolo, formato FormatoImagen, escala int) error {
+	if escala <= 0 {
+		return nuevoError(ErrDatosInvalidos, "La escala debe ser positiva")
+	}
+	if formato == ImagenPNG {
+		return EscribirPNG(w, s, escala)
+	}
+	return EscribirSVG(w, s, escala)
+}
+
+// EscribirPNG dibuja el símbolo en blanco y negro. PNG no lleva el texto
+// legible de los códigos lineales: no hay fuentes a mano para dibujarlo
+func EscribirPNG(w io.Writer, s Simbolo, escala int) error {
+	ancho, alto := s.tamano()
+	paleta := color.Palette{color.White, color.Black}
+	img := image.NewPaletted(image.Rect(0, 0, ancho*escala, alto*escala), paleta)
+	for _, r := range s.rectangulos() {
+		for y := r.y * escala; y < (r.y+r.alto)*escala; y++ {
+			for x := r.x * escala; x < (r.x+r.ancho)*escala; x++ {
+				img.SetColorIndex(x, y, 1)
+			}
+		}
+	}
+	return png.Encode(w, img)
+}
+
+// EscribirSVG dibuja el símbolo como rectángulos. Los códigos lineales
+// llevan debajo su texto legible
+func EscribirSVG(w io.Writer, s Simbolo, escala int) error {
+	ancho, alto := s.tamano()
+	texto := 0
+	if s.lineal() {
+		texto = 10
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+"\n",
+		ancho*escala, (alto+texto)*escala, ancho, alto+texto)
+	fmt.Fprintf(bw, `<rect width="%d" height="%d" fill="#fff"/>`+"\n", ancho, alto+texto)
+	fmt.Fprintln(bw, `<g fill="#000">`)
+	for _, r := range s.rectangulos() {
+		fmt.Fprintf(bw, `<rect x="%d" y="%d" width="%d" height="%d"/>`+"\n", r.x, r.y, r.ancho, r.alto)
+	}
+	fmt.Fprintln(bw, `</g>`)
+	if s.lineal() {
+		fmt.Fprintf(bw, `<text x="%d" y="%d" font-family="monospace" font-size="9" text-anchor="middle">%s</text>`+"\n",
+			ancho/2, alto+texto-2, html.EscapeString(s.Texto))
+	}
+	fmt.Fprintln(bw, `</svg>`)
+	return bw.Flush()
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// bitsDe escribe la única fila de un código lineal como unos y ceros
+func bitsDe(s Simbolo) string {
+	var b strings.Builder
+	for _, oscuro := range s.Modulos[0] {
+		if oscuro {
+			b.WriteByte('1')
+		} else {
+			b.WriteByte('0')
+		}
+	}
+	return b.String()
+}
+
+func TestCode128(t *testing.T) {
+	casos := []struct {
+		texto   string
+		modulos []string
+	}{
+		// Juego C: inicio C, 00, 01, 00, 00, control (105+2·1)%103 = 4,
+		// parada
+		{"00010000", []string{"11010011100", "11011001100", "11001101100", "11011001100", "11011001100",
+			"10010001100", "1100011101011"}},
+		// Juego B: inicio B, "!" (valor 1), control (104+1)%103 = 2, parada
+		{"!", []string{"11010010000", "11001101100", "11001100110", "1100011101011"}},
+	}
+	for _, caso := range casos {
+		simbolo, err := Code128(caso.texto)
+		if err != nil {
+			t.Fatalf("Code128(%q): %v", caso.texto, err)
+		}
+		if got, want := bitsDe(simbolo), strings.Join(caso.modulos, ""); got != want {
+			t.Errorf("Code128(%q) =\n%s\nse esperaba\n%s", caso.texto, got, want)
+		}
+	}
+	for _, texto := range []string{"", "año"} {
+		if _, err := Code128(texto); err == nil {
+			t.Errorf("Code128(%q) no retornó error", texto)
+		}
+	}
+}
+
+func TestEAN13(t *testing.T) {
+	// 9780134190440: el 9 inicial elige las paridades LGGLGL de la mitad
+	// izquierda
+	want := strings.Join([]string{
+		"101",
+		"0111011", "0001001", "0100111", "0011001", "0100001", "0100011", // 7L 8G 0G 1L 3G 4L
+		"01010",
+		"1100110", "1110100", "1110010", "1011100", "1011100", "1110010", // 1 9 0 4 4 0
+		"101",
+	}, "")
+	for _, digitos := range []string{"9780134190440", "978013419044"} {
+		simbolo, err := EAN13(digitos)
+		if err != nil {
+			t.Fatalf("EAN13(%q): %v", digitos, err)
+		}
+		if got := bitsDe(simbolo); got != want {
+			t.Errorf("EAN13(%q) =\n%s\nse esperaba\n%s", digitos, got, want)
+		}
+		if simbolo.Texto != "9780134190440" {
+			t.Errorf("EAN13(%q).Texto = %q", digitos, simbolo.Texto)
+		}
+	}
+	if _, err := EAN13("9780134190441"); err == nil {
+		t.Error("EAN13 aceptó un dígito de control equivocado")
+	}
+}
+
+// formatosQRNivelM son los 15 bits de formato del nivel M para cada
+// máscara, de la tabla de la norma
+var formatosQRNivelM = [8]string{
+	"101010000010010", "101000100100101", "101111001111100", "101101101001011",
+	"100010111111001", "100000011001110", "100111110010111", "100101010100000",
+}
+
+// leerBits arma un número con los módulos en posiciones, el primero como
+// bit 0
+func leerBits(modulos [][]bool, posiciones [][2]int) int {
+	valor := 0
+	for i, p := range posiciones {
+		if modulos[p[1]][p[0]] {
+			valor |= 1 << i
+		}
+	}
+	return valor
+}
+
+func TestQRFormatoYVersion(t *testing.T) {
+	casos := []struct {
+		texto   string
+		version int
+	}{
+		{CodigoCarnet(1), 1},
+		{strings.Repeat("x", 14), 1},
+		{strings.Repeat("x", 15), 2},
+		{strings.Repeat("x", 110), 7},
+		{strings.Repeat("x", 213), 10},
+	}
+	versiones := map[int]int{7: 0x07C94, 10: 0x0A4D3}
+	for _, caso := range casos {
+		simbolo, err := QR(caso.texto)
+		if err != nil {
+			t.Fatalf("QR de %d bytes: %v", len(caso.texto), err)
+		}
+		lado := len(simbolo.Modulos)
+		if lado != 17+4*caso.version {
+			t.Errorf("QR de %d bytes: lado %d, se esperaba la versión %d", len(caso.texto), lado, caso.version)
+			continue
+		}
+
+		// Las dos copias del formato, en el orden de la norma
+		var primera, segunda [][2]int
+		for i := range 15 {
+			switch {
+			case i < 6:
+				primera = append(primera, [2]int{8, i})
+			case i < 8:
+				primera = append(primera, [2]int{8, i + 1})
+			case i == 8:
+				primera = append(primera, [2]int{7, 8})
+			default:
+				primera = append(primera, [2]int{14 - i, 8})
+			}
+			if i < 8 {
+				segunda = append(segunda, [2]int{lado - 1 - i, 8})
+			} else {
+				segunda = append(segunda, [2]int{8, lado - 15 + i})
+			}
+		}
+		formato := leerBits(simbolo.Modulos, primera)
+		if otra := leerBits(simbolo.Modulos, segunda); otra != formato {
+			t.Errorf("versión %d: las copias del formato difieren: %015b y %015b", caso.version, formato, otra)
+		}
+		var valido []int
+		for _, bits := range formatosQRNivelM {
+			valido = append(valido, numeroDeBits(bits))
+		}
+		if !slices.Contains(valido, formato) {
+			t.Errorf("versión %d: formato %015b, no es nivel M con ninguna máscara", caso.version, formato)
+		}
+		if !simbolo.Modulos[lado-8][8] {
+			t.Errorf("versión %d: falta el módulo oscuro fijo", caso.version)
+		}
+
+		if want, ok := versiones[caso.version]; ok {
+			var arriba, abajo [][2]int
+			for i := range 18 {
+				arriba = append(arriba, [2]int{lado - 11 + i%3, i / 3})
+				abajo = append(abajo, [2]int{i / 3, lado - 11 + i%3})
+			}
+			for _, bloque := range [][][2]int{arriba, abajo} {
+				if got := leerBits(simbolo.Modulos, bloque); got != want {
+					t.Errorf("versión %d: bits de versión %#x, se esperaba %#x", caso.version, got, want)
+				}
+			}
+		}
+	}
+	if _, err := QR(strings.Repeat("x", 214)); err == nil {
+		t.Error("QR aceptó 214 bytes")
+	}
+}
+
+// numeroDeBits lee unos y ceros como un número, el último como bit 0
+func numeroDeBits(bits string) int {
+	valor := 0
+	for _, bit := range bits {
+		valor = valor<<1 | int(bit-'0')
+	}
+	return valor
+}
+
+func TestReedSolomon(t *testing.T) {
+	// "HELLO WORLD" en 1-M, el ejemplo de la norma que reproducen los
+	// tutoriales de QR
+	datos := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	if got := restoRS(datos, divisorRS(10)); !bytes.Equal(got, want) {
+		t.Errorf("restoRS = %v, se esperaba %v", got, want)
+	}
+
+	// En la versión 1 hay un solo bloque: los datos en modo byte con su
+	// relleno y después la corrección
+	datos = []byte{0x40, 0x95, 0x55, 0x35, 0x54, 0x15, 0x24, 0x94, 0xF3, 0xA3, 0x10, 0xEC, 0x11, 0xEC, 0x11, 0xEC}
+	got := nuevoQR(1).codewords(CodigoCarnet(1))
+	if want := append(slices.Clone(datos), restoRS(datos, divisorRS(10))...); !bytes.Equal(got, want) {
+		t.Errorf("codewords(%q) =\n% X\nse esperaba\n% X", CodigoCarnet(1), got, want)
+	}
+}
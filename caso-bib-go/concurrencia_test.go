@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// Varias terminales prestan el mismo libro a la vez: solo una lo consigue.
+// Pensada para correr con go test -race
+func TestPrestarMismoLibroConcurrente(t *testing.T) {
+	const terminales = 32
+	for ronda := range 20 {
+		b, _ := nuevaBibliotecaPrueba(t)
+		libro := agregarLibroPrueba(t, b, fmt.Sprintf("Rayuela %d", ronda))
+		usuarios := make([]*Usuario, terminales)
+		for i := range usuarios {
+			usuarios[i] = registrarUsuarioPrueba(t, b, fmt.Sprintf("lector%d", i))
+		}
+
+		var listo sync.WaitGroup
+		var exitos sync.Map
+		salida := make(chan struct{})
+		for _, usuario := range usuarios {
+			listo.Add(1)
+			go func() {
+				defer listo.Done()
+				<-salida
+				prestamo, err := b.PrestarLibro(Sistema, libro.ID, usuario.ID)
+				switch {
+				case err == nil:
+					exitos.Store(prestamo.ID, usuario.ID)
+				case !errors.Is(err, ErrNoPermitido):
+					t.Errorf("PrestarLibro para el usuario %d: %v", usuario.ID, err)
+				}
+				// Lecturas mezcladas con las escrituras de las otras terminales
+				b.BuscarLibro(libro.ID)
+				b.ListarLibrosDisponibles()
+			}()
+		}
+		close(salida)
+		listo.Wait()
+
+		cantidad := 0
+		exitos.Range(func(_, _ any) bool { cantidad++; return true })
+		if cantidad != 1 {
+			t.Fatalf("ronda %d: %d préstamos exitosos, se esperaba 1", ronda, cantidad)
+		}
+		if activos := prestamosActivos(b, libro.ID); activos != 1 {
+			t.Fatalf("ronda %d: %d préstamos activos, se esperaba 1", ronda, activos)
+		}
+		verificar(t, b)
+	}
+}
+
+// Préstamos y devoluciones concurrentes de varios libros mantienen las
+// invariantes
+func TestPrestarYDevolverConcurrente(t *testing.T) {
+	b, _ := nuevaBibliotecaPrueba(t)
+	const libros = 4
+	ids := make([]int, libros)
+	for i := range ids {
+		ids[i] = agregarLibroPrueba(t, b, fmt.Sprintf("Libro %d", i)).ID
+	}
+	usuarios := make([]int, 8)
+	for i := range usuarios {
+		usuarios[i] = registrarUsuarioPrueba(t, b, fmt.Sprintf("lector%d", i)).ID
+	}
+
+	var listo sync.WaitGroup
+	for n, usuarioID := range usuarios {
+		listo.Add(1)
+		go func() {
+			defer listo.Done()
+			for i := range 50 {
+				libroID := ids[(n+i)%libros]
+				if _, err := b.PrestarLibro(Sistema, libroID, usuarioID); err != nil {
+					continue
+				}
+				if _, err := b.DevolverLibro(Sistema, libroID, ""); err != nil {
+					t.Errorf("DevolverLibro(%d): %v", libroID, err)
+				}
+			}
+		}()
+	}
+	listo.Wait()
+
+	for _, id := range ids {
+		if activos := prestamosActivos(b, id); activos != 0 {
+			t.Errorf("libro %d: %d préstamos activos al final", id, activos)
+		}
+	}
+	verificar(t, b)
+}
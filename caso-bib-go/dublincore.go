@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ==========================================
+// DUBLIN CORE
+// ==========================================
+// Dublin Core simple en el esquema oai_dc de OAI-PMH. Cada libro es un
+// elemento oai_dc:dc con:
+//
+//	dc:title       título
+//	dc:creator     autor
+//	dc:identifier  ISBN como URN: "urn:isbn:9780134190440"
+//	dc:format      páginas: "350 p."
+//	dc:type        siempre "Text"
+//
+// Al exportar los registros van dentro de un elemento raíz "registros". Al
+// importar se aceptan los oai_dc:dc en cualquier lugar del documento, así
+// que también sirve una respuesta ListRecords de OAI-PMH. Si un registro
+// tiene varios títulos o autores se usa el primero.
+
+const (
+	espacioOAIDC = "http://www.openarchives.org/OAI/2.0/oai_dc/"
+	espacioDC    = "http://purl.org/dc/elements/1.1/"
+)
+
+// LectorDublinCore lee los registros oai_dc:dc de un documento
+type LectorDublinCore struct {
+	d    *xml.Decoder
+	roto bool
+}
+
+func NuevoLectorDublinCore(r io.Reader) *LectorDublinCore {
+	return &LectorDublinCore{d: xml.NewDecoder(r)}
+}
+
+// Leer retorna el libro del próximo registro. Igual que en MARCXML, un
+// documento mal formado retorna el error una vez y después io.EOF
+func (l *LectorDublinCore) Leer() (Libro, error) {
+	if l.roto {
+		return Libro{}, io.EOF
+	}
+	for {
+		token, err := l.d.Token()
+		if errors.Is(err, io.EOF) {
+			return Libro{}, io.EOF
+		}
+		if err != nil {
+			return Libro{}, l.romper(err)
+		}
+		if inicio, ok := token.(xml.StartElement); ok && inicio.Name.Space == espacioOAIDC && inicio.Name.Local == "dc" {
+			return l.leerRegistro()
+		}
+	}
+}
+
+// leerRegistro lee los elementos dc:* hasta el cierre del oai_dc:dc
+func (l *LectorDublinCore) leerRegistro() (Libro, error) {
+	var libro Libro
+	for {
+		token, err := l.d.Token()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return Libro{}, l.romper(err)
+		}
+		switch t := token.(type) {
+		case xml.EndElement:
+			if t.Name.Space == espacioOAIDC && t.Name.Local == "dc" {
+				return libro, nil
+			}
+		case xml.StartElement:
+			if t.Name.Space != espacioDC {
+				continue
+			}
+			var valor string
+			if err := l.d.DecodeElement(&valor, &t); err != nil {
+				return Libro{}, l.romper(err)
+			}
+			valor = strings.Join(strings.Fields(valor), " ")
+			switch t.Name.Local {
+			case "title":
+				if libro.Titulo == "" {
+					libro.Titulo = valor
+				}
+			case "creator":
+				if libro.Autor == "" {
+					libro.Autor = valor
+				}
+			case "identifier":
+				if isbn, ok := isbnDeIdentificador(valor); ok && libro.ISBN == "" {
+					libro.ISBN = ISBN(isbn)
+				}
+			case "format":
+				if paginas := paginasDeExtension(valor); paginas > 0 && libro.Paginas == 0 {
+					libro.Paginas = paginas
+				}
+			}
+		}
+	}
+}
+
+func (l *LectorDublinCore) romper(err error) error {
+	var sintaxis *xml.SyntaxError
+	switch {
+	case errors.As(err, &sintaxis):
+		err = nuevoError(ErrDatosInvalidos, "Dublin Core mal formado en la línea %d: %s", sintaxis.Line, sintaxis.Msg)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		err = nuevoError(ErrDatosInvalidos, "Dublin Core incompleto: el documento termina dentro de un registro")
+	default:
+		return err
+	}
+	l.roto = true
+	return err
+}
+
+// isbnDeIdentificador reconoce un dc:identifier que es un ISBN:
+// "urn:isbn:...", "ISBN ..." o el número solo
+func isbnDeIdentificador(valor string) (string, bool) {
+	minusculas := strings.ToLower(valor)
+	if resto, ok := strings.CutPrefix(minusculas, "urn:isbn:"); ok {
+		return resto, true
+	}
+	if strings.HasPrefix(minusculas, "isbn") {
+		return valor, true
+	}
+	if _, err := ParsearISBN(valor); err == nil {
+		return valor, true
+	}
+	return "", false
+}
+
+// CodificarDublinCore escribe libros como registros oai_dc
+func CodificarDublinCore(w io.Writer, libros []Libro) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(xml.Header)
+	fmt.Fprintf(bw, "<registros xmlns:oai_dc=%q xmlns:dc=%q>\n", espacioOAIDC, espacioDC)
+	elemento := func(nombre, valor string) {
+		if valor == "" {
+			return
+		}
+		fmt.Fprintf(bw, "    <dc:%s>", nombre)
+		xml.EscapeText(bw, []byte(valor))
+		fmt.Fprintf(bw, "</dc:%s>\n", nombre)
+	}
+	for _, libro := range libros {
+		bw.WriteString("  <oai_dc:dc>\n")
+		elemento("title", libro.Titulo)
+		elemento("creator", libro.Autor)
+		if libro.ISBN != "" {
+			elemento("identifier", "urn:isbn:"+libro.ISBN.ISBN13())
+		}
+		elemento("format", extensionDePaginas(libro.Paginas))
+		elemento("type", "Text")
+		bw.WriteString("  </oai_dc:dc>\n")
+	}
+	bw.WriteString("</registros>\n")
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("No se pudo escribir el Dublin Core: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ==========================================
+// EJEMPLARES
+// ==========================================
+// Un Libro es un título del catálogo; lo que se presta es cada uno de sus
+// ejemplares físicos. Cada ejemplar tiene su propio código de barras y su
+// ubicación, y la disponibilidad de un título es la de sus ejemplares.
+
+// Disponibilidad resume cuántos ejemplares de un libro hay en la estantería
+// y cuántos usuarios esperan uno. Total no cuenta los retirados. Ejemplares
+// dice dónde está cada uno; solo lo llena Biblioteca.Disponibilidad
+type Disponibilidad struct {
+	LibroID      int `json:"libro_id"`
+	Total        int `json:"total"`
+	Disponibles  int `json:"disponibles"`
+	Prestados    int `json:"prestados"`
+	Apartados    int `json:"apartados"`
+	EnReparacion int `json:"en_reparacion"`
+	Perdidos     int `json:"perdidos"`
+	SoloConsulta int `json:"solo_consulta"`
+	Retirados    int `json:"retirados"`
+	EnTransito   int `json:"en_transito"`
+	EnEspera     int `json:"en_espera"`
+
+	Ejemplares []Paradero `json:"ejemplares,omitempty"`
+}
+
+// nuevoEjemplar agrega a la transacción un ejemplar disponible del libro. Si
+// codigo está vacío se genera a partir del ID del ejemplar, y si sucursal
+// está vacía va a la principal.
+func (tx *transaccion) nuevoEjemplar(libroID int, codigo, ubicacion, sucursal string) (Ejemplar, error) {
+	sucursal, err := tx.sucursalDeAlta(sucursal)
+	if err != nil {
+		return Ejemplar{}, err
+	}
+	ejemplar := Ejemplar{
+		ID:           tx.nuevoID(),
+		LibroID:      libroID,
+		CodigoBarras: strings.TrimSpace(codigo),
+		Ubicacion:    strings.TrimSpace(ubicacion),
+		Estado:       EjemplarDisponible,
+		EstadoDesde:  tx.ahora,
+		Motivo:       "alta",
+		Sucursal:     sucursal,
+	}
+	ejemplar.SucursalActual = sucursal
+	if ejemplar.CodigoBarras == "" {
+		ejemplar.CodigoBarras = fmt.Sprintf("%08d", ejemplar.ID)
+	}
+	if _, existe := tx.b.ejemplares.PorCodigo(ejemplar.CodigoBarras); existe {
+		return Ejemplar{}, nuevoError(ErrConflicto, "Ya existe un ejemplar con el código '%s'", ejemplar.CodigoBarras)
+	}
+	tx.guardarEjemplar(ejemplar)
+	return ejemplar, nil
+}
+
+// AgregarEjemplar suma una copia física a un libro del catálogo, en el fondo
+// de la sucursal indicada o, si está vacía, de la principal
+func (b *Biblioteca) AgregarEjemplar(actor Actor, libroID int, codigo, ubicacion, sucursal string) (*Ejemplar, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.autorizar(actor, PermisoCatalogo, 0); err != nil {
+		return nil, err
+	}
+
+	tx := b.iniciar(actor, EventoEjemplarAgregado)
+	libro, ok := tx.libro(libroID)
+	if !ok {
+		return nil, nuevoError(ErrNoEncontrado, "No existe un libro con ID '%d'", libroID)
+	}
+	if libro.Retirado {
+		return nil, nuevoError(ErrNoPermitido, "El libro '%s' está retirado del catálogo", libro.Titulo)
+	}
+	ejemplar, err := tx.nuevoEjemplar(libroID, codigo, ubicacion, sucursal)
+	if err != nil {
+		return nil, err
+	}
+	// Si el libro tenía cola, el ejemplar nuevo queda apartado para el primero
+	if err := tx.asignarEjemplar(ejemplar, tx.ahora); err != nil {
+		return nil, err
+	}
+	ejemplar, _ = tx.ejemplar(ejemplar.ID)
+	if err := tx.confirmar(); err != nil {
+		return nil, err
+	}
+	return &ejemplar, nil
+}
+
+// BuscarEjemplar busca un ejemplar por ID y retorna una copia
+func (b *Biblioteca) BuscarEjemplar(id int) *Ejemplar {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	ejemplar, ok := b.ejemplares.PorID(id)
+	if !ok {
+		return nil
+	}
+	return &ejemplar
+}
+
+// BuscarEjemplarPorCodigo busca un ejemplar por su código de barras
+func (b *Biblioteca) BuscarEjemplarPorCodigo(codigo string) *Ejemplar {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	ejemplar, ok := b.ejemplares.PorCodigo(strings.TrimSpace(codigo))
+	if !ok {
+		return nil
+	}
+	return &ejemplar
+}
+
+// ListarEjemplares retorna los ejemplares de un libro
+func (b *Biblioteca) ListarEjemplares(libroID int) ([]Ejemplar, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if _, ok := b.libros.PorID(libroID); !ok {
+		return nil, nuevoError(ErrNoEncontrado, "No existe un libro con ID '%d'", libroID)
+	}
+	return b.ejemplares.PorLibro(libroID), nil
+}
+
+// Disponibilidad cuenta los ejemplares de un libro según su estado y dice
+// dónde está ahora cada uno que no esté retirado
+func (b *Biblioteca) Disponibilidad(libroID int) (Disponibilidad, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if _, ok := b.libros.PorID(libroID); !ok {
+		return Disponibilidad{}, nuevoError(ErrNoEncontrado, "No existe un libro con ID '%d'", libroID)
+	}
+	d := b.disponibilidad(libroID)
+	d.Ejemplares = make([]Paradero, 0, d.Total)
+	for _, ejemplar := range b.ejemplares.PorLibro(libroID) {
+		if ejemplar.Estado != EjemplarRetirado {
+			d.Ejemplares = append(d.Ejemplares, b.paradero(ejemplar))
+		}
+	}
+	return d, nil
+}
+
+// disponibilidad cuenta sin tomar el bloqueo; quien llama debe tenerlo
+func (b *Biblioteca) disponibilidad(libroID int) Disponibilidad {
+	d := Disponibilidad{LibroID: libroID}
+	for _, ejemplar := range b.ejemplares.PorLibro(libroID) {
+		if ejemplar.Estado != EjemplarRetirado {
+			d.Total++
+		}
+		switch ejemplar.Estado {
+		case EjemplarDisponible:
+			d.Disponibles++
+		case EjemplarPrestado:
+			d.Prestados++
+		case EjemplarApartado:
+			d.Apartados++
+		case EjemplarEnReparacion:
+			d.EnReparacion++
+		case EjemplarPerdido:
+			d.Perdidos++
+		case EjemplarSoloConsulta:
+			d.SoloConsulta++
+		case EjemplarRetirado:
+			d.Retirados++
+		case EjemplarEnTransito:
+			d.EnTransito++
+		}
+	}
+	for _, reserva := range b.reservas.PendientesPorLibro(libroID) {
+		if reserva.Estado == ReservaEnEspera {
+			d.EnEspera++
+		}
+	}
+	return d
+}
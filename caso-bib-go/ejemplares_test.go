@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDisponibilidadCuentaCadaEjemplar(t *testing.T) {
+	b, _ := nuevaBibliotecaPrueba(t)
+	libro := agregarLibroPrueba(t, b, "Rayuela")
+	for _, codigo := range []string{"RAY-2", "RAY-3", "RAY-4"} {
+		if _, err := b.AgregarEjemplar(Sistema, libro.ID, codigo, "Estante A", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := b.AgregarEjemplar(Sistema, libro.ID, "RAY-2", "", ""); !errors.Is(err, ErrConflicto) {
+		t.Errorf("código repetido: err = %v, se esperaba ErrConflicto", err)
+	}
+	ejemplares, err := b.ListarEjemplares(libro.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ejemplares) != 4 || ejemplares[0].CodigoBarras != "00000002" {
+		t.Fatalf("ejemplares = %+v", ejemplares)
+	}
+	ana := registrarUsuarioPrueba(t, b, "ana")
+	beto := registrarUsuarioPrueba(t, b, "beto")
+	carla := registrarUsuarioPrueba(t, b, "carla")
+
+	// El título se presta mientras quede algún ejemplar en el estante
+	deAna, err := b.PrestarLibro(Sistema, libro.ID, ana.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deBeto, err := b.PrestarLibro(Sistema, libro.ID, beto.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deAna.EjemplarID == deBeto.EjemplarID {
+		t.Fatalf("ana y beto se llevaron el mismo ejemplar %d", deAna.EjemplarID)
+	}
+	if _, err := b.CambiarEstadoEjemplar(Sistema, ejemplares[2].ID, EjemplarEnReparacion, "tapa suelta"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.CambiarEstadoEjemplar(Sistema, ejemplares[3].ID, EjemplarRetirado, "deteriorado"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.PrestarLibro(Sistema, libro.ID, carla.ID); !errors.Is(err, ErrNoPermitido) {
+		t.Errorf("prestar sin ejemplares en el estante: err = %v, se esperaba ErrNoPermitido", err)
+	}
+	reserva := reservar(t, b, libro.ID, carla.ID)
+
+	d, err := b.Disponibilidad(libro.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Disponibilidad{LibroID: libro.ID, Total: 3, Prestados: 2, EnReparacion: 1, Retirados: 1, EnEspera: 1}
+	if len(d.Ejemplares) != 3 {
+		t.Errorf("paraderos = %+v; el retirado no va", d.Ejemplares)
+	}
+	d.Ejemplares = nil
+	if !reflect.DeepEqual(d, want) {
+		t.Errorf("Disponibilidad = %+v, se esperaba %+v", d, want)
+	}
+
+	// Con dos ejemplares prestados hay que decir cuál vuelve
+	if _, err := b.DevolverLibro(Sistema, libro.ID, ""); !errors.Is(err, ErrNoPermitido) {
+		t.Errorf("devolver el título con dos ejemplares afuera: err = %v, se esperaba ErrNoPermitido", err)
+	}
+	if _, err := b.DevolverEjemplar(Sistema, deAna.EjemplarID, ""); err != nil {
+		t.Fatal(err)
+	}
+	verificar(t, b)
+	comprobarReserva(t, b, reserva.ID, ReservaApartada, 0)
+
+	d, err = b.Disponibilidad(libro.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Prestados != 1 || d.Apartados != 1 || d.EnEspera != 0 || len(d.Ejemplares) != 3 {
+		t.Errorf("Disponibilidad después de devolver = %+v", d)
+	}
+	for _, paradero := range d.Ejemplares {
+		var usuario int
+		switch paradero.EjemplarID {
+		case deAna.EjemplarID:
+			usuario = carla.ID
+		case deBeto.EjemplarID:
+			usuario = beto.ID
+		}
+		if paradero.UsuarioID != usuario {
+			t.Errorf("ejemplar %d %s con el usuario %d, se esperaba %d",
+				paradero.EjemplarID, paradero.Estado, paradero.UsuarioID, usuario)
+		}
+	}
+	if _, err := b.Disponibilidad(999); !errors.Is(err, ErrNoEncontrado) {
+		t.Errorf("libro inexistente: err = %v, se esperaba ErrNoEncontrado", err)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ==========================================
+// ERRORES DE NEGOCIO
+// ==========================================
+// Las operaciones de la biblioteca retornan *ErrorBiblioteca cuando la
+// petición no se puede cumplir por una regla del negocio. Cada error
+// pertenece a una categoría, que se consulta con errors.Is:
+//
+//	if errors.Is(err, ErrNoEncontrado) { ... }
+//
+// Cualquier otro error (disco, diario, ...) es un fallo interno.
+
+var (
+	// ErrDatosInvalidos: faltan datos o tienen un formato incorrecto
+	ErrDatosInvalidos = errors.New("datos inválidos")
+	// ErrNoEncontrado: el libro, ejemplar, usuario o préstamo no existe
+	ErrNoEncontrado = errors.New("no encontrado")
+	// ErrConflicto: la entidad ya existe (ISBN o email repetido)
+	ErrConflicto = errors.New("conflicto")
+	// ErrNoPermitido: el estado actual no permite la operación, por ejemplo
+	// prestar un ejemplar que ya está prestado
+	ErrNoPermitido = errors.New("operación no permitida")
+	// ErrNoAutenticado: el actor o su clave no corresponden a una credencial
+	// activa
+	ErrNoAutenticado = errors.New("no autenticado")
+	// ErrSinPermiso: el rol del actor no le permite la operación; el error
+	// es un *ErrorPermiso
+	ErrSinPermiso = errors.New("sin permiso")
+	// ErrSinServicio: un servicio externo que la operación necesita no
+	// respondió, como el de metadatos de libros
+	ErrSinServicio = errors.New("servicio no disponible")
+)
+
+// ErrorBiblioteca es un error de negocio con su categoría y un mensaje para
+// mostrar al usuario
+type ErrorBiblioteca struct {
+	Categoria error
+	Mensaje   string
+}
+
+func (e *ErrorBiblioteca) Error() string {
+	return e.Mensaje
+}
+
+func (e *ErrorBiblioteca) Unwrap() error {
+	return e.Categoria
+}
+
+// nuevoError crea un ErrorBiblioteca con el mensaje formateado
+func nuevoError(categoria error, formato string, args ...any) error {
+	return &ErrorBiblioteca{Categoria: categoria, Mensaje: fmt.Sprintf(formato, args...)}
+}
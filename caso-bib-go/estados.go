@@ -0,0 +1,223 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// ==========================================
+// CICLO DE VIDA DE LOS EJEMPLARES
+// ==========================================
+// Un ejemplar no solo se presta y se devuelve: se manda a reparar, se
+// pierde, se da de baja o se deja para consulta en sala. Cada estado solo
+// puede pasar a los que indica transicionesEjemplar, y cada cambio guarda
+// cuándo ocurrió y por qué.
+//
+// Prestado y apartado los ponen los préstamos y las reservas, y en tránsito
+// los traslados entre sucursales; el personal cambia el resto con
+// CambiarEstadoEjemplar. Un ejemplar prestado solo puede marcarse perdido:
+// se cierra el préstamo y se le cobra al usuario el atraso más
+// PoliticaMultas.Reposicion. Uno en tránsito también, y su traslado se
+// cancela.
+
+// transicionesEjemplar dice a qué estados puede pasar un ejemplar desde cada
+// uno. Retirado no tiene salida: un ejemplar dado de baja no vuelve
+var transicionesEjemplar = map[EstadoEjemplar][]EstadoEjemplar{
+	EjemplarDisponible: {EjemplarPrestado, EjemplarApartado, EjemplarEnReparacion, EjemplarPerdido,
+		EjemplarRetirado, EjemplarSoloConsulta, EjemplarEnTransito},
+	EjemplarApartado: {EjemplarPrestado, EjemplarDisponible, EjemplarEnReparacion, EjemplarPerdido,
+		EjemplarRetirado, EjemplarSoloConsulta},
+	EjemplarPrestado:     {EjemplarDisponible, EjemplarPerdido},
+	EjemplarEnReparacion: {EjemplarDisponible, EjemplarPerdido, EjemplarRetirado, EjemplarSoloConsulta},
+	EjemplarPerdido:      {EjemplarDisponible, EjemplarRetirado},
+	EjemplarSoloConsulta: {EjemplarDisponible, EjemplarEnReparacion, EjemplarPerdido, EjemplarRetirado},
+	EjemplarEnTransito:   {EjemplarDisponible, EjemplarPerdido},
+	EjemplarRetirado:     {},
+}
+
+// estadosManuales son los estados que el personal puede poner a mano
+var estadosManuales = []EstadoEjemplar{
+	EjemplarDisponible, EjemplarEnReparacion, EjemplarPerdido, EjemplarRetirado, EjemplarSoloConsulta,
+}
+
+// Validar revisa que el estado exista
+func (e EstadoEjemplar) Validar() error {
+	if _, ok := transicionesEjemplar[e]; !ok {
+		return nuevoError(ErrDatosInvalidos,
+			"Estado de ejemplar '%s' no válido: use disponible, en_reparacion, perdido, retirado o solo_consulta", e)
+	}
+	return nil
+}
+
+// PuedePasarA indica si un ejemplar en el estado e puede pasar a otro
+func (e EstadoEjemplar) PuedePasarA(otro EstadoEjemplar) bool {
+	return slices.Contains(transicionesEjemplar[e], otro)
+}
+
+// cambiarEstado pasa el ejemplar a nuevo si la transición está permitida y
+// anota cuándo y por qué
+func (e *Ejemplar) cambiarEstado(nuevo EstadoEjemplar, ahora time.Time, motivo string) error {
+	if !e.Estado.PuedePasarA(nuevo) {
+		return nuevoError(ErrNoPermitido, "El ejemplar '%s' no puede pasar de %s a %s", e.CodigoBarras, e.Estado, nuevo)
+	}
+	e.Estado = nuevo
+	e.EstadoDesde = ahora
+	e.Motivo = motivo
+	return nil
+}
+
+// VolverACola devuelve una reserva apartada a la espera, al frente de la
+// cola, cuando su ejemplar deja de estar disponible antes del retiro
+func (r *Reserva) VolverACola() {
+	r.Estado = ReservaEnEspera
+	r.EjemplarID = 0
+	r.FechaLimite = time.Time{}
+}
+
+// CambiarEstadoEjemplar pasa un ejemplar a otro estado a pedido del
+// personal. El motivo es obligatorio. Si el ejemplar estaba apartado, la
+// reserva vuelve a esperar; si estaba prestado y se pierde, se cierra el
+// préstamo y se cobra la reposición; si se pierde o se retira, se cancela su
+// traslado; si vuelve a estar disponible, se le ofrece al primero de la cola
+func (b *Biblioteca) CambiarEstadoEjemplar(actor Actor, id int, estado EstadoEjemplar, motivo string) (*Ejemplar, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.autorizar(actor, PermisoCatalogo, 0); err != nil {
+		return nil, err
+	}
+
+	tx := b.iniciar(actor, EventoEjemplarEstado)
+	ejemplar, ok := tx.ejemplar(id)
+	if !ok {
+		return nil, nuevoError(ErrNoEncontrado, "No existe un ejemplar con ID '%d'", id)
+	}
+	if err := estado.Validar(); err != nil {
+		return nil, err
+	}
+	if !slices.Contains(estadosManuales, estado) {
+		return nil, nuevoError(ErrDatosInvalidos, "El estado %s lo ponen los préstamos, las reservas y los traslados", estado)
+	}
+	motivo = strings.TrimSpace(motivo)
+	if motivo == "" {
+		return nil, nuevoError(ErrDatosInvalidos, "Debe indicar el motivo del cambio de estado")
+	}
+	tx.vencerReservas(ejemplar.LibroID, tx.ahora)
+	ejemplar, _ = tx.ejemplar(id)
+
+	switch {
+	case ejemplar.Estado == estado:
+		return nil, nuevoError(ErrNoPermitido, "El ejemplar '%s' ya está %s", ejemplar.CodigoBarras, estado)
+	case ejemplar.Estado == EjemplarApartado && estado == EjemplarDisponible:
+		return nil, nuevoError(ErrNoPermitido,
+			"El ejemplar '%s' está apartado: cancele la reserva para liberarlo", ejemplar.CodigoBarras)
+	case ejemplar.Estado == EjemplarEnTransito && estado == EjemplarDisponible:
+		return nil, nuevoError(ErrNoPermitido,
+			"El ejemplar '%s' está en tránsito: registre la recepción del traslado", ejemplar.CodigoBarras)
+	}
+	anterior := ejemplar.Estado
+	if err := ejemplar.cambiarEstado(estado, tx.ahora, motivo); err != nil {
+		return nil, err
+	}
+
+	switch anterior {
+	case EjemplarApartado:
+		if reserva, ok := tx.reservaDeEjemplar(ejemplar); ok {
+			reserva.VolverACola()
+			tx.guardarReserva(reserva)
+		}
+	case EjemplarPrestado:
+		if err := b.cobrarPerdida(tx, ejemplar); err != nil {
+			return nil, err
+		}
+	}
+	if estado == EjemplarPerdido || estado == EjemplarRetirado {
+		tx.cerrarTraslado(id)
+	}
+
+	if estado == EjemplarDisponible {
+		// Vuelve a circular: si el libro tiene cola, queda apartado
+		if err := tx.asignarEjemplar(ejemplar, tx.ahora); err != nil {
+			return nil, err
+		}
+		ejemplar, _ = tx.ejemplar(id)
+	} else {
+		tx.guardarEjemplar(ejemplar)
+	}
+	if err := tx.confirmar(); err != nil {
+		return nil, err
+	}
+	return &ejemplar, nil
+}
+
+// cobrarPerdida cierra el préstamo activo de un ejemplar que se perdió y le
+// cobra al usuario el atraso hasta hoy más la reposición. El préstamo queda
+// Devuelto, porque ya no está activo, y Perdido
+func (b *Biblioteca) cobrarPerdida(tx *transaccion, ejemplar Ejemplar) error {
+	prestamo, activo := tx.prestamoActivo(ejemplar.ID)
+	if !activo {
+		return nuevoError(ErrNoPermitido, "No existe un prestamo activo para el ejemplar '%s'", ejemplar.CodigoBarras)
+	}
+	usuario, ok := tx.usuario(prestamo.UsuarioID)
+	if !ok {
+		return nuevoError(ErrNoEncontrado, "No existe un usuario con ID '%d'", prestamo.UsuarioID)
+	}
+
+	multa := b.Politica.Multas.Cobrar(prestamo, b.diasAtraso(prestamo, tx.ahora))
+	reposicion := redondear(b.Politica.Multas.Reposicion)
+	prestamo.Devuelto = true
+	prestamo.FechaDevuelto = tx.ahora
+	prestamo.Multa = redondear(prestamo.Multa + multa)
+	prestamo.Perdido = true
+	prestamo.CargoReposicion = reposicion
+	usuario.PrestamosActivos--
+	usuario.Deuda = redondear(usuario.Deuda + multa + reposicion)
+
+	tx.guardarPrestamo(prestamo)
+	tx.guardarUsuario(usuario)
+	return nil
+}
+
+// TransicionEjemplar es un cambio de estado de un ejemplar
+type TransicionEjemplar struct {
+	Desde  EstadoEjemplar `json:"desde,omitempty"` // vacío en el alta
+	Hasta  EstadoEjemplar `json:"hasta"`
+	Fecha  time.Time      `json:"fecha"`
+	Motivo string         `json:"motivo,omitempty"`
+	Actor  Actor          `json:"actor"`
+}
+
+// HistorialEjemplar retorna los cambios de estado de un ejemplar, del más
+// viejo al más nuevo. Se arma recorriendo los eventos, que guardan cómo
+// quedó el ejemplar en cada operación
+func (b *Biblioteca) HistorialEjemplar(id int) ([]TransicionEjemplar, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if _, ok := b.ejemplares.PorID(id); !ok {
+		return nil, nuevoError(ErrNoEncontrado, "No existe un ejemplar con ID '%d'", id)
+	}
+	historial := make([]TransicionEjemplar, 0)
+	var anterior EstadoEjemplar
+	for _, e := range b.eventos {
+		i := slices.IndexFunc(e.Cambios.Ejemplares, func(ej Ejemplar) bool { return ej.ID == id })
+		if i < 0 || e.Cambios.Ejemplares[i].Estado == anterior {
+			continue
+		}
+		ejemplar := e.Cambios.Ejemplares[i]
+		fecha := ejemplar.EstadoDesde
+		if fecha.IsZero() {
+			fecha = e.Fecha
+		}
+		historial = append(historial, TransicionEjemplar{
+			Desde:  anterior,
+			Hasta:  ejemplar.Estado,
+			Fecha:  fecha,
+			Motivo: ejemplar.Motivo,
+			Actor:  e.Actor,
+		})
+		anterior = ejemplar.Estado
+	}
+	return historial, nil
+}
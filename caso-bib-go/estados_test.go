@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestCambiarEstadoEjemplar(t *testing.T) {
+	b, _ := nuevaBibliotecaPrueba(t)
+	libro := agregarLibroPrueba(t, b, "Rayuela")
+	id := primerEjemplar(t, b, libro.ID)
+
+	for _, caso := range []struct {
+		nombre string
+		estado EstadoEjemplar
+		motivo string
+		err    error
+	}{
+		{"sin motivo", EjemplarEnReparacion, " ", ErrDatosInvalidos},
+		{"estado de los préstamos", EjemplarPrestado, "a mano", ErrDatosInvalidos},
+		{"estado desconocido", "quemado", "incendio", ErrDatosInvalidos},
+		{"el mismo estado", EjemplarDisponible, "inventario", ErrNoPermitido},
+	} {
+		if _, err := b.CambiarEstadoEjemplar(Sistema, id, caso.estado, caso.motivo); !errors.Is(err, caso.err) {
+			t.Errorf("%s: err = %v, se esperaba %v", caso.nombre, err, caso.err)
+		}
+	}
+
+	pasos := []EstadoEjemplar{EjemplarEnReparacion, EjemplarSoloConsulta, EjemplarDisponible, EjemplarPerdido,
+		EjemplarDisponible, EjemplarRetirado}
+	for _, estado := range pasos {
+		ejemplar, err := b.CambiarEstadoEjemplar(Sistema, id, estado, "pasa a "+string(estado))
+		if err != nil {
+			t.Fatalf("pasar a %s: %v", estado, err)
+		}
+		if ejemplar.Estado != estado || ejemplar.Motivo != "pasa a "+string(estado) {
+			t.Errorf("ejemplar %s, motivo %q; se esperaba %s", ejemplar.Estado, ejemplar.Motivo, estado)
+		}
+		verificar(t, b)
+	}
+	// Retirado no tiene salida
+	for _, estado := range estadosManuales {
+		if _, err := b.CambiarEstadoEjemplar(Sistema, id, estado, "error de carga"); !errors.Is(err, ErrNoPermitido) {
+			t.Errorf("de retirado a %s: err = %v, se esperaba ErrNoPermitido", estado, err)
+		}
+	}
+
+	historial, err := b.HistorialEjemplar(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hasta []EstadoEjemplar
+	for i, transicion := range historial {
+		if i > 0 && transicion.Desde != historial[i-1].Hasta {
+			t.Errorf("transición %d desde %s, pero la anterior terminó en %s", i, transicion.Desde, historial[i-1].Hasta)
+		}
+		hasta = append(hasta, transicion.Hasta)
+	}
+	if want := append([]EstadoEjemplar{EjemplarDisponible}, pasos...); !slices.Equal(hasta, want) {
+		t.Errorf("historial %v, se esperaba %v", hasta, want)
+	}
+	if historial[0].Desde != "" || historial[1].Motivo != "pasa a en_reparacion" {
+		t.Errorf("historial = %+v", historial)
+	}
+}
+
+func TestEjemplarPrestadoPerdidoCobraReposicion(t *testing.T) {
+	b, reloj := nuevaBibliotecaPrueba(t)
+	libro := agregarLibroPrueba(t, b, "Ficciones")
+	id := primerEjemplar(t, b, libro.ID)
+	ana := registrarUsuarioPrueba(t, b, "ana")
+	prestamo, err := b.PrestarLibro(Sistema, libro.ID, ana.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.CambiarEstadoEjemplar(Sistema, id, EjemplarEnReparacion, "se rompió"); !errors.Is(err, ErrNoPermitido) {
+		t.Errorf("mandar a reparar un ejemplar prestado: err = %v, se esperaba ErrNoPermitido", err)
+	}
+
+	// Seis días tarde: cinco después de la gracia a 0.50, más la reposición
+	reloj.Avanzar(20 * dia)
+	if _, err := b.CambiarEstadoEjemplar(Sistema, id, EjemplarPerdido, "el usuario lo perdió"); err != nil {
+		t.Fatal(err)
+	}
+	verificar(t, b)
+	perdido, _ := b.prestamos.PorID(prestamo.ID)
+	if !perdido.Devuelto || !perdido.Perdido || perdido.Multa != 2.5 || perdido.CargoReposicion != 25 ||
+		!perdido.FechaDevuelto.Equal(reloj.Ahora()) {
+		t.Errorf("préstamo perdido = %+v; se esperaba cerrado con multa 2.50 y reposición 25", perdido)
+	}
+	if u := b.BuscarUsuario(ana.ID); u.Deuda != 27.5 || u.PrestamosActivos != 0 {
+		t.Errorf("deuda %.2f, préstamos activos %d; se esperaba 27.50 y 0", u.Deuda, u.PrestamosActivos)
+	}
+	if _, err := b.DevolverLibro(Sistema, libro.ID, ""); err == nil {
+		t.Error("se devolvió un libro cuyo único ejemplar está perdido")
+	}
+
+	// Si aparece vuelve a circular, pero el cargo ya cobrado se queda
+	if _, err := b.CambiarEstadoEjemplar(Sistema, id, EjemplarDisponible, "apareció"); err != nil {
+		t.Fatal(err)
+	}
+	verificar(t, b)
+	if u := b.BuscarUsuario(ana.ID); u.Deuda != 27.5 {
+		t.Errorf("deuda %.2f después de encontrarlo, se esperaba 27.50", u.Deuda)
+	}
+}
+
+func TestEjemplarApartadoAReparacionVuelveLaReservaACola(t *testing.T) {
+	b, _ := nuevaBibliotecaPrueba(t)
+	libro := agregarLibroPrueba(t, b, "El Aleph")
+	id := primerEjemplar(t, b, libro.ID)
+	ana := registrarUsuarioPrueba(t, b, "ana")
+	beto := registrarUsuarioPrueba(t, b, "beto")
+	if _, err := b.PrestarLibro(Sistema, libro.ID, ana.ID); err != nil {
+		t.Fatal(err)
+	}
+	reserva := reservar(t, b, libro.ID, beto.ID)
+	if _, err := b.DevolverLibro(Sistema, libro.ID, ""); err != nil {
+		t.Fatal(err)
+	}
+	comprobarReserva(t, b, reserva.ID, ReservaApartada, 0)
+	if _, err := b.CambiarEstadoEjemplar(Sistema, id, EjemplarDisponible, "liberar"); !errors.Is(err, ErrNoPermitido) {
+		t.Errorf("liberar un ejemplar apartado: err = %v, se esperaba ErrNoPermitido", err)
+	}
+
+	if _, err := b.CambiarEstadoEjemplar(Sistema, id, EjemplarEnReparacion, "hojas sueltas"); err != nil {
+		t.Fatal(err)
+	}
+	verificar(t, b)
+	comprobarReserva(t, b, reserva.ID, ReservaEnEspera, 1)
+
+	// Reparado, queda apartado otra vez para beto
+	if _, err := b.CambiarEstadoEjemplar(Sistema, id, EjemplarDisponible, "reparado"); err != nil {
+		t.Fatal(err)
+	}
+	verificar(t, b)
+	comprobarReserva(t, b, reserva.ID, ReservaApartada, 0)
+	if e := b.BuscarEjemplar(id); e.Estado != EjemplarApartado {
+		t.Errorf("ejemplar %s después de repararlo, se esperaba apartado", e.Estado)
+	}
+}
@@ -0,0 +1,516 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ==========================================
+// ETIQUETAS Y CARNÉS
+// ==========================================
+// Cada ejemplar lleva en el lomo una etiqueta con su código de barras en
+// Code128 (o el EAN-13 del ISBN de su libro) y cada usuario un carné con un
+// QR que dice "USUARIO:<ID>". Las etiquetas se dibujan de a una en PNG o
+// SVG, o en tanda en un PDF con el formato de una hoja de etiquetas
+// autoadhesivas.
+//
+// En el mostrador basta con escanear: LeerCodigo reconoce el código de un
+// ejemplar, el EAN-13 o ISBN de un libro y el QR de un carné, y
+// PrestarPorCodigos presta con lo que se escaneó.
+
+// prefijoCarnet antecede al ID del usuario en el QR de su carné
+const prefijoCarnet = "USUARIO:"
+
+// CodigoCarnet retorna lo que lleva el QR del carné de un usuario
+func CodigoCarnet(usuarioID int) string {
+	return prefijoCarnet + strconv.Itoa(usuarioID)
+}
+
+// Etiqueta es lo que se imprime en una etiqueta: unas líneas de texto y un
+// símbolo
+type Etiqueta struct {
+	Lineas  []string
+	Simbolo Simbolo
+}
+
+// SimboloEjemplar codifica un ejemplar: su código de barras en Code128 o,
+// con CodigoEAN13, el ISBN de su libro
+func (b *Biblioteca) SimboloEjemplar(id int, tipo TipoCodigo) (Simbolo, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	ejemplar, ok := b.ejemplares.PorID(id)
+	if !ok {
+		return Simbolo{}, nuevoError(ErrNoEncontrado, "No existe un ejemplar con ID '%d'", id)
+	}
+	switch tipo {
+	case "", CodigoCode128:
+		return Code128(ejemplar.CodigoBarras)
+	case CodigoEAN13:
+		return b.simboloLibro(ejemplar.LibroID)
+	}
+	return Simbolo{}, nuevoError(ErrDatosInvalidos, "Código desconocido '%s': use code128 o ean13", tipo)
+}
+
+// SimboloLibro codifica el ISBN de un libro como EAN-13
+func (b *Biblioteca) SimboloLibro(id int) (Simbolo, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.simboloLibro(id)
+}
+
+func (b *Biblioteca) simboloLibro(id int) (Simbolo, error) {
+	libro, ok := b.libros.PorID(id)
+	if !ok {
+		return Simbolo{}, nuevoError(ErrNoEncontrado, "No existe un libro con ID '%d'", id)
+	}
+	if libro.ISBN == "" {
+		return Simbolo{}, nuevoError(ErrNoPermitido, "El libro '%s' no tiene ISBN para un EAN-13", libro.Titulo)
+	}
+	return EAN13(libro.ISBN.ISBN13())
+}
+
+// Carnet arma el carné de un usuario: el nombre de la biblioteca, el del
+// usuario y su número, y el QR
+func (b *Biblioteca) Carnet(usuarioID int) (Etiqueta, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.carnet(usuarioID)
+}
+
+func (b *Biblioteca) carnet(usuarioID int) (Etiqueta, error) {
+	usuario, ok := b.usuarios.PorID(usuarioID)
+	if !ok {
+		return Etiqueta{}, nuevoError(ErrNoEncontrado, "No existe un usuario con ID '%d'", usuarioID)
+	}
+	if usuario.Anonimizado {
+		return Etiqueta{}, nuevoError(ErrNoPermitido, "La cuenta %d está anonimizada", usuarioID)
+	}
+	simbolo, err := QR(CodigoCarnet(usuarioID))
+	if err != nil {
+		return Etiqueta{}, err
+	}
+	return Etiqueta{
+		Lineas:  []string{b.Nombre, usuario.Nombre, fmt.Sprintf("Usuario N° %d", usuario.ID)},
+		Simbolo: simbolo,
+	}, nil
+}
+
+// etiquetaEjemplar arma la etiqueta de lomo de un ejemplar: título, autor,
+// ubicación y el Code128 de su código
+func (b *Biblioteca) etiquetaEjemplar(ejemplar Ejemplar) (Etiqueta, error) {
+	libro, ok := b.libros.PorID(ejemplar.LibroID)
+	if !ok {
+		return Etiqueta{}, nuevoError(ErrNoEncontrado, "No existe un libro con ID '%d'", ejemplar.LibroID)
+	}
+	simbolo, err := Code128(ejemplar.CodigoBarras)
+	if err != nil {
+		return Etiqueta{}, err
+	}
+	lineas := []string{libro.Titulo, libro.Autor}
+	if ubicacion := strings.TrimSpace(ejemplar.Sucursal + " " + ejemplar.Ubicacion); ubicacion != "" {
+		lineas = append(lineas, ubicacion)
+	}
+	return Etiqueta{Lineas: lineas, Simbolo: simbolo}, nil
+}
+
+// PedidoEtiquetas elige qué imprimir en una hoja: las etiquetas de lomo de
+// Ejemplares y de todos los ejemplares no retirados de Libros, y los
+// carnés de Usuarios, en ese orden
+type PedidoEtiquetas struct {
+	Ejemplares []int
+	Libros     []int
+	Usuarios   []int
+}
+
+// ParsearIDs lee una lista de IDs separados por coma; vacía da nil
+func ParsearIDs(texto string) ([]int, error) {
+	var ids []int
+	for _, parte := range strings.Split(texto, ",") {
+		parte = strings.TrimSpace(parte)
+		if parte == "" {
+			continue
+		}
+		id, err := strconv.Atoi(parte)
+		if err != nil || id <= 0 {
+			return nil, nuevoError(ErrDatosInvalidos, "ID inválido '%s'", parte)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Etiquetas arma las etiquetas de un pedido
+func (b *Biblioteca) Etiquetas(pedido PedidoEtiquetas) ([]Etiqueta, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	var etiquetas []Etiqueta
+	agregar := func(ejemplar Ejemplar) error {
+		etiqueta, err := b.etiquetaEjemplar(ejemplar)
+		if err != nil {
+			return err
+		}
+		etiquetas = append(etiquetas, etiqueta)
+		return nil
+	}
+	for _, id := range pedido.Ejemplares {
+		ejemplar, ok := b.ejemplares.PorID(id)
+		if !ok {
+			return nil, nuevoError(ErrNoEncontrado, "No existe un ejemplar con ID '%d'", id)
+		}
+		if err := agregar(ejemplar); err != nil {
+			return nil, err
+		}
+	}
+	for _, id := range pedido.Libros {
+		if _, ok := b.libros.PorID(id); !ok {
+			return nil, nuevoError(ErrNoEncontrado, "No existe un libro con ID '%d'", id)
+		}
+		for _, ejemplar := range b.ejemplares.PorLibro(id) {
+			if ejemplar.Estado == EjemplarRetirado {
+				continue
+			}
+			if err := agregar(ejemplar); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, id := range pedido.Usuarios {
+		carnet, err := b.carnet(id)
+		if err != nil {
+			return nil, err
+		}
+		etiquetas = append(etiquetas, carnet)
+	}
+	if len(etiquetas) == 0 {
+		return nil, nuevoError(ErrDatosInvalidos, "No hay etiquetas para imprimir")
+	}
+	return etiquetas, nil
+}
+
+// ==========================================
+// LECTURA DE CÓDIGOS
+// ==========================================
+
+// TipoLectura es a qué corresponde un código escaneado
+type TipoLectura string
+
+const (
+	LecturaEjemplar TipoLectura = "ejemplar"
+	LecturaLibro    TipoLectura = "libro"
+	LecturaUsuario  TipoLectura = "usuario"
+)
+
+// Lectura es lo que se reconoció en un código escaneado. Un ejemplar trae
+// también su libro
+type Lectura struct {
+	Codigo     string      `json:"codigo"`
+	Tipo       TipoLectura `json:"tipo"`
+	LibroID    int         `json:"libro_id,omitempty"`
+	EjemplarID int         `json:"ejemplar_id,omitempty"`
+	UsuarioID  int         `json:"usuario_id,omitempty"`
+}
+
+// LeerCodigo reconoce un código escaneado. Primero se busca como código de
+// barras de un ejemplar, porque los importados pueden ser cualquier texto;
+// después como carné y al final como ISBN
+func (b *Biblioteca) LeerCodigo(codigo string) (Lectura, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.leerCodigo(codigo)
+}
+
+// leerCodigo es LeerCodigo sin tomar el bloqueo
+func (b *Biblioteca) leerCodigo(codigo string) (Lectura, error) {
+	codigo = strings.TrimSpace(codigo)
+	lectura := Lectura{Codigo: codigo}
+	if ejemplar, ok := b.ejemplares.PorCodigo(codigo); ok {
+		lectura.Tipo, lectura.EjemplarID, lectura.LibroID = LecturaEjemplar, ejemplar.ID, ejemplar.LibroID
+		return lectura, nil
+	}
+	if texto, ok := strings.CutPrefix(codigo, prefijoCarnet); ok {
+		id, err := strconv.Atoi(texto)
+		if err != nil {
+			return Lectura{}, nuevoError(ErrDatosInvalidos, "Carné no válido '%s'", codigo)
+		}
+		if _, ok := b.usuarios.PorID(id); !ok {
+			return Lectura{}, nuevoError(ErrNoEncontrado, "No existe un usuario con ID '%d'", id)
+		}
+		lectura.Tipo, lectura.UsuarioID = LecturaUsuario, id
+		return lectura, nil
+	}
+	if isbn, err := ParsearISBN(codigo); err == nil {
+		if libro, ok := b.libros.PorISBN(isbn); ok {
+			lectura.Tipo, lectura.LibroID = LecturaLibro, libro.ID
+			return lectura, nil
+		}
+	}
+	return Lectura{}, nuevoError(ErrNoEncontrado, "El código '%s' no es de ningún ejemplar, libro ni usuario", codigo)
+}
+
+// PrestarPorCodigos presta lo que se escaneó: item es el código de un
+// ejemplar, que se presta ese, o de un libro, que se presta como con
+// PrestarLibro; usuario es el QR de su carné
+func (b *Biblioteca) PrestarPorCodigos(actor Actor, item, usuario string) (*Prestamo, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	lecturaUsuario, err := b.leerCodigo(usuario)
+	if err != nil {
+		return nil, err
+	}
+	if lecturaUsuario.Tipo != LecturaUsuario {
+		return nil, nuevoError(ErrDatosInvalidos, "El código '%s' es de un %s, no de un usuario", usuario, lecturaUsuario.Tipo)
+	}
+	lecturaItem, err := b.leerCodigo(item)
+	if err != nil {
+		return nil, err
+	}
+	switch lecturaItem.Tipo {
+	case LecturaEjemplar:
+		return b.prestarEjemplar(actor, lecturaItem.EjemplarID, lecturaUsuario.UsuarioID)
+	case LecturaLibro:
+		return b.prestarLibro(actor, lecturaItem.LibroID, lecturaUsuario.UsuarioID)
+	}
+	return nil, nuevoError(ErrDatosInvalidos, "El código '%s' es de un usuario, no de un ejemplar ni de un libro", item)
+}
+
+// ==========================================
+// HOJAS DE ETIQUETAS EN PDF
+// ==========================================
+
+// FormatoHoja describe una hoja de etiquetas autoadhesivas, en milímetros
+type FormatoHoja struct {
+	Nombre             string
+	AnchoPagina        float64
+	AltoPagina         float64
+	Columnas, Filas    int
+	AnchoEtiqueta      float64
+	AltoEtiqueta       float64
+	MargenIzquierdo    float64
+	MargenSuperior     float64
+	EspacioH, EspacioV float64 // entre etiquetas vecinas
+}
+
+// FormatosHoja son las hojas conocidas, por nombre
+var FormatosHoja = map[string]FormatoHoja{
+	// Lomos en A4, como Avery L7160
+	"a4-3x7": {Nombre: "a4-3x7", AnchoPagina: 210, AltoPagina: 297, Columnas: 3, Filas: 7,
+		AnchoEtiqueta: 63.5, AltoEtiqueta: 38.1, MargenIzquierdo: 7.2, MargenSuperior: 15.15, EspacioH: 2.5},
+	// Lomos en carta, como Avery 5160
+	"carta-3x10": {Nombre: "carta-3x10", AnchoPagina: 215.9, AltoPagina: 279.4, Columnas: 3, Filas: 10,
+		AnchoEtiqueta: 66.675, AltoEtiqueta: 25.4, MargenIzquierdo: 4.76, MargenSuperior: 12.7, EspacioH: 3.175},
+	// Carnés del tamaño de una tarjeta en A4, como Avery C32011
+	"a4-2x5": {Nombre: "a4-2x5", AnchoPagina: 210, AltoPagina: 297, Columnas: 2, Filas: 5,
+		AnchoEtiqueta: 85, AltoEtiqueta: 54, MargenIzquierdo: 15, MargenSuperior: 13.5, EspacioH: 10},
+}
+
+// HojaPorDefecto es la hoja que se usa si no se elige otra
+const HojaPorDefecto = "a4-3x7"
+
+// BuscarFormatoHoja retorna una hoja por nombre. Vacío es HojaPorDefecto
+func BuscarFormatoHoja(nombre string) (FormatoHoja, error) {
+	nombre = strings.ToLower(strings.TrimSpace(nombre))
+	if nombre == "" {
+		nombre = HojaPorDefecto
+	}
+	hoja, ok := FormatosHoja[nombre]
+	if !ok {
+		return FormatoHoja{}, nuevoError(ErrDatosInvalidos, "Hoja desconocida '%s': use a4-3x7, carta-3x10 o a4-2x5", nombre)
+	}
+	return hoja, nil
+}
+
+// puntosPorMM convierte milímetros a puntos de PDF
+const puntosPorMM = 72 / 25.4
+
+// Medidas de la letra y el relleno de una etiqueta, en milímetros
+const (
+	rellenoEtiqueta = 2.0
+	letraEtiqueta   = 2.6 // cuerpo de la letra, unos 7,4 puntos
+	lineaEtiqueta   = 3.2
+)
+
+// trazo es un elemento ya ubicado en una etiqueta, en milímetros desde su
+// esquina superior izquierda: un rectángulo o, si texto no está vacío, una
+// línea de texto con la base en y
+type trazo struct {
+	x, y, ancho, alto float64
+	texto             string
+	tamano            float64
+}
+
+// disponer ubica las líneas y el símbolo de una etiqueta de ancho por alto.
+// Un código lineal va abajo, a todo el ancho, con su texto debajo y las
+// líneas arriba; un QR va a la izquierda, a todo el alto, con las líneas a
+// su derecha
+func disponer(e Etiqueta, ancho, alto float64) []trazo {
+	var trazos []trazo
+	lineas := func(x, y, disponible float64) {
+		for _, linea := range e.Lineas {
+			if y > alto-rellenoEtiqueta {
+				break
+			}
+			trazos = append(trazos, trazo{x: x, y: y, texto: recortar(linea, disponible, letraEtiqueta), tamano: letraEtiqueta})
+			y += lineaEtiqueta
+		}
+	}
+	anchoSimbolo, altoSimbolo := e.Simbolo.tamano()
+	adentro := ancho - 2*rellenoEtiqueta
+	if e.Simbolo.lineal() {
+		arriba := rellenoEtiqueta + float64(len(e.Lineas))*lineaEtiqueta
+		lineas(rellenoEtiqueta, rellenoEtiqueta+letraEtiqueta, adentro)
+		modulo := adentro / float64(anchoSimbolo)
+		barras := max(alto-arriba-rellenoEtiqueta-lineaEtiqueta, 4)
+		escalaY := barras / float64(altoSimbolo)
+		for _, r := range e.Simbolo.rectangulos() {
+			trazos = append(trazos, trazo{
+				x: rellenoEtiqueta + float64(r.x)*modulo, y: arriba + float64(r.y)*escalaY,
+				ancho: float64(r.ancho) * modulo, alto: float64(r.alto) * escalaY,
+			})
+		}
+		texto := e.Simbolo.Texto
+		trazos = append(trazos, trazo{
+			x: ancho/2 - float64(len(texto))*letraEtiqueta*0.28, y: arriba + barras + letraEtiqueta,
+			texto: texto, tamano: letraEtiqueta,
+		})
+		return trazos
+	}
+	lado := min(alto, ancho/2) - 2*rellenoEtiqueta
+	modulo := lado / float64(anchoSimbolo)
+	for _, r := range e.Simbolo.rectangulos() {
+		trazos = append(trazos, trazo{
+			x: rellenoEtiqueta + float64(r.x)*modulo, y: rellenoEtiqueta + float64(r.y)*modulo,
+			ancho: float64(r.ancho) * modulo, alto: float64(r.alto) * modulo,
+		})
+	}
+	x := 2*rellenoEtiqueta + lado
+	lineas(x, rellenoEtiqueta+2*letraEtiqueta, ancho-x-rellenoEtiqueta)
+	return trazos
+}
+
+// recortar acorta texto para que entre en ancho milímetros con letra de
+// tamano, contando cada carácter como la mitad del cuerpo
+func recortar(texto string, ancho, tamano float64) string {
+	maximo := int(ancho / (tamano * 0.5))
+	runas := []rune(texto)
+	if len(runas) <= maximo || maximo < 1 {
+		return texto
+	}
+	return string(runas[:maximo-1]) + "…"
+}
+
+// EscribirHojaPDF imprime las etiquetas en hojas del formato dado, de
+// izquierda a derecha y de arriba abajo, con tantas páginas como hagan
+// falta. Usa Helvetica, que todo lector de PDF trae
+func EscribirHojaPDF(w io.Writer, hoja FormatoHoja, etiquetas []Etiqueta) error {
+	porPagina := hoja.Columnas * hoja.Filas
+	var paginas [][]byte
+	for inicio := 0; inicio < len(etiquetas); inicio += porPagina {
+		var contenido bytes.Buffer
+		for i, e := range etiquetas[inicio:min(inicio+porPagina, len(etiquetas))] {
+			columna, fila := i%hoja.Columnas, i/hoja.Columnas
+			x0 := hoja.MargenIzquierdo + float64(columna)*(hoja.AnchoEtiqueta+hoja.EspacioH)
+			y0 := hoja.MargenSuperior + float64(fila)*(hoja.AltoEtiqueta+hoja.EspacioV)
+			for _, t := range disponer(e, hoja.AnchoEtiqueta, hoja.AltoEtiqueta) {
+				// PDF mide desde abajo a la izquierda y en puntos
+				x := (x0 + t.x) * puntosPorMM
+				y := (hoja.AltoPagina - y0 - t.y) * puntosPorMM
+				if t.texto != "" {
+					fmt.Fprintf(&contenido, "BT /F1 %.2f Tf %.2f %.2f Td (%s) Tj ET\n",
+						t.tamano*puntosPorMM, x, y, textoPDF(t.texto))
+					continue
+				}
+				fmt.Fprintf(&contenido, "%.3f %.3f %.3f %.3f re f\n", x, y-t.alto*puntosPorMM,
+					t.ancho*puntosPorMM, t.alto*puntosPorMM)
+			}
+		}
+		paginas = append(paginas, contenido.Bytes())
+	}
+
+	bw := bufio.NewWriter(w)
+	escrito := 0
+	var posiciones []int
+	escribir := func(formato string, args ...any) {
+		n, _ := fmt.Fprintf(bw, formato, args...)
+		escrito += n
+	}
+	objeto := func(cuerpo string, args ...any) {
+		posiciones = append(posiciones, escrito)
+		escribir("%d 0 obj\n", len(posiciones))
+		escribir(cuerpo, args...)
+		escribir("\nendobj\n")
+	}
+	escribir("%%PDF-1.4\n")
+	objeto("<< /Type /Catalog /Pages 2 0 R >>")
+	hijos := make([]string, len(paginas))
+	for i := range paginas {
+		// Cada página ocupa dos objetos, la página y su contenido, después
+		// del catálogo, el árbol de páginas y la fuente
+		hijos[i] = fmt.Sprintf("%d 0 R", 4+2*i)
+	}
+	objeto("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(hijos, " "), len(paginas))
+	objeto("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	for i, contenido := range paginas {
+		objeto("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>",
+			hoja.AnchoPagina*puntosPorMM, hoja.AltoPagina*puntosPorMM, 5+2*i)
+		objeto("<< /Length %d >>\nstream\n%s\nendstream", len(contenido), contenido)
+	}
+	inicioXref := escrito
+	escribir("xref\n0 %d\n0000000000 65535 f \n", len(posiciones)+1)
+	for _, posicion := range posiciones {
+		escribir("%010d 00000 n \n", posicion)
+	}
+	escribir("trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(posiciones)+1, inicioXref)
+	return bw.Flush()
+}
+
+// textoPDF pasa texto a Windows-1252, la codificación de la fuente, y
+// escapa lo que PDF interpreta dentro de un literal. Lo que no existe en
+// esa codificación se reemplaza por '?'
+func textoPDF(texto string) string {
+	var b strings.Builder
+	for _, r := range texto {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= ' ' && r < 0x7F:
+			b.WriteRune(r)
+		case r >= 0xA0 && r <= 0xFF:
+			fmt.Fprintf(&b, "\\%03o", r)
+		case r == '…':
+			b.WriteString("\\205")
+		case r == '€':
+			b.WriteString("\\200")
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// EscribirEtiquetaSVG dibuja una sola etiqueta de ancho por alto
+// milímetros, con el mismo diseño que en la hoja
+func EscribirEtiquetaSVG(w io.Writer, e Etiqueta, ancho, alto float64) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, `<svg xmlns="http://www.w3.org/2000/svg" width="%gmm" height="%gmm" viewBox="0 0 %g %g">`+"\n",
+		ancho, alto, ancho, alto)
+	fmt.Fprintf(bw, `<rect width="%g" height="%g" fill="#fff" stroke="#ccc" stroke-width="0.2"/>`+"\n", ancho, alto)
+	for _, t := range disponer(e, ancho, alto) {
+		if t.texto != "" {
+			fmt.Fprintf(bw, `<text x="%.2f" y="%.2f" font-family="Helvetica, Arial, sans-serif" font-size="%.2f">%s</text>`+"\n",
+				t.x, t.y, t.tamano, html.EscapeString(t.texto))
+			continue
+		}
+		fmt.Fprintf(bw, `<rect x="%.3f" y="%.3f" width="%.3f" height="%.3f"/>`+"\n", t.x, t.y, t.ancho, t.alto)
+	}
+	fmt.Fprintln(bw, `</svg>`)
+	return bw.Flush()
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestHojaPDFConXrefCorrecto(t *testing.T) {
+	b, _ := nuevaBibliotecaPrueba(t)
+	libro, err := b.AgregarLibro(Sistema, "Cien años de soledad", "García Márquez", "9780134190440", 400)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ana := registrarUsuarioPrueba(t, b, "ana")
+	etiquetas, err := b.Etiquetas(PedidoEtiquetas{Libros: []int{libro.ID}, Usuarios: []int{ana.ID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hoja, _ := BuscarFormatoHoja("")
+	// 22 etiquetas no entran en una hoja de 21
+	var pdf bytes.Buffer
+	if err := EscribirHojaPDF(&pdf, hoja, slices.Repeat(etiquetas, 11)); err != nil {
+		t.Fatal(err)
+	}
+	datos := pdf.Bytes()
+
+	final := regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`).FindSubmatch(datos)
+	if final == nil {
+		t.Fatalf("el PDF no termina en startxref:\n%s", datos[max(0, len(datos)-200):])
+	}
+	inicio, _ := strconv.Atoi(string(final[1]))
+	xref := regexp.MustCompile(`^xref\n0 (\d+)\n0000000000 65535 f \n`).FindSubmatch(datos[inicio:])
+	if xref == nil {
+		t.Fatalf("startxref %d no apunta a la tabla xref", inicio)
+	}
+	objetos, _ := strconv.Atoi(string(xref[1]))
+	// Catálogo, páginas, fuente y dos objetos por página
+	if objetos != 1+3+2*2 {
+		t.Errorf("la tabla xref tiene %d entradas, se esperaban %d", objetos, 1+3+2*2)
+	}
+	entradas := datos[inicio+len(xref[0]):]
+	for n := 1; n < objetos; n++ {
+		entrada := entradas[(n-1)*20 : n*20]
+		posicion, err := strconv.Atoi(string(entrada[:10]))
+		if err != nil || string(entrada[10:]) != " 00000 n \n" {
+			t.Fatalf("entrada %d de la tabla xref mal formada: %q", n, entrada)
+		}
+		if encabezado := fmt.Sprintf("%d 0 obj\n", n); !bytes.HasPrefix(datos[posicion:], []byte(encabezado)) {
+			t.Errorf("la entrada %d apunta a %q, se esperaba %q", n, datos[posicion:posicion+len(encabezado)], encabezado)
+		}
+	}
+	if !bytes.Contains(datos, []byte("/Count 2")) {
+		t.Error("el árbol de páginas no tiene dos páginas")
+	}
+}
+
+func TestLeerCodigoYPrestarPorCodigos(t *testing.T) {
+	b, _ := nuevaBibliotecaPrueba(t)
+	libro, err := b.AgregarLibro(Sistema, "Cien años de soledad", "García Márquez", "9780134190440", 400)
+	if err != nil {
+		t.Fatal(err)
+	}
+	segundo, err := b.AgregarEjemplar(Sistema, libro.ID, "BIB-0002", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ana := registrarUsuarioPrueba(t, b, "ana")
+	carnet := CodigoCarnet(ana.ID)
+
+	casos := []struct {
+		codigo string
+		want   Lectura
+	}{
+		{"BIB-0002\n", Lectura{Tipo: LecturaEjemplar, LibroID: libro.ID, EjemplarID: segundo.ID}},
+		{"9780134190440", Lectura{Tipo: LecturaLibro, LibroID: libro.ID}},
+		{"978-0-13-419044-0", Lectura{Tipo: LecturaLibro, LibroID: libro.ID}},
+		{"0134190440", Lectura{Tipo: LecturaLibro, LibroID: libro.ID}},
+		{carnet, Lectura{Tipo: LecturaUsuario, UsuarioID: ana.ID}},
+	}
+	for _, caso := range casos {
+		lectura, err := b.LeerCodigo(caso.codigo)
+		if err != nil {
+			t.Errorf("LeerCodigo(%q): %v", caso.codigo, err)
+			continue
+		}
+		lectura.Codigo = ""
+		if lectura != caso.want {
+			t.Errorf("LeerCodigo(%q) = %+v, se esperaba %+v", caso.codigo, lectura, caso.want)
+		}
+	}
+	for codigo, want := range map[string]error{
+		"9780306406157":  ErrNoEncontrado,
+		"USUARIO:99":     ErrNoEncontrado,
+		"USUARIO:ana":    ErrDatosInvalidos,
+		"cualquier cosa": ErrNoEncontrado,
+	} {
+		if _, err := b.LeerCodigo(codigo); !errors.Is(err, want) {
+			t.Errorf("LeerCodigo(%q): err = %v, se esperaba %v", codigo, err, want)
+		}
+	}
+
+	// El código de un ejemplar presta ese ejemplar; el ISBN, el que esté
+	// disponible
+	prestamo, err := b.PrestarPorCodigos(Sistema, "BIB-0002", carnet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prestamo.EjemplarID != segundo.ID || prestamo.UsuarioID != ana.ID {
+		t.Errorf("PrestarPorCodigos por ejemplar = %+v, se esperaba el ejemplar %d para ana", prestamo, segundo.ID)
+	}
+	prestamo, err = b.PrestarPorCodigos(Sistema, "9780134190440", carnet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prestamo.EjemplarID == segundo.ID || prestamo.LibroID != libro.ID {
+		t.Errorf("PrestarPorCodigos por ISBN = %+v, se esperaba el otro ejemplar del libro", prestamo)
+	}
+	verificar(t, b)
+
+	for _, par := range [][2]string{{carnet, carnet}, {"9780134190440", "BIB-0002"}} {
+		if _, err := b.PrestarPorCodigos(Sistema, par[0], par[1]); !errors.Is(err, ErrDatosInvalidos) {
+			t.Errorf("PrestarPorCodigos(%q, %q): err = %v, se esperaba ErrDatosInvalidos", par[0], par[1], err)
+		}
+	}
+}
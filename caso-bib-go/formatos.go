@@ -0,0 +1,235 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ==========================================
+// FORMATOS BIBLIOGRÁFICOS
+// ==========================================
+// Además de CSV, el catálogo se intercambia en los formatos que usan los
+// proveedores y el catálogo colectivo: MARC21 (ISO 2709) y MARCXML, Dublin
+// Core y BibTeX. Todos traen título, autor, ISBN y páginas; el resto de cada
+// registro se ignora al importar. Cada formato tiene un lector, que
+// entrega los libros de a uno para que un registro dañado no impida leer los
+// siguientes, y una función que codifica una lista de libros.
+
+// FormatoBibliografico es un formato de archivo de libros
+type FormatoBibliografico string
+
+const (
+	FormatoCSV        FormatoBibliografico = "csv"
+	FormatoMARC       FormatoBibliografico = "marc"
+	FormatoMARCXML    FormatoBibliografico = "marcxml"
+	FormatoDublinCore FormatoBibliografico = "dc"
+	FormatoBibTeX     FormatoBibliografico = "bibtex"
+)
+
+// ParsearFormato valida el nombre de un formato. Vacío es CSV
+func ParsearFormato(texto string) (FormatoBibliografico, error) {
+	formato := FormatoBibliografico(strings.ToLower(strings.TrimSpace(texto)))
+	switch formato {
+	case "":
+		return FormatoCSV, nil
+	case FormatoCSV, FormatoMARC, FormatoMARCXML, FormatoDublinCore, FormatoBibTeX:
+		return formato, nil
+	}
+	return "", nuevoError(ErrDatosInvalidos, "Formato desconocido '%s': use csv, marc, marcxml, dc o bibtex", texto)
+}
+
+// TipoContenido retorna el tipo MIME del formato
+func (f FormatoBibliografico) TipoContenido() string {
+	switch f {
+	case FormatoMARC:
+		return "application/marc"
+	case FormatoMARCXML:
+		return "application/marcxml+xml"
+	case FormatoDublinCore:
+		return "application/xml"
+	case FormatoBibTeX:
+		return "application/x-bibtex; charset=utf-8"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// Extension retorna la extensión habitual de los archivos del formato
+func (f FormatoBibliografico) Extension() string {
+	switch f {
+	case FormatoMARC:
+		return ".mrc"
+	case FormatoMARCXML, FormatoDublinCore:
+		return ".xml"
+	case FormatoBibTeX:
+		return ".bib"
+	}
+	return ".csv"
+}
+
+// LectorLibros lee los libros de un archivo de a uno. Leer retorna io.EOF
+// cuando no quedan más, y un error de categoría ErrDatosInvalidos si un
+// registro está dañado; en ese caso la próxima llamada sigue con el
+// siguiente registro, si se puede ubicar
+type LectorLibros interface {
+	Leer() (Libro, error)
+}
+
+// NuevoLectorLibros retorna el lector de un formato distinto de CSV
+func NuevoLectorLibros(r io.Reader, formato FormatoBibliografico) (LectorLibros, error) {
+	switch formato {
+	case FormatoMARC:
+		return NuevoLectorMARC(r), nil
+	case FormatoMARCXML:
+		return NuevoLectorMARCXML(r), nil
+	case FormatoDublinCore:
+		return NuevoLectorDublinCore(r), nil
+	case FormatoBibTeX:
+		return NuevoLectorBibTeX(r), nil
+	}
+	return nil, nuevoError(ErrDatosInvalidos, "El formato '%s' no tiene lector de registros", formato)
+}
+
+// DecodificarLibros lee todos los libros de r. Falla con el primer registro
+// dañado
+func DecodificarLibros(r io.Reader, formato FormatoBibliografico) ([]Libro, error) {
+	lector, err := NuevoLectorLibros(r, formato)
+	if err != nil {
+		return nil, err
+	}
+	var libros []Libro
+	for {
+		libro, err := lector.Leer()
+		if errors.Is(err, io.EOF) {
+			return libros, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		libros = append(libros, libro)
+	}
+}
+
+// CodificarLibros escribe libros en un formato distinto de CSV
+func CodificarLibros(w io.Writer, formato FormatoBibliografico, libros []Libro) error {
+	switch formato {
+	case FormatoMARC:
+		return CodificarMARC(w, libros)
+	case FormatoMARCXML:
+		return CodificarMARCXML(w, libros)
+	case FormatoDublinCore:
+		return CodificarDublinCore(w, libros)
+	case FormatoBibTeX:
+		return CodificarBibTeX(w, libros)
+	}
+	return nuevoError(ErrDatosInvalidos, "El formato '%s' no se puede codificar como registros", formato)
+}
+
+// ImportarLibros da de alta los libros de r, en el formato de
+// opciones.Formato, con las mismas reglas que ImportarLibrosCSV. Fuera de
+// CSV, ErrorFila.Fila es el número de registro y Columnas no se usa
+func (b *Biblioteca) ImportarLibros(actor Actor, r io.Reader, opciones OpcionesImportacion) (*InformeImportacion, error) {
+	formato, err := ParsearFormato(string(opciones.Formato))
+	if err != nil {
+		return nil, err
+	}
+	if formato == FormatoCSV {
+		return b.ImportarLibrosCSV(actor, r, opciones)
+	}
+	lector, err := NuevoLectorLibros(r, formato)
+	if err != nil {
+		return nil, err
+	}
+	numero := 0
+	return b.importarRegistros(actor, EventoLibroAgregado, func() (int, func(*transaccion) error, error) {
+		libro, err := lector.Leer()
+		if errors.Is(err, io.EOF) {
+			return 0, nil, err
+		}
+		numero++
+		if err != nil {
+			return numero, nil, err
+		}
+		return numero, b.altaLibro(libro), nil
+	}, opciones.Simular)
+}
+
+// ExportarLibros escribe el catálogo en formato. En MARC y MARCXML cada
+// registro lleva además un campo 852 por ejemplar, para el catálogo
+// colectivo
+func (b *Biblioteca) ExportarLibros(w io.Writer, formato FormatoBibliografico) error {
+	formato, err := ParsearFormato(string(formato))
+	if err != nil {
+		return err
+	}
+	switch formato {
+	case FormatoCSV:
+		return b.ExportarLibrosCSV(w)
+	case FormatoMARC, FormatoMARCXML:
+		registros := b.registrosExistencias()
+		if formato == FormatoMARC {
+			return escribirMARC(w, registros)
+		}
+		return escribirMARCXML(w, registros)
+	}
+	return CodificarLibros(w, formato, b.ListarLibros())
+}
+
+// registrosExistencias arma el registro MARC de cada libro con sus
+// ejemplares
+func (b *Biblioteca) registrosExistencias() []registroMARC {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	libros := b.librosEnCatalogo()
+	registros := make([]registroMARC, 0, len(libros))
+	for _, libro := range libros {
+		registro := registroDeLibro(libro)
+		for _, e := range b.ejemplares.PorLibro(libro.ID) {
+			registro.campos = append(registro.campos, campoDatos("852", ' ', ' ',
+				subcampoMARC{'a', b.Nombre}, subcampoMARC{'c', e.Ubicacion}, subcampoMARC{'p', e.CodigoBarras}))
+		}
+		registros = append(registros, registro)
+	}
+	return registros
+}
+
+// ==========================================
+// UTILIDADES
+// ==========================================
+
+// paginasDeExtension extrae la cantidad de páginas de una descripción
+// física como "xii, 350 p. : il." o "350 páginas". Retorna 0 si no la
+// encuentra
+func paginasDeExtension(texto string) int {
+	campos := strings.FieldsFunc(strings.ToLower(texto), func(r rune) bool {
+		return r == ' ' || r == ',' || r == '(' || r == ')' || r == ';' || r == ':'
+	})
+	for i, campo := range campos {
+		numero, err := strconv.Atoi(campo)
+		if err != nil || numero <= 0 {
+			continue
+		}
+		if i+1 == len(campos) || esUnidadPaginas(campos[i+1]) {
+			return numero
+		}
+	}
+	return 0
+}
+
+func esUnidadPaginas(palabra string) bool {
+	switch strings.TrimSuffix(palabra, ".") {
+	case "p", "pp", "pág", "págs", "pag", "pags", "páginas", "paginas", "pages", "page":
+		return true
+	}
+	return false
+}
+
+// extensionDePaginas es la descripción física que se exporta
+func extensionDePaginas(paginas int) string {
+	if paginas <= 0 {
+		return ""
+	}
+	return strconv.Itoa(paginas) + " p."
+}
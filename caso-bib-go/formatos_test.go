@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// librosIntercambio tiene títulos y autores con acentos, letras de varios
+// bytes y los caracteres especiales de LaTeX
+var librosIntercambio = []Libro{
+	{ID: 1, Titulo: "Cien años de soledad", Autor: "García Márquez, Gabriel", ISBN: "9780060883287", Paginas: 471},
+	{ID: 2, Titulo: "Ñandú & compañía: 50% de descuento", Autor: "Ørsted, Hans", Paginas: 96},
+	{ID: 3, Titulo: "Llaves {y} corchetes ~ #1 $5 a_b ^c \\ barra", Autor: "Dvořák, Antonín"},
+	{ID: 4, Titulo: "東京物語 — Über Straße", Autor: "Ozu, Yasujirō", ISBN: "9788437604572", Paginas: 1200},
+}
+
+func TestIdaYVueltaFormatos(t *testing.T) {
+	for _, formato := range []FormatoBibliografico{FormatoMARC, FormatoMARCXML, FormatoDublinCore, FormatoBibTeX} {
+		t.Run(string(formato), func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := CodificarLibros(&buf, formato, librosIntercambio); err != nil {
+				t.Fatalf("CodificarLibros: %v", err)
+			}
+			leidos, err := DecodificarLibros(bytes.NewReader(buf.Bytes()), formato)
+			if err != nil {
+				t.Fatalf("DecodificarLibros: %v\n%s", err, buf.String())
+			}
+			if len(leidos) != len(librosIntercambio) {
+				t.Fatalf("se leyeron %d libros, se esperaban %d", len(leidos), len(librosIntercambio))
+			}
+			for i, esperado := range librosIntercambio {
+				leido := leidos[i]
+				if leido.Titulo != esperado.Titulo {
+					t.Errorf("título %d = %q, se esperaba %q", i, leido.Titulo, esperado.Titulo)
+				}
+				if leido.Autor != esperado.Autor {
+					t.Errorf("autor %d = %q, se esperaba %q", i, leido.Autor, esperado.Autor)
+				}
+				if isbn, err := normalizarISBN(string(leido.ISBN)); err != nil || isbn != esperado.ISBN {
+					t.Errorf("ISBN %d = %q (%v), se esperaba %q", i, leido.ISBN, err, esperado.ISBN)
+				}
+				if leido.Paginas != esperado.Paginas {
+					t.Errorf("páginas %d = %d, se esperaban %d", i, leido.Paginas, esperado.Paginas)
+				}
+			}
+		})
+	}
+}
+
+// Los largos de la cabecera y el directorio de ISO 2709 cuentan bytes, no
+// caracteres
+func TestMARCLargosEnBytes(t *testing.T) {
+	var buf bytes.Buffer
+	if err := CodificarMARC(&buf, librosIntercambio); err != nil {
+		t.Fatalf("CodificarMARC: %v", err)
+	}
+	registros := bytes.SplitAfter(buf.Bytes(), []byte{finRegistroMARC})
+	registros = registros[:len(registros)-1] // lo que sigue al último fin está vacío
+	if len(registros) != len(librosIntercambio) {
+		t.Fatalf("%d registros, se esperaban %d", len(registros), len(librosIntercambio))
+	}
+	for n, registro := range registros {
+		cabecera := string(registro[:largoCabecera])
+		if largo := atoi(t, cabecera[0:5]); largo != len(registro) {
+			t.Errorf("registro %d: la cabecera dice %d bytes y tiene %d", n, largo, len(registro))
+		}
+		base := atoi(t, cabecera[12:17])
+		if registro[base-1] != finCampoMARC {
+			t.Errorf("registro %d: la base %d no sigue al fin del directorio", n, base)
+		}
+		directorio := registro[largoCabecera : base-1]
+		if len(directorio)%largoDirectorio != 0 {
+			t.Fatalf("registro %d: directorio de %d bytes", n, len(directorio))
+		}
+		for i := 0; i < len(directorio); i += largoDirectorio {
+			entrada := string(directorio[i : i+largoDirectorio])
+			largo, inicio := atoi(t, entrada[3:7]), atoi(t, entrada[7:12])
+			campo := registro[base+inicio : base+inicio+largo]
+			if campo[len(campo)-1] != finCampoMARC || bytes.IndexByte(campo[:len(campo)-1], finCampoMARC) >= 0 {
+				t.Errorf("registro %d, campo %s: el largo %d no cae en el fin del campo", n, entrada[:3], largo)
+			}
+		}
+	}
+}
+
+func atoi(t *testing.T, texto string) int {
+	t.Helper()
+	n, err := strconv.Atoi(texto)
+	if err != nil {
+		t.Fatalf("número no válido %q", texto)
+	}
+	return n
+}
+
+func TestEscaparLaTeX(t *testing.T) {
+	casos := []struct{ texto, latex string }{
+		{"a~b", `a\textasciitilde{}b`},
+		{"{x}", `\{x\}`},
+		{"A & B", `A \& B`},
+		{"100%", `100\%`},
+		{`a\b`, `a\textbackslash{}b`},
+		{"x^2", `x\textasciicircum{}2`},
+		{"$#_", `\$\#\_`},
+		{"Peña", "Peña"},
+	}
+	for _, c := range casos {
+		if latex := escaparLaTeX(c.texto); latex != c.latex {
+			t.Errorf("escaparLaTeX(%q) = %q, se esperaba %q", c.texto, latex, c.latex)
+		}
+		if texto := desdeLaTeX(c.latex); texto != c.texto {
+			t.Errorf("desdeLaTeX(%q) = %q, se esperaba %q", c.latex, texto, c.texto)
+		}
+	}
+	// Acentos en notación LaTeX
+	for latex, texto := range map[string]string{
+		`Garc{\'\i}a`: "García", `Pe\~na`: "Peña", `{\"u}ber`: "über", `\c{c}a`: "ça", `Stra\ss e`: "Straße",
+	} {
+		if leido := desdeLaTeX(latex); leido != texto {
+			t.Errorf("desdeLaTeX(%q) = %q, se esperaba %q", latex, leido, texto)
+		}
+	}
+}
+
+// Los libros sin ID, como los de una importación simulada, no comparten
+// clave
+func TestClavesBibTeXUnicas(t *testing.T) {
+	libros := []Libro{
+		{Titulo: "Sin ID ni ISBN"},
+		{Titulo: "Otro sin ID"},
+		{Titulo: "Con ISBN", ISBN: "9780060883287"},
+		{Titulo: "Mismo ISBN", ISBN: "9780060883287"},
+		{ID: 7, Titulo: "Con ID"},
+		{ID: 7, Titulo: "ID repetido"},
+	}
+	var buf bytes.Buffer
+	if err := CodificarBibTeX(&buf, libros); err != nil {
+		t.Fatalf("CodificarBibTeX: %v", err)
+	}
+	var claves []string
+	for _, linea := range strings.Split(buf.String(), "\n") {
+		if clave, ok := strings.CutPrefix(linea, "@book{"); ok {
+			claves = append(claves, strings.TrimSuffix(clave, ","))
+		}
+	}
+	esperadas := []string{"entrada1", "entrada2", "isbn9780060883287", "isbn9780060883287-2", "libro7", "libro7-2"}
+	if strings.Join(claves, " ") != strings.Join(esperadas, " ") {
+		t.Errorf("claves %v, se esperaban %v", claves, esperadas)
+	}
+}
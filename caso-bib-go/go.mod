@@ -1,3 +1,3 @@
-module main
+module bib
 
 go 1.24.4
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ==========================================
+// IMPORTACIÓN Y EXPORTACIÓN CSV
+// ==========================================
+// Las sucursales nuevas llegan como planillas. ImportarLibrosCSV e
+// ImportarUsuariosCSV leen el archivo fila por fila y dan de alta cada una
+// con las mismas validaciones que AgregarLibro y RegistrarUsuario. Una fila
+// con errores no detiene la importación: queda en el informe con su número
+// de línea. En modo simulación se valida todo, incluidos los repetidos
+// dentro del mismo archivo, sin guardar nada.
+//
+// La primera fila del archivo es el encabezado. Cada campo se busca en la
+// columna del mismo nombre, sin distinguir mayúsculas, salvo que
+// OpcionesImportacion.Columnas indique otra. Las columnas que no son campos
+// se ignoran, así que un archivo exportado se puede volver a importar.
+
+// OpcionesImportacion ajusta cómo se lee un archivo
+type OpcionesImportacion struct {
+	// Formato del archivo; vacío es CSV. Los usuarios solo se importan de
+	// CSV, los libros también de los formatos bibliográficos
+	Formato FormatoBibliografico
+	// Columnas indica, por campo, el encabezado de la columna que lo trae
+	// cuando no se llama igual: {"titulo": "Title"}
+	Columnas map[string]string
+	// Simular valida las filas sin guardar ninguna
+	Simular bool
+}
+
+// ErrorFila es una fila que no se pudo importar
+type ErrorFila struct {
+	Fila    int    `json:"fila"` // línea en un CSV, número de registro en otros formatos
+	Mensaje string `json:"mensaje"`
+}
+
+// InformeImportacion resume una importación
+type InformeImportacion struct {
+	Simulacion bool        `json:"simulacion"`
+	Filas      int         `json:"filas"`
+	Importadas int         `json:"importadas"` // en simulación, las que se importarían
+	Errores    []ErrorFila `json:"errores"`
+}
+
+// campoCSV es un campo que se puede importar
+type campoCSV struct {
+	nombre      string
+	obligatorio bool
+}
+
+var camposLibroCSV = []campoCSV{
+	{"titulo", true}, {"autor", true}, {"isbn", false}, {"paginas", false}, {"tipo", false}, {"etiquetas", false},
+}
+
+var camposUsuarioCSV = []campoCSV{{"nombre", true}, {"email", true}, {"telefono", false}, {"categoria", false}}
+
+// ImportarLibrosCSV da de alta un libro, con su primer ejemplar, por cada
+// fila de r. Campos: titulo, autor, isbn, paginas, tipo y etiquetas
+// (separadas por comas)
+func (b *Biblioteca) ImportarLibrosCSV(actor Actor, r io.Reader, opciones OpcionesImportacion) (*InformeImportacion, error) {
+	siguiente, err := leerCSV(r, camposLibroCSV, opciones.Columnas, func(fila map[string]string) (func(*transaccion) error, error) {
+		libro := Libro{
+			Titulo: fila["titulo"], Autor: fila["autor"], ISBN: ISBN(fila["isbn"]), Tipo: TipoLibro(fila["tipo"]),
+			Etiquetas: separarEtiquetas(fila["etiquetas"]),
+		}
+		if texto := fila["paginas"]; texto != "" {
+			var err error
+			if libro.Paginas, err = strconv.Atoi(texto); err != nil {
+				return nil, nuevoError(ErrDatosInvalidos, "Cantidad de páginas no válida '%s'", texto)
+			}
+		}
+		return b.altaLibro(libro), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return b.importarRegistros(actor, EventoLibroAgregado, siguiente, opciones.Simular)
+}
+
+// ImportarUsuariosCSV registra un usuario por cada fila de r. Campos:
+// nombre, email, telefono y categoria
+func (b *Biblioteca) ImportarUsuariosCSV(actor Actor, r io.Reader, opciones OpcionesImportacion) (*InformeImportacion, error) {
+	if formato, err := ParsearFormato(string(opciones.Formato)); err != nil || formato != FormatoCSV {
+		return nil, nuevoError(ErrDatosInvalidos, "Los usuarios solo se importan desde CSV")
+	}
+	siguiente, err := leerCSV(r, camposUsuarioCSV, opciones.Columnas, func(fila map[string]string) (func(*transaccion) error, error) {
+		return func(tx *transaccion) error {
+			_, err := b.registrarUsuario(tx, fila["nombre"], fila["email"], fila["telefono"], CategoriaUsuario(fila["categoria"]))
+			return err
+		}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return b.importarRegistros(actor, EventoUsuarioRegistrado, siguiente, opciones.Simular)
+}
+
+// altaLibro retorna cómo agregar libro, leído de un archivo, con las
+// validaciones de AgregarLibro. El ISBN puede venir en cualquier forma
+func (b *Biblioteca) altaLibro(libro Libro) func(*transaccion) error {
+	return func(tx *transaccion) error {
+		agregado, err := b.agregarLibro(tx, libro.Titulo, libro.Autor, string(libro.ISBN), libro.Paginas)
+		if err != nil || (libro.Tipo == "" && len(libro.Etiquetas) == 0) {
+			return err
+		}
+		if libro.Tipo != "" {
+			if err := libro.Tipo.Validar(); err != nil {
+				return err
+			}
+		}
+		agregado.Tipo = libro.Tipo
+		agregado.Etiquetas = normalizarEtiquetas(libro.Etiquetas)
+		tx.guardarLibro(agregado)
+		return nil
+	}
+}
+
+// siguienteRegistro lee el próximo registro de un archivo y retorna su
+// posición (la línea en un CSV, el número de registro en los demás formatos)
+// y cómo darlo de alta. Un error de categoría ErrDatosInvalidos descarta
+// solo ese registro, io.EOF indica que no hay más y cualquier otro error
+// interrumpe la importación
+type siguienteRegistro func() (posicion int, alta func(*transaccion) error, err error)
+
+// importarRegistros da de alta cada registro que retorna siguiente. Cada
+// uno se confirma en su propia transacción y deja un evento tipo; al
+// simular, ver simularRegistros. Importar, aunque sea simulando, requiere
+// PermisoDatos
+func (b *Biblioteca) importarRegistros(actor Actor, tipo TipoEvento, siguiente siguienteRegistro, simular bool) (*InformeImportacion, error) {
+	if err := b.Autorizar(actor, PermisoDatos, 0); err != nil {
+		return nil, err
+	}
+	if simular {
+		return b.simularRegistros(actor, tipo, siguiente)
+	}
+	informe := &InformeImportacion{Errores: []ErrorFila{}}
+	for {
+		posicion, alta, err := siguiente()
+		if errors.Is(err, io.EOF) {
+			return informe, nil
+		}
+		if err != nil && !errors.Is(err, ErrDatosInvalidos) {
+			return nil, err
+		}
+		if err == nil {
+			err = b.importarRegistro(actor, tipo, alta)
+		}
+		informe.anotar(posicion, err)
+	}
+}
+
+// anotar cuenta una fila en el informe, con su error si no se importó
+func (informe *InformeImportacion) anotar(posicion int, err error) {
+	informe.Filas++
+	if err != nil {
+		informe.Errores = append(informe.Errores, ErrorFila{Fila: posicion, Mensaje: err.Error()})
+		return
+	}
+	informe.Importadas++
+}
+
+// registroLeido es un registro ya leído, o el error que impidió armarlo
+type registroLeido struct {
+	posicion int
+	alta     func(*transaccion) error
+	err      error
+}
+
+// simularRegistros valida los registros que retorna siguiente sin guardar
+// ninguno. Primero lee todo el archivo sin bloquear la biblioteca, para
+// que una subida lenta no frene a nadie; después aplica los registros a
+// una transacción que nunca se confirma, para que cada uno vea a los
+// anteriores, bajo un solo bloqueo de lectura, para que todos vean el mismo
+// estado
+func (b *Biblioteca) simularRegistros(actor Actor, tipo TipoEvento, siguiente siguienteRegistro) (*InformeImportacion, error) {
+	var registros []registroLeido
+	for {
+		posicion, alta, err := siguiente()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil && !errors.Is(err, ErrDatosInvalidos) {
+			return nil, err
+		}
+		registros = append(registros, registroLeido{posicion, alta, err})
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	informe := &InformeImportacion{Simulacion: true, Errores: []ErrorFila{}}
+	tx := b.iniciar(actor, tipo)
+	for _, registro := range registros {
+		err := registro.err
+		if err == nil {
+			err = simularRegistro(tx, registro.alta)
+		}
+		informe.anotar(registro.posicion, err)
+	}
+	return informe, nil
+}
+
+// importarRegistro da de alta un registro en su propia transacción
+func (b *Biblioteca) importarRegistro(actor Actor, tipo TipoEvento, alta func(*transaccion) error) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	tx := b.iniciar(actor, tipo)
+	if err := alta(tx); err != nil {
+		return err
+	}
+	return tx.confirmar()
+}
+
+// simularRegistro aplica un registro a la transacción de la simulación y,
+// si falla, descarta lo que alcanzó a cambiar. Quien llama debe tener el
+// bloqueo de lectura
+func simularRegistro(tx *transaccion, alta func(*transaccion) error) error {
+	restaurar := tx.marcar()
+	if err := alta(tx); err != nil {
+		restaurar()
+		return err
+	}
+	return nil
+}
+
+// leerCSV lee el encabezado de r y retorna un siguienteRegistro que arma
+// cada fila con sus campos y se la pasa a alta
+func leerCSV(r io.Reader, campos []campoCSV, columnas map[string]string,
+	alta func(fila map[string]string) (func(*transaccion) error, error)) (siguienteRegistro, error) {
+	lector := csv.NewReader(r)
+	lector.FieldsPerRecord = -1
+	lector.TrimLeadingSpace = true
+
+	encabezado, err := lector.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, nuevoError(ErrDatosInvalidos, "El archivo CSV está vacío")
+	}
+	if err != nil {
+		return nil, nuevoError(ErrDatosInvalidos, "Encabezado CSV no válido: %v", err)
+	}
+	posiciones, err := mapearColumnas(encabezado, campos, columnas)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() (int, func(*transaccion) error, error) {
+		registro, err := lector.Read()
+		var errorCSV *csv.ParseError
+		if errors.As(err, &errorCSV) {
+			return errorCSV.StartLine, nil, nuevoError(ErrDatosInvalidos, "%v", errorCSV.Err)
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+		linea, _ := lector.FieldPos(0)
+
+		fila := make(map[string]string, len(posiciones))
+		for campo, i := range posiciones {
+			if i < len(registro) {
+				fila[campo] = strings.TrimSpace(registro[i])
+			}
+		}
+		funcion, err := alta(fila)
+		return linea, funcion, err
+	}, nil
+}
+
+// mapearColumnas retorna, por campo, la posición de su columna en el
+// encabezado. Falla si falta una columna obligatoria o si columnas nombra un
+// campo que no existe
+func mapearColumnas(encabezado []string, campos []campoCSV, columnas map[string]string) (map[string]int, error) {
+	indice := make(map[string]int, len(encabezado))
+	for i, titulo := range encabezado {
+		// Las planillas suelen guardar el CSV con BOM
+		titulo = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(titulo, "\ufeff")))
+		if _, repetido := indice[titulo]; !repetido {
+			indice[titulo] = i
+		}
+	}
+
+	conocidos := make(map[string]bool, len(campos))
+	for _, campo := range campos {
+		conocidos[campo.nombre] = true
+	}
+	for campo := range columnas {
+		if !conocidos[campo] {
+			return nil, nuevoError(ErrDatosInvalidos, "Campo desconocido '%s' en el mapeo de columnas", campo)
+		}
+	}
+
+	posiciones := make(map[string]int, len(campos))
+	for _, campo := range campos {
+		columna := campo.nombre
+		if otra, ok := columnas[campo.nombre]; ok {
+			columna = otra
+		}
+		i, ok := indice[strings.ToLower(strings.TrimSpace(columna))]
+		if !ok {
+			if campo.obligatorio {
+				return nil, nuevoError(ErrDatosInvalidos, "Falta la columna '%s' en el encabezado", columna)
+			}
+			continue
+		}
+		posiciones[campo.nombre] = i
+	}
+	return posiciones, nil
+}
+
+// ParsearColumnas interpreta un mapeo de columnas escrito como
+// "campo=Encabezado,campo=Encabezado"
+func ParsearColumnas(texto string) (map[string]string, error) {
+	columnas := make(map[string]string)
+	if strings.TrimSpace(texto) == "" {
+		return columnas, nil
+	}
+	for _, par := range strings.Split(texto, ",") {
+		campo, columna, ok := strings.Cut(par, "=")
+		campo, columna = strings.ToLower(strings.TrimSpace(campo)), strings.TrimSpace(columna)
+		if !ok || campo == "" || columna == "" {
+			return nil, nuevoError(ErrDatosInvalidos, "Mapeo de columnas no válido '%s': se espera campo=Encabezado", par)
+		}
+		columnas[campo] = columna
+	}
+	return columnas, nil
+}
+
+// ==========================================
+// EXPORTACIÓN
+// ==========================================
+
+// ExportarLibrosCSV escribe el catálogo como CSV. Las columnas de los campos
+// importables se llaman igual que en ImportarLibrosCSV
+func (b *Biblioteca) ExportarLibrosCSV(w io.Writer) error {
+	b.mu.RLock()
+	filas := [][]string{{"id", "titulo", "autor", "isbn", "paginas", "tipo", "etiquetas", "ejemplares"}}
+	for _, l := range b.librosEnCatalogo() {
+		filas = append(filas, []string{
+			strconv.Itoa(l.ID), l.Titulo, l.Autor, l.ISBN.ISBN13(), strconv.Itoa(l.Paginas),
+			string(l.TipoEfectivo()), strings.Join(l.Etiquetas, ","), strconv.Itoa(len(b.ejemplares.PorLibro(l.ID))),
+		})
+	}
+	b.mu.RUnlock()
+	return escribirCSV(w, filas)
+}
+
+// ExportarUsuariosCSV escribe los usuarios como CSV. Las columnas de los
+// campos importables se llaman igual que en ImportarUsuariosCSV
+func (b *Biblioteca) ExportarUsuariosCSV(w io.Writer) error {
+	b.mu.RLock()
+	filas := [][]string{{"id", "nombre", "email", "telefono", "categoria", "activo", "prestamos_activos", "deuda"}}
+	for _, u := range b.usuarios.Listar() {
+		filas = append(filas, []string{
+			strconv.Itoa(u.ID), u.Nombre, u.Email, u.Telefono, string(b.Politica.categoriaDe(u)),
+			strconv.FormatBool(u.Activo), strconv.Itoa(u.PrestamosActivos), strconv.FormatFloat(u.Deuda, 'f', 2, 64),
+		})
+	}
+	b.mu.RUnlock()
+	return escribirCSV(w, filas)
+}
+
+// ExportarPrestamosCSV escribe el historial completo de préstamos, activos y
+// devueltos, como CSV
+func (b *Biblioteca) ExportarPrestamosCSV(w io.Writer) error {
+	b.mu.RLock()
+	filas := [][]string{{
+		"id", "libro_id", "titulo", "ejemplar_id", "codigo_barras", "usuario_id", "usuario",
+		"fecha_prestamo", "fecha_devolucion", "devuelto", "fecha_devuelto", "renovaciones", "multa",
+		"perdido", "cargo_reposicion",
+	}}
+	for _, p := range b.prestamos.Listar() {
+		libro, _ := b.libros.PorID(p.LibroID)
+		ejemplar, _ := b.ejemplares.PorID(p.EjemplarID)
+		usuario, _ := b.usuarios.PorID(p.UsuarioID)
+		devuelto := ""
+		if !p.FechaDevuelto.IsZero() {
+			devuelto = p.FechaDevuelto.Format(time.RFC3339)
+		}
+		filas = append(filas, []string{
+			strconv.Itoa(p.ID), strconv.Itoa(p.LibroID), libro.Titulo, strconv.Itoa(p.EjemplarID), ejemplar.CodigoBarras,
+			strconv.Itoa(p.UsuarioID), usuario.Nombre, p.FechaPrestamo.Format(time.RFC3339),
+			p.FechaDevolucion.Format(time.RFC3339), strconv.FormatBool(p.Devuelto), devuelto,
+			strconv.Itoa(len(p.Renovaciones)), strconv.FormatFloat(p.Multa, 'f', 2, 64),
+			strconv.FormatBool(p.Perdido), strconv.FormatFloat(p.CargoReposicion, 'f', 2, 64),
+		})
+	}
+	b.mu.RUnlock()
+	return escribirCSV(w, filas)
+}
+
+func escribirCSV(w io.Writer, filas [][]string) error {
+	escritor := csv.NewWriter(w)
+	if err := escritor.WriteAll(filas); err != nil {
+		return fmt.Errorf("No se pudo escribir el CSV: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSimularImportacionNoGuarda(t *testing.T) {
+	b, _ := nuevaBibliotecaPrueba(t)
+	registrarUsuarioPrueba(t, b, "ana")
+	csv := "nombre,email\n" +
+		"Beto,beto@prueba.org\n" +
+		"Ana Bis,ana@prueba.org\n" +
+		"Beto Bis,beto@prueba.org\n" +
+		"Sin Email,\n"
+
+	informe, err := b.ImportarUsuariosCSV(Sistema, strings.NewReader(csv), OpcionesImportacion{Simular: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if informe.Filas != 4 || informe.Importadas != 1 || len(informe.Errores) != 3 {
+		t.Errorf("informe = %+v; se esperaban 4 filas, 1 importada y 3 errores", informe)
+	}
+	if n := len(b.ListarUsuarios()); n != 1 {
+		t.Errorf("la simulación dejó %d usuarios", n)
+	}
+	verificar(t, b)
+}
+
+func TestSimularImportacionNoBloqueaDuranteLaSubida(t *testing.T) {
+	b, _ := nuevaBibliotecaPrueba(t)
+	lector, escritor := io.Pipe()
+	informes := make(chan *InformeImportacion)
+	go func() {
+		informe, err := b.ImportarUsuariosCSV(Sistema, lector, OpcionesImportacion{Simular: true})
+		if err != nil {
+			t.Error(err)
+		}
+		informes <- informe
+	}()
+	io.WriteString(escritor, "nombre,email\n")
+	io.WriteString(escritor, "Ana,ana@prueba.org\n")
+
+	// Con la subida detenida a mitad del archivo, la biblioteca sigue
+	// aceptando operaciones
+	registrado := make(chan error)
+	go func() {
+		_, err := b.RegistrarUsuario(Sistema, "carla", "carla@prueba.org", "", CategoriaEstudiante)
+		registrado <- err
+	}()
+	select {
+	case err := <-registrado:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("una subida detenida bloquea a la biblioteca")
+	}
+
+	// Todas las filas se validan contra el mismo estado, que ya incluye a
+	// carla, y cada una ve a las anteriores
+	io.WriteString(escritor, "Carla,carla@prueba.org\n")
+	io.WriteString(escritor, "Ana Bis,ana@prueba.org\n")
+	escritor.Close()
+	informe := <-informes
+	if informe.Filas != 3 || informe.Importadas != 1 || len(informe.Errores) != 2 {
+		t.Errorf("informe = %+v; se esperaban 3 filas, 1 importada y 2 errores", informe)
+	}
+	if n := len(b.ListarUsuarios()); n != 1 {
+		t.Errorf("hay %d usuarios; se esperaba solo carla", n)
+	}
+	verificar(t, b)
+}
@@ -0,0 +1,470 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ==========================================
+// INFORMES DE CIRCULACIÓN
+// ==========================================
+// InformeCirculacion reúne lo que pide la dirección cada mes: qué se presta
+// más, cuánto se presta por día y por semana, cuánto duran los préstamos,
+// cuántos se devuelven tarde y qué usuarios dejaron de venir. El informe es
+// un struct con datos tipados; EscribirInforme lo presenta como texto, JSON,
+// CSV o Markdown.
+
+// Valores por defecto de OpcionesInforme
+const (
+	topInformePorDefecto         = 10
+	inactividadInformePorDefecto = 90
+)
+
+// OpcionesInforme elige el período y el tamaño del informe
+type OpcionesInforme struct {
+	// Desde y Hasta acotan el período; Hasta no se incluye. Sin Desde el
+	// período empieza el primer día del mes de Hasta, y sin Hasta termina
+	// ahora
+	Desde, Hasta time.Time
+	// Top es cuántos títulos y autores se listan (10 si es 0)
+	Top int
+	// DiasInactividad es cuántos días sin pedir nada hasta Hasta hacen
+	// inactivo a un usuario (90 si es 0)
+	DiasInactividad int
+}
+
+// InformeCirculacion es el informe de un período
+type InformeCirculacion struct {
+	Biblioteca string    `json:"biblioteca"`
+	Desde      time.Time `json:"desde"`
+	Hasta      time.Time `json:"hasta"`
+	Generado   time.Time `json:"generado"`
+	// Resumen es el estado de la biblioteca al generar el informe
+	Resumen Estadisticas `json:"resumen"`
+
+	// Prestamos y Devoluciones cuentan los hechos dentro del período
+	Prestamos    int `json:"prestamos"`
+	Devoluciones int `json:"devoluciones"`
+
+	TitulosMasPrestados []ConteoTitulo `json:"titulos_mas_prestados"`
+	AutoresMasPrestados []ConteoAutor  `json:"autores_mas_prestados"`
+	// PrestamosPorDia y PrestamosPorSemana tienen una fila por cada día o
+	// semana (de lunes a domingo) del período, aunque no haya préstamos,
+	// para poder graficarlos
+	PrestamosPorDia    []ConteoFecha `json:"prestamos_por_dia"`
+	PrestamosPorSemana []ConteoFecha `json:"prestamos_por_semana"`
+
+	// DuracionPromedioDias es el promedio de días entre préstamo y
+	// devolución de los préstamos devueltos en el período
+	DuracionPromedioDias float64 `json:"duracion_promedio_dias"`
+	// Vencimientos son los préstamos cuya fecha de devolución cayó en el
+	// período y ya pasó; Atrasados, los que de ellos se devolvieron tarde o
+	// siguen afuera. TasaAtraso es Atrasados / Vencimientos
+	Vencimientos int     `json:"vencimientos"`
+	Atrasados    int     `json:"atrasados"`
+	TasaAtraso   float64 `json:"tasa_atraso"`
+
+	// DiasInactividad es el umbral usado para UsuariosInactivos
+	DiasInactividad   int               `json:"dias_inactividad"`
+	UsuariosInactivos []UsuarioInactivo `json:"usuarios_inactivos"`
+}
+
+// ConteoTitulo es cuántas veces se prestó un libro en el período
+type ConteoTitulo struct {
+	LibroID   int    `json:"libro_id"`
+	Titulo    string `json:"titulo"`
+	Autor     string `json:"autor"`
+	Prestamos int    `json:"prestamos"`
+}
+
+// ConteoAutor es cuántos préstamos tuvieron los libros de un autor
+type ConteoAutor struct {
+	Autor     string `json:"autor"`
+	Prestamos int    `json:"prestamos"`
+}
+
+// ConteoFecha es la cantidad de préstamos de un día o de la semana que
+// empieza en Inicio
+type ConteoFecha struct {
+	Inicio    time.Time `json:"inicio"`
+	Prestamos int       `json:"prestamos"`
+}
+
+// UsuarioInactivo es un usuario activo que no pidió nada en los últimos
+// DiasInactividad días. UltimoPrestamo es cero si nunca pidió nada
+type UsuarioInactivo struct {
+	UsuarioID      int       `json:"usuario_id"`
+	Nombre         string    `json:"nombre"`
+	UltimoPrestamo time.Time `json:"ultimo_prestamo,omitzero"`
+}
+
+// InformeCirculacion arma el informe del período de opciones
+func (b *Biblioteca) InformeCirculacion(opciones OpcionesInforme) (*InformeCirculacion, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	ahora := b.Reloj.Ahora()
+	desde, hasta := opciones.Desde, opciones.Hasta
+	if hasta.IsZero() {
+		hasta = ahora
+	}
+	if desde.IsZero() {
+		desde = time.Date(hasta.Year(), hasta.Month(), 1, 0, 0, 0, 0, hasta.Location())
+	}
+	if !desde.Before(hasta) {
+		return nil, nuevoError(ErrDatosInvalidos, "El período del informe termina antes de empezar")
+	}
+	top := cmp.Or(opciones.Top, topInformePorDefecto)
+	inactividad := cmp.Or(opciones.DiasInactividad, inactividadInformePorDefecto)
+	if top < 0 || inactividad < 0 {
+		return nil, nuevoError(ErrDatosInvalidos, "El tamaño del informe y los días de inactividad no pueden ser negativos")
+	}
+	enPeriodo := func(t time.Time) bool { return !t.Before(desde) && t.Before(hasta) }
+
+	informe := &InformeCirculacion{
+		Biblioteca:      b.Nombre,
+		Desde:           desde,
+		Hasta:           hasta,
+		Generado:        ahora,
+		Resumen:         b.estadisticas(),
+		DiasInactividad: inactividad,
+	}
+
+	porLibro := make(map[int]int)
+	// Los días se cuentan en la zona horaria del período
+	porDia := make(map[string]int)
+	ultimoPrestamo := make(map[int]time.Time)
+	var duracionTotal time.Duration
+	for _, p := range b.prestamos.Listar() {
+		if p.FechaPrestamo.Before(hasta) && p.FechaPrestamo.After(ultimoPrestamo[p.UsuarioID]) {
+			ultimoPrestamo[p.UsuarioID] = p.FechaPrestamo
+		}
+		if enPeriodo(p.FechaPrestamo) {
+			informe.Prestamos++
+			porLibro[p.LibroID]++
+			porDia[p.FechaPrestamo.In(desde.Location()).Format(time.DateOnly)]++
+		}
+		// Un préstamo perdido se cierra sin que el ejemplar vuelva
+		if p.Devuelto && !p.Perdido && enPeriodo(p.FechaDevuelto) {
+			informe.Devoluciones++
+			duracionTotal += p.FechaDevuelto.Sub(p.FechaPrestamo)
+		}
+		// Solo cuentan los vencimientos ya cumplidos: un préstamo que vence
+		// mañana todavía puede volver a tiempo
+		if enPeriodo(p.FechaDevolucion) && p.FechaDevolucion.Before(ahora) {
+			informe.Vencimientos++
+			if !p.Devuelto || p.FechaDevuelto.After(p.FechaDevolucion) {
+				informe.Atrasados++
+			}
+		}
+	}
+	if informe.Devoluciones > 0 {
+		promedio := duracionTotal.Hours() / 24 / float64(informe.Devoluciones)
+		informe.DuracionPromedioDias = redondear(promedio)
+	}
+	if informe.Vencimientos > 0 {
+		informe.TasaAtraso = redondear(float64(informe.Atrasados) / float64(informe.Vencimientos))
+	}
+
+	informe.TitulosMasPrestados, informe.AutoresMasPrestados = b.masPrestados(porLibro, top)
+	informe.PrestamosPorDia, informe.PrestamosPorSemana = seriesPrestamos(porDia, desde, hasta)
+
+	limite := hasta.AddDate(0, 0, -inactividad)
+	informe.UsuariosInactivos = make([]UsuarioInactivo, 0)
+	for _, u := range b.usuarios.Listar() {
+		ultimo := ultimoPrestamo[u.ID]
+		if u.Activo && ultimo.Before(limite) {
+			informe.UsuariosInactivos = append(informe.UsuariosInactivos,
+				UsuarioInactivo{UsuarioID: u.ID, Nombre: u.Nombre, UltimoPrestamo: ultimo})
+		}
+	}
+	return informe, nil
+}
+
+// masPrestados ordena los libros y autores por préstamos, de más a menos, y
+// deja los primeros top. Los empates se ordenan por título o autor
+func (b *Biblioteca) masPrestados(porLibro map[int]int, top int) ([]ConteoTitulo, []ConteoAutor) {
+	titulos := make([]ConteoTitulo, 0, len(porLibro))
+	porAutor := make(map[string]int)
+	for id, prestamos := range porLibro {
+		conteo := ConteoTitulo{LibroID: id, Prestamos: prestamos}
+		if libro, ok := b.libros.PorID(id); ok {
+			conteo.Titulo, conteo.Autor = libro.Titulo, libro.Autor
+		}
+		titulos = append(titulos, conteo)
+		porAutor[conteo.Autor] += prestamos
+	}
+	autores := make([]ConteoAutor, 0, len(porAutor))
+	for autor, prestamos := range porAutor {
+		autores = append(autores, ConteoAutor{Autor: autor, Prestamos: prestamos})
+	}
+
+	slices.SortFunc(titulos, func(a, b ConteoTitulo) int {
+		return cmp.Or(cmp.Compare(b.Prestamos, a.Prestamos), cmp.Compare(a.Titulo, b.Titulo), cmp.Compare(a.LibroID, b.LibroID))
+	})
+	slices.SortFunc(autores, func(a, b ConteoAutor) int {
+		return cmp.Or(cmp.Compare(b.Prestamos, a.Prestamos), cmp.Compare(a.Autor, b.Autor))
+	})
+	return titulos[:min(top, len(titulos))], autores[:min(top, len(autores))]
+}
+
+// seriesPrestamos arma las series diaria y semanal entre desde y hasta
+func seriesPrestamos(porDia map[string]int, desde, hasta time.Time) (dias, semanas []ConteoFecha) {
+	dias, semanas = make([]ConteoFecha, 0), make([]ConteoFecha, 0)
+	for dia := inicioDia(desde); dia.Before(hasta); dia = dia.AddDate(0, 0, 1) {
+		prestamos := porDia[dia.Format(time.DateOnly)]
+		dias = append(dias, ConteoFecha{Inicio: dia, Prestamos: prestamos})
+
+		semana := inicioSemana(dia)
+		if len(semanas) == 0 || !semanas[len(semanas)-1].Inicio.Equal(semana) {
+			semanas = append(semanas, ConteoFecha{Inicio: semana})
+		}
+		semanas[len(semanas)-1].Prestamos += prestamos
+	}
+	return dias, semanas
+}
+
+func inicioDia(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// inicioSemana retorna el lunes de la semana de t
+func inicioSemana(t time.Time) time.Time {
+	dia := inicioDia(t)
+	return dia.AddDate(0, 0, -((int(dia.Weekday()) + 6) % 7))
+}
+
+// opcionesInformeDe arma las opciones con los parámetros de texto que usan
+// la API y la línea de comandos: mes como AAAA-MM, o desde/hasta como
+// AAAA-MM-DD (hasta incluye ese día entero), top e inactividad en días
+func opcionesInformeDe(valor func(clave string) string) (OpcionesInforme, error) {
+	var opciones OpcionesInforme
+	if texto := valor("mes"); texto != "" {
+		if valor("desde") != "" || valor("hasta") != "" {
+			return OpcionesInforme{}, nuevoError(ErrDatosInvalidos, "Indique el mes o las fechas desde y hasta, no ambos")
+		}
+		mes, err := time.ParseInLocation("2006-01", texto, time.Local)
+		if err != nil {
+			return OpcionesInforme{}, nuevoError(ErrDatosInvalidos, "Mes '%s' no válido: use AAAA-MM", texto)
+		}
+		opciones.Desde, opciones.Hasta = mes, mes.AddDate(0, 1, 0)
+	}
+	for clave, destino := range map[string]*time.Time{"desde": &opciones.Desde, "hasta": &opciones.Hasta} {
+		if texto := valor(clave); texto != "" {
+			fecha, err := time.ParseInLocation(time.DateOnly, texto, time.Local)
+			if err != nil {
+				return OpcionesInforme{}, nuevoError(ErrDatosInvalidos, "Fecha '%s' no válida: use AAAA-MM-DD", texto)
+			}
+			*destino = fecha
+		}
+	}
+	if valor("hasta") != "" {
+		opciones.Hasta = opciones.Hasta.AddDate(0, 0, 1)
+	}
+	for clave, destino := range map[string]*int{"top": &opciones.Top, "inactividad": &opciones.DiasInactividad} {
+		if texto := valor(clave); texto != "" {
+			numero, err := strconv.Atoi(texto)
+			if err != nil || numero <= 0 {
+				return OpcionesInforme{}, nuevoError(ErrDatosInvalidos, "El valor de %s debe ser un número positivo, no '%s'", clave, texto)
+			}
+			*destino = numero
+		}
+	}
+	return opciones, nil
+}
+
+// ==========================================
+// PRESENTACIÓN
+// ==========================================
+
+// FormatoInforme es la forma de presentar un informe
+type FormatoInforme string
+
+const (
+	InformeTexto    FormatoInforme = "texto"
+	InformeJSON     FormatoInforme = "json"
+	InformeCSV      FormatoInforme = "csv"
+	InformeMarkdown FormatoInforme = "markdown"
+)
+
+// ParsearFormatoInforme valida el nombre de un formato. Vacío es texto
+func ParsearFormatoInforme(texto string) (FormatoInforme, error) {
+	formato := FormatoInforme(strings.ToLower(strings.TrimSpace(texto)))
+	switch formato {
+	case "":
+		return InformeTexto, nil
+	case "md":
+		return InformeMarkdown, nil
+	case InformeTexto, InformeJSON, InformeCSV, InformeMarkdown:
+		return formato, nil
+	}
+	return "", nuevoError(ErrDatosInvalidos, "Formato de informe desconocido '%s': use texto, json, csv o markdown", texto)
+}
+
+// TipoContenido retorna el tipo MIME del formato
+func (f FormatoInforme) TipoContenido() string {
+	switch f {
+	case InformeJSON:
+		return "application/json; charset=utf-8"
+	case InformeCSV:
+		return "text/csv; charset=utf-8"
+	case InformeMarkdown:
+		return "text/markdown; charset=utf-8"
+	}
+	return "text/plain; charset=utf-8"
+}
+
+// EscribirInforme presenta informe en formato
+func EscribirInforme(w io.Writer, formato FormatoInforme, informe *InformeCirculacion) error {
+	var err error
+	switch formato {
+	case InformeJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(informe)
+	case InformeCSV:
+		err = escribirInformeCSV(w, informe)
+	case InformeMarkdown:
+		err = escribirInformeMarkdown(w, informe)
+	case InformeTexto, "":
+		err = escribirInformeTexto(w, informe)
+	default:
+		return nuevoError(ErrDatosInvalidos, "Formato de informe desconocido '%s'", formato)
+	}
+	if err != nil {
+		return fmt.Errorf("No se pudo escribir el informe: %w", err)
+	}
+	return nil
+}
+
+// periodo describe el período del informe con el último día incluido
+func (i *InformeCirculacion) periodo() string {
+	return fmt.Sprintf("%s al %s", i.Desde.Format(time.DateOnly), i.Hasta.Add(-time.Nanosecond).Format(time.DateOnly))
+}
+
+// indicadores son las cifras sueltas del informe, en el orden en que se
+// presentan
+func (i *InformeCirculacion) indicadores() [][2]string {
+	return [][2]string{
+		{"prestamos", strconv.Itoa(i.Prestamos)},
+		{"devoluciones", strconv.Itoa(i.Devoluciones)},
+		{"duracion_promedio_dias", strconv.FormatFloat(i.DuracionPromedioDias, 'f', 2, 64)},
+		{"vencimientos", strconv.Itoa(i.Vencimientos)},
+		{"atrasados", strconv.Itoa(i.Atrasados)},
+		{"tasa_atraso", strconv.FormatFloat(i.TasaAtraso, 'f', 2, 64)},
+		{"usuarios_inactivos", strconv.Itoa(len(i.UsuariosInactivos))},
+		{"total_libros", strconv.Itoa(i.Resumen.TotalLibros)},
+		{"total_ejemplares", strconv.Itoa(i.Resumen.TotalEjemplares)},
+		{"usuarios_activos", strconv.Itoa(i.Resumen.UsuariosActivos)},
+		{"prestamos_activos", strconv.Itoa(i.Resumen.PrestamosActivos)},
+		{"prestamos_vencidos", strconv.Itoa(i.Resumen.PrestamosVencidos)},
+	}
+}
+
+func escribirInformeTexto(w io.Writer, i *InformeCirculacion) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "📊 Circulación de %s, %s\n", i.Biblioteca, i.periodo())
+	fmt.Fprintln(bw, strings.Repeat("=", 51))
+	fmt.Fprintf(bw, "📋 Préstamos: %d    Devoluciones: %d\n", i.Prestamos, i.Devoluciones)
+	fmt.Fprintf(bw, "⏱️ Duración promedio: %.1f días\n", i.DuracionPromedioDias)
+	fmt.Fprintf(bw, "⏰ Devueltos tarde o sin devolver: %d de %d (%.0f%%)\n", i.Atrasados, i.Vencimientos, i.TasaAtraso*100)
+
+	fmt.Fprintln(bw, "\n📚 Títulos más prestados:")
+	for n, t := range i.TitulosMasPrestados {
+		fmt.Fprintf(bw, " %2d. %s, de %s: %d\n", n+1, t.Titulo, t.Autor, t.Prestamos)
+	}
+	fmt.Fprintln(bw, "\n✍️ Autores más prestados:")
+	for n, a := range i.AutoresMasPrestados {
+		fmt.Fprintf(bw, " %2d. %s: %d\n", n+1, a.Autor, a.Prestamos)
+	}
+	fmt.Fprintln(bw, "\n📅 Préstamos por semana:")
+	for _, s := range i.PrestamosPorSemana {
+		fmt.Fprintf(bw, " %s  %4d\n", s.Inicio.Format(time.DateOnly), s.Prestamos)
+	}
+	fmt.Fprintf(bw, "\n💤 Usuarios sin préstamos en %d días: %d\n", i.DiasInactividad, len(i.UsuariosInactivos))
+	for _, u := range i.UsuariosInactivos {
+		fmt.Fprintf(bw, " [%d] %s, %s\n", u.UsuarioID, u.Nombre, ultimoPrestamoTexto(u, "nunca pidió un libro"))
+	}
+	return bw.Flush()
+}
+
+// escribirInformeCSV escribe una fila por dato con las columnas seccion,
+// clave y valor, para filtrar por sección en una planilla
+func escribirInformeCSV(w io.Writer, i *InformeCirculacion) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"seccion", "clave", "valor"})
+	cw.Write([]string{"periodo", "desde", i.Desde.Format(time.DateOnly)})
+	cw.Write([]string{"periodo", "hasta", i.Hasta.Add(-time.Nanosecond).Format(time.DateOnly)})
+	for _, indicador := range i.indicadores() {
+		cw.Write([]string{"indicadores", indicador[0], indicador[1]})
+	}
+	for _, t := range i.TitulosMasPrestados {
+		cw.Write([]string{"titulos", t.Titulo, strconv.Itoa(t.Prestamos)})
+	}
+	for _, a := range i.AutoresMasPrestados {
+		cw.Write([]string{"autores", a.Autor, strconv.Itoa(a.Prestamos)})
+	}
+	for _, d := range i.PrestamosPorDia {
+		cw.Write([]string{"por_dia", d.Inicio.Format(time.DateOnly), strconv.Itoa(d.Prestamos)})
+	}
+	for _, s := range i.PrestamosPorSemana {
+		cw.Write([]string{"por_semana", s.Inicio.Format(time.DateOnly), strconv.Itoa(s.Prestamos)})
+	}
+	for _, u := range i.UsuariosInactivos {
+		cw.Write([]string{"usuarios_inactivos", strconv.Itoa(u.UsuarioID), ultimoPrestamoTexto(u, "")})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func escribirInformeMarkdown(w io.Writer, i *InformeCirculacion) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# Circulación de %s\n\n", celdaMarkdown(i.Biblioteca))
+	fmt.Fprintf(bw, "Período: %s. Generado el %s.\n\n", i.periodo(), i.Generado.Format(time.DateTime))
+
+	fmt.Fprintln(bw, "## Indicadores\n\n| Indicador | Valor |\n|---|---:|")
+	for _, indicador := range i.indicadores() {
+		fmt.Fprintf(bw, "| %s | %s |\n", indicador[0], indicador[1])
+	}
+	fmt.Fprintln(bw, "\n## Títulos más prestados\n\n| # | Título | Autor | Préstamos |\n|---:|---|---|---:|")
+	for n, t := range i.TitulosMasPrestados {
+		fmt.Fprintf(bw, "| %d | %s | %s | %d |\n", n+1, celdaMarkdown(t.Titulo), celdaMarkdown(t.Autor), t.Prestamos)
+	}
+	fmt.Fprintln(bw, "\n## Autores más prestados\n\n| # | Autor | Préstamos |\n|---:|---|---:|")
+	for n, a := range i.AutoresMasPrestados {
+		fmt.Fprintf(bw, "| %d | %s | %d |\n", n+1, celdaMarkdown(a.Autor), a.Prestamos)
+	}
+	fmt.Fprintln(bw, "\n## Préstamos por semana\n\n| Semana del | Préstamos |\n|---|---:|")
+	for _, s := range i.PrestamosPorSemana {
+		fmt.Fprintf(bw, "| %s | %d |\n", s.Inicio.Format(time.DateOnly), s.Prestamos)
+	}
+	fmt.Fprintln(bw, "\n## Préstamos por día\n\n| Día | Préstamos |\n|---|---:|")
+	for _, d := range i.PrestamosPorDia {
+		fmt.Fprintf(bw, "| %s | %d |\n", d.Inicio.Format(time.DateOnly), d.Prestamos)
+	}
+	fmt.Fprintf(bw, "\n## Usuarios sin préstamos en %d días\n\n| ID | Nombre | Último préstamo |\n|---:|---|---|\n", i.DiasInactividad)
+	for _, u := range i.UsuariosInactivos {
+		fmt.Fprintf(bw, "| %d | %s | %s |\n", u.UsuarioID, celdaMarkdown(u.Nombre), ultimoPrestamoTexto(u, "nunca"))
+	}
+	return bw.Flush()
+}
+
+func ultimoPrestamoTexto(u UsuarioInactivo, nunca string) string {
+	if u.UltimoPrestamo.IsZero() {
+		return nunca
+	}
+	return u.UltimoPrestamo.Format(time.DateOnly)
+}
+
+// celdaMarkdown escapa las barras que cortarían una celda de tabla
+func celdaMarkdown(texto string) string {
+	return strings.ReplaceAll(texto, "|", `\|`)
+}
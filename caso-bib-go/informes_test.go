@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestInformeCirculacion(t *testing.T) {
+	b, reloj := nuevaBibliotecaPrueba(t)
+	var libros []*Libro
+	for _, datos := range [][2]string{{"Rayuela", "Julio Cortázar"}, {"Ficciones", "Jorge Luis Borges"}, {"El Aleph", "Jorge Luis Borges"}} {
+		libro, err := b.AgregarLibro(Sistema, datos[0], datos[1], "", 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		libros = append(libros, libro)
+	}
+	rayuela, ficciones, aleph := libros[0].ID, libros[1].ID, libros[2].ID
+	ana := registrarUsuarioPrueba(t, b, "ana")
+	beto := registrarUsuarioPrueba(t, b, "beto")
+	carla := registrarUsuarioPrueba(t, b, "carla")
+	dani := registrarUsuarioPrueba(t, b, "dani")
+	prestar := func(libroID, usuarioID int) {
+		t.Helper()
+		if _, err := b.PrestarLibro(Sistema, libroID, usuarioID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	devolver := func(libroID int) {
+		t.Helper()
+		if _, err := b.DevolverLibro(Sistema, libroID, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Lunes 3 de marzo: vencen el 17
+	prestar(rayuela, ana.ID)
+	prestar(ficciones, beto.ID)
+	// 10 de marzo: ana devuelve a tiempo tras 7 días y carla se lo lleva
+	// hasta el 24, que no devuelve
+	reloj.Avanzar(7 * dia)
+	devolver(rayuela)
+	prestar(rayuela, carla.ID)
+	// 20 de marzo: beto devuelve tarde tras 17 días; El Aleph vence en abril
+	reloj.Avanzar(10 * dia)
+	devolver(ficciones)
+	prestar(aleph, carla.ID)
+	reloj.Avanzar(12 * dia)
+	verificar(t, b)
+
+	informe, err := b.InformeCirculacion(OpcionesInforme{
+		Desde: time.Date(2025, time.March, 1, 0, 0, 0, 0, time.Local),
+		Hasta: time.Date(2025, time.April, 1, 0, 0, 0, 0, time.Local),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if informe.Prestamos != 4 || informe.Devoluciones != 2 || informe.DuracionPromedioDias != 12 {
+		t.Errorf("préstamos %d, devoluciones %d, duración %.2f; se esperaban 4, 2 y 12",
+			informe.Prestamos, informe.Devoluciones, informe.DuracionPromedioDias)
+	}
+	if informe.Vencimientos != 3 || informe.Atrasados != 2 || informe.TasaAtraso != 0.67 {
+		t.Errorf("vencimientos %d, atrasados %d, tasa %.2f; se esperaban 3, 2 y 0.67",
+			informe.Vencimientos, informe.Atrasados, informe.TasaAtraso)
+	}
+
+	titulos := make([]string, 0)
+	for _, conteo := range informe.TitulosMasPrestados {
+		titulos = append(titulos, conteo.Titulo)
+	}
+	// Los empates van por título y por autor
+	if want := []string{"Rayuela", "El Aleph", "Ficciones"}; !slices.Equal(titulos, want) {
+		t.Errorf("títulos más prestados %v, se esperaba %v", titulos, want)
+	}
+	if want := []ConteoAutor{{"Jorge Luis Borges", 2}, {"Julio Cortázar", 2}}; !slices.Equal(informe.AutoresMasPrestados, want) {
+		t.Errorf("autores más prestados %+v, se esperaba %+v", informe.AutoresMasPrestados, want)
+	}
+
+	if len(informe.PrestamosPorDia) != 31 || informe.PrestamosPorDia[2].Prestamos != 2 || informe.PrestamosPorDia[9].Prestamos != 1 {
+		t.Errorf("préstamos por día = %+v", informe.PrestamosPorDia)
+	}
+	// El 1 de marzo es sábado: la primera semana empieza el lunes 24 de
+	// febrero
+	semanas := make([]int, 0)
+	for _, semana := range informe.PrestamosPorSemana {
+		semanas = append(semanas, semana.Prestamos)
+	}
+	if want := []int{0, 2, 1, 1, 0, 0}; !slices.Equal(semanas, want) ||
+		!informe.PrestamosPorSemana[0].Inicio.Equal(time.Date(2025, time.February, 24, 0, 0, 0, 0, time.Local)) {
+		t.Errorf("préstamos por semana = %+v, se esperaba %v desde el 24 de febrero", informe.PrestamosPorSemana, want)
+	}
+
+	if len(informe.UsuariosInactivos) != 1 || informe.UsuariosInactivos[0].UsuarioID != dani.ID ||
+		!informe.UsuariosInactivos[0].UltimoPrestamo.IsZero() {
+		t.Errorf("usuarios inactivos = %+v, se esperaba solo dani", informe.UsuariosInactivos)
+	}
+	if r := informe.Resumen; r.TotalLibros != 3 || r.UsuariosActivos != 4 || r.PrestamosActivos != 2 || r.PrestamosVencidos != 1 {
+		t.Errorf("resumen = %+v", r)
+	}
+
+	corto, err := b.InformeCirculacion(OpcionesInforme{Desde: informe.Desde, Hasta: informe.Hasta, Top: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(corto.TitulosMasPrestados) != 1 || len(corto.AutoresMasPrestados) != 1 {
+		t.Errorf("con top 1: títulos %+v, autores %+v", corto.TitulosMasPrestados, corto.AutoresMasPrestados)
+	}
+	if _, err := b.InformeCirculacion(OpcionesInforme{Desde: informe.Hasta, Hasta: informe.Desde}); !errors.Is(err, ErrDatosInvalidos) {
+		t.Errorf("período invertido: err = %v, se esperaba ErrDatosInvalidos", err)
+	}
+}
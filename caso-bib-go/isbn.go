@@ -0,0 +1,214 @@
+package main
+
+import (
+	"strings"
+)
+
+// ==========================================
+// ISBN
+// ==========================================
+// Un mismo libro puede escribirse como ISBN-10 o ISBN-13, con o sin guiones:
+// "0-13-419044-0", "978-0-13-419044-0" y "9780134190440" son el mismo. El
+// catálogo guarda y compara la forma canónica (los 13 dígitos del ISBN-13,
+// sin guiones) y agrega los guiones solo para mostrarlo.
+
+// ISBN es un ISBN en forma canónica. El valor vacío indica que el libro no
+// tiene ISBN
+type ISBN string
+
+// ParsearISBN interpreta un ISBN-10 o ISBN-13 con o sin guiones y espacios,
+// verifica su dígito de control y lo retorna en forma canónica. Acepta
+// también el prefijo "ISBN"
+func ParsearISBN(texto string) (ISBN, error) {
+	limpio := strings.ToUpper(strings.TrimSpace(texto))
+	limpio = strings.TrimPrefix(limpio, "ISBN")
+	limpio = strings.TrimLeft(limpio, ":- ")
+	limpio = strings.NewReplacer("-", "", " ", "").Replace(limpio)
+
+	switch len(limpio) {
+	case 10:
+		if !isbn10Valido(limpio) {
+			return "", nuevoError(ErrDatosInvalidos, "ISBN-10 no válido '%s'", texto)
+		}
+		return ISBN(convertirA13("978" + limpio[:9])), nil
+	case 13:
+		if !soloDigitos(limpio) || digitoControl13(limpio[:12]) != limpio[12] {
+			return "", nuevoError(ErrDatosInvalidos, "ISBN-13 no válido '%s'", texto)
+		}
+		if !strings.HasPrefix(limpio, "978") && !strings.HasPrefix(limpio, "979") {
+			return "", nuevoError(ErrDatosInvalidos, "ISBN-13 no válido '%s': debe empezar con 978 o 979", texto)
+		}
+		return ISBN(limpio), nil
+	default:
+		return "", nuevoError(ErrDatosInvalidos, "ISBN no válido '%s': debe tener 10 o 13 dígitos", texto)
+	}
+}
+
+// normalizarISBN parsea texto si no está vacío; un ISBN vacío sigue vacío
+func normalizarISBN(texto string) (ISBN, error) {
+	if strings.TrimSpace(texto) == "" {
+		return "", nil
+	}
+	return ParsearISBN(texto)
+}
+
+// ISBN13 retorna el ISBN-13 sin guiones
+func (i ISBN) ISBN13() string {
+	return string(i)
+}
+
+// ISBN10 retorna el ISBN-10 equivalente sin guiones. Solo los ISBN con
+// prefijo 978 tienen uno
+func (i ISBN) ISBN10() (string, bool) {
+	if len(i) != 13 || !strings.HasPrefix(string(i), "978") {
+		return "", false
+	}
+	cuerpo := string(i[3:12])
+	return cuerpo + string(digitoControl10(cuerpo)), true
+}
+
+// String retorna el ISBN-13 con guiones, o el valor tal cual si no está en
+// forma canónica
+func (i ISBN) String() string {
+	return i.ConGuiones()
+}
+
+// ConGuiones separa prefijo, grupo de registro, editorial, publicación y
+// dígito de control: "978-0-13-419044-0". Solo se conocen los rangos de
+// editorial de los grupos de rangosEditorial; en los demás grupos se separan
+// el prefijo y el grupo y el resto queda junto ("978-84-37604947"), porque
+// partirlo mal lo hace parecer otro. Un grupo desconocido se muestra sin
+// guiones
+func (i ISBN) ConGuiones() string {
+	s := string(i)
+	if len(s) != 13 || !soloDigitos(s) {
+		return s
+	}
+	prefijo, resto := s[:3], s[3:12]
+	largoGrupo := buscarRango(rangosGrupo[prefijo], resto)
+	if largoGrupo == 0 {
+		return s
+	}
+	grupo, resto := resto[:largoGrupo], resto[largoGrupo:]
+	largo := buscarRango(rangosEditorial[prefijo+"-"+grupo], resto)
+	if largo == 0 || largo >= len(resto) {
+		return strings.Join([]string{prefijo, grupo, s[3+largoGrupo:]}, "-")
+	}
+	return strings.Join([]string{prefijo, grupo, resto[:largo], resto[largo:], s[12:]}, "-")
+}
+
+// ==========================================
+// DÍGITOS DE CONTROL
+// ==========================================
+
+func soloDigitos(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// isbn10Valido verifica un ISBN-10 sin guiones: nueve dígitos y un control
+// que puede ser X (10)
+func isbn10Valido(s string) bool {
+	if !soloDigitos(s[:9]) {
+		return false
+	}
+	return digitoControl10(s[:9]) == s[9]
+}
+
+// digitoControl10 calcula el control de los primeros nueve dígitos de un
+// ISBN-10: la suma ponderada de 10 a 2 más el control es múltiplo de 11
+func digitoControl10(cuerpo string) byte {
+	suma := 0
+	for i := range 9 {
+		suma += int(cuerpo[i]-'0') * (10 - i)
+	}
+	control := (11 - suma%11) % 11
+	if control == 10 {
+		return 'X'
+	}
+	return byte('0' + control)
+}
+
+// digitoControl13 calcula el control de los primeros doce dígitos de un
+// ISBN-13: pesos alternados 1 y 3, módulo 10
+func digitoControl13(cuerpo string) byte {
+	suma := 0
+	for i := range 12 {
+		peso := 1
+		if i%2 == 1 {
+			peso = 3
+		}
+		suma += int(cuerpo[i]-'0') * peso
+	}
+	return byte('0' + (10-suma%10)%10)
+}
+
+// convertirA13 agrega el dígito de control a los doce primeros dígitos
+func convertirA13(cuerpo string) string {
+	return cuerpo + string(digitoControl13(cuerpo))
+}
+
+// ==========================================
+// RANGOS PARA LOS GUIONES
+// ==========================================
+// Extracto de los rangos que publica la Agencia Internacional del ISBN. Cada
+// rango dice cuántos dígitos ocupa el grupo (o la editorial) cuando lo que
+// sigue al prefijo empieza entre Desde y Hasta.
+
+type rangoISBN struct {
+	Desde, Hasta string
+	Largo        int
+}
+
+var rangosGrupo = map[string][]rangoISBN{
+	"978": {
+		{"0000000", "5999999", 1},
+		{"6000000", "6499999", 3},
+		{"6500000", "6599999", 2},
+		{"7000000", "7999999", 1},
+		{"8000000", "9499999", 2},
+		{"9500000", "9899999", 3},
+		{"9900000", "9989999", 4},
+		{"9990000", "9999999", 5},
+	},
+	"979": {
+		{"1000000", "1299999", 2},
+		{"8000000", "8999999", 1},
+	},
+}
+
+var rangosEditorial = map[string][]rangoISBN{
+	// Inglés
+	"978-0": {
+		{"0000000", "1999999", 2},
+		{"2000000", "6999999", 3},
+		{"7000000", "8499999", 4},
+		{"8500000", "8999999", 5},
+		{"9000000", "9499999", 6},
+		{"9500000", "9999999", 7},
+	},
+	"978-1": {
+		{"0000000", "0999999", 2},
+		{"1000000", "3999999", 3},
+		{"4000000", "5499999", 4},
+		{"5500000", "8697999", 5},
+		{"8698000", "9989999", 6},
+		{"9990000", "9999999", 7},
+	},
+}
+
+// buscarRango retorna el largo del rango en que cae digitos, o 0 si no cae
+// en ninguno. Se comparan los primeros siete dígitos, completando con ceros
+func buscarRango(rangos []rangoISBN, digitos string) int {
+	clave := (digitos + "0000000")[:7]
+	for _, r := range rangos {
+		if clave >= r.Desde && clave <= r.Hasta {
+			return r.Largo
+		}
+	}
+	return 0
+}
@@ -0,0 +1,47 @@
+package main
+
+import "testing"
+
+func TestParsearISBN(t *testing.T) {
+	casos := []struct {
+		texto, canonico string
+	}{
+		{"0-13-419044-0", "9780134190440"},
+		{"978-0-13-419044-0", "9780134190440"},
+		{"ISBN 9780134190440", "9780134190440"},
+		{"0-8044-2957-X", "9780804429573"},
+	}
+	for _, c := range casos {
+		isbn, err := ParsearISBN(c.texto)
+		if err != nil || isbn.ISBN13() != c.canonico {
+			t.Errorf("ParsearISBN(%q) = %q, %v; se esperaba %q", c.texto, isbn, err, c.canonico)
+		}
+	}
+	for _, texto := range []string{"0-13-419044-5", "978-0-13-419044-1", "12345", "9770134190442"} {
+		if isbn, err := ParsearISBN(texto); err == nil {
+			t.Errorf("ParsearISBN(%q) = %q; se esperaba un error", texto, isbn)
+		}
+	}
+}
+
+func TestISBNConGuiones(t *testing.T) {
+	casos := []struct {
+		isbn, guiones string
+	}{
+		{"9780134190440", "978-0-13-419044-0"},
+		{"9781491950357", "978-1-4919-5035-7"},
+		// Grupos sin rangos de editorial conocidos: solo prefijo y grupo
+		{"9788437604947", "978-84-37604947"},
+		{"9789500726238", "978-950-0726238"},
+		{"9789561122437", "978-956-1122437"},
+		{"9791032305690", "979-10-32305690"},
+		// Grupo sin rango conocido: sin guiones
+		{"9795000000000", "9795000000000"},
+		{"", ""},
+	}
+	for _, c := range casos {
+		if got := ISBN(c.isbn).ConGuiones(); got != c.guiones {
+			t.Errorf("ISBN(%q).ConGuiones() = %q; se esperaba %q", c.isbn, got, c.guiones)
+		}
+	}
+}
@@ -2,40 +2,111 @@ package main
 
 import (
 	"fmt"
+	"os"
 	"strings"
+	"sync"
 	"time"
 )
 
 // ==========================================
 // PASO 1: STRUCTS BÁSICOS
 // ==========================================
-// Libro representa un libro en la biblioteca
+// Libro representa un título del catálogo. Los ejemplares físicos que se
+// prestan son Ejemplar
 type Libro struct {
-	ID       int
-	Titulo   string
-	Autor    string
-	ISBN     string
-	Paginas  int
-	Prestado bool
+	ID      int       `json:"id"`
+	Titulo  string    `json:"titulo"`
+	Autor   string    `json:"autor"`
+	ISBN    ISBN      `json:"isbn"` // forma canónica, ver ParsearISBN
+	Paginas int       `json:"paginas"`
+	Tipo    TipoLibro `json:"tipo,omitempty"` // vacío es general
+	// Etiquetas agrupan libros en el catálogo; ver EtiquetarLibro
+	Etiquetas []string `json:"etiquetas,omitempty"`
+	// Retirado saca el libro del catálogo sin borrarlo; ver RetirarLibro
+	Retirado    bool      `json:"retirado,omitempty"`
+	FechaRetiro time.Time `json:"fecha_retiro,omitzero"`
+}
+
+// EstadoEjemplar indica qué está pasando con un ejemplar físico
+type EstadoEjemplar string
+
+const (
+	EjemplarDisponible EstadoEjemplar = "disponible"
+	EjemplarPrestado   EstadoEjemplar = "prestado"
+	// EjemplarApartado: devuelto y reservado para el siguiente de la cola
+	EjemplarApartado EstadoEjemplar = "apartado"
+	// EjemplarEnReparacion: fuera de circulación hasta que vuelva del taller
+	EjemplarEnReparacion EstadoEjemplar = "en_reparacion"
+	// EjemplarPerdido: no se encuentra; puede reaparecer
+	EjemplarPerdido EstadoEjemplar = "perdido"
+	// EjemplarRetirado: dado de baja del fondo; es definitivo
+	EjemplarRetirado EstadoEjemplar = "retirado"
+	// EjemplarSoloConsulta: se usa en sala y no se presta
+	EjemplarSoloConsulta EstadoEjemplar = "solo_consulta"
+	// EjemplarEnTransito: viaja de una sucursal a otra; ver sucursales.go
+	EjemplarEnTransito EstadoEjemplar = "en_transito"
+)
+
+// Ejemplar representa una copia física de un Libro, con su propio código de
+// barras y ubicación en la estantería. EstadoDesde y Motivo dicen cuándo y
+// por qué pasó a su Estado actual; ver estados.go
+type Ejemplar struct {
+	ID           int            `json:"id"`
+	LibroID      int            `json:"libro_id"`
+	CodigoBarras string         `json:"codigo_barras"`
+	Ubicacion    string         `json:"ubicacion"`
+	Estado       EstadoEjemplar `json:"estado"`
+	EstadoDesde  time.Time      `json:"estado_desde,omitzero"`
+	Motivo       string         `json:"motivo,omitempty"`
+	// Sucursal es la sucursal a cuyo fondo pertenece y SucursalActual
+	// aquella en la que está o de la que salió por última vez; ver
+	// sucursales.go. Las dos quedan vacías si la biblioteca no tiene
+	// sucursales
+	Sucursal       string `json:"sucursal,omitempty"`
+	SucursalActual string `json:"sucursal_actual,omitempty"`
 }
 
 // Usuario representa un usuario de la biblioteca
 type Usuario struct {
-	ID       int
-	Nombre   string
-	Email    string
-	Telefono string
-	Activo   bool
+	ID               int     `json:"id"`
+	Nombre           string  `json:"nombre"`
+	Email            string  `json:"email"`
+	Telefono         string  `json:"telefono"`
+	Activo           bool    `json:"activo"`
+	PrestamosActivos int     `json:"prestamos_activos"`
+	Deuda            float64 `json:"deuda"` // multas sin pagar
+	// Categoria decide las reglas de préstamo; vacía es la categoría por
+	// defecto de la política
+	Categoria CategoriaUsuario `json:"categoria,omitempty"`
+	// Cerrada indica que la cuenta se dio de baja; ver CerrarCuenta. Pasados
+	// los días de retención sus datos personales se borran y queda
+	// Anonimizado
+	Cerrada     bool      `json:"cerrada,omitempty"`
+	FechaCierre time.Time `json:"fecha_cierre,omitzero"`
+	Anonimizado bool      `json:"anonimizado,omitempty"`
 }
 
-// Prestamo representa un prestamo de un libro
+// Prestamo representa un prestamo de un ejemplar. LibroID repite el título
+// del ejemplar para consultar el historial sin buscarlo
 type Prestamo struct {
-	ID              int
-	LibroID         int
-	UsuarioID       int
-	FechaPrestamo   time.Time
-	FechaDevolucion time.Time
-	Devuelto        bool
+	ID              int          `json:"id"`
+	LibroID         int          `json:"libro_id"`
+	EjemplarID      int          `json:"ejemplar_id"`
+	UsuarioID       int          `json:"usuario_id"`
+	FechaPrestamo   time.Time    `json:"fecha_prestamo"`
+	FechaDevolucion time.Time    `json:"fecha_devolucion"` // fecha límite para devolverlo
+	Devuelto        bool         `json:"devuelto"`
+	FechaDevuelto   time.Time    `json:"fecha_devuelto,omitzero"` // cuándo se devolvió
+	Multa           float64      `json:"multa,omitempty"`
+	Renovaciones    []Renovacion `json:"renovaciones,omitempty"`
+	// Perdido indica que el préstamo se cerró porque el ejemplar se perdió;
+	// CargoReposicion es lo que se cobró por reponerlo, aparte de la Multa
+	Perdido         bool    `json:"perdido,omitempty"`
+	CargoReposicion float64 `json:"cargo_reposicion,omitempty"`
+	// Sucursal es donde se prestó y SucursalDevolucion donde se devolvió,
+	// que puede ser otra
+	Sucursal           string `json:"sucursal,omitempty"`
+	SucursalDevolucion string `json:"sucursal_devolucion,omitempty"`
 }
 
 // ==========================================
@@ -45,17 +116,7 @@ type Prestamo struct {
 // ObtenerInfo retorna información básica del libro
 // Usa receptor de VALOR porque solo LEE, no modifica
 func (l Libro) ObtenerInfo() string {
-	estado := "Disponible"
-	if l.Prestado {
-		estado = "Prestado"
-	}
-	return fmt.Sprintf("[%d] %s por %s - %s", l.ID, l.Titulo, l.Autor, estado)
-}
-
-// EsPretable verifica si el libro se puede prestar
-// Usa receptor de VALOR porque solo LEE
-func (l Libro) EsPrestable() bool {
-	return !l.Prestado && l.Paginas > 0
+	return fmt.Sprintf("[%d] %s por %s", l.ID, l.Titulo, l.Autor)
 }
 
 func (l Libro) EsGrande() bool {
@@ -72,8 +133,20 @@ func (u Usuario) ObtenerResumen() string {
 	return fmt.Sprintf("%s (%s) - %s", u.Nombre, u.Email, estado)
 }
 
-func (u Usuario) PuedePrestar() bool {
-	return u.Activo && u.Email != "" && u.Nombre != ""
+// PuedePrestar verifica que el usuario esté activo, tenga sus datos y no
+// deba más de limiteDeuda en multas
+func (u Usuario) PuedePrestar(limiteDeuda float64) bool {
+	return u.Activo && u.Email != "" && u.Nombre != "" && u.Deuda <= limiteDeuda
+}
+
+// ObtenerInfo retorna información básica del ejemplar
+func (e Ejemplar) ObtenerInfo() string {
+	return fmt.Sprintf("[%d] %s en %s - %s", e.ID, e.CodigoBarras, e.Ubicacion, e.Estado)
+}
+
+// EsPrestable verifica si el ejemplar está en la estantería
+func (e Ejemplar) EsPrestable() bool {
+	return e.Estado == EjemplarDisponible
 }
 
 // ==========================================
@@ -81,36 +154,41 @@ func (u Usuario) PuedePrestar() bool {
 // (Para MODIFICAR el estado del struct)
 // ==========================================
 
-// Prestar marca el libro como prestado
+// Prestar marca el ejemplar como prestado
 // Usa receptor de PUNTERO porque MODIFICA el estado
 
-func (l *Libro) Prestar() error {
-	if l.Prestado {
-		return fmt.Errorf("El libro '%s' ya está prestado", l.Titulo)
+// Un ejemplar apartado también se puede prestar: Biblioteca comprueba antes
+// que sea a quien lo reservó
+func (e *Ejemplar) Prestar(ahora time.Time) error {
+	if e.Estado != EjemplarDisponible && e.Estado != EjemplarApartado {
+		return nuevoError(ErrNoPermitido, "El ejemplar '%s' no está disponible (%s)", e.CodigoBarras, e.Estado)
 	}
-	if l.Paginas <= 0 {
-		return fmt.Errorf("El libro '%s' no es valido", l.Titulo)
+	return e.cambiarEstado(EjemplarPrestado, ahora, "préstamo")
+}
+
+func (e *Ejemplar) Devolver(ahora time.Time) error {
+	if e.Estado != EjemplarPrestado {
+		return nuevoError(ErrNoPermitido, "El ejemplar '%s' no está prestado", e.CodigoBarras)
 	}
-	l.Prestado = true
-	return nil
+	return e.cambiarEstado(EjemplarDisponible, ahora, "devolución")
 }
 
-func (l *Libro) Devolver() error {
-	if !l.Prestado {
-		return fmt.Errorf("El libro '%s' no está prestado", l.Titulo)
+// Apartar reserva el ejemplar en la estantería para quien lo espera
+func (e *Ejemplar) Apartar(ahora time.Time, motivo string) error {
+	if e.Estado != EjemplarDisponible {
+		return nuevoError(ErrNoPermitido, "El ejemplar '%s' no está disponible (%s)", e.CodigoBarras, e.Estado)
 	}
-	l.Prestado = false
-	return nil
+	return e.cambiarEstado(EjemplarApartado, ahora, motivo)
 }
 
 // ActualizarInfo permite actualizar información del libro
 // Usa receptor de PUNTERO porque MODIFICA el estado
 func (l *Libro) ActualizarInfo(titulo, autor string, paginas int) error {
 	if titulo == "" || autor == "" {
-		return fmt.Errorf("Debe proporcionar titulo y autor")
+		return nuevoError(ErrDatosInvalidos, "Debe proporcionar titulo y autor")
 	}
 	if paginas <= 0 {
-		return fmt.Errorf("Debe proporcionar cantidad de paginas")
+		return nuevoError(ErrDatosInvalidos, "Debe proporcionar cantidad de paginas")
 	}
 
 	l.Titulo = titulo
@@ -129,7 +207,7 @@ func (u *Usuario) Desactivar() {
 
 func (u *Usuario) ActualizarContacto(email, telefono string) error {
 	if !strings.Contains(email, "@") {
-		return fmt.Errorf("Email no válido '%s'", email)
+		return nuevoError(ErrDatosInvalidos, "Email no válido '%s'", email)
 	}
 	u.Email = email
 	u.Telefono = telefono
@@ -140,14 +218,56 @@ func (u *Usuario) ActualizarContacto(email, telefono string) error {
 // PASO 4: STRUCT PRINCIPAL CON COMPOSICIÓN
 // ==========================================
 // Biblioteca es el struct principal que maneja todo el sistema
+// Todos sus métodos se pueden llamar desde varias goroutines a la vez: las
+// lecturas comparten un bloqueo de lectura y cada operación que modifica
+// toma el bloqueo exclusivo durante toda su transacción
 
 type Biblioteca struct {
-	Nombre    string
-	Direccion string
-	Libros    []Libro
-	Usuarios  []Usuario
-	Prestamos []Prestamo
-	proximoID int
+	Nombre       string
+	Direccion    string
+	mu           sync.RWMutex
+	libros       LibroRepo
+	ejemplares   EjemplarRepo
+	usuarios     UsuarioRepo
+	prestamos    PrestamoRepo
+	reservas     ReservaRepo
+	credenciales map[string]Credencial // credenciales de acceso por nombre, ver acceso.go
+	sucursales   map[string]Sucursal   // sucursales de la red por código, ver sucursales.go
+	traslados    map[int]Traslado      // traslados de ejemplares entre sucursales por ID
+	clavesDatos  map[int][]byte        // claves de los datos personales de los eventos por usuario, ver retencion.go
+	proximoID    int
+	diario       *diario[entradaDiario] // nil mientras no se haya usado Guardar o Cargar
+	busqueda     *indiceBusqueda        // índice de texto de los libros, ver BuscarLibros
+
+	// eventos es el registro completo de auditoría, ver Eventos. Se guarda
+	// en archivoEventos, que como el diario existe desde Guardar o Cargar
+	eventos        []Evento
+	archivoEventos *diario[Evento]
+	rutaEventos    string
+
+	// MaxReservasPorUsuario limita las reservas pendientes de cada usuario
+	MaxReservasPorUsuario int
+	// PlazoRetiro es el tiempo que un ejemplar queda apartado para quien lo
+	// reservó antes de pasar al siguiente de la cola
+	PlazoRetiro time.Duration
+	// Politica define las reglas de préstamo, renovación y multas de cada
+	// categoría de usuario
+	Politica Politica
+	// Calendarios dice qué días atiende cada sucursal, por código; el de
+	// clave vacía es el de la biblioteca y vale para las sucursales sin uno
+	// propio. Sin calendario se atiende todos los días. Ver calendario.go
+	Calendarios map[string]*Calendario
+	// Metadatos propone los datos de un libro por su ISBN; nil si no hay
+	// servicio configurado. Ver metadatos.go
+	Metadatos *ServicioMetadatos
+	// Reloj da la hora de cada operación; se reemplaza en pruebas para
+	// simular el paso del tiempo
+	Reloj Reloj
+
+	// ModoEstricto verifica las invariantes antes de confirmar cada
+	// operación y la revierte si las rompe. Recorre toda la biblioteca, así
+	// que está pensado para pruebas y diagnóstico.
+	ModoEstricto bool
 }
 
 // ==========================================
@@ -155,338 +275,636 @@ type Biblioteca struct {
 // ==========================================
 // NuevaBiblioteca es un constructor (patrón común en Go)
 func NuevaBiblioteca(nombre, direccion string) *Biblioteca {
-	return &Biblioteca{
-		Nombre:    nombre,
-		Direccion: direccion,
-		Libros:    make([]Libro, 0),
-		Usuarios:  make([]Usuario, 0),
-		Prestamos: make([]Prestamo, 0),
-		proximoID: 1,
+	return NuevaBibliotecaConRepos(nombre, direccion, NuevoLibroRepoMemoria(), NuevoEjemplarRepoMemoria(),
+		NuevoUsuarioRepoMemoria(), NuevoPrestamoRepoMemoria(), NuevoReservaRepoMemoria())
+}
+
+// NuevaBibliotecaConRepos crea una biblioteca sobre repositorios ya
+// existentes. El próximo ID continúa después del mayor ID guardado.
+func NuevaBibliotecaConRepos(nombre, direccion string, libros LibroRepo, ejemplares EjemplarRepo,
+	usuarios UsuarioRepo, prestamos PrestamoRepo, reservas ReservaRepo) *Biblioteca {
+	b := &Biblioteca{
+		Nombre:                nombre,
+		Direccion:             direccion,
+		libros:                libros,
+		ejemplares:            ejemplares,
+		usuarios:              usuarios,
+		prestamos:             prestamos,
+		reservas:              reservas,
+		credenciales:          make(map[string]Credencial),
+		sucursales:            make(map[string]Sucursal),
+		traslados:             make(map[int]Traslado),
+		clavesDatos:           make(map[int][]byte),
+		proximoID:             1,
+		MaxReservasPorUsuario: 5,
+		PlazoRetiro:           3 * 24 * time.Hour,
+		Politica:              PoliticaPorDefecto,
+		Reloj:                 RelojSistema{},
+		busqueda:              nuevoIndiceBusqueda(),
+	}
+	for _, libro := range libros.Listar() {
+		b.proximoID = max(b.proximoID, libro.ID+1)
+		b.busqueda.indexar(libro)
+	}
+	for _, ejemplar := range ejemplares.Listar() {
+		b.proximoID = max(b.proximoID, ejemplar.ID+1)
+	}
+	for _, usuario := range usuarios.Listar() {
+		b.proximoID = max(b.proximoID, usuario.ID+1)
+	}
+	for _, prestamo := range prestamos.Listar() {
+		b.proximoID = max(b.proximoID, prestamo.ID+1)
+	}
+	for _, reserva := range reservas.Listar() {
+		b.proximoID = max(b.proximoID, reserva.ID+1)
+	}
+	// Los eventos de lo que ya estaba en los repositorios no se conocen
+	if estado := b.eventoEstado(EventoEstadoInicial); !estado.Cambios.vacia() {
+		b.agregarEvento(estado)
+	}
+	return b
+}
+
+// AgregarLibro añade un nuevo título al catálogo junto con su primer
+// ejemplar. Para sumar más copias de un título existente se usa
+// AgregarEjemplar
+// Usa receptor de PUNTERO porque modifica el catálogo
+func (b *Biblioteca) AgregarLibro(actor Actor, titulo, autor, isbn string, paginas int) (*Libro, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.autorizar(actor, PermisoCatalogo, 0); err != nil {
+		return nil, err
+	}
+
+	tx := b.iniciar(actor, EventoLibroAgregado)
+	libro, err := b.agregarLibro(tx, titulo, autor, isbn, paginas)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.confirmar(); err != nil {
+		return nil, err
 	}
+	return &libro, nil
 }
 
-// AgregarLibro añade un nuevo libro a la biblioteca
-// Usa receptor de PUNTERO porque modifica el slice de libros
-func (b *Biblioteca) AgregarLibro(titulo, autor, isbn string, paginas int) (*Libro, error) {
+// agregarLibro valida el libro y lo agrega con su primer ejemplar a tx
+func (b *Biblioteca) agregarLibro(tx *transaccion, titulo, autor, isbn string, paginas int) (Libro, error) {
 	if titulo == "" || autor == "" {
-		return nil, fmt.Errorf("Debe proporcionar titulo y autor")
+		return Libro{}, nuevoError(ErrDatosInvalidos, "Debe proporcionar titulo y autor")
+	}
+
+	canonico, err := normalizarISBN(isbn)
+	if err != nil {
+		return Libro{}, err
 	}
 
 	//verificar que no exista un lubro con el mismo ISBN
-	for _, libro := range b.Libros {
-		if libro.ISBN == isbn && isbn != "" {
-			return nil, fmt.Errorf("Ya existe un libro con el ISBN '%s'", isbn)
-		}
+	if _, existe := tx.libroPorISBN(canonico); existe && canonico != "" {
+		return Libro{}, nuevoError(ErrConflicto, "Ya existe un libro con el ISBN '%s'", canonico)
 	}
 
 	libro := Libro{
-		ID:       b.proximoID,
-		Titulo:   titulo,
-		Autor:    autor,
-		ISBN:     isbn,
-		Paginas:  paginas,
-		Prestado: false,
+		ID:      tx.nuevoID(),
+		Titulo:  titulo,
+		Autor:   autor,
+		ISBN:    canonico,
+		Paginas: paginas,
+	}
+	tx.guardarLibro(libro)
+	if _, err := tx.nuevoEjemplar(libro.ID, "", "", ""); err != nil {
+		return Libro{}, err
 	}
+	return libro, nil
+}
 
-	b.Libros = append(b.Libros, libro)
-	b.proximoID++
+// RegistrarUsuario registra un nuevo usuario. Con categoria vacía se usa la
+// categoría por defecto de la política
+// Usa receptor de PUNTERO porque modifica los usuarios registrados
+func (b *Biblioteca) RegistrarUsuario(actor Actor, nombre, email, telefono string, categoria CategoriaUsuario) (*Usuario, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
 
-	return &libro, nil
+	if err := b.autorizar(actor, PermisoUsuarios, 0); err != nil {
+		return nil, err
+	}
+
+	tx := b.iniciar(actor, EventoUsuarioRegistrado)
+	usuario, err := b.registrarUsuario(tx, nombre, email, telefono, categoria)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.confirmar(); err != nil {
+		return nil, err
+	}
+	return &usuario, nil
 }
 
-// RegistrarUsuario registra un nuevo usuario
-// Usa receptor de PUNTERO porque modifica el slice de usuarios
-func (b *Biblioteca) RegistrarUsuario(nombre, email, telefono string) (*Usuario, error) {
+// registrarUsuario valida el usuario y lo agrega a tx
+func (b *Biblioteca) registrarUsuario(tx *transaccion, nombre, email, telefono string, categoria CategoriaUsuario) (Usuario, error) {
 	if nombre == "" || email == "" {
-		return nil, fmt.Errorf("Debe proporcionar nombre y email")
+		return Usuario{}, nuevoError(ErrDatosInvalidos, "Debe proporcionar nombre y email")
 	}
 
 	if !strings.Contains(email, "@") {
-		return nil, fmt.Errorf("Email no válido '%s'", email)
+		return Usuario{}, nuevoError(ErrDatosInvalidos, "Email no válido '%s'", email)
 	}
 
-	for _, usuario := range b.Usuarios {
-		if usuario.Email == email {
-			return nil, fmt.Errorf("Ya existe un usuario con el email '%s'", email)
-		}
+	if categoria == "" {
+		categoria = b.Politica.CategoriaPorDefecto
+	}
+	if !b.Politica.EsCategoria(categoria) {
+		return Usuario{}, nuevoError(ErrDatosInvalidos, "Categoría de usuario desconocida '%s'", categoria)
+	}
+
+	if _, existe := tx.usuarioPorEmail(email); existe {
+		return Usuario{}, nuevoError(ErrConflicto, "Ya existe un usuario con el email '%s'", email)
 	}
 	usuario := Usuario{
-		ID:       b.proximoID,
-		Nombre:   nombre,
-		Email:    email,
-		Telefono: telefono,
-		Activo:   true,
+		ID:        tx.nuevoID(),
+		Nombre:    nombre,
+		Email:     email,
+		Telefono:  telefono,
+		Activo:    true,
+		Categoria: categoria,
 	}
+	tx.guardarUsuario(usuario)
+	return usuario, nil
+}
 
-	b.Usuarios = append(b.Usuarios, usuario)
-	b.proximoID++
+// BuscarLibro busca un libro por ID
+// Retorna una copia: para modificar el libro hay que usar los métodos de
+// Biblioteca. Usa receptor de PUNTERO aunque solo lee, porque copiar la
+// Biblioteca copiaría también su mutex
+func (b *Biblioteca) BuscarLibro(id int) *Libro {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	libro, ok := b.libros.PorID(id)
+	if !ok {
+		return nil
+	}
+	return &libro
+}
 
-	return &usuario, nil
+// BuscarLibroPorISBN busca un libro por su ISBN, escrito en cualquiera de
+// sus formas, y retorna una copia
+func (b *Biblioteca) BuscarLibroPorISBN(isbn string) (*Libro, error) {
+	canonico, err := ParsearISBN(isbn)
+	if err != nil {
+		return nil, err
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	libro, ok := b.libros.PorISBN(canonico)
+	if !ok {
+		return nil, nuevoError(ErrNoEncontrado, "No existe un libro con el ISBN '%s'", canonico)
+	}
+	return &libro, nil
 }
 
-// BuscarLibro busca un libro por ID
-// Usa receptor de VALOR porque solo lee y retorna una copia
-func (b Biblioteca) BuscarLibro(id int) *Libro {
-	for i, libro := range b.Libros {
-		if libro.ID == id {
-			return &b.Libros[i] // Retorna puntero al libro original
+// BuscarUsuario busca un usuario por ID y retorna una copia
+func (b *Biblioteca) BuscarUsuario(id int) *Usuario {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	usuario, ok := b.usuarios.PorID(id)
+	if !ok {
+		return nil
+	}
+	return &usuario
+}
+
+// ListarLibros retorna todos los libros del catálogo, sin los retirados
+func (b *Biblioteca) ListarLibros() []Libro {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.librosEnCatalogo()
+}
+
+// librosEnCatalogo retorna los libros no retirados sin tomar el bloqueo;
+// quien llama debe tenerlo
+func (b *Biblioteca) librosEnCatalogo() []Libro {
+	libros := make([]Libro, 0)
+	for _, libro := range b.libros.Listar() {
+		if !libro.Retirado {
+			libros = append(libros, libro)
 		}
 	}
-	return nil
+	return libros
 }
 
-// BuscarUsuario busca un usuario por ID
-// Usa receptor de VALOR porque solo lee
-func (b Biblioteca) BuscarUsuario(id int) *Usuario {
-	for i, usuario := range b.Usuarios {
-		if usuario.ID == id {
-			return &b.Usuarios[i] // Retorna puntero al usuario original
+// ListarUsuarios retorna todos los usuarios registrados
+func (b *Biblioteca) ListarUsuarios() []Usuario {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.usuarios.Listar()
+}
+
+// ListarPrestamos retorna todos los préstamos, activos y devueltos
+func (b *Biblioteca) ListarPrestamos() []Prestamo {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.prestamos.Listar()
+}
+
+// BuscarLibros retorna los libros que coinciden con todas las palabras de
+// texto en su título, autor o etiquetas, sin distinguir mayúsculas ni
+// acentos y de más a menos relevante. Ver indiceBusqueda
+func (b *Biblioteca) BuscarLibros(texto string) []Libro {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	encontrados := make([]Libro, 0)
+	for _, c := range b.busqueda.buscar(texto) {
+		if libro, ok := b.libros.PorID(c.libroID); ok && !libro.Retirado {
+			encontrados = append(encontrados, libro)
 		}
 	}
-	return nil
+	return encontrados
 }
 
-// PrestarLibro realiza el préstamo de un libro
-// Usa receptor de PUNTERO porque modifica múltiples estados
-func (b *Biblioteca) PrestarLibro(libroID, usuarioID int) error {
+// PrestarLibro presta al usuario el ejemplar que tenga apartado del libro o,
+// si no tiene ninguno, el primer ejemplar disponible. Retorna el préstamo
+// creado
+func (b *Biblioteca) PrestarLibro(actor Actor, libroID, usuarioID int) (*Prestamo, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.prestarLibro(actor, libroID, usuarioID)
+}
+
+// prestarLibro es PrestarLibro sin tomar el bloqueo
+func (b *Biblioteca) prestarLibro(actor Actor, libroID, usuarioID int) (*Prestamo, error) {
+	if err := b.autorizar(actor, PermisoCirculacion, usuarioID); err != nil {
+		return nil, err
+	}
+
+	tx := b.iniciar(actor, EventoPrestamoCreado)
+
 	//Buscar libro
-	libro := b.BuscarLibro(libroID)
-	if libro == nil {
-		return fmt.Errorf("No existe un libro con ID '%d'", libroID)
+	libro, ok := tx.libro(libroID)
+	if !ok {
+		return nil, nuevoError(ErrNoEncontrado, "No existe un libro con ID '%d'", libroID)
+	}
+	tx.vencerReservas(libroID, tx.ahora)
+
+	for _, reserva := range tx.reservasPendientes(libroID) {
+		if reserva.UsuarioID == usuarioID && reserva.Estado == ReservaApartada {
+			ejemplar, _ := tx.ejemplar(reserva.EjemplarID)
+			return b.prestar(tx, ejemplar, usuarioID)
+		}
+	}
+	for _, ejemplar := range tx.ejemplaresDe(libroID) {
+		if ejemplar.EsPrestable() {
+			return b.prestar(tx, ejemplar, usuarioID)
+		}
+	}
+	return nil, nuevoError(ErrNoPermitido, "No quedan ejemplares disponibles de '%s'; se puede reservar", libro.Titulo)
+}
+
+// PrestarEjemplar realiza el préstamo de un ejemplar concreto y retorna el
+// préstamo creado
+func (b *Biblioteca) PrestarEjemplar(actor Actor, ejemplarID, usuarioID int) (*Prestamo, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.prestarEjemplar(actor, ejemplarID, usuarioID)
+}
+
+// prestarEjemplar es PrestarEjemplar sin tomar el bloqueo
+func (b *Biblioteca) prestarEjemplar(actor Actor, ejemplarID, usuarioID int) (*Prestamo, error) {
+	if err := b.autorizar(actor, PermisoCirculacion, usuarioID); err != nil {
+		return nil, err
+	}
+
+	tx := b.iniciar(actor, EventoPrestamoCreado)
+	ejemplar, ok := tx.ejemplar(ejemplarID)
+	if !ok {
+		return nil, nuevoError(ErrNoEncontrado, "No existe un ejemplar con ID '%d'", ejemplarID)
+	}
+	tx.vencerReservas(ejemplar.LibroID, tx.ahora)
+	ejemplar, _ = tx.ejemplar(ejemplarID)
+	return b.prestar(tx, ejemplar, usuarioID)
+}
+
+// prestar presta ejemplar dentro de tx
+// Usa receptor de PUNTERO porque modifica múltiples estados: el ejemplar, el
+// préstamo y el usuario cambian juntos o no cambia ninguno
+func (b *Biblioteca) prestar(tx *transaccion, ejemplar Ejemplar, usuarioID int) (*Prestamo, error) {
+	libro, ok := tx.libro(ejemplar.LibroID)
+	if !ok {
+		return nil, nuevoError(ErrNoEncontrado, "No existe un libro con ID '%d'", ejemplar.LibroID)
 	}
 
 	// Buscar Usuario
-	usuario := b.BuscarUsuario(usuarioID)
-	if usuario == nil {
-		return fmt.Errorf("No existe un usuario con ID '%d'", usuarioID)
+	usuario, ok := tx.usuario(usuarioID)
+	if !ok {
+		return nil, nuevoError(ErrNoEncontrado, "No existe un usuario con ID '%d'", usuarioID)
 	}
 
-	// validar que el usuario pueda prestar
-	if !usuario.PuedePrestar() {
-		return fmt.Errorf("El usuario '%s' no puede prestar", usuario.Nombre)
+	// validar que la política permita el préstamo y obtener el plazo
+	dias, err := b.Politica.EvaluarPrestamo(usuario, libro)
+	if err != nil {
+		return nil, err
 	}
 
-	// validar que el libro se puede prestar
-	if !libro.EsPrestable() {
-		return fmt.Errorf("El libro '%s' no se puede prestar", libro.Titulo)
+	// un ejemplar apartado solo se presta a quien lo reservó
+	if ejemplar.Estado == EjemplarApartado {
+		reserva, ok := tx.reservaDeEjemplar(ejemplar)
+		if !ok || reserva.UsuarioID != usuarioID {
+			return nil, nuevoError(ErrNoPermitido, "El ejemplar '%s' está apartado para otro usuario", ejemplar.CodigoBarras)
+		}
+		reserva.Estado = ReservaCompletada
+		tx.guardarReserva(reserva)
 	}
 
 	// Realizar el prestamo
+	if err := ejemplar.Prestar(tx.ahora); err != nil {
+		return nil, err
+	}
 	prestamo := Prestamo{
-		ID:              b.proximoID,
-		LibroID:         libroID,
+		ID:              tx.nuevoID(),
+		LibroID:         libro.ID,
+		EjemplarID:      ejemplar.ID,
 		UsuarioID:       usuarioID,
-		FechaPrestamo:   time.Now(),
-		FechaDevolucion: time.Now().AddDate(0, 0, 14), // 14 dias
+		FechaPrestamo:   tx.ahora,
+		FechaDevolucion: b.calendarioDe(ejemplar.SucursalActual).Vencimiento(tx.ahora, dias),
 		Devuelto:        false,
+		Sucursal:        ejemplar.SucursalActual,
 	}
-	b.Prestamos = append(b.Prestamos, prestamo)
-	b.proximoID++
+	usuario.PrestamosActivos++
 
-	return nil
+	tx.guardarEjemplar(ejemplar)
+	tx.guardarPrestamo(prestamo)
+	tx.guardarUsuario(usuario)
+	if err := tx.confirmar(); err != nil {
+		return nil, err
+	}
+	return &prestamo, nil
 }
 
-// DevolverLibro procesa la devolución de un libro
-// Usa receptor de PUNTERO porque modifica estados
-func (b *Biblioteca) DevolverLibro(libroID int) error {
+// DevolverLibro procesa la devolución de un libro en la sucursal indicada y
+// retorna el préstamo cerrado. Solo sirve cuando hay un único ejemplar
+// prestado del título; si hay varios hay que indicar cuál con
+// DevolverEjemplar
+func (b *Biblioteca) DevolverLibro(actor Actor, libroID int, sucursal string) (*Prestamo, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	tx := b.iniciar(actor, EventoPrestamoDevuelto)
+
 	//Buscar libro
-	libro := b.BuscarLibro(libroID)
-	if libro == nil {
-		return fmt.Errorf("No existe un libro con ID '%d'", libroID)
+	libro, ok := tx.libro(libroID)
+	if !ok {
+		return nil, nuevoError(ErrNoEncontrado, "No existe un libro con ID '%d'", libroID)
 	}
+	tx.vencerReservas(libroID, tx.ahora)
 
-	// Buscar prestamo activo
-	var prestamoActivo *Prestamo
-	for i := range b.Prestamos {
-		if b.Prestamos[i].LibroID == libroID && !b.Prestamos[i].Devuelto {
-			prestamoActivo = &b.Prestamos[i]
-			break
+	var prestados []Ejemplar
+	for _, ejemplar := range tx.ejemplaresDe(libroID) {
+		if _, activo := tx.prestamoActivo(ejemplar.ID); activo {
+			prestados = append(prestados, ejemplar)
 		}
 	}
-	if prestamoActivo == nil {
-		return fmt.Errorf("No existe un prestamo activo para el libro '%s'", libro.Titulo)
-	}
-
-	// Realizar la devolucion
-	if err := libro.Devolver(); err != nil {
-		return err
+	switch len(prestados) {
+	case 0:
+		return nil, nuevoError(ErrNoPermitido, "No existe un prestamo activo para el libro '%s'", libro.Titulo)
+	case 1:
+		return b.devolver(tx, prestados[0], sucursal)
+	default:
+		return nil, nuevoError(ErrNoPermitido,
+			"Hay %d ejemplares prestados de '%s': indique cuál se devuelve", len(prestados), libro.Titulo)
 	}
+}
 
-	// Marcar prestamo como devuelto
-	prestamoActivo.Devuelto = true
+// DevolverEjemplar procesa la devolución de un ejemplar en la sucursal
+// indicada y retorna el préstamo cerrado
+func (b *Biblioteca) DevolverEjemplar(actor Actor, ejemplarID int, sucursal string) (*Prestamo, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
 
-	return nil
+	tx := b.iniciar(actor, EventoPrestamoDevuelto)
+	ejemplar, ok := tx.ejemplar(ejemplarID)
+	if !ok {
+		return nil, nuevoError(ErrNoEncontrado, "No existe un ejemplar con ID '%d'", ejemplarID)
+	}
+	tx.vencerReservas(ejemplar.LibroID, tx.ahora)
+	return b.devolver(tx, ejemplar, sucursal)
 }
 
-// ObtenerEstadisticas retorna estadísticas de la biblioteca
-// Usa receptor de VALOR porque solo lee información
-func (b Biblioteca) ObtenerEstadisticas() string {
-	totalLibros := len(b.Libros)
-	librosPrestados := 0
-	usuariosActivos := 0
-	prestamosActivos := 0
+// devolver cierra el préstamo activo de ejemplar dentro de tx. Si alguien
+// espera el libro, el ejemplar queda apartado para el primero de la cola
+// en la sucursal donde se devolvió; si no, vuelve a su sucursal. Con
+// sucursal vacía se devuelve donde se prestó
+// Usa receptor de PUNTERO porque modifica estados
+func (b *Biblioteca) devolver(tx *transaccion, ejemplar Ejemplar, sucursal string) (*Prestamo, error) {
+	// Buscar prestamo activo
+	prestamo, activo := tx.prestamoActivo(ejemplar.ID)
+	if !activo {
+		return nil, nuevoError(ErrNoPermitido, "No existe un prestamo activo para el ejemplar '%s'", ejemplar.CodigoBarras)
+	}
+	if err := b.autorizar(tx.actor, PermisoCirculacion, prestamo.UsuarioID); err != nil {
+		return nil, err
+	}
 
-	for _, libro := range b.Libros {
-		if libro.Prestado {
-			librosPrestados++
+	usuario, ok := tx.usuario(prestamo.UsuarioID)
+	if !ok {
+		return nil, nuevoError(ErrNoEncontrado, "No existe un usuario con ID '%d'", prestamo.UsuarioID)
+	}
+	sucursal = strings.TrimSpace(sucursal)
+	if sucursal != "" {
+		if _, ok := tx.sucursal(sucursal); !ok {
+			return nil, nuevoError(ErrNoEncontrado, "No existe una sucursal '%s'", sucursal)
 		}
+		ejemplar.SucursalActual = sucursal
 	}
 
-	for _, usuario := range b.Usuarios {
-		if usuario.Activo {
-			usuariosActivos++
-		}
+	// Realizar la devolucion
+	if err := ejemplar.Devolver(tx.ahora); err != nil {
+		return nil, err
 	}
+	prestamo.SucursalDevolucion = ejemplar.SucursalActual
 
-	for _, prestamo := range b.Prestamos {
-		if !prestamo.Devuelto {
-			prestamosActivos++
-		}
+	// Marcar prestamo como devuelto y cobrar el atraso. Si ya se cobró
+	// atraso al renovarlo, esta multa se suma a esa hasta el tope
+	multa := b.Politica.Multas.Cobrar(prestamo, b.diasAtraso(prestamo, tx.ahora))
+	prestamo.Devuelto = true
+	prestamo.FechaDevuelto = tx.ahora
+	prestamo.Multa = redondear(prestamo.Multa + multa)
+	usuario.PrestamosActivos--
+	usuario.Deuda = redondear(usuario.Deuda + multa)
+
+	tx.guardarPrestamo(prestamo)
+	tx.guardarUsuario(usuario)
+	if err := tx.asignarEjemplar(ejemplar, tx.ahora); err != nil {
+		return nil, err
 	}
-	return fmt.Sprintf(`📊 Estadísticas de %s: 
-		📚 Total de libros: %d
-		📖 Libros prestados: %d
-		📕 Libros disponibles: %d
-		👥 Usuarios activos: %d
-		📋 Préstamos activos: %d`, b.Nombre, totalLibros, librosPrestados, totalLibros-librosPrestados, usuariosActivos, prestamosActivos)
-}
-
-// ListarLibrosDisponibles muestra todos los libros disponibles
-// Usa receptor de VALOR porque solo lee
-func (b Biblioteca) ListarLibrosDisponibles() {
-	fmt.Println("📚 Libros disponibles:")
-	fmt.Println("=" + strings.Repeat("=", 50))
-
-	disponibles := 0
-	for _, libro := range b.Libros {
-		if !libro.Prestado {
-			fmt.Printf(" %s\n", libro.ObtenerInfo())
-			if libro.EsGrande() {
-				fmt.Printf("     📖 Libro extenso (%d páginas)\n",
-					libro.Paginas)
-			}
-		}
-		disponibles++
+	if err := tx.confirmar(); err != nil {
+		return nil, err
 	}
+	return &prestamo, nil
+}
+
+// ActualizarLibro actualiza título, autor y páginas de un libro del catálogo
+// Usa receptor de PUNTERO porque modifica el libro y lo registra en el diario
+func (b *Biblioteca) ActualizarLibro(actor Actor, id int, titulo, autor string, paginas int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
 
-	if disponibles == 0 {
-		fmt.Println(" No hay libros disponibles")
+	if err := b.autorizar(actor, PermisoCatalogo, 0); err != nil {
+		return err
 	}
-}
 
-// ==========================================
-// FUNCIÓN PRINCIPAL DEMOSTRATIVA
-// ==========================================
-func main() {
-	fmt.Println("🏛 SISTEMA DE BIBLIOTECA - DEMO PRÁCTICA")
-	fmt.Println("=" + strings.Repeat("=", 50))
-
-	// PASO 1: Crear biblioteca
-	biblioteca := NuevaBiblioteca("Biblioteca Central", "Av. Principal 123")
-	fmt.Printf("\n✅ Biblioteca creada: %s\n", biblioteca.Nombre)
-
-	// PASO 2: Agregar libros
-	fmt.Println("\n📚 Agregando libros...")
-
-	libros := []struct {
-		titulo, autor, isbn string
-		paginas             int
-	}{
-		{"El Quijote", "Miguel de Cervantes", "978-84-376-0494-7", 863},
-		{"Cien Años de Soledad", "Gabriel García Márquez", "978-84-376-0495-4", 471},
-		{"Go Programming", "Alan Donovan", "978-0-13-419044-0", 380},
-		{"Clean Code", "Robert Martin", "978-0-13-235088-4", 464},
-	}
-	for _, l := range libros {
-		libro, err := biblioteca.AgregarLibro(l.titulo, l.autor, l.isbn, l.paginas)
-		if err != nil {
-			fmt.Printf("❌ Error al agregar libro: %s\n", err)
-		} else {
-			fmt.Printf("✅ Agregado libro: %s\n", libro.ObtenerInfo())
-		}
+	tx := b.iniciar(actor, EventoLibroActualizado)
+	libro, ok := tx.libro(id)
+	if !ok {
+		return nuevoError(ErrNoEncontrado, "No existe un libro con ID '%d'", id)
+	}
+
+	if err := libro.ActualizarInfo(titulo, autor, paginas); err != nil {
+		return err
 	}
+	tx.guardarLibro(libro)
+	return tx.confirmar()
+}
 
-	// PASO 3: Registrar usuarios
-	fmt.Println("\n👥 Registrando usuarios...")
+// ActivarUsuario activa la cuenta de un usuario
+func (b *Biblioteca) ActivarUsuario(actor Actor, id int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
 
-	usuarios := []struct {
-		nombre, email, telefono string
-	}{
-		{"Carlos", "carlos@gmail.com", "+56 999 999 999"},
-		{"Maria", "maria@gmail.com", "+56 999 999 999"},
-		{"Juan", "juan@gmail.com", "+56 999 999 999"},
-		{"Pedro", "pedro@gmail.com", "+56 999 999 999"},
+	if err := b.autorizar(actor, PermisoUsuarios, 0); err != nil {
+		return err
 	}
 
-	for _, u := range usuarios {
-		usuario, err := biblioteca.RegistrarUsuario(u.nombre, u.email, u.telefono)
-		if err != nil {
-			fmt.Printf("❌ Error al registrar usuario: %s\n", err)
-		} else {
-			fmt.Printf("✅ Registrado usuario: %s\n", usuario.ObtenerResumen())
-		}
+	return b.modificarUsuario(b.iniciar(actor, EventoUsuarioActivado), id, func(u *Usuario) error {
+		u.Activar()
+		return nil
+	})
+}
+
+// DesactivarUsuario desactiva la cuenta de un usuario
+func (b *Biblioteca) DesactivarUsuario(actor Actor, id int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.autorizar(actor, PermisoUsuarios, 0); err != nil {
+		return err
 	}
 
-	// PASO 4: Realizar préstamos
-	fmt.Println("\n📋 Realizando préstamos...")
+	return b.modificarUsuario(b.iniciar(actor, EventoUsuarioDesactivado), id, func(u *Usuario) error {
+		u.Desactivar()
+		return nil
+	})
+}
+
+// ActualizarContactoUsuario cambia email y teléfono de un usuario
+func (b *Biblioteca) ActualizarContactoUsuario(actor Actor, id int, email, telefono string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
 
-	prestamos := []struct {
-		libroID, usuarioID int
-	}{
-		{1, 1},
-		{3, 2},
-		{2, 3},
+	if err := b.autorizar(actor, PermisoUsuarios, id); err != nil {
+		return err
 	}
 
-	for _, p := range prestamos {
-		err := biblioteca.PrestarLibro(p.libroID, p.usuarioID)
-		if err != nil {
-			fmt.Printf("❌ Error al realizar préstamo: %s\n", err)
-		} else {
-			libro := biblioteca.BuscarLibro(p.libroID)
-			usuario := biblioteca.BuscarUsuario(p.usuarioID)
-			fmt.Printf(" ✅ %s prestó '%s'\n", usuario.Nombre, libro.Titulo)
-		}
+	if otro, existe := b.usuarios.PorEmail(email); existe && otro.ID != id {
+		return nuevoError(ErrConflicto, "Ya existe un usuario con el email '%s'", email)
 	}
+	return b.modificarUsuario(b.iniciar(actor, EventoContactoActualizado), id, func(u *Usuario) error {
+		return u.ActualizarContacto(email, telefono)
+	})
+}
 
-	// PASO 5: Mostrar estado actual
-	biblioteca.ListarLibrosDisponibles()
+// modificarUsuario aplica cambio sobre una copia del usuario y la confirma
+// en tx
+func (b *Biblioteca) modificarUsuario(tx *transaccion, id int, cambio func(*Usuario) error) error {
+	usuario, ok := tx.usuario(id)
+	if !ok {
+		return nuevoError(ErrNoEncontrado, "No existe un usuario con ID '%d'", id)
+	}
+	if usuario.Cerrada {
+		return nuevoError(ErrNoPermitido, "La cuenta de '%s' está cerrada", usuario.Nombre)
+	}
 
-	// PASO 6: Devolver un libro
-	fmt.Println("\n🔄 Devolviendo libro...")
-	err := biblioteca.DevolverLibro(1) // Libro 1 - El Quijote
-	if err != nil {
-		fmt.Printf("❌ Error al devolver libro: %s\n", err)
-	} else {
-		fmt.Printf("✅ Libro devuelto\n")
+	if err := cambio(&usuario); err != nil {
+		return err
 	}
+	tx.guardarUsuario(usuario)
+	return tx.confirmar()
+}
 
-	// PASO 7: Mostrar estadísticas finales
-	fmt.Println("\n" + biblioteca.ObtenerEstadisticas())
+// Estadisticas resume el estado de la biblioteca
+type Estadisticas struct {
+	TotalLibros           int `json:"total_libros"`
+	TotalEjemplares       int `json:"total_ejemplares"`
+	EjemplaresPrestados   int `json:"ejemplares_prestados"`
+	EjemplaresDisponibles int `json:"ejemplares_disponibles"`
+	UsuariosActivos       int `json:"usuarios_activos"`
+	PrestamosActivos      int `json:"prestamos_activos"`
+	PrestamosVencidos     int `json:"prestamos_vencidos"`
+}
 
-	// PASO 8: Demostrar diferencia entre receptor de valor y puntero
-	fmt.Println("\n🔍 DEMO: Diferencia entre receptores")
-	fmt.Println("=" + strings.Repeat("=", 50))
+// Estadisticas cuenta libros, ejemplares, usuarios y préstamos
+func (b *Biblioteca) Estadisticas() Estadisticas {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.estadisticas()
+}
 
-	libro := biblioteca.BuscarLibro(4) // Clean Code
-	fmt.Printf("Estado inicial: %s\n", libro.ObtenerInfo())
+// estadisticas hace el conteo de Estadisticas sin bloquear
+func (b *Biblioteca) estadisticas() Estadisticas {
+	ejemplares := b.ejemplares.Listar()
+	e := Estadisticas{TotalLibros: len(b.librosEnCatalogo()), TotalEjemplares: len(ejemplares)}
+
+	for _, ejemplar := range ejemplares {
+		switch ejemplar.Estado {
+		case EjemplarPrestado:
+			e.EjemplaresPrestados++
+		case EjemplarDisponible:
+			e.EjemplaresDisponibles++
+		}
+	}
 
-	// Intentar prestar (modifica el struct)
-	err = libro.Prestar()
-	if err != nil {
-		fmt.Printf("❌ Error al prestar libro: %s\n", err)
-	} else {
-		fmt.Printf("Despues del prestamo: %s\n", libro.ObtenerInfo())
+	for _, usuario := range b.usuarios.Listar() {
+		if usuario.Activo {
+			e.UsuariosActivos++
+		}
+	}
+
+	ahora := b.Reloj.Ahora()
+	for _, prestamo := range b.prestamos.Listar() {
+		if !prestamo.Devuelto {
+			e.PrestamosActivos++
+		}
+		if prestamo.Vencido(ahora) {
+			e.PrestamosVencidos++
+		}
 	}
+	return e
+}
 
-	// Verificar info (no modificada)
-	fmt.Printf("¿Es prestable?: %v\n", libro.EsPrestable())
-	fmt.Printf("¿Es libro grande?: %v\n", libro.EsGrande())
-	fmt.Println("\n🎯 ¡Demo completada! Los estudiantes pueden ver:")
-	fmt.Println(" • Structs básicos y composición")
-	fmt.Println(" • Métodos con receptor de valor (lectura)")
-	fmt.Println(" • Métodos con receptor de puntero (modificación)")
-	fmt.Println(" • Validaciones y manejo de errores")
-	fmt.Println(" • Lógica de negocio completa")
+// ListarLibrosDisponibles retorna los libros con algún ejemplar disponible
+func (b *Biblioteca) ListarLibrosDisponibles() []Libro {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	libros := make([]Libro, 0)
+	for _, libro := range b.librosEnCatalogo() {
+		for _, ejemplar := range b.ejemplares.PorLibro(libro.ID) {
+			if ejemplar.Estado == EjemplarDisponible {
+				libros = append(libros, libro)
+				break
+			}
+		}
+	}
+	return libros
+}
 
+// ==========================================
+// FUNCIÓN PRINCIPAL
+// ==========================================
+// main delega en la línea de comandos (ver cli.go)
+func main() {
+	os.Exit(ejecutar(os.Args[1:], os.Stdout, os.Stderr))
 }
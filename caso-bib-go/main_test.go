@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// inicioPruebas es la hora en que arrancan los relojes de las pruebas
+var inicioPruebas = time.Date(2025, time.March, 3, 10, 0, 0, 0, time.Local)
+
+func init() {
+	// Las claves de las pruebas no necesitan resistir un ataque, y con las
+	// iteraciones de producción cada credencial tarda segundos con -race
+	iteracionesClave = 1000
+}
+
+// nuevaBibliotecaPrueba crea una biblioteca en memoria con un RelojFijo y
+// ModoEstricto, para que cada operación verifique las invariantes
+func nuevaBibliotecaPrueba(t *testing.T) (*Biblioteca, *RelojFijo) {
+	t.Helper()
+	b := NuevaBiblioteca("Biblioteca de Prueba", "Calle Falsa 123")
+	reloj := NuevoRelojFijo(inicioPruebas)
+	b.Reloj = reloj
+	b.ModoEstricto = true
+	return b, reloj
+}
+
+// agregarLibroPrueba agrega un libro con un solo ejemplar
+func agregarLibroPrueba(t *testing.T, b *Biblioteca, titulo string) *Libro {
+	t.Helper()
+	libro, err := b.AgregarLibro(Sistema, titulo, "Autor de Prueba", "", 100)
+	if err != nil {
+		t.Fatalf("AgregarLibro(%q): %v", titulo, err)
+	}
+	return libro
+}
+
+// registrarUsuarioPrueba registra un usuario estudiante
+func registrarUsuarioPrueba(t *testing.T, b *Biblioteca, nombre string) *Usuario {
+	t.Helper()
+	usuario, err := b.RegistrarUsuario(Sistema, nombre, nombre+"@prueba.org", "", CategoriaEstudiante)
+	if err != nil {
+		t.Fatalf("RegistrarUsuario(%q): %v", nombre, err)
+	}
+	return usuario
+}
+
+// verificar falla la prueba si la biblioteca rompe alguna invariante
+func verificar(t *testing.T, b *Biblioteca) {
+	t.Helper()
+	if err := b.VerificarInvariantes(); err != nil {
+		t.Fatalf("invariantes rotas: %v", err)
+	}
+}
+
+// prestamosActivos cuenta los préstamos no devueltos de un libro
+func prestamosActivos(b *Biblioteca, libroID int) int {
+	activos := 0
+	for _, p := range b.ListarPrestamos() {
+		if p.LibroID == libroID && !p.Devuelto {
+			activos++
+		}
+	}
+	return activos
+}
@@ -0,0 +1,482 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ==========================================
+// MARC21
+// ==========================================
+// Los proveedores mandan sus lotes en MARC21, en ISO 2709 (binario, archivos
+// .mrc) o en MARCXML. Un registro es una cabecera de 24 posiciones y una
+// lista de campos identificados por una etiqueta de tres dígitos; los campos
+// de control (001 a 009) tienen un valor, y los de datos dos indicadores y
+// subcampos ($a, $b...). Del registro se usan:
+//
+//	001    número de control (al exportar, el ID del libro)
+//	020 $a ISBN, seguido a veces de una aclaración: "9780134190440 (rústica)"
+//	100 $a autor; si falta, 110 $a (entidad) o el primer 700 $a
+//	245 $a título, y $b el subtítulo
+//	300 $a descripción física: "xii, 350 p. : il."
+//	852    ubicación de un ejemplar: $a biblioteca, $c ubicación, $p código
+//
+// Al leer se quita la puntuación ISBD con que los catalogadores terminan
+// cada subcampo ("Cien años de soledad /"). Solo se aceptan registros en
+// UTF-8; los que declaran MARC-8 se leen si son ASCII.
+
+const (
+	finCampoMARC    = 0x1E
+	finRegistroMARC = 0x1D
+	subcampoMARCSep = 0x1F
+	largoCabecera   = 24
+	largoDirectorio = 12
+)
+
+// registroMARC es un registro MARC21 en memoria
+type registroMARC struct {
+	cabecera string
+	campos   []campoMARC
+}
+
+// campoMARC es un campo de control (valor) o de datos (indicadores y
+// subcampos)
+type campoMARC struct {
+	etiqueta   string
+	valor      string
+	ind1, ind2 byte
+	subcampos  []subcampoMARC
+}
+
+type subcampoMARC struct {
+	codigo byte
+	valor  string
+}
+
+func (c campoMARC) esControl() bool {
+	return c.etiqueta < "010"
+}
+
+// subcampo retorna el primer subcampo con ese código
+func (c campoMARC) subcampo(codigo byte) string {
+	for _, s := range c.subcampos {
+		if s.codigo == codigo {
+			return s.valor
+		}
+	}
+	return ""
+}
+
+// campo retorna el primer campo con esa etiqueta
+func (r registroMARC) campo(etiqueta string) (campoMARC, bool) {
+	for _, c := range r.campos {
+		if c.etiqueta == etiqueta {
+			return c, true
+		}
+	}
+	return campoMARC{}, false
+}
+
+// campoDatos arma un campo de datos con los subcampos que tienen valor
+func campoDatos(etiqueta string, ind1, ind2 byte, subcampos ...subcampoMARC) campoMARC {
+	campo := campoMARC{etiqueta: etiqueta, ind1: ind1, ind2: ind2}
+	for _, s := range subcampos {
+		if s.valor != "" {
+			campo.subcampos = append(campo.subcampos, s)
+		}
+	}
+	return campo
+}
+
+// registroDeLibro arma el registro bibliográfico de un libro
+func registroDeLibro(libro Libro) registroMARC {
+	// Cabecera: registro nuevo (n) de un libro (a) monográfico (m) en
+	// UTF-8 (a); largo y base de datos se completan al codificar
+	r := registroMARC{cabecera: "00000nam a2200000   4500"}
+	r.campos = append(r.campos, campoMARC{etiqueta: "001", valor: strconv.Itoa(libro.ID)})
+	if libro.ISBN != "" {
+		r.campos = append(r.campos, campoDatos("020", ' ', ' ', subcampoMARC{'a', libro.ISBN.ISBN13()}))
+	}
+	indicador := byte('0')
+	if libro.Autor != "" {
+		r.campos = append(r.campos, campoDatos("100", '1', ' ', subcampoMARC{'a', libro.Autor}))
+		indicador = '1'
+	}
+	r.campos = append(r.campos, campoDatos("245", indicador, '0', subcampoMARC{'a', libro.Titulo}))
+	if extension := extensionDePaginas(libro.Paginas); extension != "" {
+		r.campos = append(r.campos, campoDatos("300", ' ', ' ', subcampoMARC{'a', extension}))
+	}
+	return r
+}
+
+// libroDeRegistro extrae los datos del libro de un registro
+func libroDeRegistro(r registroMARC) Libro {
+	var libro Libro
+	if c, ok := r.campo("245"); ok {
+		libro.Titulo = sinPuntuacionISBD(c.subcampo('a'))
+		if subtitulo := sinPuntuacionISBD(c.subcampo('b')); subtitulo != "" {
+			libro.Titulo += ": " + subtitulo
+		}
+	}
+	for _, etiqueta := range []string{"100", "110", "700"} {
+		if c, ok := r.campo(etiqueta); ok && c.subcampo('a') != "" {
+			libro.Autor = sinPuntuacionISBD(c.subcampo('a'))
+			break
+		}
+	}
+	// Puede haber un 020 por encuadernación; sirve el primero válido
+	for _, c := range r.campos {
+		if c.etiqueta != "020" {
+			continue
+		}
+		valor, _, _ := strings.Cut(strings.TrimSpace(c.subcampo('a')), " ")
+		if valor == "" {
+			continue
+		}
+		if libro.ISBN == "" {
+			libro.ISBN = ISBN(valor)
+		}
+		if _, err := ParsearISBN(valor); err == nil {
+			libro.ISBN = ISBN(valor)
+			break
+		}
+	}
+	if c, ok := r.campo("300"); ok {
+		libro.Paginas = paginasDeExtension(c.subcampo('a'))
+	}
+	return libro
+}
+
+// sinPuntuacionISBD quita la puntuación y los espacios con que termina un
+// subcampo. El punto final se conserva si cierra una inicial ("Tolkien, J.
+// R. R.")
+func sinPuntuacionISBD(valor string) string {
+	valor = strings.TrimRight(strings.TrimSpace(valor), " /:;,=")
+	if strings.HasSuffix(valor, ".") {
+		palabras := strings.Fields(valor)
+		ultima := palabras[len(palabras)-1]
+		if utf8.RuneCountInString(ultima) > 2 {
+			valor = strings.TrimSuffix(valor, ".")
+		}
+	}
+	return strings.TrimSpace(valor)
+}
+
+// ==========================================
+// ISO 2709
+// ==========================================
+
+// LectorMARC lee registros MARC21 en ISO 2709
+type LectorMARC struct {
+	r *bufio.Reader
+}
+
+func NuevoLectorMARC(r io.Reader) *LectorMARC {
+	return &LectorMARC{r: bufio.NewReader(r)}
+}
+
+// Leer retorna el libro del próximo registro. Un registro dañado se saltea
+// hasta su terminador
+func (l *LectorMARC) Leer() (Libro, error) {
+	datos, err := l.r.ReadBytes(finRegistroMARC)
+	// Algunos proveedores separan los registros con saltos de línea
+	datos = bytes.TrimLeft(datos, " \r\n")
+	if errors.Is(err, io.EOF) {
+		if len(bytes.TrimSpace(datos)) == 0 {
+			return Libro{}, io.EOF
+		}
+		return Libro{}, nuevoError(ErrDatosInvalidos, "Registro MARC incompleto: falta el terminador")
+	}
+	if err != nil {
+		return Libro{}, err
+	}
+	registro, err := parsearRegistroMARC(datos)
+	if err != nil {
+		return Libro{}, err
+	}
+	return libroDeRegistro(registro), nil
+}
+
+// parsearRegistroMARC interpreta un registro completo, con su terminador
+func parsearRegistroMARC(datos []byte) (registroMARC, error) {
+	if len(datos) < largoCabecera+1 {
+		return registroMARC{}, nuevoError(ErrDatosInvalidos, "Registro MARC demasiado corto (%d bytes)", len(datos))
+	}
+	cabecera := string(datos[:largoCabecera])
+	base, err := strconv.Atoi(cabecera[12:17])
+	if err != nil || base <= largoCabecera || base > len(datos) {
+		return registroMARC{}, nuevoError(ErrDatosInvalidos, "Registro MARC con dirección base no válida '%s'", cabecera[12:17])
+	}
+	if cabecera[9] != 'a' && !esASCII(datos) {
+		return registroMARC{}, nuevoError(ErrDatosInvalidos, "Registro MARC en MARC-8: solo se acepta UTF-8")
+	}
+
+	directorio := datos[largoCabecera : base-1]
+	if len(directorio)%largoDirectorio != 0 {
+		return registroMARC{}, nuevoError(ErrDatosInvalidos, "Directorio MARC no válido")
+	}
+	registro := registroMARC{cabecera: cabecera}
+	for i := 0; i < len(directorio); i += largoDirectorio {
+		entrada := string(directorio[i : i+largoDirectorio])
+		largo, err1 := strconv.Atoi(entrada[3:7])
+		inicio, err2 := strconv.Atoi(entrada[7:12])
+		if err1 != nil || err2 != nil || base+inicio+largo > len(datos) {
+			return registroMARC{}, nuevoError(ErrDatosInvalidos, "Entrada de directorio MARC no válida '%s'", entrada)
+		}
+		valor := datos[base+inicio : base+inicio+largo]
+		campo, err := parsearCampoMARC(entrada[:3], bytes.TrimSuffix(valor, []byte{finCampoMARC}))
+		if err != nil {
+			return registroMARC{}, err
+		}
+		registro.campos = append(registro.campos, campo)
+	}
+	return registro, nil
+}
+
+func parsearCampoMARC(etiqueta string, valor []byte) (campoMARC, error) {
+	if !utf8.Valid(valor) {
+		return campoMARC{}, nuevoError(ErrDatosInvalidos, "Campo MARC %s no es UTF-8 válido", etiqueta)
+	}
+	campo := campoMARC{etiqueta: etiqueta}
+	if campo.esControl() {
+		campo.valor = string(valor)
+		return campo, nil
+	}
+	if len(valor) < 2 {
+		return campoMARC{}, nuevoError(ErrDatosInvalidos, "Campo MARC %s sin indicadores", etiqueta)
+	}
+	campo.ind1, campo.ind2 = valor[0], valor[1]
+	for _, parte := range bytes.Split(valor[2:], []byte{subcampoMARCSep})[1:] {
+		if len(parte) == 0 {
+			continue
+		}
+		campo.subcampos = append(campo.subcampos, subcampoMARC{parte[0], string(parte[1:])})
+	}
+	return campo, nil
+}
+
+func esASCII(datos []byte) bool {
+	for _, c := range datos {
+		if c >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// CodificarMARC escribe libros como registros MARC21 en ISO 2709
+func CodificarMARC(w io.Writer, libros []Libro) error {
+	registros := make([]registroMARC, 0, len(libros))
+	for _, libro := range libros {
+		registros = append(registros, registroDeLibro(libro))
+	}
+	return escribirMARC(w, registros)
+}
+
+func escribirMARC(w io.Writer, registros []registroMARC) error {
+	bw := bufio.NewWriter(w)
+	for _, r := range registros {
+		datos, err := codificarRegistroMARC(r)
+		if err != nil {
+			return err
+		}
+		bw.Write(datos)
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("No se pudo escribir el MARC: %w", err)
+	}
+	return nil
+}
+
+// codificarRegistroMARC arma la cabecera, el directorio y los campos. Los
+// largos de ISO 2709 limitan un campo a 9999 bytes y el registro a 99999
+func codificarRegistroMARC(r registroMARC) ([]byte, error) {
+	var directorio, campos bytes.Buffer
+	for _, c := range r.campos {
+		inicio := campos.Len()
+		if c.esControl() {
+			campos.WriteString(c.valor)
+		} else {
+			campos.WriteByte(c.ind1)
+			campos.WriteByte(c.ind2)
+			for _, s := range c.subcampos {
+				campos.WriteByte(subcampoMARCSep)
+				campos.WriteByte(s.codigo)
+				campos.WriteString(s.valor)
+			}
+		}
+		campos.WriteByte(finCampoMARC)
+		largo := campos.Len() - inicio
+		if largo > 9999 {
+			return nil, nuevoError(ErrDatosInvalidos, "El campo MARC %s supera los 9999 bytes", c.etiqueta)
+		}
+		fmt.Fprintf(&directorio, "%s%04d%05d", c.etiqueta, largo, inicio)
+	}
+	directorio.WriteByte(finCampoMARC)
+
+	base := largoCabecera + directorio.Len()
+	total := base + campos.Len() + 1
+	if total > 99999 {
+		return nil, nuevoError(ErrDatosInvalidos, "El registro MARC supera los 99999 bytes")
+	}
+	cabecera := fmt.Sprintf("%05d%s%05d%s", total, r.cabecera[5:12], base, r.cabecera[17:])
+
+	datos := make([]byte, 0, total)
+	datos = append(datos, cabecera...)
+	datos = append(datos, directorio.Bytes()...)
+	datos = append(datos, campos.Bytes()...)
+	return append(datos, finRegistroMARC), nil
+}
+
+// ==========================================
+// MARCXML
+// ==========================================
+
+const espacioMARCXML = "http://www.loc.gov/MARC21/slim"
+
+type registroMARCXML struct {
+	Cabecera string            `xml:"leader"`
+	Control  []campoControlXML `xml:"controlfield"`
+	Datos    []campoDatosXML   `xml:"datafield"`
+}
+
+type campoControlXML struct {
+	Etiqueta string `xml:"tag,attr"`
+	Valor    string `xml:",chardata"`
+}
+
+type campoDatosXML struct {
+	Etiqueta  string        `xml:"tag,attr"`
+	Ind1      string        `xml:"ind1,attr"`
+	Ind2      string        `xml:"ind2,attr"`
+	Subcampos []subcampoXML `xml:"subfield"`
+}
+
+type subcampoXML struct {
+	Codigo string `xml:"code,attr"`
+	Valor  string `xml:",chardata"`
+}
+
+// LectorMARCXML lee los elementos record de un documento MARCXML, estén en
+// una collection o sueltos
+type LectorMARCXML struct {
+	d    *xml.Decoder
+	roto bool
+}
+
+func NuevoLectorMARCXML(r io.Reader) *LectorMARCXML {
+	return &LectorMARCXML{d: xml.NewDecoder(r)}
+}
+
+// Leer retorna el libro del próximo record. Si el XML está mal formado el
+// resto del documento no se puede leer: Leer retorna el error una vez y
+// después io.EOF
+func (l *LectorMARCXML) Leer() (Libro, error) {
+	if l.roto {
+		return Libro{}, io.EOF
+	}
+	for {
+		token, err := l.d.Token()
+		if errors.Is(err, io.EOF) {
+			return Libro{}, io.EOF
+		}
+		if err != nil {
+			return Libro{}, l.romper(err)
+		}
+		inicio, ok := token.(xml.StartElement)
+		if !ok || inicio.Name.Local != "record" {
+			continue
+		}
+		var registro registroMARCXML
+		if err := l.d.DecodeElement(&registro, &inicio); err != nil {
+			return Libro{}, l.romper(err)
+		}
+		return libroDeRegistro(registro.aRegistro()), nil
+	}
+}
+
+func (l *LectorMARCXML) romper(err error) error {
+	var sintaxis *xml.SyntaxError
+	if !errors.As(err, &sintaxis) {
+		return err
+	}
+	l.roto = true
+	return nuevoError(ErrDatosInvalidos, "MARCXML mal formado en la línea %d: %s", sintaxis.Line, sintaxis.Msg)
+}
+
+func (x registroMARCXML) aRegistro() registroMARC {
+	r := registroMARC{cabecera: x.Cabecera}
+	for _, c := range x.Control {
+		r.campos = append(r.campos, campoMARC{etiqueta: c.Etiqueta, valor: c.Valor})
+	}
+	for _, c := range x.Datos {
+		campo := campoMARC{etiqueta: c.Etiqueta, ind1: indicadorXML(c.Ind1), ind2: indicadorXML(c.Ind2)}
+		for _, s := range c.Subcampos {
+			if s.Codigo != "" {
+				campo.subcampos = append(campo.subcampos, subcampoMARC{s.Codigo[0], s.Valor})
+			}
+		}
+		r.campos = append(r.campos, campo)
+	}
+	return r
+}
+
+func indicadorXML(valor string) byte {
+	if valor == "" {
+		return ' '
+	}
+	return valor[0]
+}
+
+func registroXML(r registroMARC) registroMARCXML {
+	x := registroMARCXML{Cabecera: r.cabecera}
+	for _, c := range r.campos {
+		if c.esControl() {
+			x.Control = append(x.Control, campoControlXML{c.etiqueta, c.valor})
+			continue
+		}
+		campo := campoDatosXML{Etiqueta: c.etiqueta, Ind1: string(c.ind1), Ind2: string(c.ind2)}
+		for _, s := range c.subcampos {
+			campo.Subcampos = append(campo.Subcampos, subcampoXML{string(s.codigo), s.valor})
+		}
+		x.Datos = append(x.Datos, campo)
+	}
+	return x
+}
+
+// CodificarMARCXML escribe libros como una collection MARCXML
+func CodificarMARCXML(w io.Writer, libros []Libro) error {
+	registros := make([]registroMARC, 0, len(libros))
+	for _, libro := range libros {
+		registros = append(registros, registroDeLibro(libro))
+	}
+	return escribirMARCXML(w, registros)
+}
+
+func escribirMARCXML(w io.Writer, registros []registroMARC) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(xml.Header)
+	bw.WriteString(`<collection xmlns="` + espacioMARCXML + `">` + "\n")
+	encoder := xml.NewEncoder(bw)
+	encoder.Indent("  ", "  ")
+	for _, r := range registros {
+		if err := encoder.EncodeElement(registroXML(r), xml.StartElement{Name: xml.Name{Local: "record"}}); err != nil {
+			return fmt.Errorf("No se pudo escribir el MARCXML: %w", err)
+		}
+	}
+	if err := encoder.Flush(); err != nil {
+		return fmt.Errorf("No se pudo escribir el MARCXML: %w", err)
+	}
+	bw.WriteString("\n</collection>\n")
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("No se pudo escribir el MARCXML: %w", err)
+	}
+	return nil
+}
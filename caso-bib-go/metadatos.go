@@ -0,0 +1,362 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ==========================================
+// METADATOS EXTERNOS
+// ==========================================
+// ServicioMetadatos propone la ficha de un libro a partir de su ISBN
+// consultando un servicio con el formato de la API de libros de Open
+// Library:
+//
+//	GET {URL}/api/books?bibkeys=ISBN:9780140328721&format=json&jscmd=data
+//
+// que responde un objeto con una clave "ISBN:..." por libro encontrado
+// (vacío si no conoce el ISBN), con title, subtitle, authors[].name,
+// number_of_pages, publishers[].name y cover.large/medium/small.
+//
+// Las respuestas se guardan en una caché que vale Vigencia y se conserva en
+// Archivo. Que el servicio no conozca un ISBN también se guarda, pero vale
+// solo VigenciaDesconocidos: el libro puede cargarse en el servicio al día
+// siguiente. Si el servicio no responde a tiempo o falla, también con un
+// 404, se usa lo que haya en la caché aunque esté vencido; con URL vacía no
+// se consulta nada y solo se usa la caché. URL puede apuntar a
+// un servidor local que imite el formato, por ejemplo uno de httptest.
+
+// URLOpenLibrary es el servicio que se usa si no se configura otro
+const URLOpenLibrary = "https://openlibrary.org"
+
+// extensionMetadatos se agrega a la ruta del snapshot para obtener la de la
+// caché de metadatos
+const extensionMetadatos = ".metadatos"
+
+const (
+	// esperaMetadatos es cuánto se espera al servicio antes de darlo por
+	// caído
+	esperaMetadatos = 5 * time.Second
+	// vigenciaMetadatos es cuánto vale una respuesta guardada en la caché
+	vigenciaMetadatos = 30 * 24 * time.Hour
+	// vigenciaDesconocidos es cuánto vale en la caché que el servicio no
+	// conozca un ISBN
+	vigenciaDesconocidos = 24 * time.Hour
+	// limiteMetadatos es el tamaño máximo aceptado de una respuesta
+	limiteMetadatos = 1 << 20
+)
+
+// OrigenPropuesta dice de dónde salió una propuesta
+type OrigenPropuesta string
+
+const (
+	OrigenServicio OrigenPropuesta = "servicio"
+	OrigenCache    OrigenPropuesta = "cache"
+)
+
+// PropuestaLibro son los datos de un libro según el servicio de metadatos.
+// Es solo una propuesta: el catalogador decide qué usar
+type PropuestaLibro struct {
+	ISBN      ISBN     `json:"isbn"`
+	Titulo    string   `json:"titulo"`
+	Autores   []string `json:"autores"`
+	Paginas   int      `json:"paginas,omitempty"`
+	Editorial string   `json:"editorial,omitempty"`
+	Portada   string   `json:"portada,omitempty"` // URL de la imagen de tapa
+	// Origen es OrigenCache si no se consultó el servicio; Vencida indica
+	// que el servicio falló y la caché ya había pasado su vigencia
+	Origen     OrigenPropuesta `json:"origen"`
+	Vencida    bool            `json:"vencida,omitempty"`
+	Consultada time.Time       `json:"consultada"`
+}
+
+// Autor retorna los autores como se guardan en Libro.Autor
+func (p *PropuestaLibro) Autor() string {
+	return strings.Join(p.Autores, "; ")
+}
+
+// Completar llena con la propuesta los datos que faltan: título y autor
+// vacíos y páginas en cero
+func (p *PropuestaLibro) Completar(titulo, autor string, paginas int) (string, string, int) {
+	if titulo == "" {
+		titulo = p.Titulo
+	}
+	if autor == "" {
+		autor = p.Autor()
+	}
+	if paginas == 0 {
+		paginas = p.Paginas
+	}
+	return titulo, autor, paginas
+}
+
+// ServicioMetadatos consulta el servicio de metadatos y guarda sus
+// respuestas. Se puede usar desde varias goroutines
+type ServicioMetadatos struct {
+	// URL es la base del servicio; vacía trabaja sin conexión
+	URL string
+	// Archivo conserva la caché entre ejecuciones; vacío la deja en memoria
+	Archivo  string
+	Cliente  *http.Client
+	Vigencia time.Duration
+	// VigenciaDesconocidos es la vigencia de los ISBN que el servicio no
+	// conoce
+	VigenciaDesconocidos time.Duration
+	Reloj                Reloj
+
+	mu      sync.Mutex
+	cache   map[ISBN]entradaMetadatos
+	cargada bool
+}
+
+// entradaMetadatos es una respuesta guardada; Propuesta nil significa que
+// el servicio no conoce el ISBN
+type entradaMetadatos struct {
+	Propuesta  *PropuestaLibro `json:"propuesta"`
+	Consultada time.Time       `json:"consultada"`
+}
+
+// NuevoServicioMetadatos crea un servicio para url con la caché en archivo
+// y la espera y vigencia por defecto
+func NuevoServicioMetadatos(url, archivo string) *ServicioMetadatos {
+	return &ServicioMetadatos{
+		URL:                  strings.TrimRight(url, "/"),
+		Archivo:              archivo,
+		Cliente:              &http.Client{Timeout: esperaMetadatos},
+		Vigencia:             vigenciaMetadatos,
+		VigenciaDesconocidos: vigenciaDesconocidos,
+		Reloj:                RelojSistema{},
+	}
+}
+
+// Buscar propone los datos del libro con el ISBN dado, en cualquier forma
+// que acepte ParsearISBN. Retorna ErrNoEncontrado si el servicio no conoce
+// el ISBN y ErrSinServicio si no responde y la caché no tiene nada
+func (s *ServicioMetadatos) Buscar(ctx context.Context, texto string) (*PropuestaLibro, error) {
+	isbn, err := ParsearISBN(texto)
+	if err != nil {
+		return nil, err
+	}
+
+	guardada, hay, err := s.buscarEnCache(isbn)
+	if err != nil {
+		return nil, err
+	}
+	ahora := s.Reloj.Ahora()
+	if hay && ahora.Sub(guardada.Consultada) < guardada.vigencia(s) {
+		return guardada.resultado(isbn, false)
+	}
+
+	propuesta, err := s.consultar(ctx, isbn)
+	if err != nil {
+		if hay {
+			if s.URL != "" {
+				log.Printf("servicio de metadatos: %v; se usa la caché", err)
+			}
+			return guardada.resultado(isbn, true)
+		}
+		return nil, nuevoError(ErrSinServicio, "No se pudieron consultar los metadatos del ISBN '%s': %v", isbn, err)
+	}
+	if propuesta == nil {
+		s.guardarEnCache(isbn, entradaMetadatos{Consultada: ahora})
+		return nil, nuevoError(ErrNoEncontrado, "El servicio de metadatos no conoce el ISBN '%s'", isbn)
+	}
+	propuesta.Origen = OrigenServicio
+	propuesta.Consultada = ahora
+	s.guardarEnCache(isbn, entradaMetadatos{Propuesta: propuesta.copiar(), Consultada: ahora})
+	return propuesta, nil
+}
+
+// vigencia es cuánto vale la entrada según conozca o no el ISBN
+func (e entradaMetadatos) vigencia(s *ServicioMetadatos) time.Duration {
+	if e.Propuesta == nil {
+		return s.VigenciaDesconocidos
+	}
+	return s.Vigencia
+}
+
+// resultado es lo que Buscar retorna para una entrada de la caché
+func (e entradaMetadatos) resultado(isbn ISBN, vencida bool) (*PropuestaLibro, error) {
+	if e.Propuesta == nil {
+		return nil, nuevoError(ErrNoEncontrado, "El servicio de metadatos no conoce el ISBN '%s'", isbn)
+	}
+	propuesta := e.Propuesta.copiar()
+	propuesta.Origen = OrigenCache
+	propuesta.Vencida = vencida
+	return propuesta, nil
+}
+
+// copiar retorna una copia que no comparte los autores
+func (p *PropuestaLibro) copiar() *PropuestaLibro {
+	copia := *p
+	copia.Autores = append([]string(nil), p.Autores...)
+	return &copia
+}
+
+// errSinConexion es el fallo de consultar sin URL configurada
+var errSinConexion = errors.New("trabajando sin conexión")
+
+// consultar pide el ISBN al servicio; retorna nil sin error si no lo conoce.
+// Open Library responde a un ISBN desconocido con 200 y un objeto sin la
+// clave; un 404 es que la URL no es la de un servicio así, y es un fallo
+func (s *ServicioMetadatos) consultar(ctx context.Context, isbn ISBN) (*PropuestaLibro, error) {
+	if s.URL == "" {
+		return nil, errSinConexion
+	}
+	clave := "ISBN:" + isbn.ISBN13()
+	consulta := url.Values{"bibkeys": {clave}, "format": {"json"}, "jscmd": {"data"}}
+	peticion, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL+"/api/books?"+consulta.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	peticion.Header.Set("Accept", "application/json")
+	respuesta, err := s.Cliente.Do(peticion)
+	if err != nil {
+		return nil, err
+	}
+	defer respuesta.Body.Close()
+	if respuesta.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("el servicio respondió %s", respuesta.Status)
+	}
+
+	var libros map[string]libroOpenLibrary
+	if err := json.NewDecoder(io.LimitReader(respuesta.Body, limiteMetadatos)).Decode(&libros); err != nil {
+		return nil, fmt.Errorf("respuesta no válida: %w", err)
+	}
+	libro, ok := libros[clave]
+	if !ok {
+		return nil, nil
+	}
+	return libro.propuesta(isbn), nil
+}
+
+// libroOpenLibrary es un libro de la respuesta con jscmd=data
+type libroOpenLibrary struct {
+	Title         string `json:"title"`
+	Subtitle      string `json:"subtitle"`
+	NumberOfPages int    `json:"number_of_pages"`
+	Pagination    string `json:"pagination"`
+	Authors       []struct {
+		Name string `json:"name"`
+	} `json:"authors"`
+	Publishers []struct {
+		Name string `json:"name"`
+	} `json:"publishers"`
+	Cover struct {
+		Small  string `json:"small"`
+		Medium string `json:"medium"`
+		Large  string `json:"large"`
+	} `json:"cover"`
+}
+
+func (l libroOpenLibrary) propuesta(isbn ISBN) *PropuestaLibro {
+	p := &PropuestaLibro{ISBN: isbn, Titulo: strings.TrimSpace(l.Title), Paginas: l.NumberOfPages}
+	if subtitulo := strings.TrimSpace(l.Subtitle); subtitulo != "" {
+		p.Titulo += ": " + subtitulo
+	}
+	for _, autor := range l.Authors {
+		if nombre := strings.TrimSpace(autor.Name); nombre != "" {
+			p.Autores = append(p.Autores, nombre)
+		}
+	}
+	if len(l.Publishers) > 0 {
+		p.Editorial = strings.TrimSpace(l.Publishers[0].Name)
+	}
+	if p.Paginas == 0 {
+		// pagination es texto libre como "xii, 350 p."; se toma el último número
+		campos := strings.FieldsFunc(l.Pagination, func(r rune) bool { return r < '0' || r > '9' })
+		if len(campos) > 0 {
+			p.Paginas, _ = strconv.Atoi(campos[len(campos)-1])
+		}
+	}
+	for _, portada := range []string{l.Cover.Large, l.Cover.Medium, l.Cover.Small} {
+		if portada != "" {
+			p.Portada = portada
+			break
+		}
+	}
+	return p
+}
+
+// buscarEnCache retorna la entrada guardada del ISBN, leyendo Archivo la
+// primera vez
+func (s *ServicioMetadatos) buscarEnCache(isbn ISBN) (entradaMetadatos, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.cargada {
+		s.cache = make(map[ISBN]entradaMetadatos)
+		if s.Archivo != "" {
+			datos, err := os.ReadFile(s.Archivo)
+			switch {
+			case errors.Is(err, os.ErrNotExist):
+			case err != nil:
+				return entradaMetadatos{}, false, fmt.Errorf("No se pudo leer la caché de metadatos: %w", err)
+			default:
+				if err := json.Unmarshal(datos, &s.cache); err != nil {
+					return entradaMetadatos{}, false, fmt.Errorf("Caché de metadatos '%s' no válida: %w", s.Archivo, err)
+				}
+			}
+		}
+		s.cargada = true
+	}
+	entrada, hay := s.cache[isbn]
+	return entrada, hay, nil
+}
+
+// guardarEnCache agrega la entrada y reescribe Archivo. Si no se puede
+// escribir la respuesta igual sirve, así que solo queda en el log
+func (s *ServicioMetadatos) guardarEnCache(isbn ISBN, entrada entradaMetadatos) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cache[isbn] = entrada
+	if s.Archivo == "" {
+		return
+	}
+	if err := s.escribirCache(); err != nil {
+		log.Printf("no se pudo guardar la caché de metadatos: %v", err)
+	}
+}
+
+// escribirCache reemplaza Archivo por la caché actual
+func (s *ServicioMetadatos) escribirCache() error {
+	datos, err := json.MarshalIndent(s.cache, "", "  ")
+	if err != nil {
+		return err
+	}
+	temporal, err := os.CreateTemp(filepath.Dir(s.Archivo), filepath.Base(s.Archivo)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temporal.Name())
+	if _, err := temporal.Write(datos); err != nil {
+		temporal.Close()
+		return err
+	}
+	if err := temporal.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temporal.Name(), s.Archivo)
+}
+
+// ProponerLibro consulta Metadatos por el ISBN. Pide permiso de catálogo
+func (b *Biblioteca) ProponerLibro(ctx context.Context, actor Actor, isbn string) (*PropuestaLibro, error) {
+	if err := b.Autorizar(actor, PermisoCatalogo, 0); err != nil {
+		return nil, err
+	}
+	if b.Metadatos == nil {
+		return nil, nuevoError(ErrSinServicio, "No hay un servicio de metadatos configurado")
+	}
+	return b.Metadatos.Buscar(ctx, isbn)
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const respuestaOpenLibrary = `{"ISBN:9780140328721": {
+	"title": "Fantastic Mr Fox",
+	"authors": [{"url": "https://openlibrary.org/authors/OL34184A", "name": "Roald Dahl"}],
+	"number_of_pages": 96,
+	"publishers": [{"name": "Puffin"}],
+	"cover": {"small": "https://covers.openlibrary.org/b/id/8739161-S.jpg",
+		"large": "https://covers.openlibrary.org/b/id/8739161-L.jpg"}
+}}`
+
+// servidorMetadatos imita el servicio: responde el libro de
+// respuestaOpenLibrary, o lo que diga modo, y cuenta las peticiones
+type servidorMetadatos struct {
+	*httptest.Server
+	peticiones atomic.Int32
+	modo       atomic.Value // "", "404", "lento" o "corte"
+}
+
+func nuevoServidorMetadatos(t *testing.T) *servidorMetadatos {
+	s := &servidorMetadatos{}
+	s.modo.Store("")
+	lento := make(chan struct{})
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.peticiones.Add(1)
+		if r.URL.Path != "/api/books" || r.URL.Query().Get("format") != "json" || r.URL.Query().Get("jscmd") != "data" {
+			t.Errorf("petición inesperada %s", r.URL)
+		}
+		switch s.modo.Load() {
+		case "404":
+			http.NotFound(w, r)
+			return
+		case "lento":
+			select {
+			case <-lento:
+			case <-r.Context().Done():
+			}
+			return
+		case "corte":
+			conexion, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Errorf("Hijack: %v", err)
+				return
+			}
+			conexion.Close()
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("bibkeys") == "ISBN:9780140328721" {
+			w.Write([]byte(respuestaOpenLibrary))
+		} else {
+			w.Write([]byte("{}"))
+		}
+	}))
+	t.Cleanup(func() {
+		close(lento)
+		s.Close()
+	})
+	return s
+}
+
+func nuevoServicioPrueba(t *testing.T, url string) (*ServicioMetadatos, *RelojFijo) {
+	servicio := NuevoServicioMetadatos(url, filepath.Join(t.TempDir(), "b.json"+extensionMetadatos))
+	servicio.Cliente.Timeout = 200 * time.Millisecond
+	reloj := NuevoRelojFijo(inicioPruebas)
+	servicio.Reloj = reloj
+	return servicio, reloj
+}
+
+func TestMetadatosPropuestaYCache(t *testing.T) {
+	servidor := nuevoServidorMetadatos(t)
+	servicio, reloj := nuevoServicioPrueba(t, servidor.URL)
+	ctx := context.Background()
+
+	propuesta, err := servicio.Buscar(ctx, "0-14-032872-6")
+	if err != nil {
+		t.Fatalf("Buscar: %v", err)
+	}
+	if propuesta.Titulo != "Fantastic Mr Fox" || propuesta.Autor() != "Roald Dahl" || propuesta.Paginas != 96 ||
+		propuesta.Editorial != "Puffin" || propuesta.Portada != "https://covers.openlibrary.org/b/id/8739161-L.jpg" {
+		t.Errorf("propuesta %+v", propuesta)
+	}
+	if propuesta.ISBN != "9780140328721" || propuesta.Origen != OrigenServicio {
+		t.Errorf("ISBN %q y origen %q", propuesta.ISBN, propuesta.Origen)
+	}
+
+	// Dentro de la vigencia responde la caché sin consultar
+	reloj.Avanzar(24 * time.Hour)
+	propuesta, err = servicio.Buscar(ctx, "978-0-14-032872-1")
+	if err != nil {
+		t.Fatalf("Buscar desde la caché: %v", err)
+	}
+	if propuesta.Origen != OrigenCache || propuesta.Vencida || propuesta.Titulo != "Fantastic Mr Fox" {
+		t.Errorf("propuesta de la caché %+v", propuesta)
+	}
+	if n := servidor.peticiones.Load(); n != 1 {
+		t.Errorf("%d peticiones al servicio, se esperaba 1", n)
+	}
+
+	// Otro servicio con el mismo archivo ve la caché guardada
+	otro := NuevoServicioMetadatos("", servicio.Archivo)
+	otro.Reloj = reloj
+	if propuesta, err := otro.Buscar(ctx, "9780140328721"); err != nil || propuesta.Origen != OrigenCache {
+		t.Errorf("Buscar sin conexión con la caché en disco: %+v, %v", propuesta, err)
+	}
+
+	// Vencida la caché se vuelve a consultar
+	reloj.Avanzar(vigenciaMetadatos)
+	if propuesta, err := servicio.Buscar(ctx, "9780140328721"); err != nil || propuesta.Origen != OrigenServicio {
+		t.Errorf("Buscar con la caché vencida: %+v, %v", propuesta, err)
+	}
+	if n := servidor.peticiones.Load(); n != 2 {
+		t.Errorf("%d peticiones al servicio, se esperaban 2", n)
+	}
+}
+
+func TestMetadatosISBNDesconocido(t *testing.T) {
+	servidor := nuevoServidorMetadatos(t)
+	ctx := context.Background()
+
+	// El servicio responde {} y se recuerda que no lo conoce, pero por menos
+	// tiempo que un libro encontrado
+	servicio, reloj := nuevoServicioPrueba(t, servidor.URL)
+	for range 2 {
+		if _, err := servicio.Buscar(ctx, "9780306406157"); !errors.Is(err, ErrNoEncontrado) {
+			t.Fatalf("Buscar de un ISBN desconocido: err = %v, se esperaba ErrNoEncontrado", err)
+		}
+	}
+	if n := servidor.peticiones.Load(); n != 1 {
+		t.Errorf("%d peticiones al servicio, se esperaba 1", n)
+	}
+	reloj.Avanzar(vigenciaDesconocidos)
+	if _, err := servicio.Buscar(ctx, "9780306406157"); !errors.Is(err, ErrNoEncontrado) {
+		t.Fatalf("Buscar de un ISBN desconocido: err = %v, se esperaba ErrNoEncontrado", err)
+	}
+	if n := servidor.peticiones.Load(); n != 2 {
+		t.Errorf("%d peticiones al servicio, se esperaban 2 al vencer la respuesta negativa", n)
+	}
+
+	if _, err := servicio.Buscar(ctx, "978-0-14-032872-2"); !errors.Is(err, ErrDatosInvalidos) {
+		t.Errorf("Buscar de un ISBN mal escrito: err = %v, se esperaba ErrDatosInvalidos", err)
+	}
+}
+
+// Un 404 es un servicio mal configurado, no un ISBN desconocido: no se
+// guarda y se usa la caché como con cualquier otra falla
+func TestMetadatos404EsFallaDelServicio(t *testing.T) {
+	servidor := nuevoServidorMetadatos(t)
+	servicio, reloj := nuevoServicioPrueba(t, servidor.URL)
+	ctx := context.Background()
+	if _, err := servicio.Buscar(ctx, "9780140328721"); err != nil {
+		t.Fatalf("Buscar: %v", err)
+	}
+	reloj.Avanzar(vigenciaMetadatos + time.Hour)
+	servidor.modo.Store("404")
+
+	propuesta, err := servicio.Buscar(ctx, "9780140328721")
+	if err != nil || propuesta.Origen != OrigenCache || !propuesta.Vencida {
+		t.Errorf("Buscar con 404 y caché vencida: %+v, %v", propuesta, err)
+	}
+	for range 2 {
+		if _, err := servicio.Buscar(ctx, "9780306406157"); !errors.Is(err, ErrSinServicio) {
+			t.Errorf("Buscar con 404 sin caché: err = %v, se esperaba ErrSinServicio", err)
+		}
+	}
+	if n := servidor.peticiones.Load(); n != 4 {
+		t.Errorf("%d peticiones al servicio, se esperaban 4: el 404 no se guarda", n)
+	}
+}
+
+// Si el servicio tarda demasiado o corta la conexión se usa la caché
+// aunque esté vencida, y sin caché el error es ErrSinServicio
+func TestMetadatosSinServicio(t *testing.T) {
+	for _, modo := range []string{"lento", "corte"} {
+		t.Run(modo, func(t *testing.T) {
+			servidor := nuevoServidorMetadatos(t)
+			servicio, reloj := nuevoServicioPrueba(t, servidor.URL)
+			ctx := context.Background()
+			if _, err := servicio.Buscar(ctx, "9780140328721"); err != nil {
+				t.Fatalf("Buscar: %v", err)
+			}
+			reloj.Avanzar(vigenciaMetadatos + time.Hour)
+			servidor.modo.Store(modo)
+
+			inicio := time.Now()
+			propuesta, err := servicio.Buscar(ctx, "9780140328721")
+			if err != nil {
+				t.Fatalf("Buscar con el servicio caído: %v", err)
+			}
+			if propuesta.Origen != OrigenCache || !propuesta.Vencida || propuesta.Titulo != "Fantastic Mr Fox" {
+				t.Errorf("propuesta %+v, se esperaba la de la caché vencida", propuesta)
+			}
+			if espera := time.Since(inicio); espera > 2*time.Second {
+				t.Errorf("Buscar esperó %v con una espera de %v", espera, servicio.Cliente.Timeout)
+			}
+
+			if _, err := servicio.Buscar(ctx, "9788437604572"); !errors.Is(err, ErrSinServicio) {
+				t.Errorf("Buscar sin caché: err = %v, se esperaba ErrSinServicio", err)
+			}
+			// net/http reintenta una vez un GET si se corta una conexión reusada
+			if n := servidor.peticiones.Load(); n < 3 {
+				t.Errorf("%d peticiones al servicio, se esperaban al menos 3", n)
+			}
+		})
+	}
+}
+
+func TestMetadatosSinConexion(t *testing.T) {
+	servicio, _ := nuevoServicioPrueba(t, "")
+	if _, err := servicio.Buscar(context.Background(), "9780140328721"); !errors.Is(err, ErrSinServicio) {
+		t.Errorf("Buscar sin URL ni caché: err = %v, se esperaba ErrSinServicio", err)
+	}
+}
+
+func TestProponerLibroCompleta(t *testing.T) {
+	servidor := nuevoServidorMetadatos(t)
+	b, _ := nuevaBibliotecaPrueba(t)
+	if _, err := b.ProponerLibro(context.Background(), Sistema, "9780140328721"); !errors.Is(err, ErrSinServicio) {
+		t.Errorf("ProponerLibro sin servicio: err = %v, se esperaba ErrSinServicio", err)
+	}
+	b.Metadatos, _ = nuevoServicioPrueba(t, servidor.URL)
+	propuesta, err := b.ProponerLibro(context.Background(), Sistema, "9780140328721")
+	if err != nil {
+		t.Fatalf("ProponerLibro: %v", err)
+	}
+	titulo, autor, paginas := propuesta.Completar("Mr Fox", "", 0)
+	if titulo != "Mr Fox" || autor != "Roald Dahl" || paginas != 96 {
+		t.Errorf("Completar = %q, %q, %d", titulo, autor, paginas)
+	}
+}
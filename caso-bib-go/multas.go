@@ -0,0 +1,155 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// ==========================================
+// ATRASOS Y MULTAS
+// ==========================================
+// Un préstamo está vencido cuando sigue activo después de su
+// FechaDevolucion. Al devolverlo se le cobra la multa que corresponda a sus
+// días de atraso, sin contar los días en que su sucursal estuvo cerrada
+// (ver calendario.go), y se suma a la deuda del usuario; con deuda por
+// encima de Politica.Multas.LimiteDeuda el usuario no puede prestar ni
+// reservar hasta pagar. Si el ejemplar se pierde estando prestado, además del atraso se le
+// cobra Reposicion.
+
+// PoliticaMultas define cómo se cobran los atrasos
+type PoliticaMultas struct {
+	// TarifaDiaria es lo que se cobra por cada día de atraso
+	TarifaDiaria float64 `json:"tarifa_diaria"`
+	// DiasGracia son los primeros días de atraso que no se cobran
+	DiasGracia int `json:"dias_gracia"`
+	// TopePorPrestamo limita la multa de un préstamo; 0 es sin tope
+	TopePorPrestamo float64 `json:"tope_por_prestamo"`
+	// LimiteDeuda es la deuda máxima con la que todavía se puede prestar
+	LimiteDeuda float64 `json:"limite_deuda"`
+	// Reposicion es lo que se cobra por un ejemplar perdido estando prestado
+	Reposicion float64 `json:"reposicion"`
+}
+
+// PoliticaMultasPorDefecto es la política de una biblioteca nueva
+var PoliticaMultasPorDefecto = PoliticaMultas{
+	TarifaDiaria:    0.50,
+	DiasGracia:      1,
+	TopePorPrestamo: 20,
+	LimiteDeuda:     10,
+	Reposicion:      25,
+}
+
+// Calcular retorna la multa por dias de atraso: se cobran solo los días
+// posteriores a la gracia, hasta el tope
+func (p PoliticaMultas) Calcular(dias int) float64 {
+	if dias <= p.DiasGracia {
+		return 0
+	}
+	multa := float64(dias-p.DiasGracia) * p.TarifaDiaria
+	if p.TopePorPrestamo > 0 {
+		multa = min(multa, p.TopePorPrestamo)
+	}
+	return redondear(multa)
+}
+
+// Cobrar retorna cuánto más se le cobra a prestamo por dias de atraso: lo
+// que dice Calcular, sin que la multa del préstamo, sumada a lo que ya se le
+// cobró al renovarlo, pase el tope
+func (p PoliticaMultas) Cobrar(prestamo Prestamo, dias int) float64 {
+	multa := p.Calcular(dias)
+	if p.TopePorPrestamo > 0 {
+		multa = min(multa, max(0, p.TopePorPrestamo-prestamo.Multa))
+	}
+	return redondear(multa)
+}
+
+// redondear lleva un monto a centavos
+func redondear(monto float64) float64 {
+	return math.Round(monto*100) / 100
+}
+
+// Vencido indica si el préstamo sigue activo pasada su fecha de devolución
+func (p Prestamo) Vencido(ahora time.Time) bool {
+	return !p.Devuelto && ahora.After(p.FechaDevolucion)
+}
+
+// DiasAtraso cuenta los días, o fracción, entre la fecha de devolución y
+// hasta. Es 0 si hasta no pasó la fecha de devolución
+func (p Prestamo) DiasAtraso(hasta time.Time) int {
+	atraso := hasta.Sub(p.FechaDevolucion)
+	if atraso <= 0 {
+		return 0
+	}
+	return int(math.Ceil(atraso.Hours() / 24))
+}
+
+// ListarPrestamosVencidos retorna los préstamos activos cuya fecha de
+// devolución ya pasó
+func (b *Biblioteca) ListarPrestamosVencidos() []Prestamo {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	ahora := b.Reloj.Ahora()
+	vencidos := make([]Prestamo, 0)
+	for _, prestamo := range b.prestamos.Listar() {
+		if prestamo.Vencido(ahora) {
+			vencidos = append(vencidos, prestamo)
+		}
+	}
+	return vencidos
+}
+
+// MultaAcumulada retorna la multa que tendría el préstamo si se devolviera
+// ahora, con lo ya cobrado al renovarlo; para uno ya devuelto, la multa que
+// se cobró
+func (b *Biblioteca) MultaAcumulada(prestamo Prestamo) float64 {
+	if prestamo.Devuelto {
+		return prestamo.Multa
+	}
+	return redondear(prestamo.Multa + b.Politica.Multas.Cobrar(prestamo, b.diasAtraso(prestamo, b.Reloj.Ahora())))
+}
+
+// PagarMulta descuenta monto de la deuda del usuario
+func (b *Biblioteca) PagarMulta(actor Actor, usuarioID int, monto float64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.autorizar(actor, PermisoCobrar, 0); err != nil {
+		return err
+	}
+
+	return b.modificarUsuario(b.iniciar(actor, EventoMultaPagada), usuarioID, func(u *Usuario) error {
+		monto = redondear(monto)
+		if monto <= 0 {
+			return nuevoError(ErrDatosInvalidos, "El monto a pagar debe ser positivo")
+		}
+		if monto > u.Deuda {
+			return nuevoError(ErrDatosInvalidos, "El usuario '%s' debe %.2f: no puede pagar %.2f", u.Nombre, u.Deuda, monto)
+		}
+		u.Deuda = redondear(u.Deuda - monto)
+		return nil
+	})
+}
+
+// CondonarMulta perdona monto de la deuda del usuario sin cobrarlo. Queda
+// registrado como un evento aparte de los pagos
+func (b *Biblioteca) CondonarMulta(actor Actor, usuarioID int, monto float64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.autorizar(actor, PermisoCondonar, 0); err != nil {
+		return err
+	}
+
+	return b.modificarUsuario(b.iniciar(actor, EventoMultaCondonada), usuarioID, func(u *Usuario) error {
+		monto = redondear(monto)
+		if monto <= 0 {
+			return nuevoError(ErrDatosInvalidos, "El monto a condonar debe ser positivo")
+		}
+		if monto > u.Deuda {
+			return nuevoError(ErrDatosInvalidos, "El usuario '%s' debe %.2f: no se puede condonar %.2f", u.Nombre, u.Deuda, monto)
+		}
+		u.Deuda = redondear(u.Deuda - monto)
+		return nil
+	})
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+const dia = 24 * time.Hour
+
+func TestCalcularMulta(t *testing.T) {
+	politica := PoliticaMultas{TarifaDiaria: 0.5, DiasGracia: 2, TopePorPrestamo: 10}
+	casos := []struct {
+		nombre string
+		p      PoliticaMultas
+		dias   int
+		multa  float64
+	}{
+		{"sin atraso", politica, 0, 0},
+		{"dentro de la gracia", politica, 2, 0},
+		{"primer día cobrado", politica, 3, 0.5},
+		{"varios días", politica, 12, 5},
+		{"justo en el tope", politica, 22, 10},
+		{"pasado el tope", politica, 100, 10},
+		{"sin tope", PoliticaMultas{TarifaDiaria: 0.5}, 100, 50},
+		{"redondeo a centavos", PoliticaMultas{TarifaDiaria: 0.333}, 3, 1},
+		{"suma flotante", PoliticaMultas{TarifaDiaria: 0.1}, 3, 0.3},
+		{"medio centavo", PoliticaMultas{TarifaDiaria: 0.125}, 1, 0.13},
+	}
+	for _, c := range casos {
+		if multa := c.p.Calcular(c.dias); multa != c.multa {
+			t.Errorf("%s: Calcular(%d) = %v, se esperaba %v", c.nombre, c.dias, multa, c.multa)
+		}
+	}
+}
+
+func TestPrestamosVencidosYMultaAlDevolver(t *testing.T) {
+	b, reloj := nuevaBibliotecaPrueba(t)
+	puntual := agregarLibroPrueba(t, b, "Pedro Páramo")
+	atrasado := agregarLibroPrueba(t, b, "El llano en llamas")
+	ana := registrarUsuarioPrueba(t, b, "ana")
+
+	aTiempo, err := b.PrestarLibro(Sistema, puntual.ID, ana.ID)
+	if err != nil {
+		t.Fatalf("PrestarLibro: %v", err)
+	}
+	reloj.Avanzar(2 * dia)
+	tarde, err := b.PrestarLibro(Sistema, atrasado.ID, ana.ID)
+	if err != nil {
+		t.Fatalf("PrestarLibro: %v", err)
+	}
+	if vencidos := b.ListarPrestamosVencidos(); len(vencidos) != 0 {
+		t.Fatalf("vencidos recién prestados: %+v", vencidos)
+	}
+
+	// El primero vence a los 14 días del préstamo; el segundo dos días después
+	reloj.Avanzar(12*dia + time.Hour)
+	vencidos := b.ListarPrestamosVencidos()
+	if len(vencidos) != 1 || vencidos[0].ID != aTiempo.ID {
+		t.Fatalf("vencidos = %+v, se esperaba solo el préstamo %d", vencidos, aTiempo.ID)
+	}
+	if _, err := b.DevolverLibro(Sistema, puntual.ID, ""); err != nil {
+		t.Fatalf("DevolverLibro: %v", err)
+	}
+	// Una hora de atraso es un día, dentro de la gracia: no se cobra
+	if u := b.BuscarUsuario(ana.ID); u.Deuda != 0 {
+		t.Errorf("deuda %v después de una devolución dentro de la gracia", u.Deuda)
+	}
+
+	reloj.Avanzar(5 * dia)
+	vencidos = b.ListarPrestamosVencidos()
+	if len(vencidos) != 1 || vencidos[0].ID != tarde.ID {
+		t.Fatalf("vencidos = %+v, se esperaba solo el préstamo %d", vencidos, tarde.ID)
+	}
+	// Venció hace 3 días y una hora: 4 días de atraso, 3 cobrados a 0,50
+	if multa := b.MultaAcumulada(vencidos[0]); multa != 1.5 {
+		t.Errorf("MultaAcumulada = %v, se esperaba 1.5", multa)
+	}
+	devuelto, err := b.DevolverLibro(Sistema, atrasado.ID, "")
+	if err != nil {
+		t.Fatalf("DevolverLibro: %v", err)
+	}
+	if devuelto.Multa != 1.5 {
+		t.Errorf("multa cobrada %v, se esperaba 1.5", devuelto.Multa)
+	}
+	if u := b.BuscarUsuario(ana.ID); u.Deuda != 1.5 {
+		t.Errorf("deuda %v, se esperaba 1.5", u.Deuda)
+	}
+	if vencidos := b.ListarPrestamosVencidos(); len(vencidos) != 0 {
+		t.Errorf("vencidos después de devolver todo: %+v", vencidos)
+	}
+	verificar(t, b)
+}
+
+func TestDeudaSobreElLimiteBloqueaPrestamos(t *testing.T) {
+	b, reloj := nuevaBibliotecaPrueba(t)
+	libro := agregarLibroPrueba(t, b, "Rayuela")
+	otro := agregarLibroPrueba(t, b, "Final del juego")
+	ana := registrarUsuarioPrueba(t, b, "ana")
+	limite := b.Politica.Multas.LimiteDeuda
+
+	if _, err := b.PrestarLibro(Sistema, libro.ID, ana.ID); err != nil {
+		t.Fatalf("PrestarLibro: %v", err)
+	}
+	// Dos meses de atraso: la multa llega al tope, que supera el límite
+	reloj.Avanzar(75 * dia)
+	devuelto, err := b.DevolverLibro(Sistema, libro.ID, "")
+	if err != nil {
+		t.Fatalf("DevolverLibro: %v", err)
+	}
+	if devuelto.Multa != b.Politica.Multas.TopePorPrestamo {
+		t.Fatalf("multa %v, se esperaba el tope %v", devuelto.Multa, b.Politica.Multas.TopePorPrestamo)
+	}
+
+	usuario := b.BuscarUsuario(ana.ID)
+	if usuario.PuedePrestar(limite) {
+		t.Errorf("PuedePrestar(%v) con deuda %v", limite, usuario.Deuda)
+	}
+	_, err = b.PrestarLibro(Sistema, otro.ID, ana.ID)
+	var politica *ErrorPolitica
+	if !errors.As(err, &politica) || politica.Regla != ReglaLimiteDeuda || !errors.Is(err, ErrNoPermitido) {
+		t.Fatalf("PrestarLibro con deuda sobre el límite: err = %v, se esperaba la regla %s", err, ReglaLimiteDeuda)
+	}
+	verificar(t, b)
+
+	// Pagando hasta quedar justo en el límite vuelve a poder prestar
+	if err := b.PagarMulta(Sistema, ana.ID, usuario.Deuda-limite); err != nil {
+		t.Fatalf("PagarMulta: %v", err)
+	}
+	if usuario := b.BuscarUsuario(ana.ID); !usuario.PuedePrestar(limite) {
+		t.Errorf("PuedePrestar(%v) con deuda %v", limite, usuario.Deuda)
+	}
+	if _, err := b.PrestarLibro(Sistema, otro.ID, ana.ID); err != nil {
+		t.Errorf("PrestarLibro con la deuda en el límite: %v", err)
+	}
+	verificar(t, b)
+}
+
+// El tope es por préstamo: el atraso cobrado al renovar y el cobrado al
+// devolver juntos no lo pasan
+func TestTopeDeMultaEntreRenovacionYDevolucion(t *testing.T) {
+	b, reloj := nuevaBibliotecaPrueba(t)
+	b.Politica.Multas = PoliticaMultas{TarifaDiaria: 1, TopePorPrestamo: 5, LimiteDeuda: 100}
+	libro := agregarLibroPrueba(t, b, "Rayuela")
+	ana := registrarUsuarioPrueba(t, b, "ana")
+
+	prestamo, err := b.PrestarLibro(Sistema, libro.ID, ana.ID)
+	if err != nil {
+		t.Fatalf("PrestarLibro: %v", err)
+	}
+	reloj.Avanzar(14*dia + 3*dia)
+	renovado, err := b.RenovarPrestamo(Sistema, prestamo.ID)
+	if err != nil {
+		t.Fatalf("RenovarPrestamo: %v", err)
+	}
+	if renovado.Multa != 3 {
+		t.Errorf("multa al renovar = %v, se esperaba 3", renovado.Multa)
+	}
+	verificar(t, b)
+
+	reloj.Avanzar(renovado.FechaDevolucion.Sub(reloj.Ahora()) + 4*dia)
+	if multa := b.MultaAcumulada(*renovado); multa != 5 {
+		t.Errorf("MultaAcumulada = %v, se esperaba el tope 5", multa)
+	}
+	devuelto, err := b.DevolverLibro(Sistema, libro.ID, "")
+	if err != nil {
+		t.Fatalf("DevolverLibro: %v", err)
+	}
+	if devuelto.Multa != 5 {
+		t.Errorf("multa del préstamo = %v, se esperaba el tope 5", devuelto.Multa)
+	}
+	if deuda := b.BuscarUsuario(ana.ID).Deuda; deuda != 5 {
+		t.Errorf("deuda = %v, se esperaba 5", deuda)
+	}
+	verificar(t, b)
+}
@@ -0,0 +1,571 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ==========================================
+// PERSISTENCIA: SNAPSHOT + DIARIO
+// ==========================================
+// El estado completo se guarda como un snapshot JSON versionado. Entre
+// snapshots, cada operación que modifica la biblioteca se agrega primero a un
+// diario (un JSON por línea) junto al snapshot, de modo que si el proceso cae
+// antes del próximo Guardar no se pierde nada.
+
+// versionSnapshot es la versión actual del formato en disco. La versión 1
+// no marcaba los libros prestados ni contaba los préstamos de cada usuario;
+// hasta la 2 cada libro era a la vez título y única copia física; hasta la
+// 3 los ISBN se guardaban tal como se escribieron.
+const versionSnapshot = 4
+
+// extensionDiario se agrega a la ruta del snapshot para obtener la del diario
+const extensionDiario = ".diario"
+
+// extensionEventos se agrega a la ruta del snapshot para obtener la del
+// registro de eventos, que a diferencia del diario nunca se vacía
+const extensionEventos = ".eventos"
+
+// snapshot es la representación en disco de una Biblioteca
+type snapshot struct {
+	Version      int            `json:"version"`
+	Nombre       string         `json:"nombre"`
+	Direccion    string         `json:"direccion"`
+	ProximoID    int            `json:"proximo_id"`
+	Libros       []Libro        `json:"libros"`
+	Ejemplares   []Ejemplar     `json:"ejemplares"`
+	Usuarios     []Usuario      `json:"usuarios"`
+	Prestamos    []Prestamo     `json:"prestamos"`
+	Reservas     []Reserva      `json:"reservas"`
+	Credenciales []Credencial   `json:"credenciales,omitempty"`
+	Sucursales   []Sucursal     `json:"sucursales,omitempty"`
+	Traslados    []Traslado     `json:"traslados,omitempty"`
+	ClavesDatos  map[int][]byte `json:"claves_datos,omitempty"`
+}
+
+// entradaDiario guarda el estado resultante de las entidades que cambió una
+// operación. Aplicarla dos veces deja el mismo estado que aplicarla una vez.
+type entradaDiario struct {
+	ProximoID    int          `json:"proximo_id"`
+	Libros       []Libro      `json:"libros,omitempty"`
+	Ejemplares   []Ejemplar   `json:"ejemplares,omitempty"`
+	Usuarios     []Usuario    `json:"usuarios,omitempty"`
+	Prestamos    []Prestamo   `json:"prestamos,omitempty"`
+	Reservas     []Reserva    `json:"reservas,omitempty"`
+	Credenciales []Credencial `json:"credenciales,omitempty"`
+	Sucursales   []Sucursal   `json:"sucursales,omitempty"`
+	Traslados    []Traslado   `json:"traslados,omitempty"`
+	// ClavesDatos son las claves de los datos personales de los eventos
+	// que se crearon o, con valor nil, se borraron
+	ClavesDatos map[int][]byte `json:"claves_datos,omitempty"`
+}
+
+// diario es un archivo de solo-agregar con un registro JSON por línea. La
+// biblioteca lo usa para sus operaciones y los repositorios en archivo para
+// sus entidades.
+type diario[T any] struct {
+	ruta    string
+	archivo *os.File
+}
+
+// abrirDiario abre (o crea) el diario en ruta. Si truncar es true se
+// descarta su contenido, porque ya está incluido en un snapshot nuevo.
+func abrirDiario[T any](ruta string, truncar bool) (*diario[T], error) {
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if truncar {
+		flags |= os.O_TRUNC
+	}
+	archivo, err := os.OpenFile(ruta, flags, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("No se pudo abrir el diario '%s': %w", ruta, err)
+	}
+	return &diario[T]{ruta: ruta, archivo: archivo}, nil
+}
+
+// escribir agrega una entrada al diario y la sincroniza con el disco
+func (d *diario[T]) escribir(e T) error {
+	linea, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	linea = append(linea, '\n')
+	if _, err := d.archivo.Write(linea); err != nil {
+		return fmt.Errorf("No se pudo escribir el diario '%s': %w", d.ruta, err)
+	}
+	return d.archivo.Sync()
+}
+
+// tamano retorna cuántos bytes ocupa el diario
+func (d *diario[T]) tamano() (int64, error) {
+	info, err := d.archivo.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("No se pudo leer el diario '%s': %w", d.ruta, err)
+	}
+	return info.Size(), nil
+}
+
+// truncar descarta lo escrito después de los primeros tamano bytes
+func (d *diario[T]) truncar(tamano int64) error {
+	if err := d.archivo.Truncate(tamano); err != nil {
+		return fmt.Errorf("No se pudo truncar el diario '%s': %w", d.ruta, err)
+	}
+	return d.archivo.Sync()
+}
+
+func (d *diario[T]) cerrar() error {
+	return d.archivo.Close()
+}
+
+// leerDiario lee todas las entradas completas del diario y retorna además
+// cuántos bytes ocupan. Una última línea incompleta (el proceso cayó mientras
+// escribía) se ignora.
+func leerDiario[T any](ruta string) ([]T, int64, error) {
+	archivo, err := os.Open(ruta)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer archivo.Close()
+
+	var entradas []T
+	var leidos int64
+	lector := bufio.NewReader(archivo)
+	for numero := 1; ; numero++ {
+		linea, err := lector.ReadBytes('\n')
+		if err == io.EOF {
+			// Sin salto de línea final: escritura interrumpida
+			return entradas, leidos, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		leidos += int64(len(linea))
+		linea = bytes.TrimSpace(linea)
+		if len(linea) == 0 {
+			continue
+		}
+		var e T
+		if err := json.Unmarshal(linea, &e); err != nil {
+			return nil, 0, fmt.Errorf("Diario '%s' dañado en la línea %d: %w", ruta, numero, err)
+		}
+		entradas = append(entradas, e)
+	}
+}
+
+// recuperarDiario lee las entradas completas del diario y descarta una línea
+// incompleta, para que las nuevas entradas no se peguen a ella
+func recuperarDiario[T any](ruta string) ([]T, error) {
+	entradas, validos, err := leerDiario[T](ruta)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(ruta); err == nil && info.Size() > validos {
+		if err := os.Truncate(ruta, validos); err != nil {
+			return nil, fmt.Errorf("No se pudo reparar el diario '%s': %w", ruta, err)
+		}
+	}
+	return entradas, nil
+}
+
+// registrar escribe la entrada en el diario, si la biblioteca tiene uno.
+// Quien llama debe tener el bloqueo exclusivo.
+func (b *Biblioteca) registrar(e entradaDiario) error {
+	if b.diario == nil {
+		return nil
+	}
+	return b.diario.escribir(e)
+}
+
+// aplicar guarda en los repositorios las entidades de una entrada del
+// diario, reemplazando por ID las que ya existían
+func (b *Biblioteca) aplicar(e entradaDiario) error {
+	for _, libro := range e.Libros {
+		if err := b.libros.Guardar(libro); err != nil {
+			return err
+		}
+		b.busqueda.indexar(libro)
+	}
+	for _, ejemplar := range e.Ejemplares {
+		if err := b.ejemplares.Guardar(ejemplar); err != nil {
+			return err
+		}
+	}
+	for _, usuario := range e.Usuarios {
+		if err := b.usuarios.Guardar(usuario); err != nil {
+			return err
+		}
+	}
+	for _, credencial := range e.Credenciales {
+		b.credenciales[credencial.Nombre] = credencial
+	}
+	for _, sucursal := range e.Sucursales {
+		b.sucursales[sucursal.Codigo] = sucursal
+	}
+	for _, traslado := range e.Traslados {
+		b.traslados[traslado.ID] = traslado
+	}
+	for _, prestamo := range e.Prestamos {
+		if err := b.prestamos.Guardar(prestamo); err != nil {
+			return err
+		}
+	}
+	for _, reserva := range e.Reservas {
+		if err := b.reservas.Guardar(reserva); err != nil {
+			return err
+		}
+	}
+	for id, clave := range e.ClavesDatos {
+		if len(clave) == 0 {
+			delete(b.clavesDatos, id)
+		} else {
+			b.clavesDatos[id] = clave
+		}
+	}
+	if e.ProximoID > b.proximoID {
+		b.proximoID = e.ProximoID
+	}
+	return nil
+}
+
+// Guardar escribe un snapshot de la biblioteca en path y reinicia el diario.
+// El snapshot se escribe en un archivo temporal y luego se renombra, así que
+// una caída a mitad de camino deja intacto el snapshot anterior.
+func (b *Biblioteca) Guardar(path string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.guardar(path)
+}
+
+// guardar es Guardar para quien ya tiene el bloqueo exclusivo
+func (b *Biblioteca) guardar(path string) error {
+	datos, err := json.MarshalIndent(snapshot{
+		Version:      versionSnapshot,
+		Nombre:       b.Nombre,
+		Direccion:    b.Direccion,
+		ProximoID:    b.proximoID,
+		Libros:       b.libros.Listar(),
+		Ejemplares:   b.ejemplares.Listar(),
+		Usuarios:     b.usuarios.Listar(),
+		Prestamos:    b.prestamos.Listar(),
+		Reservas:     b.reservas.Listar(),
+		Credenciales: b.listarCredenciales(),
+		Sucursales:   b.listarSucursales(),
+		Traslados:    b.listarTraslados(),
+		ClavesDatos:  b.clavesDatos,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	temporal, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("No se pudo guardar la biblioteca: %w", err)
+	}
+	defer os.Remove(temporal.Name())
+
+	if err := temporal.Chmod(0o644); err != nil {
+		temporal.Close()
+		return fmt.Errorf("No se pudo guardar la biblioteca: %w", err)
+	}
+	if _, err := temporal.Write(datos); err != nil {
+		temporal.Close()
+		return fmt.Errorf("No se pudo guardar la biblioteca: %w", err)
+	}
+	if err := temporal.Sync(); err != nil {
+		temporal.Close()
+		return fmt.Errorf("No se pudo guardar la biblioteca: %w", err)
+	}
+	if err := temporal.Close(); err != nil {
+		return fmt.Errorf("No se pudo guardar la biblioteca: %w", err)
+	}
+	if err := os.Rename(temporal.Name(), path); err != nil {
+		return fmt.Errorf("No se pudo guardar la biblioteca: %w", err)
+	}
+
+	// Lo que había en el diario ya está en el snapshot
+	nuevo, err := abrirDiario[entradaDiario](path+extensionDiario, true)
+	if err != nil {
+		return err
+	}
+	if b.diario != nil {
+		b.diario.cerrar()
+	}
+	b.diario = nuevo
+	return b.guardarEventos(path + extensionEventos)
+}
+
+// guardarEventos deja el registro de eventos en ruta. Si ya se escribía ahí
+// no hace nada; si no, escribe todos los eventos en un archivo nuevo
+func (b *Biblioteca) guardarEventos(ruta string) error {
+	if b.archivoEventos != nil && b.rutaEventos == ruta {
+		return nil
+	}
+	archivo, err := abrirDiario[Evento](ruta, true)
+	if err != nil {
+		return err
+	}
+	for _, e := range b.eventos {
+		if err := archivo.escribir(e); err != nil {
+			archivo.cerrar()
+			return err
+		}
+	}
+	if b.archivoEventos != nil {
+		b.archivoEventos.cerrar()
+	}
+	b.archivoEventos, b.rutaEventos = archivo, ruta
+	return nil
+}
+
+// cargarEventos lee el registro de eventos de ruta y lo deja abierto para
+// seguir agregando. Una biblioteca guardada antes de que existiera el
+// registro empieza con un evento de su estado completo
+func (b *Biblioteca) cargarEventos(ruta string, migrado bool) error {
+	eventos, err := recuperarDiario[Evento](ruta)
+	if err != nil {
+		return err
+	}
+	archivo, err := abrirDiario[Evento](ruta, false)
+	if err != nil {
+		return err
+	}
+	for i, e := range eventos {
+		// Los registros anteriores a que se quitaran las claves todavía
+		// pueden traerlas
+		eventos[i] = e.sinClaves()
+	}
+	b.eventos, b.archivoEventos, b.rutaEventos = eventos, archivo, ruta
+
+	estado := b.eventoEstado(EventoEstadoInicial)
+	switch {
+	case estado.Cambios.vacia():
+		return nil
+	case len(eventos) == 0:
+		return b.agregarEvento(estado)
+	case migrado:
+		// Los eventos anteriores tienen el formato viejo: el estado migrado
+		// los reemplaza al reconstruir
+		estado.Tipo = EventoMigracion
+		return b.agregarEvento(estado)
+	}
+	return nil
+}
+
+// Cargar lee el snapshot de path, le aplica el diario pendiente y deja el
+// diario abierto para seguir registrando operaciones
+func Cargar(path string) (*Biblioteca, error) {
+	datos, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("No se pudo cargar la biblioteca: %w", err)
+	}
+
+	var s snapshot
+	if err := json.Unmarshal(datos, &s); err != nil {
+		return nil, fmt.Errorf("Snapshot '%s' no válido: %w", path, err)
+	}
+	if s.Version < 1 || s.Version > versionSnapshot {
+		return nil, fmt.Errorf("Versión de snapshot no soportada: %d", s.Version)
+	}
+
+	entradas, err := recuperarDiario[entradaDiario](path + extensionDiario)
+	if err != nil {
+		return nil, err
+	}
+
+	// Un formato anterior se migra junto con su diario, porque las entradas
+	// viejas tienen el mismo formato que su snapshot
+	migrado := s.Version < versionSnapshot
+	if migrado {
+		for _, e := range entradas {
+			fusionarEntrada(&s, e)
+		}
+		entradas = nil
+		migrarSnapshot(&s)
+	}
+
+	b := NuevaBiblioteca(s.Nombre, s.Direccion)
+	if err := b.aplicar(entradaDiario{
+		ProximoID:    s.ProximoID,
+		Libros:       s.Libros,
+		Ejemplares:   s.Ejemplares,
+		Usuarios:     s.Usuarios,
+		Prestamos:    s.Prestamos,
+		Reservas:     s.Reservas,
+		Credenciales: s.Credenciales,
+		Sucursales:   s.Sucursales,
+		Traslados:    s.Traslados,
+		ClavesDatos:  s.ClavesDatos,
+	}); err != nil {
+		return nil, fmt.Errorf("Snapshot '%s' no válido: %w", path, err)
+	}
+
+	for _, e := range entradas {
+		if err := b.aplicar(e); err != nil {
+			return nil, fmt.Errorf("Diario '%s' no válido: %w", path+extensionDiario, err)
+		}
+	}
+	if !migrado {
+		// Se abre antes que los eventos para registrar las claves que cree
+		// el evento de estado inicial
+		b.diario, err = abrirDiario[entradaDiario](path+extensionDiario, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := b.cargarEventos(path+extensionEventos, migrado); err != nil {
+		if b.diario != nil {
+			b.diario.cerrar()
+		}
+		return nil, err
+	}
+
+	if migrado {
+		// Se reescribe en el formato actual; esto también vacía el diario
+		if err := b.Guardar(path); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// abrirOCrear carga la biblioteca guardada en path o, si el archivo aún no
+// existe, guarda ahí la biblioteca nueva
+func abrirOCrear(path string, nueva *Biblioteca) (*Biblioteca, error) {
+	if _, err := os.Stat(path); err == nil {
+		return Cargar(path)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("No se pudo cargar la biblioteca: %w", err)
+	}
+	if err := nueva.Guardar(path); err != nil {
+		return nil, err
+	}
+	return nueva, nil
+}
+
+// Cerrar libera el diario, el registro de eventos y los repositorios en
+// archivo. La biblioteca sigue usable, pero sus cambios ya no se registran
+// en disco hasta el próximo Guardar.
+func (b *Biblioteca) Cerrar() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var errs []error
+	if b.diario != nil {
+		errs = append(errs, b.diario.cerrar())
+		b.diario = nil
+	}
+	if b.archivoEventos != nil {
+		errs = append(errs, b.archivoEventos.cerrar())
+		b.archivoEventos, b.rutaEventos = nil, ""
+	}
+	for _, repo := range []any{b.libros, b.ejemplares, b.usuarios, b.prestamos, b.reservas} {
+		if repo, ok := repo.(repoArchivo); ok {
+			errs = append(errs, repo.Cerrar())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// migrarSnapshot lleva un snapshot de una versión anterior a la actual
+func migrarSnapshot(s *snapshot) {
+	if s.Version < 3 {
+		migrarEjemplares(s)
+	}
+	if s.Version < 4 {
+		migrarISBN(s)
+	}
+	s.Version = versionSnapshot
+}
+
+// migrarEjemplares lleva un snapshot de versión 1 o 2 a la 3: crea un
+// ejemplar por cada libro, asigna a cada préstamo el ejemplar de su libro y
+// reconstruye los préstamos activos de cada usuario a partir de los préstamos
+// no devueltos
+func migrarEjemplares(s *snapshot) {
+	prestados := make(map[int]bool)
+	activos := make(map[int]int)
+	for _, prestamo := range s.Prestamos {
+		if !prestamo.Devuelto {
+			prestados[prestamo.LibroID] = true
+			activos[prestamo.UsuarioID]++
+		}
+	}
+
+	ejemplarDe := make(map[int]int)
+	s.Ejemplares = nil
+	for _, libro := range s.Libros {
+		ejemplar := Ejemplar{
+			ID:           s.ProximoID,
+			LibroID:      libro.ID,
+			CodigoBarras: fmt.Sprintf("%08d", s.ProximoID),
+			Estado:       EjemplarDisponible,
+		}
+		if prestados[libro.ID] {
+			ejemplar.Estado = EjemplarPrestado
+		}
+		s.ProximoID++
+		ejemplarDe[libro.ID] = ejemplar.ID
+		s.Ejemplares = append(s.Ejemplares, ejemplar)
+	}
+
+	for i := range s.Prestamos {
+		s.Prestamos[i].EjemplarID = ejemplarDe[s.Prestamos[i].LibroID]
+	}
+	for i := range s.Usuarios {
+		s.Usuarios[i].PrestamosActivos = activos[s.Usuarios[i].ID]
+	}
+}
+
+// migrarISBN pasa los ISBN de los libros a su forma canónica. Los que no se
+// pueden interpretar, o que quedarían repetidos, se dejan como estaban para
+// no perder el dato
+func migrarISBN(s *snapshot) {
+	usados := make(map[ISBN]bool)
+	for _, libro := range s.Libros {
+		usados[libro.ISBN] = true
+	}
+	for i, libro := range s.Libros {
+		canonico, err := ParsearISBN(string(libro.ISBN))
+		if err != nil || canonico == libro.ISBN || usados[canonico] {
+			continue
+		}
+		delete(usados, libro.ISBN)
+		usados[canonico] = true
+		s.Libros[i].ISBN = canonico
+	}
+}
+
+// fusionarEntrada aplica una entrada del diario sobre el snapshot,
+// reemplazando por ID las entidades que ya estaban
+func fusionarEntrada(s *snapshot, e entradaDiario) {
+	s.Libros = fusionarPorID(s.Libros, e.Libros, func(l Libro) int { return l.ID })
+	s.Ejemplares = fusionarPorID(s.Ejemplares, e.Ejemplares, func(ej Ejemplar) int { return ej.ID })
+	s.Usuarios = fusionarPorID(s.Usuarios, e.Usuarios, func(u Usuario) int { return u.ID })
+	s.Prestamos = fusionarPorID(s.Prestamos, e.Prestamos, func(p Prestamo) int { return p.ID })
+	s.Reservas = fusionarPorID(s.Reservas, e.Reservas, func(r Reserva) int { return r.ID })
+	s.ProximoID = max(s.ProximoID, e.ProximoID)
+}
+
+func fusionarPorID[T any](lista, nuevos []T, id func(T) int) []T {
+	posicion := make(map[int]int, len(lista))
+	for i, valor := range lista {
+		posicion[id(valor)] = i
+	}
+	for _, valor := range nuevos {
+		if i, ok := posicion[id(valor)]; ok {
+			lista[i] = valor
+			continue
+		}
+		posicion[id(valor)] = len(lista)
+		lista = append(lista, valor)
+	}
+	return lista
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+)
+
+// ==========================================
+// POLÍTICA DE PRÉSTAMOS
+// ==========================================
+// Qué puede llevarse cada usuario, por cuánto tiempo y cuántas veces puede
+// renovarlo depende de su categoría. Las reglas se declaran en una Politica,
+// que se carga desde un archivo JSON:
+//
+//	{
+//	  "categoria_por_defecto": "estudiante",
+//	  "categorias": {
+//	    "estudiante": {
+//	      "max_prestamos": 5,
+//	      "dias_prestamo": {"general": 14, "novedad": 7},
+//	      "max_renovaciones": 2,
+//	      "presta_referencia": false
+//	    }
+//	  },
+//	  "multas": {"tarifa_diaria": 0.5, "dias_gracia": 1, "tope_por_prestamo": 20, "limite_deuda": 10, "reposicion": 25},
+//	  "max_dias_atraso_renovacion": 3,
+//	  "dias_retencion": 730
+//	}
+//
+// Cuando una regla impide la operación se retorna un *ErrorPolitica que
+// indica cuál fue.
+
+// CategoriaUsuario agrupa usuarios con las mismas reglas de préstamo
+type CategoriaUsuario string
+
+const (
+	CategoriaEstudiante CategoriaUsuario = "estudiante"
+	CategoriaPersonal   CategoriaUsuario = "personal"
+	CategoriaExterno    CategoriaUsuario = "externo"
+)
+
+// TipoLibro clasifica los títulos para decidir el plazo de préstamo
+type TipoLibro string
+
+const (
+	TipoGeneral TipoLibro = "general"
+	// TipoNovedad: títulos recientes con mucha demanda, de plazo corto
+	TipoNovedad TipoLibro = "novedad"
+	// TipoReferencia: obras de consulta en sala (diccionarios, atlas...)
+	TipoReferencia TipoLibro = "referencia"
+)
+
+// ReglasCategoria son los límites de préstamo de una categoría de usuarios
+type ReglasCategoria struct {
+	// MaxPrestamos es cuántos préstamos activos puede tener a la vez
+	MaxPrestamos int `json:"max_prestamos"`
+	// DiasPrestamo es el plazo por tipo de libro. Un tipo que no figura usa
+	// el plazo de "general"
+	DiasPrestamo map[TipoLibro]int `json:"dias_prestamo"`
+	// MaxRenovaciones es cuántas veces se puede renovar un mismo préstamo
+	MaxRenovaciones int `json:"max_renovaciones"`
+	// PrestaReferencia indica si puede llevarse libros de referencia
+	PrestaReferencia bool `json:"presta_referencia"`
+}
+
+// Politica reúne las reglas de préstamo de la biblioteca
+type Politica struct {
+	// CategoriaPorDefecto se asigna a los usuarios que se registran sin
+	// categoría y a los registrados antes de que existieran las categorías
+	CategoriaPorDefecto CategoriaUsuario                     `json:"categoria_por_defecto"`
+	Categorias          map[CategoriaUsuario]ReglasCategoria `json:"categorias"`
+	Multas              PoliticaMultas                       `json:"multas"`
+	// MaxDiasAtrasoRenovacion es el atraso máximo con el que todavía se
+	// puede renovar. El atraso que haya se cobra al renovar
+	MaxDiasAtrasoRenovacion int `json:"max_dias_atraso_renovacion"`
+	// DiasRetencion es cuánto se guardan los datos personales de una cuenta
+	// cerrada antes de anonimizarla; 0 los borra en la próxima anonimización
+	DiasRetencion int `json:"dias_retencion"`
+}
+
+// PoliticaPorDefecto es la política de una biblioteca nueva
+var PoliticaPorDefecto = Politica{
+	CategoriaPorDefecto: CategoriaEstudiante,
+	Categorias: map[CategoriaUsuario]ReglasCategoria{
+		CategoriaEstudiante: {
+			MaxPrestamos:    5,
+			DiasPrestamo:    map[TipoLibro]int{TipoGeneral: 14, TipoNovedad: 7},
+			MaxRenovaciones: 2,
+		},
+		CategoriaPersonal: {
+			MaxPrestamos:     15,
+			DiasPrestamo:     map[TipoLibro]int{TipoGeneral: 28, TipoNovedad: 14, TipoReferencia: 3},
+			MaxRenovaciones:  3,
+			PrestaReferencia: true,
+		},
+		CategoriaExterno: {
+			MaxPrestamos:    2,
+			DiasPrestamo:    map[TipoLibro]int{TipoGeneral: 14, TipoNovedad: 7},
+			MaxRenovaciones: 1,
+		},
+	},
+	Multas:                  PoliticaMultasPorDefecto,
+	MaxDiasAtrasoRenovacion: 3,
+	DiasRetencion:           730,
+}
+
+// Reglas que puede incumplir una operación, para ErrorPolitica.Regla
+const (
+	ReglaCuentaInactiva    = "cuenta_inactiva"
+	ReglaLimiteDeuda       = "limite_deuda"
+	ReglaCategoria         = "categoria"
+	ReglaMaxPrestamos      = "max_prestamos"
+	ReglaPrestaReferencia  = "presta_referencia"
+	ReglaMaxRenovaciones   = "max_renovaciones"
+	ReglaAtrasoRenovacion  = "max_dias_atraso_renovacion"
+	ReglaReservadoPorOtros = "reservado_por_otro_usuario"
+)
+
+// ErrorPolitica es un ErrorBiblioteca de categoría ErrNoPermitido que
+// además dice qué regla de la política se incumplió
+type ErrorPolitica struct {
+	Regla   string
+	Mensaje string
+}
+
+func (e *ErrorPolitica) Error() string {
+	return e.Mensaje
+}
+
+func (e *ErrorPolitica) Unwrap() error {
+	return ErrNoPermitido
+}
+
+func nuevoErrorPolitica(regla, formato string, args ...any) error {
+	return &ErrorPolitica{Regla: regla, Mensaje: fmt.Sprintf(formato, args...)}
+}
+
+// CargarPolitica lee y valida una política desde un archivo JSON
+func CargarPolitica(ruta string) (Politica, error) {
+	datos, err := os.ReadFile(ruta)
+	if err != nil {
+		return Politica{}, fmt.Errorf("No se pudo leer la política: %w", err)
+	}
+	var p Politica
+	decoder := json.NewDecoder(bytes.NewReader(datos))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&p); err != nil {
+		return Politica{}, fmt.Errorf("Política '%s' no válida: %w", ruta, err)
+	}
+	if err := p.Validar(); err != nil {
+		return Politica{}, fmt.Errorf("Política '%s' no válida: %w", ruta, err)
+	}
+	return p, nil
+}
+
+// Validar revisa que la política sea utilizable: que la categoría por
+// defecto exista y que cada categoría tenga plazo general y límites no
+// negativos
+func (p Politica) Validar() error {
+	var errs []error
+	if _, ok := p.Categorias[p.CategoriaPorDefecto]; !ok {
+		errs = append(errs, fmt.Errorf("La categoría por defecto '%s' no está definida", p.CategoriaPorDefecto))
+	}
+	for _, categoria := range slices.Sorted(maps.Keys(p.Categorias)) {
+		reglas := p.Categorias[categoria]
+		if _, ok := reglas.DiasPrestamo[TipoGeneral]; !ok {
+			errs = append(errs, fmt.Errorf("La categoría '%s' no tiene plazo 'general'", categoria))
+		}
+		if reglas.MaxPrestamos < 0 || reglas.MaxRenovaciones < 0 {
+			errs = append(errs, fmt.Errorf("La categoría '%s' tiene límites negativos", categoria))
+		}
+		for _, tipo := range slices.Sorted(maps.Keys(reglas.DiasPrestamo)) {
+			if dias := reglas.DiasPrestamo[tipo]; dias <= 0 {
+				errs = append(errs, fmt.Errorf("La categoría '%s' tiene un plazo no positivo para '%s'", categoria, tipo))
+			}
+		}
+	}
+	if p.Multas.TarifaDiaria < 0 || p.Multas.DiasGracia < 0 || p.Multas.TopePorPrestamo < 0 || p.Multas.LimiteDeuda < 0 ||
+		p.Multas.Reposicion < 0 {
+		errs = append(errs, errors.New("Las multas tienen valores negativos"))
+	}
+	if p.MaxDiasAtrasoRenovacion < 0 {
+		errs = append(errs, errors.New("El atraso máximo para renovar es negativo"))
+	}
+	if p.DiasRetencion < 0 {
+		errs = append(errs, errors.New("Los días de retención son negativos"))
+	}
+	return errors.Join(errs...)
+}
+
+// categoriaDe retorna la categoría efectiva del usuario
+func (p Politica) categoriaDe(usuario Usuario) CategoriaUsuario {
+	if usuario.Categoria == "" {
+		return p.CategoriaPorDefecto
+	}
+	return usuario.Categoria
+}
+
+// Reglas retorna las reglas de la categoría del usuario
+func (p Politica) Reglas(usuario Usuario) (ReglasCategoria, error) {
+	categoria := p.categoriaDe(usuario)
+	reglas, ok := p.Categorias[categoria]
+	if !ok {
+		return ReglasCategoria{}, nuevoErrorPolitica(ReglaCategoria,
+			"La categoría '%s' del usuario '%s' no está en la política", categoria, usuario.Nombre)
+	}
+	return reglas, nil
+}
+
+// Dias retorna el plazo de préstamo para un tipo de libro
+func (r ReglasCategoria) Dias(tipo TipoLibro) int {
+	if dias, ok := r.DiasPrestamo[tipo]; ok {
+		return dias
+	}
+	return r.DiasPrestamo[TipoGeneral]
+}
+
+// EvaluarUsuario comprueba las reglas que no dependen del libro: que la
+// cuenta esté activa, que no deba demasiado y que su categoría exista
+func (p Politica) EvaluarUsuario(usuario Usuario) (ReglasCategoria, error) {
+	if !usuario.PuedePrestar(p.Multas.LimiteDeuda) {
+		if usuario.Activo && usuario.Deuda > p.Multas.LimiteDeuda {
+			return ReglasCategoria{}, nuevoErrorPolitica(ReglaLimiteDeuda,
+				"El usuario '%s' debe %.2f en multas (máximo %.2f para prestar)",
+				usuario.Nombre, usuario.Deuda, p.Multas.LimiteDeuda)
+		}
+		return ReglasCategoria{}, nuevoErrorPolitica(ReglaCuentaInactiva, "El usuario '%s' no puede prestar", usuario.Nombre)
+	}
+	return p.Reglas(usuario)
+}
+
+// EvaluarPrestamo decide si usuario puede llevarse libro y retorna el plazo
+// en días. Si no puede, retorna la primera regla incumplida
+func (p Politica) EvaluarPrestamo(usuario Usuario, libro Libro) (int, error) {
+	reglas, err := p.EvaluarUsuario(usuario)
+	if err != nil {
+		return 0, err
+	}
+	if usuario.PrestamosActivos >= reglas.MaxPrestamos {
+		return 0, nuevoErrorPolitica(ReglaMaxPrestamos, "El usuario '%s' ya tiene %d préstamos (máximo %d para '%s')",
+			usuario.Nombre, usuario.PrestamosActivos, reglas.MaxPrestamos, p.categoriaDe(usuario))
+	}
+	tipo := libro.TipoEfectivo()
+	if tipo == TipoReferencia && !reglas.PrestaReferencia {
+		return 0, nuevoErrorPolitica(ReglaPrestaReferencia, "'%s' es de referencia y la categoría '%s' no puede llevárselo",
+			libro.Titulo, p.categoriaDe(usuario))
+	}
+	return reglas.Dias(tipo), nil
+}
+
+// EsCategoria indica si la política define la categoría
+func (p Politica) EsCategoria(categoria CategoriaUsuario) bool {
+	_, ok := p.Categorias[categoria]
+	return ok
+}
+
+// ==========================================
+// CATEGORÍAS Y TIPOS
+// ==========================================
+
+// TipoEfectivo retorna el tipo del libro; los libros sin tipo son generales
+func (l Libro) TipoEfectivo() TipoLibro {
+	if l.Tipo == "" {
+		return TipoGeneral
+	}
+	return l.Tipo
+}
+
+// Validar comprueba que el tipo sea uno de los conocidos
+func (t TipoLibro) Validar() error {
+	switch t {
+	case TipoGeneral, TipoNovedad, TipoReferencia:
+		return nil
+	}
+	return nuevoError(ErrDatosInvalidos, "Tipo de libro desconocido '%s'", t)
+}
+
+// ClasificarLibro cambia el tipo de un libro del catálogo
+func (b *Biblioteca) ClasificarLibro(actor Actor, id int, tipo TipoLibro) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.autorizar(actor, PermisoCatalogo, 0); err != nil {
+		return err
+	}
+
+	if err := tipo.Validar(); err != nil {
+		return err
+	}
+	tx := b.iniciar(actor, EventoLibroClasificado)
+	libro, ok := tx.libro(id)
+	if !ok {
+		return nuevoError(ErrNoEncontrado, "No existe un libro con ID '%d'", id)
+	}
+	libro.Tipo = tipo
+	tx.guardarLibro(libro)
+	return tx.confirmar()
+}
+
+// CambiarCategoriaUsuario asigna al usuario otra categoría de la política
+func (b *Biblioteca) CambiarCategoriaUsuario(actor Actor, id int, categoria CategoriaUsuario) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.autorizar(actor, PermisoUsuarios, 0); err != nil {
+		return err
+	}
+
+	if !b.Politica.EsCategoria(categoria) {
+		return nuevoError(ErrDatosInvalidos, "Categoría de usuario desconocida '%s'", categoria)
+	}
+	return b.modificarUsuario(b.iniciar(actor, EventoCategoriaCambiada), id, func(u *Usuario) error {
+		u.Categoria = categoria
+		return nil
+	})
+}
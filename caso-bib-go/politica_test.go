@@ -0,0 +1,113 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func escribirPolitica(t *testing.T, contenido string) string {
+	t.Helper()
+	ruta := filepath.Join(t.TempDir(), "politica.json")
+	if err := os.WriteFile(ruta, []byte(contenido), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return ruta
+}
+
+func TestCargarPolitica(t *testing.T) {
+	politica, err := CargarPolitica(escribirPolitica(t, `{
+		"categoria_por_defecto": "socio",
+		"categorias": {
+			"socio": {"max_prestamos": 1, "dias_prestamo": {"general": 21, "novedad": 5}, "max_renovaciones": 1},
+			"investigador": {"max_prestamos": 10, "dias_prestamo": {"general": 60}, "presta_referencia": true}
+		},
+		"multas": {"tarifa_diaria": 1, "dias_gracia": 0, "tope_por_prestamo": 10, "limite_deuda": 5, "reposicion": 30},
+		"max_dias_atraso_renovacion": 0,
+		"dias_retencion": 365
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	socio := politica.Categorias["socio"]
+	if politica.CategoriaPorDefecto != "socio" || socio.Dias(TipoNovedad) != 5 || socio.Dias(TipoReferencia) != 21 ||
+		politica.Multas.Reposicion != 30 || politica.DiasRetencion != 365 {
+		t.Errorf("política cargada = %+v", politica)
+	}
+
+	for nombre, contenido := range map[string]string{
+		"campo desconocido":     `{"categoria_por_defecto": "socio", "categorias": {"socio": {"dias_prestamo": {"general": 7}}}, "max_prestamo": 3}`,
+		"sin categoría defecto": `{"categoria_por_defecto": "otro", "categorias": {"socio": {"dias_prestamo": {"general": 7}}}}`,
+		"sin plazo general":     `{"categoria_por_defecto": "socio", "categorias": {"socio": {"dias_prestamo": {"novedad": 7}}}}`,
+		"plazo cero":            `{"categoria_por_defecto": "socio", "categorias": {"socio": {"dias_prestamo": {"general": 0}}}}`,
+		"multa negativa":        `{"categoria_por_defecto": "socio", "categorias": {"socio": {"dias_prestamo": {"general": 7}}}, "multas": {"tarifa_diaria": -1}}`,
+		"no es JSON":            `categorias: socio`,
+	} {
+		if _, err := CargarPolitica(escribirPolitica(t, contenido)); err == nil {
+			t.Errorf("%s: CargarPolitica no retornó error", nombre)
+		} else if !strings.Contains(err.Error(), "no válida") {
+			t.Errorf("%s: err = %v, se esperaba que dijera qué política no es válida", nombre, err)
+		}
+	}
+}
+
+func TestEvaluarPrestamo(t *testing.T) {
+	politica := PoliticaPorDefecto
+	general := Libro{Titulo: "Rayuela"}
+	novedad := Libro{Titulo: "Novedad", Tipo: TipoNovedad}
+	referencia := Libro{Titulo: "Diccionario", Tipo: TipoReferencia}
+	socio := func(categoria CategoriaUsuario, prestamos int, deuda float64) Usuario {
+		return Usuario{Nombre: "Ana", Email: "ana@prueba.org", Activo: true, Categoria: categoria,
+			PrestamosActivos: prestamos, Deuda: deuda}
+	}
+	inactivo := socio(CategoriaPersonal, 0, 0)
+	inactivo.Activo = false
+	casos := []struct {
+		nombre  string
+		usuario Usuario
+		libro   Libro
+		dias    int
+		regla   string
+	}{
+		{"estudiante, general", socio(CategoriaEstudiante, 0, 0), general, 14, ""},
+		{"estudiante, novedad", socio(CategoriaEstudiante, 0, 0), novedad, 7, ""},
+		{"sin categoría usa la por defecto", socio("", 0, 0), novedad, 7, ""},
+		{"personal, referencia", socio(CategoriaPersonal, 0, 0), referencia, 3, ""},
+		{"estudiante, referencia", socio(CategoriaEstudiante, 0, 0), referencia, 0, ReglaPrestaReferencia},
+		{"externo en su máximo", socio(CategoriaExterno, 2, 0), general, 0, ReglaMaxPrestamos},
+		{"deuda sobre el límite", socio(CategoriaPersonal, 0, 10.5), general, 0, ReglaLimiteDeuda},
+		{"deuda en el límite", socio(CategoriaPersonal, 0, 10), general, 28, ""},
+		{"cuenta inactiva", inactivo, general, 0, ReglaCuentaInactiva},
+		{"categoría que no existe", socio("jubilado", 0, 0), general, 0, ReglaCategoria},
+	}
+	for _, caso := range casos {
+		dias, err := politica.EvaluarPrestamo(caso.usuario, caso.libro)
+		if dias != caso.dias || reglaIncumplida(err) != caso.regla || (err == nil) != (caso.regla == "") {
+			t.Errorf("%s: EvaluarPrestamo = %d, %v; se esperaba %d y la regla %q", caso.nombre, dias, err, caso.dias, caso.regla)
+		}
+	}
+}
+
+func TestPrestamoSegunPolitica(t *testing.T) {
+	b, reloj := nuevaBibliotecaPrueba(t)
+	libro := agregarLibroPrueba(t, b, "Diccionario")
+	if err := b.ClasificarLibro(Sistema, libro.ID, TipoReferencia); err != nil {
+		t.Fatal(err)
+	}
+	ana := registrarUsuarioPrueba(t, b, "ana")
+	if _, err := b.PrestarLibro(Sistema, libro.ID, ana.ID); reglaIncumplida(err) != ReglaPrestaReferencia {
+		t.Fatalf("estudiante se lleva una obra de referencia: err = %v", err)
+	}
+	if err := b.CambiarCategoriaUsuario(Sistema, ana.ID, CategoriaPersonal); err != nil {
+		t.Fatal(err)
+	}
+	prestamo, err := b.PrestarLibro(Sistema, libro.ID, ana.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := reloj.Ahora().Add(3 * dia); !prestamo.FechaDevolucion.Equal(want) {
+		t.Errorf("FechaDevolucion = %s, se esperaba %s", prestamo.FechaDevolucion, want)
+	}
+	verificar(t, b)
+}
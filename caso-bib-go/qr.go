@@ -0,0 +1,437 @@
+package main
+
+// ==========================================
+// CÓDIGOS QR
+// ==========================================
+// Los carnés llevan un QR con el código del usuario. Alcanza con una parte
+// chica de la norma (ISO/IEC 18004): modo byte, corrección de errores nivel
+// M (un 15% del símbolo puede dañarse) y versiones 1 a 10, hasta 213 bytes.
+// Se elige la versión más chica en la que entra el texto y la máscara con
+// menos penalización.
+
+// bloquesQR describe cómo se parten los datos de una versión en nivel M:
+// cuántos bloques hay de cada largo y cuántos bytes de corrección lleva
+// cada bloque
+type bloquesQR struct {
+	correccion     int
+	cortos, largos int // cantidad de bloques de cada grupo
+	datosCortos    int // bytes de datos de un bloque corto; los largos uno más
+}
+
+// versionesQR son las versiones 1 a 10 en nivel M, desde el índice 1
+var versionesQR = [...]bloquesQR{
+	{},
+	{10, 1, 0, 16},
+	{16, 1, 0, 28},
+	{26, 1, 0, 44},
+	{18, 2, 0, 32},
+	{24, 2, 0, 43},
+	{16, 4, 0, 27},
+	{18, 4, 0, 31},
+	{22, 2, 2, 38},
+	{22, 3, 2, 36},
+	{26, 4, 1, 43},
+}
+
+// alineacionQR son las filas y columnas de los centros de los patrones de
+// alineación de cada versión
+var alineacionQR = [...][]int{
+	{}, {}, {6, 18}, {6, 22}, {6, 26}, {6, 30}, {6, 34},
+	{6, 22, 38}, {6, 24, 42}, {6, 26, 46}, {6, 28, 50},
+}
+
+// datos retorna cuántos bytes de datos entran en la versión
+func (v bloquesQR) datos() int {
+	return v.cortos*v.datosCortos + v.largos*(v.datosCortos+1)
+}
+
+// QR codifica texto, que puede ser cualquier secuencia de bytes, en el QR
+// más chico que lo contenga
+func QR(texto string) (Simbolo, error) {
+	version := 0
+	for v := 1; v < len(versionesQR); v++ {
+		// Modo (4 bits) y largo (8 bits hasta la versión 9, 16 después)
+		cabecera := 12
+		if v >= 10 {
+			cabecera = 20
+		}
+		if (cabecera+8*len(texto)+7)/8 <= versionesQR[v].datos() {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return Simbolo{}, nuevoError(ErrDatosInvalidos, "El texto es demasiado largo para un QR (%d bytes, máximo 213)", len(texto))
+	}
+
+	q := nuevoQR(version)
+	q.dibujarFunciones()
+	q.dibujarDatos(q.codewords(texto))
+	mejor, penalizacion := 0, -1
+	for mascara := range 8 {
+		q.aplicarMascara(mascara)
+		q.dibujarFormato(mascara)
+		if p := q.penalizacion(); penalizacion < 0 || p < penalizacion {
+			mejor, penalizacion = mascara, p
+		}
+		q.aplicarMascara(mascara) // XOR: la segunda vez la quita
+	}
+	q.aplicarMascara(mejor)
+	q.dibujarFormato(mejor)
+	return Simbolo{Tipo: CodigoQR, Texto: texto, Modulos: q.modulos, Margen: 4}, nil
+}
+
+// matrizQR es un QR en construcción. funcion marca los módulos fijos
+// (patrones, formato, versión), que no llevan datos ni se enmascaran
+type matrizQR struct {
+	version int
+	lado    int
+	modulos [][]bool
+	funcion [][]bool
+}
+
+func nuevoQR(version int) *matrizQR {
+	lado := 17 + 4*version
+	q := &matrizQR{version: version, lado: lado}
+	q.modulos = make([][]bool, lado)
+	q.funcion = make([][]bool, lado)
+	for i := range lado {
+		q.modulos[i] = make([]bool, lado)
+		q.funcion[i] = make([]bool, lado)
+	}
+	return q
+}
+
+// fijar pone un módulo de función en la columna x, fila y
+func (q *matrizQR) fijar(x, y int, oscuro bool) {
+	q.modulos[y][x] = oscuro
+	q.funcion[y][x] = true
+}
+
+// dibujarFunciones dibuja los patrones de posición y alineación, las
+// líneas de temporización y reserva el lugar del formato y la versión
+func (q *matrizQR) dibujarFunciones() {
+	for i := range q.lado {
+		q.fijar(6, i, i%2 == 0)
+		q.fijar(i, 6, i%2 == 0)
+	}
+	// Los patrones de posición van con su separador claro alrededor
+	for _, centro := range [][2]int{{3, 3}, {q.lado - 4, 3}, {3, q.lado - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := centro[0]+dx, centro[1]+dy
+				if x >= 0 && x < q.lado && y >= 0 && y < q.lado {
+					distancia := max(abs(dx), abs(dy))
+					q.fijar(x, y, distancia != 2 && distancia != 4)
+				}
+			}
+		}
+	}
+	posiciones := alineacionQR[q.version]
+	for i, y := range posiciones {
+		for j, x := range posiciones {
+			// Los que caerían sobre un patrón de posición no se dibujan
+			if (i == 0 && j == 0) || (i == 0 && j == len(posiciones)-1) || (i == len(posiciones)-1 && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					q.fijar(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+	q.dibujarFormato(0)
+	q.dibujarVersion()
+}
+
+// dibujarFormato escribe el nivel de corrección y la máscara, con su código
+// BCH, en sus dos copias
+func (q *matrizQR) dibujarFormato(mascara int) {
+	// El nivel M se escribe 00
+	datos := mascara
+	resto := datos
+	for range 10 {
+		resto = (resto << 1) ^ ((resto >> 9) * 0x537)
+	}
+	bits := (datos<<10 | resto) ^ 0x5412
+	bit := func(i int) bool { return (bits>>i)&1 != 0 }
+
+	for i := range 6 {
+		q.fijar(8, i, bit(i))
+	}
+	q.fijar(8, 7, bit(6))
+	q.fijar(8, 8, bit(7))
+	q.fijar(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		q.fijar(14-i, 8, bit(i))
+	}
+	for i := range 8 {
+		q.fijar(q.lado-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		q.fijar(8, q.lado-15+i, bit(i))
+	}
+	q.fijar(8, q.lado-8, true) // el módulo oscuro fijo
+}
+
+// dibujarVersion escribe la versión en sus dos bloques, desde la 7
+func (q *matrizQR) dibujarVersion() {
+	if q.version < 7 {
+		return
+	}
+	resto := q.version
+	for range 12 {
+		resto = (resto << 1) ^ ((resto >> 11) * 0x1F25)
+	}
+	bits := q.version<<12 | resto
+	for i := range 18 {
+		oscuro := (bits>>i)&1 != 0
+		a, b := q.lado-11+i%3, i/3
+		q.fijar(a, b, oscuro)
+		q.fijar(b, a, oscuro)
+	}
+}
+
+// codewords arma los bytes de datos con relleno, les agrega la corrección
+// de errores por bloque y los intercala como pide la norma
+func (q *matrizQR) codewords(texto string) []byte {
+	v := versionesQR[q.version]
+	var bits []bool
+	agregar := func(valor, n int) {
+		for i := n - 1; i >= 0; i-- {
+			bits = append(bits, (valor>>i)&1 != 0)
+		}
+	}
+	agregar(0b0100, 4) // modo byte
+	if q.version >= 10 {
+		agregar(len(texto), 16)
+	} else {
+		agregar(len(texto), 8)
+	}
+	for i := 0; i < len(texto); i++ {
+		agregar(int(texto[i]), 8)
+	}
+	capacidad := v.datos() * 8
+	agregar(0, min(4, capacidad-len(bits))) // terminador
+	agregar(0, (8-len(bits)%8)%8)
+	for relleno := 0xEC; len(bits) < capacidad; relleno ^= 0xEC ^ 0x11 {
+		agregar(relleno, 8)
+	}
+	datos := make([]byte, 0, v.datos())
+	for i := 0; i < len(bits); i += 8 {
+		var b byte
+		for _, bit := range bits[i : i+8] {
+			b <<= 1
+			if bit {
+				b |= 1
+			}
+		}
+		datos = append(datos, b)
+	}
+
+	divisor := divisorRS(v.correccion)
+	var bloques, correcciones [][]byte
+	for i := range v.cortos + v.largos {
+		largo := v.datosCortos
+		if i >= v.cortos {
+			largo++
+		}
+		bloques = append(bloques, datos[:largo])
+		correcciones = append(correcciones, restoRS(datos[:largo], divisor))
+		datos = datos[largo:]
+	}
+	var resultado []byte
+	for i := range v.datosCortos + 1 {
+		for _, bloque := range bloques {
+			if i < len(bloque) {
+				resultado = append(resultado, bloque[i])
+			}
+		}
+	}
+	for i := range v.correccion {
+		for _, correccion := range correcciones {
+			resultado = append(resultado, correccion[i])
+		}
+	}
+	return resultado
+}
+
+// dibujarDatos recorre la matriz en zigzag de a dos columnas, de abajo a la
+// derecha hacia arriba, y pone los bits en los módulos libres. Los que
+// sobran quedan claros
+func (q *matrizQR) dibujarDatos(datos []byte) {
+	i := 0
+	for derecha := q.lado - 1; derecha >= 1; derecha -= 2 {
+		if derecha == 6 {
+			derecha = 5 // la columna de temporización no tiene datos
+		}
+		subiendo := (derecha+1)&2 == 0
+		for vertical := range q.lado {
+			for j := range 2 {
+				x, y := derecha-j, vertical
+				if subiendo {
+					y = q.lado - 1 - vertical
+				}
+				if !q.funcion[y][x] && i < len(datos)*8 {
+					q.modulos[y][x] = (datos[i/8]>>(7-i%8))&1 != 0
+					i++
+				}
+			}
+		}
+	}
+}
+
+// aplicarMascara invierte los módulos de datos que elige la máscara
+func (q *matrizQR) aplicarMascara(mascara int) {
+	for y := range q.lado {
+		for x := range q.lado {
+			var invertir bool
+			switch mascara {
+			case 0:
+				invertir = (x+y)%2 == 0
+			case 1:
+				invertir = y%2 == 0
+			case 2:
+				invertir = x%3 == 0
+			case 3:
+				invertir = (x+y)%3 == 0
+			case 4:
+				invertir = (x/3+y/2)%2 == 0
+			case 5:
+				invertir = x*y%2+x*y%3 == 0
+			case 6:
+				invertir = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invertir = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invertir && !q.funcion[y][x] {
+				q.modulos[y][x] = !q.modulos[y][x]
+			}
+		}
+	}
+}
+
+// penalizacion puntúa lo difícil que será leer el símbolo: tramos largos
+// de un color, bloques de 2x2, figuras parecidas a un patrón de posición y
+// desequilibrio entre claros y oscuros
+func (q *matrizQR) penalizacion() int {
+	total := 0
+	en := func(x, y int, porFila bool) bool {
+		if !porFila {
+			x, y = y, x
+		}
+		if x < 0 || y < 0 || x >= q.lado || y >= q.lado {
+			return false
+		}
+		return q.modulos[y][x]
+	}
+	figura := []bool{true, false, true, true, true, false, true}
+	for _, porFila := range []bool{true, false} {
+		for y := range q.lado {
+			tramo := 1
+			for x := 1; x <= q.lado; x++ {
+				if x < q.lado && en(x, y, porFila) == en(x-1, y, porFila) {
+					tramo++
+					continue
+				}
+				if tramo >= 5 {
+					total += tramo - 2
+				}
+				tramo = 1
+			}
+			for x := 0; x+len(figura) <= q.lado; x++ {
+				coincide := true
+				for k, oscuro := range figura {
+					if en(x+k, y, porFila) != oscuro {
+						coincide = false
+						break
+					}
+				}
+				if !coincide {
+					continue
+				}
+				antes, despues := true, true
+				for k := 1; k <= 4; k++ {
+					antes = antes && !en(x-k, y, porFila)
+					despues = despues && !en(x+len(figura)-1+k, y, porFila)
+				}
+				if antes || despues {
+					total += 40
+				}
+			}
+		}
+	}
+	oscuros := 0
+	for y := range q.lado {
+		for x := range q.lado {
+			if q.modulos[y][x] {
+				oscuros++
+			}
+			if x+1 < q.lado && y+1 < q.lado {
+				c := q.modulos[y][x]
+				if q.modulos[y][x+1] == c && q.modulos[y+1][x] == c && q.modulos[y+1][x+1] == c {
+					total += 3
+				}
+			}
+		}
+	}
+	porcentaje := oscuros * 100 / (q.lado * q.lado)
+	total += abs(porcentaje-50) / 5 * 10
+	return total
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// ==========================================
+// REED-SOLOMON
+// ==========================================
+// La corrección de errores es el resto de dividir los datos por un
+// polinomio generador en GF(256), con el polinomio reductor 0x11D.
+
+// multiplicarGF multiplica dos elementos de GF(256)
+func multiplicarGF(x, y byte) byte {
+	var z int
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int((y>>i)&1) * int(x)
+	}
+	return byte(z)
+}
+
+// divisorRS retorna los coeficientes, sin el principal, del generador de
+// grado grado: el producto de (x - 2^i) para i de 0 a grado-1
+func divisorRS(grado int) []byte {
+	resultado := make([]byte, grado)
+	resultado[grado-1] = 1
+	raiz := byte(1)
+	for range grado {
+		for j := range grado {
+			resultado[j] = multiplicarGF(resultado[j], raiz)
+			if j+1 < grado {
+				resultado[j] ^= resultado[j+1]
+			}
+		}
+		raiz = multiplicarGF(raiz, 0x02)
+	}
+	return resultado
+}
+
+// restoRS calcula los bytes de corrección de un bloque de datos
+func restoRS(datos, divisor []byte) []byte {
+	resultado := make([]byte, len(divisor))
+	for _, b := range datos {
+		factor := b ^ resultado[0]
+		copy(resultado, resultado[1:])
+		resultado[len(resultado)-1] = 0
+		for i, coef := range divisor {
+			resultado[i] ^= multiplicarGF(coef, factor)
+		}
+	}
+	return resultado
+}
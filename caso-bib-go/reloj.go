@@ -0,0 +1,50 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// ==========================================
+// RELOJ
+// ==========================================
+// Biblioteca no llama a time.Now directamente: pide la hora a su Reloj. En
+// producción es el reloj del sistema; en pruebas se usa un RelojFijo para
+// simular atrasos sin esperar días.
+
+// Reloj da la hora actual
+type Reloj interface {
+	Ahora() time.Time
+}
+
+// RelojSistema es el reloj real
+type RelojSistema struct{}
+
+func (RelojSistema) Ahora() time.Time {
+	return time.Now()
+}
+
+// RelojFijo marca siempre la misma hora hasta que se lo avanza. Se puede
+// usar desde varias goroutines
+type RelojFijo struct {
+	mu    sync.Mutex
+	ahora time.Time
+}
+
+// NuevoRelojFijo crea un reloj detenido en ahora
+func NuevoRelojFijo(ahora time.Time) *RelojFijo {
+	return &RelojFijo{ahora: ahora}
+}
+
+func (r *RelojFijo) Ahora() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.ahora
+}
+
+// Avanzar adelanta el reloj en d
+func (r *RelojFijo) Avanzar(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ahora = r.ahora.Add(d)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"slices"
+	"time"
+)
+
+// ==========================================
+// RENOVACIONES
+// ==========================================
+// Renovar un préstamo corre su fecha de devolución sin que el usuario tenga
+// que devolver el ejemplar y volver a pedirlo. Cada renovación queda en el
+// historial del préstamo. Cuántas veces se puede renovar y por cuántos días
+// lo decide la categoría del usuario en la Politica.
+
+// Renovacion es una entrada del historial de un préstamo
+type Renovacion struct {
+	Fecha                   time.Time `json:"fecha"`
+	FechaDevolucionAnterior time.Time `json:"fecha_devolucion_anterior"`
+	FechaDevolucion         time.Time `json:"fecha_devolucion"`
+	Multa                   float64   `json:"multa,omitempty"` // atraso cobrado al renovar
+}
+
+// RenovarPrestamo extiende la fecha de devolución de un préstamo activo y
+// retorna el préstamo actualizado. Se rechaza si el préstamo tiene demasiado
+// atraso, si ya se renovó el máximo de veces o si otro usuario reservó el
+// libro
+func (b *Biblioteca) RenovarPrestamo(actor Actor, prestamoID int) (*Prestamo, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	tx := b.iniciar(actor, EventoPrestamoRenovado)
+	prestamo, ok := b.prestamos.PorID(prestamoID)
+	if !ok {
+		return nil, nuevoError(ErrNoEncontrado, "No existe un préstamo con ID '%d'", prestamoID)
+	}
+	if err := b.autorizar(actor, PermisoCirculacion, prestamo.UsuarioID); err != nil {
+		return nil, err
+	}
+	if prestamo.Devuelto {
+		return nil, nuevoError(ErrNoPermitido, "El préstamo %d ya fue devuelto", prestamoID)
+	}
+	usuario, ok := tx.usuario(prestamo.UsuarioID)
+	if !ok {
+		return nil, nuevoError(ErrNoEncontrado, "No existe un usuario con ID '%d'", prestamo.UsuarioID)
+	}
+	libro, ok := tx.libro(prestamo.LibroID)
+	if !ok {
+		return nil, nuevoError(ErrNoEncontrado, "No existe un libro con ID '%d'", prestamo.LibroID)
+	}
+	reglas, err := b.Politica.EvaluarUsuario(usuario)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(prestamo.Renovaciones) >= reglas.MaxRenovaciones {
+		return nil, nuevoErrorPolitica(ReglaMaxRenovaciones, "El préstamo %d ya se renovó %d veces (máximo %d)",
+			prestamoID, len(prestamo.Renovaciones), reglas.MaxRenovaciones)
+	}
+	atraso := b.diasAtraso(prestamo, tx.ahora)
+	if maximo := b.Politica.MaxDiasAtrasoRenovacion; atraso > maximo {
+		return nil, nuevoErrorPolitica(ReglaAtrasoRenovacion, "El préstamo %d tiene %d días de atraso (máximo %d para renovar)",
+			prestamoID, atraso, maximo)
+	}
+
+	tx.vencerReservas(prestamo.LibroID, tx.ahora)
+	for _, reserva := range tx.reservasPendientes(prestamo.LibroID) {
+		if reserva.UsuarioID != prestamo.UsuarioID {
+			return nil, nuevoErrorPolitica(ReglaReservadoPorOtros, "El libro del préstamo %d está reservado por otro usuario", prestamoID)
+		}
+	}
+
+	// El plazo nuevo nunca acorta el que ya tenía
+	renovacion := Renovacion{
+		Fecha:                   tx.ahora,
+		FechaDevolucionAnterior: prestamo.FechaDevolucion,
+		FechaDevolucion:         b.calendarioDe(prestamo.Sucursal).Vencimiento(tx.ahora, reglas.Dias(libro.TipoEfectivo())),
+		Multa:                   b.Politica.Multas.Cobrar(prestamo, atraso),
+	}
+	if renovacion.FechaDevolucion.Before(prestamo.FechaDevolucion) {
+		renovacion.FechaDevolucion = prestamo.FechaDevolucion
+	}
+	prestamo.FechaDevolucion = renovacion.FechaDevolucion
+	// Clip evita escribir sobre el arreglo que comparte con el préstamo guardado
+	prestamo.Renovaciones = append(slices.Clip(prestamo.Renovaciones), renovacion)
+	if renovacion.Multa > 0 {
+		prestamo.Multa = redondear(prestamo.Multa + renovacion.Multa)
+		usuario.Deuda = redondear(usuario.Deuda + renovacion.Multa)
+		tx.guardarUsuario(usuario)
+	}
+
+	tx.guardarPrestamo(prestamo)
+	if err := tx.confirmar(); err != nil {
+		return nil, err
+	}
+	return &prestamo, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// reglaIncumplida retorna la regla de un *ErrorPolitica, o "" si err no lo
+// es
+func reglaIncumplida(err error) string {
+	var politica *ErrorPolitica
+	if errors.As(err, &politica) {
+		return politica.Regla
+	}
+	return ""
+}
+
+func TestRenovarPrestamo(t *testing.T) {
+	b, reloj := nuevaBibliotecaPrueba(t)
+	libro := agregarLibroPrueba(t, b, "Rayuela")
+	ana := registrarUsuarioPrueba(t, b, "ana")
+	prestamo, err := b.PrestarLibro(Sistema, libro.ID, ana.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vencimiento := prestamo.FechaDevolucion
+
+	// A los diez días el plazo vuelve a contar desde hoy
+	reloj.Avanzar(10 * dia)
+	renovado, err := b.RenovarPrestamo(Sistema, prestamo.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verificar(t, b)
+	if want := reloj.Ahora().Add(14 * dia); !renovado.FechaDevolucion.Equal(want) {
+		t.Errorf("FechaDevolucion = %s, se esperaba %s", renovado.FechaDevolucion, want)
+	}
+	if len(renovado.Renovaciones) != 1 || !renovado.Renovaciones[0].FechaDevolucionAnterior.Equal(vencimiento) ||
+		renovado.Renovaciones[0].Multa != 0 {
+		t.Errorf("Renovaciones = %+v", renovado.Renovaciones)
+	}
+
+	// Renovar otra vez el mismo día no acorta ni alarga el plazo
+	otra, err := b.RenovarPrestamo(Sistema, prestamo.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !otra.FechaDevolucion.Equal(renovado.FechaDevolucion) || len(otra.Renovaciones) != 2 {
+		t.Errorf("segunda renovación = %+v", otra)
+	}
+
+	// Un estudiante renueva dos veces
+	if _, err := b.RenovarPrestamo(Sistema, prestamo.ID); reglaIncumplida(err) != ReglaMaxRenovaciones {
+		t.Errorf("tercera renovación: err = %v, se esperaba la regla %s", err, ReglaMaxRenovaciones)
+	}
+	verificar(t, b)
+	if p, _ := b.prestamos.PorID(prestamo.ID); len(p.Renovaciones) != 2 {
+		t.Errorf("la renovación rechazada quedó en el historial: %+v", p.Renovaciones)
+	}
+}
+
+func TestRenovarPrestamoConAtraso(t *testing.T) {
+	b, reloj := nuevaBibliotecaPrueba(t)
+	libro := agregarLibroPrueba(t, b, "Ficciones")
+	ana := registrarUsuarioPrueba(t, b, "ana")
+	prestamo, err := b.PrestarLibro(Sistema, libro.ID, ana.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Dos días tarde se puede renovar y se cobra el atraso: un día después
+	// de la gracia, a 0.50
+	reloj.Avanzar(16 * dia)
+	renovado, err := b.RenovarPrestamo(Sistema, prestamo.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verificar(t, b)
+	if renovado.Multa != 0.5 || renovado.Renovaciones[0].Multa != 0.5 {
+		t.Errorf("multa del préstamo %.2f, de la renovación %.2f; se esperaba 0.50", renovado.Multa, renovado.Renovaciones[0].Multa)
+	}
+	if u := b.BuscarUsuario(ana.ID); u.Deuda != 0.5 {
+		t.Errorf("Deuda = %.2f, se esperaba 0.50", u.Deuda)
+	}
+
+	// Cuatro días tarde ya no
+	reloj.Avanzar(18 * dia)
+	if _, err := b.RenovarPrestamo(Sistema, prestamo.ID); reglaIncumplida(err) != ReglaAtrasoRenovacion {
+		t.Errorf("renovar con 4 días de atraso: err = %v, se esperaba la regla %s", err, ReglaAtrasoRenovacion)
+	}
+	verificar(t, b)
+}
+
+func TestRenovarPrestamoReservadoPorOtro(t *testing.T) {
+	b, _ := nuevaBibliotecaPrueba(t)
+	libro := agregarLibroPrueba(t, b, "El Aleph")
+	ana := registrarUsuarioPrueba(t, b, "ana")
+	beto := registrarUsuarioPrueba(t, b, "beto")
+	prestamo, err := b.PrestarLibro(Sistema, libro.ID, ana.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reserva := reservar(t, b, libro.ID, beto.ID)
+	if _, err := b.RenovarPrestamo(Sistema, prestamo.ID); reglaIncumplida(err) != ReglaReservadoPorOtros {
+		t.Errorf("renovar con una reserva de otro: err = %v, se esperaba la regla %s", err, ReglaReservadoPorOtros)
+	}
+	if _, err := b.CancelarReserva(Sistema, reserva.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.RenovarPrestamo(Sistema, prestamo.ID); err != nil {
+		t.Errorf("renovar sin reservas: %v", err)
+	}
+	verificar(t, b)
+
+	devuelto, err := b.DevolverLibro(Sistema, libro.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.RenovarPrestamo(Sistema, devuelto.ID); !errors.Is(err, ErrNoPermitido) {
+		t.Errorf("renovar un préstamo devuelto: err = %v, se esperaba ErrNoPermitido", err)
+	}
+}
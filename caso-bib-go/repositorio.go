@@ -0,0 +1,803 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// ==========================================
+// REPOSITORIOS
+// ==========================================
+// Biblioteca no guarda las entidades directamente: las pide a estos
+// repositorios. Los repositorios trabajan con copias, así que modificar un
+// valor retornado no cambia lo guardado hasta que se llame a Guardar.
+// Las implementaciones de este archivo no usan bloqueos propios: Biblioteca
+// serializa el acceso a ellas.
+
+// LibroRepo almacena los libros del catálogo
+type LibroRepo interface {
+	// Guardar agrega el libro o reemplaza el que tenga el mismo ID
+	Guardar(libro Libro) error
+	PorID(id int) (Libro, bool)
+	PorISBN(isbn ISBN) (Libro, bool)
+	// Listar retorna todos los libros en orden de alta
+	Listar() []Libro
+	// Eliminar quita el libro; no es un error si no existe
+	Eliminar(id int) error
+}
+
+// EjemplarRepo almacena las copias físicas de cada libro
+type EjemplarRepo interface {
+	// Guardar agrega el ejemplar o reemplaza el que tenga el mismo ID
+	Guardar(ejemplar Ejemplar) error
+	PorID(id int) (Ejemplar, bool)
+	PorCodigo(codigo string) (Ejemplar, bool)
+	// PorLibro retorna los ejemplares de un libro en orden de alta
+	PorLibro(libroID int) []Ejemplar
+	// Listar retorna todos los ejemplares en orden de alta
+	Listar() []Ejemplar
+	// Eliminar quita el ejemplar; no es un error si no existe
+	Eliminar(id int) error
+}
+
+// UsuarioRepo almacena los usuarios registrados
+type UsuarioRepo interface {
+	// Guardar agrega el usuario o reemplaza el que tenga el mismo ID
+	Guardar(usuario Usuario) error
+	PorID(id int) (Usuario, bool)
+	PorEmail(email string) (Usuario, bool)
+	// Listar retorna todos los usuarios en orden de alta
+	Listar() []Usuario
+	// Eliminar quita el usuario; no es un error si no existe
+	Eliminar(id int) error
+}
+
+// PrestamoRepo almacena los préstamos, activos y devueltos
+type PrestamoRepo interface {
+	// Guardar agrega el préstamo o reemplaza el que tenga el mismo ID
+	Guardar(prestamo Prestamo) error
+	PorID(id int) (Prestamo, bool)
+	// ActivoPorEjemplar retorna el préstamo no devuelto del ejemplar, si lo
+	// hay
+	ActivoPorEjemplar(ejemplarID int) (Prestamo, bool)
+	// Listar retorna todos los préstamos en orden de alta
+	Listar() []Prestamo
+	// Eliminar quita el préstamo; no es un error si no existe
+	Eliminar(id int) error
+}
+
+// ReservaRepo almacena las reservas de libros, pendientes y cerradas
+type ReservaRepo interface {
+	// Guardar agrega la reserva o reemplaza la que tenga el mismo ID
+	Guardar(reserva Reserva) error
+	PorID(id int) (Reserva, bool)
+	// PendientesPorLibro retorna las reservas en espera o apartadas del
+	// libro, en orden de llegada
+	PendientesPorLibro(libroID int) []Reserva
+	// PendientesPorUsuario retorna las reservas en espera o apartadas del
+	// usuario, en orden de llegada
+	PendientesPorUsuario(usuarioID int) []Reserva
+	// Listar retorna todas las reservas en orden de alta
+	Listar() []Reserva
+	// Eliminar quita la reserva; no es un error si no existe
+	Eliminar(id int) error
+}
+
+// ==========================================
+// IMPLEMENTACIÓN EN MEMORIA CON ÍNDICES
+// ==========================================
+
+// tabla guarda entidades por ID recordando el orden en que se agregaron
+type tabla[T any] struct {
+	filas map[int]T
+	orden []int
+}
+
+func nuevaTabla[T any]() tabla[T] {
+	return tabla[T]{filas: make(map[int]T)}
+}
+
+// guardar agrega o reemplaza la fila y retorna la que había antes
+func (t *tabla[T]) guardar(id int, fila T) (anterior T, existia bool) {
+	anterior, existia = t.filas[id]
+	if !existia {
+		t.orden = append(t.orden, id)
+	}
+	t.filas[id] = fila
+	return anterior, existia
+}
+
+// eliminar quita la fila y retorna la que había
+func (t *tabla[T]) eliminar(id int) (anterior T, existia bool) {
+	anterior, existia = t.filas[id]
+	if !existia {
+		return anterior, false
+	}
+	delete(t.filas, id)
+	// Casi siempre se elimina la última fila agregada (al deshacer un alta)
+	for i := len(t.orden) - 1; i >= 0; i-- {
+		if t.orden[i] == id {
+			t.orden = append(t.orden[:i], t.orden[i+1:]...)
+			break
+		}
+	}
+	return anterior, true
+}
+
+func (t *tabla[T]) porID(id int) (T, bool) {
+	fila, ok := t.filas[id]
+	return fila, ok
+}
+
+func (t *tabla[T]) listar() []T {
+	filas := make([]T, 0, len(t.orden))
+	for _, id := range t.orden {
+		filas = append(filas, t.filas[id])
+	}
+	return filas
+}
+
+// indice agrupa IDs por otra clave (un libro, un usuario) y los mantiene
+// ordenados, que es el orden de alta porque los IDs son crecientes
+type indice map[int][]int
+
+func (ix indice) agregar(clave, id int) {
+	ids := ix[clave]
+	if i, ok := slices.BinarySearch(ids, id); !ok {
+		ix[clave] = slices.Insert(ids, i, id)
+	}
+}
+
+func (ix indice) quitar(clave, id int) {
+	ids := ix[clave]
+	if i, ok := slices.BinarySearch(ids, id); ok {
+		ids = slices.Delete(ids, i, i+1)
+	}
+	if len(ids) == 0 {
+		delete(ix, clave)
+	} else {
+		ix[clave] = ids
+	}
+}
+
+// filasDe retorna las filas de t cuyos IDs están en ids
+func filasDe[T any](t *tabla[T], ids []int) []T {
+	filas := make([]T, 0, len(ids))
+	for _, id := range ids {
+		fila, _ := t.porID(id)
+		filas = append(filas, fila)
+	}
+	return filas
+}
+
+// LibroRepoMemoria es un LibroRepo en memoria indexado por ID e ISBN
+type LibroRepoMemoria struct {
+	tabla   tabla[Libro]
+	porISBN map[ISBN]int
+}
+
+func NuevoLibroRepoMemoria() *LibroRepoMemoria {
+	return &LibroRepoMemoria{tabla: nuevaTabla[Libro](), porISBN: make(map[ISBN]int)}
+}
+
+// comprobar valida que guardar libro no rompa la unicidad del ISBN
+func (r *LibroRepoMemoria) comprobar(libro Libro) error {
+	if id, ok := r.porISBN[libro.ISBN]; ok && libro.ISBN != "" && id != libro.ID {
+		return nuevoError(ErrConflicto, "Ya existe un libro con el ISBN '%s'", libro.ISBN)
+	}
+	return nil
+}
+
+func (r *LibroRepoMemoria) Guardar(libro Libro) error {
+	if err := r.comprobar(libro); err != nil {
+		return err
+	}
+	if anterior, ok := r.tabla.guardar(libro.ID, libro); ok && anterior.ISBN != "" {
+		delete(r.porISBN, anterior.ISBN)
+	}
+	if libro.ISBN != "" {
+		r.porISBN[libro.ISBN] = libro.ID
+	}
+	return nil
+}
+
+func (r *LibroRepoMemoria) PorID(id int) (Libro, bool) {
+	return r.tabla.porID(id)
+}
+
+func (r *LibroRepoMemoria) PorISBN(isbn ISBN) (Libro, bool) {
+	id, ok := r.porISBN[isbn]
+	if !ok {
+		return Libro{}, false
+	}
+	return r.tabla.porID(id)
+}
+
+func (r *LibroRepoMemoria) Listar() []Libro {
+	return r.tabla.listar()
+}
+
+func (r *LibroRepoMemoria) Eliminar(id int) error {
+	if anterior, ok := r.tabla.eliminar(id); ok && anterior.ISBN != "" {
+		delete(r.porISBN, anterior.ISBN)
+	}
+	return nil
+}
+
+// EjemplarRepoMemoria es un EjemplarRepo en memoria indexado por ID, código
+// de barras y libro
+type EjemplarRepoMemoria struct {
+	tabla     tabla[Ejemplar]
+	porCodigo map[string]int
+	porLibro  indice
+}
+
+func NuevoEjemplarRepoMemoria() *EjemplarRepoMemoria {
+	return &EjemplarRepoMemoria{
+		tabla:     nuevaTabla[Ejemplar](),
+		porCodigo: make(map[string]int),
+		porLibro:  make(indice),
+	}
+}
+
+// comprobar valida que guardar ejemplar no repita un código de barras
+func (r *EjemplarRepoMemoria) comprobar(ejemplar Ejemplar) error {
+	if id, ok := r.porCodigo[ejemplar.CodigoBarras]; ok && ejemplar.CodigoBarras != "" && id != ejemplar.ID {
+		return nuevoError(ErrConflicto, "Ya existe un ejemplar con el código '%s'", ejemplar.CodigoBarras)
+	}
+	return nil
+}
+
+func (r *EjemplarRepoMemoria) Guardar(ejemplar Ejemplar) error {
+	if err := r.comprobar(ejemplar); err != nil {
+		return err
+	}
+	anterior, existia := r.tabla.guardar(ejemplar.ID, ejemplar)
+	if existia {
+		r.desindexar(anterior)
+	}
+	if ejemplar.CodigoBarras != "" {
+		r.porCodigo[ejemplar.CodigoBarras] = ejemplar.ID
+	}
+	r.porLibro.agregar(ejemplar.LibroID, ejemplar.ID)
+	return nil
+}
+
+// desindexar quita ejemplar de los índices por código y por libro
+func (r *EjemplarRepoMemoria) desindexar(ejemplar Ejemplar) {
+	if ejemplar.CodigoBarras != "" {
+		delete(r.porCodigo, ejemplar.CodigoBarras)
+	}
+	r.porLibro.quitar(ejemplar.LibroID, ejemplar.ID)
+}
+
+func (r *EjemplarRepoMemoria) PorID(id int) (Ejemplar, bool) {
+	return r.tabla.porID(id)
+}
+
+func (r *EjemplarRepoMemoria) PorCodigo(codigo string) (Ejemplar, bool) {
+	id, ok := r.porCodigo[codigo]
+	if !ok {
+		return Ejemplar{}, false
+	}
+	return r.tabla.porID(id)
+}
+
+func (r *EjemplarRepoMemoria) PorLibro(libroID int) []Ejemplar {
+	return filasDe(&r.tabla, r.porLibro[libroID])
+}
+
+func (r *EjemplarRepoMemoria) Listar() []Ejemplar {
+	return r.tabla.listar()
+}
+
+func (r *EjemplarRepoMemoria) Eliminar(id int) error {
+	if anterior, ok := r.tabla.eliminar(id); ok {
+		r.desindexar(anterior)
+	}
+	return nil
+}
+
+// UsuarioRepoMemoria es un UsuarioRepo en memoria indexado por ID y email
+type UsuarioRepoMemoria struct {
+	tabla    tabla[Usuario]
+	porEmail map[string]int
+}
+
+func NuevoUsuarioRepoMemoria() *UsuarioRepoMemoria {
+	return &UsuarioRepoMemoria{tabla: nuevaTabla[Usuario](), porEmail: make(map[string]int)}
+}
+
+// comprobar valida que guardar usuario no rompa la unicidad del email
+func (r *UsuarioRepoMemoria) comprobar(usuario Usuario) error {
+	if id, ok := r.porEmail[usuario.Email]; ok && usuario.Email != "" && id != usuario.ID {
+		return nuevoError(ErrConflicto, "Ya existe un usuario con el email '%s'", usuario.Email)
+	}
+	return nil
+}
+
+func (r *UsuarioRepoMemoria) Guardar(usuario Usuario) error {
+	if err := r.comprobar(usuario); err != nil {
+		return err
+	}
+	if anterior, ok := r.tabla.guardar(usuario.ID, usuario); ok && anterior.Email != "" {
+		delete(r.porEmail, anterior.Email)
+	}
+	if usuario.Email != "" {
+		r.porEmail[usuario.Email] = usuario.ID
+	}
+	return nil
+}
+
+func (r *UsuarioRepoMemoria) PorID(id int) (Usuario, bool) {
+	return r.tabla.porID(id)
+}
+
+func (r *UsuarioRepoMemoria) PorEmail(email string) (Usuario, bool) {
+	id, ok := r.porEmail[email]
+	if !ok {
+		return Usuario{}, false
+	}
+	return r.tabla.porID(id)
+}
+
+func (r *UsuarioRepoMemoria) Listar() []Usuario {
+	return r.tabla.listar()
+}
+
+func (r *UsuarioRepoMemoria) Eliminar(id int) error {
+	if anterior, ok := r.tabla.eliminar(id); ok && anterior.Email != "" {
+		delete(r.porEmail, anterior.Email)
+	}
+	return nil
+}
+
+// PrestamoRepoMemoria es un PrestamoRepo en memoria indexado por ID y por
+// el préstamo activo de cada ejemplar
+type PrestamoRepoMemoria struct {
+	tabla   tabla[Prestamo]
+	activos map[int]int // ejemplarID -> prestamoID
+}
+
+func NuevoPrestamoRepoMemoria() *PrestamoRepoMemoria {
+	return &PrestamoRepoMemoria{tabla: nuevaTabla[Prestamo](), activos: make(map[int]int)}
+}
+
+// comprobar valida que un ejemplar no quede con dos préstamos activos
+func (r *PrestamoRepoMemoria) comprobar(prestamo Prestamo) error {
+	if id, ok := r.activos[prestamo.EjemplarID]; ok && !prestamo.Devuelto && id != prestamo.ID {
+		return nuevoError(ErrConflicto, "El ejemplar con ID '%d' ya tiene un préstamo activo", prestamo.EjemplarID)
+	}
+	return nil
+}
+
+func (r *PrestamoRepoMemoria) Guardar(prestamo Prestamo) error {
+	if err := r.comprobar(prestamo); err != nil {
+		return err
+	}
+	if anterior, ok := r.tabla.guardar(prestamo.ID, prestamo); ok && !anterior.Devuelto {
+		delete(r.activos, anterior.EjemplarID)
+	}
+	if !prestamo.Devuelto {
+		r.activos[prestamo.EjemplarID] = prestamo.ID
+	}
+	return nil
+}
+
+func (r *PrestamoRepoMemoria) PorID(id int) (Prestamo, bool) {
+	return r.tabla.porID(id)
+}
+
+func (r *PrestamoRepoMemoria) ActivoPorEjemplar(ejemplarID int) (Prestamo, bool) {
+	id, ok := r.activos[ejemplarID]
+	if !ok {
+		return Prestamo{}, false
+	}
+	return r.tabla.porID(id)
+}
+
+func (r *PrestamoRepoMemoria) Listar() []Prestamo {
+	return r.tabla.listar()
+}
+
+func (r *PrestamoRepoMemoria) Eliminar(id int) error {
+	if anterior, ok := r.tabla.eliminar(id); ok && !anterior.Devuelto {
+		delete(r.activos, anterior.EjemplarID)
+	}
+	return nil
+}
+
+// ReservaRepoMemoria es un ReservaRepo en memoria indexado por ID y por las
+// reservas pendientes de cada libro y de cada usuario
+type ReservaRepoMemoria struct {
+	tabla      tabla[Reserva]
+	porLibro   indice
+	porUsuario indice
+}
+
+func NuevoReservaRepoMemoria() *ReservaRepoMemoria {
+	return &ReservaRepoMemoria{tabla: nuevaTabla[Reserva](), porLibro: make(indice), porUsuario: make(indice)}
+}
+
+// comprobar valida que un usuario no quede con dos reservas pendientes del
+// mismo libro
+func (r *ReservaRepoMemoria) comprobar(reserva Reserva) error {
+	if !reserva.Pendiente() {
+		return nil
+	}
+	for _, id := range r.porUsuario[reserva.UsuarioID] {
+		if otra, _ := r.tabla.porID(id); otra.LibroID == reserva.LibroID && id != reserva.ID {
+			return nuevoError(ErrConflicto, "El usuario con ID '%d' ya tiene una reserva pendiente del libro con ID '%d'",
+				reserva.UsuarioID, reserva.LibroID)
+		}
+	}
+	return nil
+}
+
+func (r *ReservaRepoMemoria) Guardar(reserva Reserva) error {
+	if err := r.comprobar(reserva); err != nil {
+		return err
+	}
+	if anterior, ok := r.tabla.guardar(reserva.ID, reserva); ok {
+		r.porLibro.quitar(anterior.LibroID, anterior.ID)
+		r.porUsuario.quitar(anterior.UsuarioID, anterior.ID)
+	}
+	if reserva.Pendiente() {
+		r.porLibro.agregar(reserva.LibroID, reserva.ID)
+		r.porUsuario.agregar(reserva.UsuarioID, reserva.ID)
+	}
+	return nil
+}
+
+func (r *ReservaRepoMemoria) PorID(id int) (Reserva, bool) {
+	return r.tabla.porID(id)
+}
+
+func (r *ReservaRepoMemoria) PendientesPorLibro(libroID int) []Reserva {
+	return filasDe(&r.tabla, r.porLibro[libroID])
+}
+
+func (r *ReservaRepoMemoria) PendientesPorUsuario(usuarioID int) []Reserva {
+	return filasDe(&r.tabla, r.porUsuario[usuarioID])
+}
+
+func (r *ReservaRepoMemoria) Listar() []Reserva {
+	return r.tabla.listar()
+}
+
+func (r *ReservaRepoMemoria) Eliminar(id int) error {
+	if anterior, ok := r.tabla.eliminar(id); ok {
+		r.porLibro.quitar(anterior.LibroID, anterior.ID)
+		r.porUsuario.quitar(anterior.UsuarioID, anterior.ID)
+	}
+	return nil
+}
+
+// ==========================================
+// IMPLEMENTACIÓN EN ARCHIVO
+// ==========================================
+// Cada repositorio en archivo mantiene los índices en memoria y agrega cada
+// Guardar o Eliminar como una línea JSON a su archivo. Al abrirlo se vuelven
+// a aplicar todas las líneas, así que el último valor guardado de cada ID es
+// el que queda. Compactar reescribe el archivo con solo los valores
+// actuales; AnonimizarCuentas lo usa para que no queden los datos
+// personales en las líneas anteriores.
+
+// repoArchivo es lo que los repositorios en archivo agregan a su interfaz
+type repoArchivo interface {
+	Cerrar() error
+	Compactar() error
+}
+
+// registroArchivo es una línea de un repositorio en archivo: un valor
+// guardado o el ID de uno eliminado
+type registroArchivo[T any] struct {
+	Valor     *T  `json:"valor,omitempty"`
+	Eliminado int `json:"eliminado,omitempty"`
+}
+
+// LibroRepoArchivo es un LibroRepo persistido en un archivo JSONL
+type LibroRepoArchivo struct {
+	*LibroRepoMemoria
+	archivo *diario[registroArchivo[Libro]]
+}
+
+// AbrirLibroRepoArchivo carga los libros guardados en ruta, creando el
+// archivo si no existe
+func AbrirLibroRepoArchivo(ruta string) (*LibroRepoArchivo, error) {
+	memoria := NuevoLibroRepoMemoria()
+	archivo, err := abrirRepoArchivo(ruta, memoria.Guardar, memoria.Eliminar)
+	if err != nil {
+		return nil, err
+	}
+	return &LibroRepoArchivo{LibroRepoMemoria: memoria, archivo: archivo}, nil
+}
+
+func (r *LibroRepoArchivo) Guardar(libro Libro) error {
+	if err := r.comprobar(libro); err != nil {
+		return err
+	}
+	if err := r.archivo.escribir(registroArchivo[Libro]{Valor: &libro}); err != nil {
+		return err
+	}
+	return r.LibroRepoMemoria.Guardar(libro)
+}
+
+func (r *LibroRepoArchivo) Eliminar(id int) error {
+	if err := r.archivo.escribir(registroArchivo[Libro]{Eliminado: id}); err != nil {
+		return err
+	}
+	return r.LibroRepoMemoria.Eliminar(id)
+}
+
+func (r *LibroRepoArchivo) Cerrar() error {
+	return r.archivo.cerrar()
+}
+
+func (r *LibroRepoArchivo) Compactar() error {
+	return compactarRepoArchivo(&r.archivo, r.Listar())
+}
+
+// EjemplarRepoArchivo es un EjemplarRepo persistido en un archivo JSONL
+type EjemplarRepoArchivo struct {
+	*EjemplarRepoMemoria
+	archivo *diario[registroArchivo[Ejemplar]]
+}
+
+// AbrirEjemplarRepoArchivo carga los ejemplares guardados en ruta, creando
+// el archivo si no existe
+func AbrirEjemplarRepoArchivo(ruta string) (*EjemplarRepoArchivo, error) {
+	memoria := NuevoEjemplarRepoMemoria()
+	archivo, err := abrirRepoArchivo(ruta, memoria.Guardar, memoria.Eliminar)
+	if err != nil {
+		return nil, err
+	}
+	return &EjemplarRepoArchivo{EjemplarRepoMemoria: memoria, archivo: archivo}, nil
+}
+
+func (r *EjemplarRepoArchivo) Guardar(ejemplar Ejemplar) error {
+	if err := r.comprobar(ejemplar); err != nil {
+		return err
+	}
+	if err := r.archivo.escribir(registroArchivo[Ejemplar]{Valor: &ejemplar}); err != nil {
+		return err
+	}
+	return r.EjemplarRepoMemoria.Guardar(ejemplar)
+}
+
+func (r *EjemplarRepoArchivo) Eliminar(id int) error {
+	if err := r.archivo.escribir(registroArchivo[Ejemplar]{Eliminado: id}); err != nil {
+		return err
+	}
+	return r.EjemplarRepoMemoria.Eliminar(id)
+}
+
+func (r *EjemplarRepoArchivo) Cerrar() error {
+	return r.archivo.cerrar()
+}
+
+func (r *EjemplarRepoArchivo) Compactar() error {
+	return compactarRepoArchivo(&r.archivo, r.Listar())
+}
+
+// UsuarioRepoArchivo es un UsuarioRepo persistido en un archivo JSONL
+type UsuarioRepoArchivo struct {
+	*UsuarioRepoMemoria
+	archivo *diario[registroArchivo[Usuario]]
+}
+
+// AbrirUsuarioRepoArchivo carga los usuarios guardados en ruta, creando el
+// archivo si no existe
+func AbrirUsuarioRepoArchivo(ruta string) (*UsuarioRepoArchivo, error) {
+	memoria := NuevoUsuarioRepoMemoria()
+	archivo, err := abrirRepoArchivo(ruta, memoria.Guardar, memoria.Eliminar)
+	if err != nil {
+		return nil, err
+	}
+	return &UsuarioRepoArchivo{UsuarioRepoMemoria: memoria, archivo: archivo}, nil
+}
+
+func (r *UsuarioRepoArchivo) Guardar(usuario Usuario) error {
+	if err := r.comprobar(usuario); err != nil {
+		return err
+	}
+	if err := r.archivo.escribir(registroArchivo[Usuario]{Valor: &usuario}); err != nil {
+		return err
+	}
+	return r.UsuarioRepoMemoria.Guardar(usuario)
+}
+
+func (r *UsuarioRepoArchivo) Eliminar(id int) error {
+	if err := r.archivo.escribir(registroArchivo[Usuario]{Eliminado: id}); err != nil {
+		return err
+	}
+	return r.UsuarioRepoMemoria.Eliminar(id)
+}
+
+func (r *UsuarioRepoArchivo) Cerrar() error {
+	return r.archivo.cerrar()
+}
+
+func (r *UsuarioRepoArchivo) Compactar() error {
+	return compactarRepoArchivo(&r.archivo, r.Listar())
+}
+
+// PrestamoRepoArchivo es un PrestamoRepo persistido en un archivo JSONL
+type PrestamoRepoArchivo struct {
+	*PrestamoRepoMemoria
+	archivo *diario[registroArchivo[Prestamo]]
+}
+
+// AbrirPrestamoRepoArchivo carga los préstamos guardados en ruta, creando
+// el archivo si no existe
+func AbrirPrestamoRepoArchivo(ruta string) (*PrestamoRepoArchivo, error) {
+	memoria := NuevoPrestamoRepoMemoria()
+	archivo, err := abrirRepoArchivo(ruta, memoria.Guardar, memoria.Eliminar)
+	if err != nil {
+		return nil, err
+	}
+	return &PrestamoRepoArchivo{PrestamoRepoMemoria: memoria, archivo: archivo}, nil
+}
+
+func (r *PrestamoRepoArchivo) Guardar(prestamo Prestamo) error {
+	if err := r.comprobar(prestamo); err != nil {
+		return err
+	}
+	if err := r.archivo.escribir(registroArchivo[Prestamo]{Valor: &prestamo}); err != nil {
+		return err
+	}
+	return r.PrestamoRepoMemoria.Guardar(prestamo)
+}
+
+func (r *PrestamoRepoArchivo) Eliminar(id int) error {
+	if err := r.archivo.escribir(registroArchivo[Prestamo]{Eliminado: id}); err != nil {
+		return err
+	}
+	return r.PrestamoRepoMemoria.Eliminar(id)
+}
+
+func (r *PrestamoRepoArchivo) Cerrar() error {
+	return r.archivo.cerrar()
+}
+
+func (r *PrestamoRepoArchivo) Compactar() error {
+	return compactarRepoArchivo(&r.archivo, r.Listar())
+}
+
+// ReservaRepoArchivo es un ReservaRepo persistido en un archivo JSONL
+type ReservaRepoArchivo struct {
+	*ReservaRepoMemoria
+	archivo *diario[registroArchivo[Reserva]]
+}
+
+// AbrirReservaRepoArchivo carga las reservas guardadas en ruta, creando el
+// archivo si no existe
+func AbrirReservaRepoArchivo(ruta string) (*ReservaRepoArchivo, error) {
+	memoria := NuevoReservaRepoMemoria()
+	archivo, err := abrirRepoArchivo(ruta, memoria.Guardar, memoria.Eliminar)
+	if err != nil {
+		return nil, err
+	}
+	return &ReservaRepoArchivo{ReservaRepoMemoria: memoria, archivo: archivo}, nil
+}
+
+func (r *ReservaRepoArchivo) Guardar(reserva Reserva) error {
+	if err := r.comprobar(reserva); err != nil {
+		return err
+	}
+	if err := r.archivo.escribir(registroArchivo[Reserva]{Valor: &reserva}); err != nil {
+		return err
+	}
+	return r.ReservaRepoMemoria.Guardar(reserva)
+}
+
+func (r *ReservaRepoArchivo) Eliminar(id int) error {
+	if err := r.archivo.escribir(registroArchivo[Reserva]{Eliminado: id}); err != nil {
+		return err
+	}
+	return r.ReservaRepoMemoria.Eliminar(id)
+}
+
+func (r *ReservaRepoArchivo) Cerrar() error {
+	return r.archivo.cerrar()
+}
+
+func (r *ReservaRepoArchivo) Compactar() error {
+	return compactarRepoArchivo(&r.archivo, r.Listar())
+}
+
+// abrirRepoArchivo aplica con guardar y eliminar cada registro de ruta y
+// deja el archivo abierto para agregar los siguientes
+func abrirRepoArchivo[T any](ruta string, guardar func(T) error, eliminar func(int) error) (*diario[registroArchivo[T]], error) {
+	registros, err := recuperarDiario[registroArchivo[T]](ruta)
+	if err != nil {
+		return nil, err
+	}
+	for i, registro := range registros {
+		if registro.Valor != nil {
+			err = guardar(*registro.Valor)
+		} else {
+			err = eliminar(registro.Eliminado)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Registro %d de '%s' no válido: %w", i+1, ruta, err)
+		}
+	}
+	return abrirDiario[registroArchivo[T]](ruta, false)
+}
+
+// compactarRepoArchivo reemplaza el archivo de un repositorio por uno con
+// una línea por cada valor. Se escribe en un archivo temporal que luego se
+// renombra, así que una caída deja el archivo anterior entero
+func compactarRepoArchivo[T any](archivo **diario[registroArchivo[T]], valores []T) error {
+	ruta := (*archivo).ruta
+	temporal, err := abrirDiario[registroArchivo[T]](ruta+".tmp", true)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temporal.ruta)
+	for _, valor := range valores {
+		if err := temporal.escribir(registroArchivo[T]{Valor: &valor}); err != nil {
+			temporal.cerrar()
+			return err
+		}
+	}
+	if err := temporal.cerrar(); err != nil {
+		return err
+	}
+	if err := os.Rename(temporal.ruta, ruta); err != nil {
+		return fmt.Errorf("No se pudo compactar '%s': %w", ruta, err)
+	}
+	nuevo, err := abrirDiario[registroArchivo[T]](ruta, false)
+	if err != nil {
+		return err
+	}
+	(*archivo).cerrar()
+	*archivo = nuevo
+	return nil
+}
+
+// AbrirBibliotecaEnArchivos crea una biblioteca sobre repositorios en
+// archivo dentro de directorio: libros, ejemplares, usuarios, préstamos y
+// reservas quedan en disco apenas se confirma cada operación, sin esperar a
+// Guardar. Las credenciales, sucursales y traslados y el registro de
+// eventos se guardan con Guardar, como en cualquier biblioteca
+func AbrirBibliotecaEnArchivos(nombre, direccion, directorio string) (*Biblioteca, error) {
+	if err := os.MkdirAll(directorio, 0o755); err != nil {
+		return nil, fmt.Errorf("No se pudo crear el directorio '%s': %w", directorio, err)
+	}
+	var abiertos []repoArchivo
+	fallar := func(err error) (*Biblioteca, error) {
+		for _, repo := range abiertos {
+			repo.Cerrar()
+		}
+		return nil, err
+	}
+	libros, err := AbrirLibroRepoArchivo(filepath.Join(directorio, "libros.jsonl"))
+	if err != nil {
+		return fallar(err)
+	}
+	abiertos = append(abiertos, libros)
+	ejemplares, err := AbrirEjemplarRepoArchivo(filepath.Join(directorio, "ejemplares.jsonl"))
+	if err != nil {
+		return fallar(err)
+	}
+	abiertos = append(abiertos, ejemplares)
+	usuarios, err := AbrirUsuarioRepoArchivo(filepath.Join(directorio, "usuarios.jsonl"))
+	if err != nil {
+		return fallar(err)
+	}
+	abiertos = append(abiertos, usuarios)
+	prestamos, err := AbrirPrestamoRepoArchivo(filepath.Join(directorio, "prestamos.jsonl"))
+	if err != nil {
+		return fallar(err)
+	}
+	abiertos = append(abiertos, prestamos)
+	reservas, err := AbrirReservaRepoArchivo(filepath.Join(directorio, "reservas.jsonl"))
+	if err != nil {
+		return fallar(err)
+	}
+	return NuevaBibliotecaConRepos(nombre, direccion, libros, ejemplares, usuarios, prestamos, reservas), nil
+}
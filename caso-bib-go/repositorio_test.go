@@ -0,0 +1,243 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// ==========================================
+// CONTRATO DE LOS REPOSITORIOS
+// ==========================================
+// Las mismas pruebas corren sobre la implementación en memoria y la en
+// archivo. reabrir retorna el repositorio leído de nuevo desde donde lo
+// guarda: el mismo en memoria, uno abierto otra vez sobre el archivo.
+
+type implementacion[R any] struct {
+	nombre  string
+	nuevo   func(t *testing.T) R
+	reabrir func(t *testing.T, repo R) R
+}
+
+func implementaciones[R any, A repoArchivo](memoria func() R, abrir func(ruta string) (A, error), comoRepo func(A) R) []implementacion[R] {
+	rutas := make(map[any]string)
+	abrirEn := func(t *testing.T, ruta string) R {
+		t.Helper()
+		archivo, err := abrir(ruta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { archivo.Cerrar() })
+		repo := comoRepo(archivo)
+		rutas[repo] = ruta
+		return repo
+	}
+	return []implementacion[R]{
+		{
+			nombre:  "memoria",
+			nuevo:   func(*testing.T) R { return memoria() },
+			reabrir: func(_ *testing.T, repo R) R { return repo },
+		},
+		{
+			nombre: "archivo",
+			nuevo: func(t *testing.T) R {
+				return abrirEn(t, filepath.Join(t.TempDir(), "repo.jsonl"))
+			},
+			reabrir: func(t *testing.T, repo R) R {
+				any(repo).(repoArchivo).Cerrar()
+				return abrirEn(t, rutas[repo])
+			},
+		},
+	}
+}
+
+func TestContratoLibroRepo(t *testing.T) {
+	for _, impl := range implementaciones(
+		func() LibroRepo { return NuevoLibroRepoMemoria() },
+		AbrirLibroRepoArchivo, func(r *LibroRepoArchivo) LibroRepo { return r }) {
+		t.Run(impl.nombre, func(t *testing.T) {
+			repo := impl.nuevo(t)
+			for _, libro := range []Libro{
+				{ID: 3, Titulo: "Ficciones", ISBN: "9780802130303"},
+				{ID: 1, Titulo: "Rayuela"},
+				{ID: 2, Titulo: "Pedro Páramo", ISBN: "9788437604183"},
+			} {
+				if err := repo.Guardar(libro); err != nil {
+					t.Fatalf("Guardar(%d): %v", libro.ID, err)
+				}
+			}
+			if err := repo.Guardar(Libro{ID: 4, ISBN: "9780802130303"}); !errors.Is(err, ErrConflicto) {
+				t.Errorf("Guardar con un ISBN repetido: err = %v, se esperaba ErrConflicto", err)
+			}
+			// Cambiar el ISBN libera el anterior
+			if err := repo.Guardar(Libro{ID: 3, Titulo: "Ficciones", ISBN: "9788420633114"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := repo.Eliminar(1); err != nil {
+				t.Fatal(err)
+			}
+			if err := repo.Eliminar(99); err != nil {
+				t.Errorf("Eliminar un ID que no existe: %v", err)
+			}
+
+			repo = impl.reabrir(t, repo)
+			if got := idsDe(repo.Listar(), func(l Libro) int { return l.ID }); !slices.Equal(got, []int{3, 2}) {
+				t.Errorf("Listar = %v, se esperaba [3 2] en orden de alta", got)
+			}
+			if _, ok := repo.PorISBN("9780802130303"); ok {
+				t.Error("el ISBN anterior de Ficciones sigue indexado")
+			}
+			if libro, ok := repo.PorISBN("9788420633114"); !ok || libro.ID != 3 {
+				t.Errorf("PorISBN = %+v, %v; se esperaba Ficciones", libro, ok)
+			}
+			if libro, ok := repo.PorID(2); !ok || libro.Titulo != "Pedro Páramo" {
+				t.Errorf("PorID(2) = %+v, %v", libro, ok)
+			}
+			if _, ok := repo.PorID(1); ok {
+				t.Error("PorID encuentra un libro eliminado")
+			}
+			if err := repo.Guardar(Libro{ID: 5, ISBN: "9780802130303"}); err != nil {
+				t.Errorf("Guardar con el ISBN liberado: %v", err)
+			}
+		})
+	}
+}
+
+func TestContratoUsuarioRepo(t *testing.T) {
+	for _, impl := range implementaciones(
+		func() UsuarioRepo { return NuevoUsuarioRepoMemoria() },
+		AbrirUsuarioRepoArchivo, func(r *UsuarioRepoArchivo) UsuarioRepo { return r }) {
+		t.Run(impl.nombre, func(t *testing.T) {
+			repo := impl.nuevo(t)
+			for _, usuario := range []Usuario{
+				{ID: 1, Nombre: "Ana", Email: "ana@prueba.org"},
+				{ID: 2, Nombre: "Beto", Email: "beto@prueba.org"},
+			} {
+				if err := repo.Guardar(usuario); err != nil {
+					t.Fatalf("Guardar(%d): %v", usuario.ID, err)
+				}
+			}
+			if err := repo.Guardar(Usuario{ID: 3, Email: "ana@prueba.org"}); !errors.Is(err, ErrConflicto) {
+				t.Errorf("Guardar con un email repetido: err = %v, se esperaba ErrConflicto", err)
+			}
+			if err := repo.Guardar(Usuario{ID: 1, Nombre: "Ana", Email: "ana@otra.org"}); err != nil {
+				t.Fatal(err)
+			}
+
+			repo = impl.reabrir(t, repo)
+			if got := idsDe(repo.Listar(), func(u Usuario) int { return u.ID }); !slices.Equal(got, []int{1, 2}) {
+				t.Errorf("Listar = %v, se esperaba [1 2]", got)
+			}
+			if _, ok := repo.PorEmail("ana@prueba.org"); ok {
+				t.Error("el email anterior de Ana sigue indexado")
+			}
+			if usuario, ok := repo.PorEmail("ana@otra.org"); !ok || usuario.ID != 1 {
+				t.Errorf("PorEmail = %+v, %v; se esperaba Ana", usuario, ok)
+			}
+			if usuario, ok := repo.PorID(2); !ok || usuario.Nombre != "Beto" {
+				t.Errorf("PorID(2) = %+v, %v", usuario, ok)
+			}
+		})
+	}
+}
+
+func TestContratoPrestamoRepo(t *testing.T) {
+	for _, impl := range implementaciones(
+		func() PrestamoRepo { return NuevoPrestamoRepoMemoria() },
+		AbrirPrestamoRepoArchivo, func(r *PrestamoRepoArchivo) PrestamoRepo { return r }) {
+		t.Run(impl.nombre, func(t *testing.T) {
+			repo := impl.nuevo(t)
+			if err := repo.Guardar(Prestamo{ID: 1, EjemplarID: 10, UsuarioID: 5}); err != nil {
+				t.Fatal(err)
+			}
+			if err := repo.Guardar(Prestamo{ID: 2, EjemplarID: 10, UsuarioID: 6}); !errors.Is(err, ErrConflicto) {
+				t.Errorf("dos préstamos activos del mismo ejemplar: err = %v, se esperaba ErrConflicto", err)
+			}
+			// Devuelto el primero, el ejemplar se puede volver a prestar
+			if err := repo.Guardar(Prestamo{ID: 1, EjemplarID: 10, UsuarioID: 5, Devuelto: true}); err != nil {
+				t.Fatal(err)
+			}
+			if err := repo.Guardar(Prestamo{ID: 2, EjemplarID: 10, UsuarioID: 6}); err != nil {
+				t.Fatal(err)
+			}
+
+			repo = impl.reabrir(t, repo)
+			if got := idsDe(repo.Listar(), func(p Prestamo) int { return p.ID }); !slices.Equal(got, []int{1, 2}) {
+				t.Errorf("Listar = %v, se esperaba [1 2]", got)
+			}
+			if prestamo, ok := repo.ActivoPorEjemplar(10); !ok || prestamo.ID != 2 {
+				t.Errorf("ActivoPorEjemplar(10) = %+v, %v; se esperaba el préstamo 2", prestamo, ok)
+			}
+			if prestamo, ok := repo.PorID(1); !ok || !prestamo.Devuelto {
+				t.Errorf("PorID(1) = %+v, %v; se esperaba devuelto", prestamo, ok)
+			}
+		})
+	}
+}
+
+// ==========================================
+// BIBLIOTECA EN ARCHIVOS
+// ==========================================
+
+func TestBibliotecaEnArchivos(t *testing.T) {
+	directorio := t.TempDir()
+	b, err := AbrirBibliotecaEnArchivos("Prueba", "", directorio)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloj := NuevoRelojFijo(inicioPruebas)
+	b.Reloj = reloj
+	b.ModoEstricto = true
+	libro := agregarLibroPrueba(t, b, "Rayuela")
+	ana := registrarUsuarioPrueba(t, b, "ana")
+	beto := registrarUsuarioPrueba(t, b, "beto")
+	prestamo, err := b.PrestarLibro(Sistema, libro.ID, beto.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.CerrarCuenta(Sistema, ana.ID); err != nil {
+		t.Fatal(err)
+	}
+	reloj.Avanzar(731 * dia)
+	if n, err := b.AnonimizarCuentas(Sistema); err != nil || n != 1 {
+		t.Fatalf("AnonimizarCuentas = %d, %v; se esperaba 1", n, err)
+	}
+	verificar(t, b)
+
+	// La compactación saca los datos de ana también de las líneas viejas
+	datos, err := os.ReadFile(filepath.Join(directorio, "usuarios.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(datos), "ana@prueba.org") {
+		t.Errorf("usuarios.jsonl conserva el email de una cuenta anonimizada:\n%s", datos)
+	}
+	if err := b.Cerrar(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Todo lo confirmado sigue ahí al volver a abrir, sin Guardar
+	b, err = AbrirBibliotecaEnArchivos("Prueba", "", directorio)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Cerrar()
+	b.Reloj = NuevoRelojFijo(inicioPruebas.Add(732 * dia))
+	verificar(t, b)
+	if usuario := b.BuscarUsuario(ana.ID); usuario == nil || !usuario.Anonimizado || usuario.Email != "" {
+		t.Errorf("ana al volver a abrir = %+v; se esperaba anonimizada", usuario)
+	}
+	if activos := prestamosActivos(b, libro.ID); activos != 1 {
+		t.Fatalf("%d préstamos activos al volver a abrir, se esperaba 1", activos)
+	}
+	if _, err := b.DevolverLibro(Sistema, libro.ID, ""); err != nil {
+		t.Fatalf("DevolverLibro del préstamo %d: %v", prestamo.ID, err)
+	}
+	if nuevo := agregarLibroPrueba(t, b, "Ficciones"); nuevo.ID <= beto.ID {
+		t.Errorf("el libro nuevo tiene ID %d: los IDs deben seguir después de los guardados", nuevo.ID)
+	}
+	verificar(t, b)
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// ==========================================
+// RESERVAS
+// ==========================================
+// Cuando no quedan ejemplares disponibles de un libro, los usuarios pueden
+// reservarlo y forman una cola por orden de llegada. Cada ejemplar que se
+// devuelve (o se agrega) queda apartado para el primero de la cola durante
+// PlazoRetiro; si no lo retira a tiempo la reserva vence y el ejemplar pasa
+// al siguiente.
+
+// EstadoReserva indica en qué punto está una reserva
+type EstadoReserva string
+
+const (
+	ReservaEnEspera   EstadoReserva = "en_espera"
+	ReservaApartada   EstadoReserva = "apartada"
+	ReservaCompletada EstadoReserva = "completada"
+	ReservaCancelada  EstadoReserva = "cancelada"
+	ReservaVencida    EstadoReserva = "vencida"
+)
+
+// Reserva representa el pedido de un usuario para llevarse un libro cuando
+// haya un ejemplar. EjemplarID y FechaLimite se completan al apartarlo
+type Reserva struct {
+	ID           int           `json:"id"`
+	LibroID      int           `json:"libro_id"`
+	UsuarioID    int           `json:"usuario_id"`
+	FechaReserva time.Time     `json:"fecha_reserva"`
+	Estado       EstadoReserva `json:"estado"`
+	EjemplarID   int           `json:"ejemplar_id,omitempty"`
+	FechaLimite  time.Time     `json:"fecha_limite,omitzero"`
+}
+
+// Pendiente indica si la reserva sigue en la cola o esperando el retiro
+func (r Reserva) Pendiente() bool {
+	return r.Estado == ReservaEnEspera || r.Estado == ReservaApartada
+}
+
+// Apartar asigna a la reserva el ejemplar que el usuario debe retirar antes
+// de limite
+func (r *Reserva) Apartar(ejemplarID int, limite time.Time) {
+	r.Estado = ReservaApartada
+	r.EjemplarID = ejemplarID
+	r.FechaLimite = limite
+}
+
+// Cancelar cierra una reserva pendiente a pedido del usuario
+func (r *Reserva) Cancelar() error {
+	if !r.Pendiente() {
+		return nuevoError(ErrNoPermitido, "La reserva %d ya está %s", r.ID, r.Estado)
+	}
+	r.Estado = ReservaCancelada
+	return nil
+}
+
+// ==========================================
+// COLA DE RESERVAS EN LA TRANSACCIÓN
+// ==========================================
+
+// asignarEjemplar aparta ejemplar para la primera reserva en espera de su
+// libro o, si no hay cola, lo deja disponible; si está fuera de su sucursal
+// lo manda de vuelta, ver volverAlFondo. El ejemplar debe llegar disponible
+func (tx *transaccion) asignarEjemplar(ejemplar Ejemplar, ahora time.Time) error {
+	for _, reserva := range tx.reservasPendientes(ejemplar.LibroID) {
+		if reserva.Estado != ReservaEnEspera {
+			continue
+		}
+		// Si el ejemplar acaba de volver a la estantería, el motivo de ese
+		// cambio se conserva junto al de la reserva
+		motivo := fmt.Sprintf("reserva %d", reserva.ID)
+		if ejemplar.EstadoDesde.Equal(ahora) && ejemplar.Motivo != "" {
+			motivo = ejemplar.Motivo + "; " + motivo
+		}
+		if err := ejemplar.Apartar(ahora, motivo); err != nil {
+			return err
+		}
+		reserva.Apartar(ejemplar.ID, ahora.Add(tx.b.PlazoRetiro))
+		tx.guardarReserva(reserva)
+		break
+	}
+	if ejemplar.Estado == EjemplarDisponible {
+		if err := tx.volverAlFondo(&ejemplar); err != nil {
+			return err
+		}
+	}
+	tx.guardarEjemplar(ejemplar)
+	return nil
+}
+
+// vencerReservas cierra las reservas apartadas del libro cuyo plazo de
+// retiro pasó y ofrece sus ejemplares al siguiente de la cola
+func (tx *transaccion) vencerReservas(libroID int, ahora time.Time) int {
+	vencidas := 0
+	for _, reserva := range tx.reservasPendientes(libroID) {
+		if reserva.Estado != ReservaApartada || !ahora.After(reserva.FechaLimite) {
+			continue
+		}
+		reserva.Estado = ReservaVencida
+		tx.guardarReserva(reserva)
+		tx.liberarEjemplar(reserva.EjemplarID, ahora, "reserva vencida")
+		vencidas++
+	}
+	return vencidas
+}
+
+// liberarEjemplar devuelve a la estantería un ejemplar apartado y se lo
+// ofrece al siguiente de la cola
+func (tx *transaccion) liberarEjemplar(ejemplarID int, ahora time.Time, motivo string) {
+	ejemplar, ok := tx.ejemplar(ejemplarID)
+	if !ok || ejemplar.Estado != EjemplarApartado {
+		return
+	}
+	// Apartado siempre puede volver a disponible
+	_ = ejemplar.cambiarEstado(EjemplarDisponible, ahora, motivo)
+	// Un ejemplar recién liberado siempre se puede apartar
+	_ = tx.asignarEjemplar(ejemplar, ahora)
+}
+
+// reservaDeEjemplar retorna la reserva que tiene apartado el ejemplar
+func (tx *transaccion) reservaDeEjemplar(ejemplar Ejemplar) (Reserva, bool) {
+	for _, reserva := range tx.reservasPendientes(ejemplar.LibroID) {
+		if reserva.Estado == ReservaApartada && reserva.EjemplarID == ejemplar.ID {
+			return reserva, true
+		}
+	}
+	return Reserva{}, false
+}
+
+// ==========================================
+// OPERACIONES
+// ==========================================
+
+// ReservarLibro pone al usuario en la cola de un libro sin ejemplares
+// disponibles
+func (b *Biblioteca) ReservarLibro(actor Actor, libroID, usuarioID int) (*Reserva, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.autorizar(actor, PermisoCirculacion, usuarioID); err != nil {
+		return nil, err
+	}
+
+	tx := b.iniciar(actor, EventoReservaCreada)
+	ahora := tx.ahora
+
+	libro, ok := tx.libro(libroID)
+	if !ok {
+		return nil, nuevoError(ErrNoEncontrado, "No existe un libro con ID '%d'", libroID)
+	}
+	if libro.Retirado {
+		return nil, nuevoError(ErrNoPermitido, "El libro '%s' está retirado del catálogo", libro.Titulo)
+	}
+	usuario, ok := tx.usuario(usuarioID)
+	if !ok {
+		return nil, nuevoError(ErrNoEncontrado, "No existe un usuario con ID '%d'", usuarioID)
+	}
+	if _, err := b.Politica.EvaluarUsuario(usuario); err != nil {
+		return nil, err
+	}
+	tx.vencerReservas(libroID, ahora)
+
+	for _, ejemplar := range tx.ejemplaresDe(libroID) {
+		if ejemplar.EsPrestable() {
+			return nil, nuevoError(ErrNoPermitido, "Hay ejemplares disponibles de '%s': no hace falta reservarlo", libro.Titulo)
+		}
+		if prestamo, activo := tx.prestamoActivo(ejemplar.ID); activo && prestamo.UsuarioID == usuarioID {
+			return nil, nuevoError(ErrNoPermitido, "El usuario '%s' ya tiene prestado '%s'", usuario.Nombre, libro.Titulo)
+		}
+	}
+
+	pendientes := tx.reservasDeUsuario(usuarioID)
+	for _, reserva := range pendientes {
+		if reserva.LibroID == libroID {
+			return nil, nuevoError(ErrConflicto, "El usuario '%s' ya reservó '%s'", usuario.Nombre, libro.Titulo)
+		}
+	}
+	if len(pendientes) >= b.MaxReservasPorUsuario {
+		return nil, nuevoError(ErrNoPermitido, "El usuario '%s' ya tiene %d reservas pendientes (máximo %d)",
+			usuario.Nombre, len(pendientes), b.MaxReservasPorUsuario)
+	}
+
+	reserva := Reserva{
+		ID:           tx.nuevoID(),
+		LibroID:      libroID,
+		UsuarioID:    usuarioID,
+		FechaReserva: ahora,
+		Estado:       ReservaEnEspera,
+	}
+	tx.guardarReserva(reserva)
+	if err := tx.confirmar(); err != nil {
+		return nil, err
+	}
+	return &reserva, nil
+}
+
+// CancelarReserva saca la reserva de la cola. Si tenía un ejemplar apartado,
+// pasa al siguiente de la cola
+func (b *Biblioteca) CancelarReserva(actor Actor, id int) (*Reserva, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	tx := b.iniciar(actor, EventoReservaCancelada)
+	reserva, ok := b.reservas.PorID(id)
+	if !ok {
+		return nil, nuevoError(ErrNoEncontrado, "No existe una reserva con ID '%d'", id)
+	}
+	if err := b.autorizar(actor, PermisoCirculacion, reserva.UsuarioID); err != nil {
+		return nil, err
+	}
+	apartada := reserva.Estado == ReservaApartada
+	if err := reserva.Cancelar(); err != nil {
+		return nil, err
+	}
+	tx.guardarReserva(reserva)
+	if apartada {
+		tx.liberarEjemplar(reserva.EjemplarID, tx.ahora, "reserva cancelada")
+	}
+	if err := tx.confirmar(); err != nil {
+		return nil, err
+	}
+	return &reserva, nil
+}
+
+// BuscarReserva busca una reserva por ID y retorna una copia
+func (b *Biblioteca) BuscarReserva(id int) *Reserva {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	reserva, ok := b.reservas.PorID(id)
+	if !ok {
+		return nil
+	}
+	return &reserva
+}
+
+// PosicionEnCola retorna cuántas reservas en espera del mismo libro van
+// antes que esta, más uno. Una reserva apartada está en la posición 0: ya
+// puede retirar su ejemplar
+func (b *Biblioteca) PosicionEnCola(id int) (int, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	reserva, ok := b.reservas.PorID(id)
+	if !ok {
+		return 0, nuevoError(ErrNoEncontrado, "No existe una reserva con ID '%d'", id)
+	}
+	switch reserva.Estado {
+	case ReservaApartada:
+		return 0, nil
+	case ReservaEnEspera:
+	default:
+		return 0, nuevoError(ErrNoPermitido, "La reserva %d ya está %s", id, reserva.Estado)
+	}
+
+	posicion := 1
+	for _, otra := range b.reservas.PendientesPorLibro(reserva.LibroID) {
+		if otra.ID == id {
+			break
+		}
+		if otra.Estado == ReservaEnEspera {
+			posicion++
+		}
+	}
+	return posicion, nil
+}
+
+// ReservasDeLibro retorna la cola de reservas pendientes de un libro
+func (b *Biblioteca) ReservasDeLibro(libroID int) ([]Reserva, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if _, ok := b.libros.PorID(libroID); !ok {
+		return nil, nuevoError(ErrNoEncontrado, "No existe un libro con ID '%d'", libroID)
+	}
+	return b.reservas.PendientesPorLibro(libroID), nil
+}
+
+// ReservasDeUsuario retorna las reservas pendientes de un usuario
+func (b *Biblioteca) ReservasDeUsuario(usuarioID int) ([]Reserva, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if _, ok := b.usuarios.PorID(usuarioID); !ok {
+		return nil, nuevoError(ErrNoEncontrado, "No existe un usuario con ID '%d'", usuarioID)
+	}
+	return b.reservas.PendientesPorUsuario(usuarioID), nil
+}
+
+// ListarReservas retorna todas las reservas, pendientes y cerradas
+func (b *Biblioteca) ListarReservas() []Reserva {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.reservas.Listar()
+}
+
+// VencerReservas cierra todas las reservas apartadas cuyo plazo de retiro
+// pasó y retorna cuántas venció. Las operaciones sobre un libro ya vencen
+// sus reservas; esto sirve para ponerse al día con los demás
+func (b *Biblioteca) VencerReservas(actor Actor) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.autorizar(actor, PermisoCirculacion, 0); err != nil {
+		return 0, err
+	}
+
+	tx := b.iniciar(actor, EventoReservasVencidas)
+	libros := make(map[int]bool)
+	for _, reserva := range b.reservas.Listar() {
+		if reserva.Estado == ReservaApartada {
+			libros[reserva.LibroID] = true
+		}
+	}
+	vencidas := 0
+	for libroID := range libros {
+		vencidas += tx.vencerReservas(libroID, tx.ahora)
+	}
+	if vencidas == 0 {
+		return 0, nil
+	}
+	return vencidas, tx.confirmar()
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// primerEjemplar retorna el ID del primer ejemplar de un libro
+func primerEjemplar(t *testing.T, b *Biblioteca, libroID int) int {
+	t.Helper()
+	ejemplares, err := b.ListarEjemplares(libroID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ejemplares[0].ID
+}
+
+// reservar reserva el libro para el usuario y verifica las invariantes
+func reservar(t *testing.T, b *Biblioteca, libroID, usuarioID int) *Reserva {
+	t.Helper()
+	reserva, err := b.ReservarLibro(Sistema, libroID, usuarioID)
+	if err != nil {
+		t.Fatalf("ReservarLibro(%d, %d): %v", libroID, usuarioID, err)
+	}
+	verificar(t, b)
+	return reserva
+}
+
+// comprobarReserva compara el estado y la posición en la cola de una reserva
+func comprobarReserva(t *testing.T, b *Biblioteca, id int, estado EstadoReserva, posicion int) {
+	t.Helper()
+	reserva := b.BuscarReserva(id)
+	if reserva.Estado != estado {
+		t.Errorf("reserva %d en estado %s, se esperaba %s", id, reserva.Estado, estado)
+	}
+	if !reserva.Pendiente() {
+		return
+	}
+	if got, err := b.PosicionEnCola(id); err != nil || got != posicion {
+		t.Errorf("PosicionEnCola(%d) = %d, %v; se esperaba %d", id, got, err, posicion)
+	}
+}
+
+func TestColaDeReservasPorOrdenDeLlegada(t *testing.T) {
+	b, reloj := nuevaBibliotecaPrueba(t)
+	libro := agregarLibroPrueba(t, b, "Rayuela")
+	ejemplarID := primerEjemplar(t, b, libro.ID)
+	ana := registrarUsuarioPrueba(t, b, "ana")
+	beto := registrarUsuarioPrueba(t, b, "beto")
+	carla := registrarUsuarioPrueba(t, b, "carla")
+	dani := registrarUsuarioPrueba(t, b, "dani")
+
+	if _, err := b.ReservarLibro(Sistema, libro.ID, beto.ID); !errors.Is(err, ErrNoPermitido) {
+		t.Fatalf("reservar con el ejemplar disponible: err = %v, se esperaba ErrNoPermitido", err)
+	}
+	if _, err := b.PrestarLibro(Sistema, libro.ID, ana.ID); err != nil {
+		t.Fatal(err)
+	}
+	deBeto := reservar(t, b, libro.ID, beto.ID)
+	deCarla := reservar(t, b, libro.ID, carla.ID)
+	deDani := reservar(t, b, libro.ID, dani.ID)
+	comprobarReserva(t, b, deBeto.ID, ReservaEnEspera, 1)
+	comprobarReserva(t, b, deCarla.ID, ReservaEnEspera, 2)
+	comprobarReserva(t, b, deDani.ID, ReservaEnEspera, 3)
+	if _, err := b.ReservarLibro(Sistema, libro.ID, beto.ID); !errors.Is(err, ErrConflicto) {
+		t.Errorf("reservar dos veces: err = %v, se esperaba ErrConflicto", err)
+	}
+	if _, err := b.ReservarLibro(Sistema, libro.ID, ana.ID); !errors.Is(err, ErrNoPermitido) {
+		t.Errorf("reservar lo que se tiene prestado: err = %v, se esperaba ErrNoPermitido", err)
+	}
+
+	// La devolución aparta el ejemplar para el primero de la cola, y nadie
+	// más se lo puede llevar
+	reloj.Avanzar(2 * dia)
+	if _, err := b.DevolverLibro(Sistema, libro.ID, ""); err != nil {
+		t.Fatal(err)
+	}
+	verificar(t, b)
+	comprobarReserva(t, b, deBeto.ID, ReservaApartada, 0)
+	comprobarReserva(t, b, deCarla.ID, ReservaEnEspera, 1)
+	if reserva := b.BuscarReserva(deBeto.ID); reserva.EjemplarID != ejemplarID ||
+		!reserva.FechaLimite.Equal(reloj.Ahora().Add(b.PlazoRetiro)) {
+		t.Errorf("reserva de beto = %+v; se esperaba el ejemplar %d hasta dentro de %s", reserva, ejemplarID, b.PlazoRetiro)
+	}
+	if e := b.BuscarEjemplar(ejemplarID); e.Estado != EjemplarApartado {
+		t.Errorf("ejemplar en estado %s, se esperaba apartado", e.Estado)
+	}
+	if _, err := b.PrestarLibro(Sistema, libro.ID, carla.ID); !errors.Is(err, ErrNoPermitido) {
+		t.Errorf("carla se lleva el ejemplar apartado para beto: err = %v", err)
+	}
+
+	// Beto no lo retira a tiempo: pasa a carla
+	reloj.Avanzar(b.PlazoRetiro + 1)
+	if n, err := b.VencerReservas(Sistema); err != nil || n != 1 {
+		t.Fatalf("VencerReservas = %d, %v; se esperaba 1", n, err)
+	}
+	verificar(t, b)
+	comprobarReserva(t, b, deBeto.ID, ReservaVencida, 0)
+	comprobarReserva(t, b, deCarla.ID, ReservaApartada, 0)
+	comprobarReserva(t, b, deDani.ID, ReservaEnEspera, 1)
+
+	// Carla cancela: pasa a dani, que se lo lleva
+	if _, err := b.CancelarReserva(Sistema, deCarla.ID); err != nil {
+		t.Fatal(err)
+	}
+	verificar(t, b)
+	comprobarReserva(t, b, deCarla.ID, ReservaCancelada, 0)
+	comprobarReserva(t, b, deDani.ID, ReservaApartada, 0)
+	prestamo, err := b.PrestarLibro(Sistema, libro.ID, dani.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verificar(t, b)
+	if prestamo.EjemplarID != ejemplarID {
+		t.Errorf("dani se llevó el ejemplar %d, se esperaba %d", prestamo.EjemplarID, ejemplarID)
+	}
+	comprobarReserva(t, b, deDani.ID, ReservaCompletada, 0)
+	if _, err := b.CancelarReserva(Sistema, deDani.ID); !errors.Is(err, ErrNoPermitido) {
+		t.Errorf("cancelar una reserva completada: err = %v, se esperaba ErrNoPermitido", err)
+	}
+}
+
+func TestLimiteDeReservasPorUsuario(t *testing.T) {
+	b, _ := nuevaBibliotecaPrueba(t)
+	b.MaxReservasPorUsuario = 2
+	ana := registrarUsuarioPrueba(t, b, "ana")
+	beto := registrarUsuarioPrueba(t, b, "beto")
+	var libros []*Libro
+	for _, titulo := range []string{"Rayuela", "Ficciones", "El Aleph"} {
+		libro := agregarLibroPrueba(t, b, titulo)
+		if _, err := b.PrestarLibro(Sistema, libro.ID, ana.ID); err != nil {
+			t.Fatal(err)
+		}
+		libros = append(libros, libro)
+	}
+
+	primera := reservar(t, b, libros[0].ID, beto.ID)
+	reservar(t, b, libros[1].ID, beto.ID)
+	if _, err := b.ReservarLibro(Sistema, libros[2].ID, beto.ID); !errors.Is(err, ErrNoPermitido) {
+		t.Fatalf("tercera reserva con un máximo de 2: err = %v, se esperaba ErrNoPermitido", err)
+	}
+	// Las reservas cerradas no cuentan
+	if _, err := b.CancelarReserva(Sistema, primera.ID); err != nil {
+		t.Fatal(err)
+	}
+	reservar(t, b, libros[2].ID, beto.ID)
+	if reservas, _ := b.ReservasDeUsuario(beto.ID); len(reservas) != 2 {
+		t.Errorf("beto tiene %d reservas pendientes, se esperaban 2", len(reservas))
+	}
+}
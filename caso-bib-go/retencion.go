@@ -0,0 +1,348 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// ==========================================
+// BAJAS Y RETENCIÓN DE DATOS
+// ==========================================
+// Ni los libros ni los usuarios se borran: los préstamos los siguen
+// nombrando y los informes los cuentan. Un libro se retira del catálogo y
+// una cuenta se cierra; los dos siguen guardados con su historial.
+//
+// Los datos personales de una cuenta cerrada (nombre, email y teléfono) se
+// guardan Politica.DiasRetencion días y después AnonimizarCuentas los borra.
+// El registro de eventos no se reescribe: los eventos guardan esos datos
+// cifrados con una clave de cada usuario, y anonimizar borra la clave. Los
+// préstamos no guardan datos personales, así que las estadísticas no
+// cambian al anonimizar.
+
+// ==========================================
+// RETIRO DE LIBROS
+// ==========================================
+
+// RetirarLibro saca un libro del catálogo: deja de listarse, buscarse y
+// exportarse, y todos sus ejemplares quedan retirados con el motivo dado.
+// Sus reservas pendientes se cancelan. No se puede retirar mientras tenga
+// ejemplares prestados
+func (b *Biblioteca) RetirarLibro(actor Actor, id int, motivo string) (*Libro, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.autorizar(actor, PermisoCatalogo, 0); err != nil {
+		return nil, err
+	}
+
+	tx := b.iniciar(actor, EventoLibroRetirado)
+	libro, ok := tx.libro(id)
+	if !ok {
+		return nil, nuevoError(ErrNoEncontrado, "No existe un libro con ID '%d'", id)
+	}
+	if libro.Retirado {
+		return nil, nuevoError(ErrNoPermitido, "El libro '%s' ya está retirado", libro.Titulo)
+	}
+	motivo = strings.TrimSpace(motivo)
+	if motivo == "" {
+		return nil, nuevoError(ErrDatosInvalidos, "Debe indicar el motivo del retiro")
+	}
+
+	ejemplares := tx.ejemplaresDe(id)
+	for _, ejemplar := range ejemplares {
+		switch ejemplar.Estado {
+		case EjemplarPrestado:
+			return nil, nuevoError(ErrNoPermitido,
+				"El ejemplar '%s' de '%s' está prestado: debe devolverse antes del retiro", ejemplar.CodigoBarras, libro.Titulo)
+		case EjemplarEnTransito:
+			return nil, nuevoError(ErrNoPermitido,
+				"El ejemplar '%s' de '%s' está en tránsito: debe recibirse antes del retiro", ejemplar.CodigoBarras, libro.Titulo)
+		}
+	}
+	for _, reserva := range tx.reservasPendientes(id) {
+		// Una reserva pendiente siempre se puede cancelar
+		_ = reserva.Cancelar()
+		tx.guardarReserva(reserva)
+	}
+	for _, ejemplar := range ejemplares {
+		if ejemplar.Estado == EjemplarRetirado {
+			continue
+		}
+		if err := ejemplar.cambiarEstado(EjemplarRetirado, tx.ahora, motivo); err != nil {
+			return nil, err
+		}
+		tx.cerrarTraslado(ejemplar.ID)
+		tx.guardarEjemplar(ejemplar)
+	}
+
+	libro.Retirado = true
+	libro.FechaRetiro = tx.ahora
+	tx.guardarLibro(libro)
+	if err := tx.confirmar(); err != nil {
+		return nil, err
+	}
+	return &libro, nil
+}
+
+// ==========================================
+// CIERRE DE CUENTAS
+// ==========================================
+
+// CerrarCuenta da de baja a un usuario a su pedido. La cuenta queda
+// inactiva y no se puede volver a abrir ni modificar; sus reservas
+// pendientes se cancelan. Hace falta que no tenga préstamos activos ni
+// deuda
+func (b *Biblioteca) CerrarCuenta(actor Actor, id int) (*Usuario, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.autorizar(actor, PermisoUsuarios, id); err != nil {
+		return nil, err
+	}
+
+	tx := b.iniciar(actor, EventoCuentaCerrada)
+	usuario, ok := tx.usuario(id)
+	if !ok {
+		return nil, nuevoError(ErrNoEncontrado, "No existe un usuario con ID '%d'", id)
+	}
+	if usuario.Cerrada {
+		return nil, nuevoError(ErrNoPermitido, "La cuenta de '%s' ya está cerrada", usuario.Nombre)
+	}
+	if usuario.PrestamosActivos > 0 {
+		return nil, nuevoError(ErrNoPermitido, "El usuario '%s' tiene %d préstamos activos", usuario.Nombre, usuario.PrestamosActivos)
+	}
+	if usuario.Deuda > 0 {
+		return nil, nuevoError(ErrNoPermitido, "El usuario '%s' debe %.2f en multas", usuario.Nombre, usuario.Deuda)
+	}
+
+	for _, reserva := range tx.reservasDeUsuario(id) {
+		apartada := reserva.Estado == ReservaApartada
+		_ = reserva.Cancelar()
+		tx.guardarReserva(reserva)
+		if apartada {
+			tx.liberarEjemplar(reserva.EjemplarID, tx.ahora, "cuenta cerrada")
+		}
+	}
+	usuario.Desactivar()
+	usuario.Cerrada = true
+	usuario.FechaCierre = tx.ahora
+	tx.guardarUsuario(usuario)
+	if err := tx.confirmar(); err != nil {
+		return nil, err
+	}
+	return &usuario, nil
+}
+
+// ==========================================
+// ANONIMIZACIÓN
+// ==========================================
+
+// anonimizar borra los datos personales del usuario. El nombre queda como
+// referencia para los listados
+func (u *Usuario) anonimizar() {
+	u.Nombre = nombreAnonimizado(u.ID)
+	u.Email = ""
+	u.Telefono = ""
+	u.Anonimizado = true
+}
+
+func nombreAnonimizado(id int) string {
+	return fmt.Sprintf("Usuario %d (anonimizado)", id)
+}
+
+// AnonimizarCuentas borra los datos personales de las cuentas cerradas hace
+// más de Politica.DiasRetencion días y retorna cuántas anonimizó.
+//
+// También borra la clave de sus datos en los eventos, así que en los
+// eventos anteriores quedan ilegibles. Si la biblioteca está guardada en
+// disco se reescribe el snapshot, que vacía el diario, y si los usuarios
+// están en un repositorio en archivo se compacta, para que no queden en sus
+// líneas anteriores
+func (b *Biblioteca) AnonimizarCuentas(actor Actor) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.autorizar(actor, PermisoAdministrar, 0); err != nil {
+		return 0, err
+	}
+
+	tx := b.iniciar(actor, EventoCuentasAnonimizadas)
+	limite := tx.ahora.AddDate(0, 0, -b.Politica.DiasRetencion)
+	borradas := make(map[int][]byte)
+	anonimizados := 0
+	for _, usuario := range b.usuarios.Listar() {
+		if usuario.Anonimizado {
+			// Una caída pudo dejar la clave de una cuenta ya anonimizada
+			if _, ok := b.clavesDatos[usuario.ID]; ok {
+				borradas[usuario.ID] = nil
+			}
+			continue
+		}
+		if !usuario.Cerrada || usuario.FechaCierre.After(limite) {
+			continue
+		}
+		usuario.anonimizar()
+		tx.guardarUsuario(usuario)
+		borradas[usuario.ID] = nil
+		anonimizados++
+	}
+	if len(borradas) == 0 {
+		return 0, nil
+	}
+	if err := tx.confirmar(); err != nil {
+		return 0, err
+	}
+
+	entrada := entradaDiario{ProximoID: b.proximoID, ClavesDatos: borradas}
+	if err := b.registrar(entrada); err != nil {
+		return anonimizados, err
+	}
+	if err := b.aplicar(entrada); err != nil {
+		return anonimizados, err
+	}
+	if b.diario != nil {
+		if err := b.guardar(strings.TrimSuffix(b.diario.ruta, extensionDiario)); err != nil {
+			return anonimizados, err
+		}
+	}
+	if repo, ok := b.usuarios.(repoArchivo); ok {
+		if err := repo.Compactar(); err != nil {
+			return anonimizados, err
+		}
+	}
+	return anonimizados, nil
+}
+
+// ==========================================
+// DATOS PERSONALES EN LOS EVENTOS
+// ==========================================
+// Cada usuario tiene una clave AES-256 propia, que se guarda en el snapshot
+// y el diario pero nunca en los eventos. Los eventos llevan su nombre, email
+// y teléfono cifrados con ella en DatosPersonales, y los campos en claro
+// vacíos; Eventos los descifra. Una cuenta anonimizada ya no tiene clave.
+
+// largoClaveDatos es el largo en bytes de la clave de cada usuario
+const largoClaveDatos = 32
+
+// datosPersonales son los campos de un Usuario que se cifran en los eventos
+type datosPersonales struct {
+	Nombre   string `json:"nombre"`
+	Email    string `json:"email,omitempty"`
+	Telefono string `json:"telefono,omitempty"`
+}
+
+// ocultarDatos retorna el evento con los datos personales de sus usuarios
+// cifrados. Crea y registra en el diario la clave de los usuarios que aún no
+// tienen una. Quien llama debe tener el bloqueo exclusivo
+func (b *Biblioteca) ocultarDatos(e Evento) (Evento, error) {
+	if len(e.Cambios.Usuarios) == 0 {
+		return e, nil
+	}
+	nuevas := make(map[int][]byte)
+	e.Cambios.Usuarios = slices.Clone(e.Cambios.Usuarios)
+	e.DatosPersonales = make(map[int]string, len(e.Cambios.Usuarios))
+	for i, usuario := range e.Cambios.Usuarios {
+		if usuario.Anonimizado {
+			continue
+		}
+		clave, ok := b.clavesDatos[usuario.ID]
+		if !ok {
+			clave = make([]byte, largoClaveDatos)
+			if _, err := rand.Read(clave); err != nil {
+				return Evento{}, err
+			}
+			nuevas[usuario.ID] = clave
+		}
+		cifrado, err := cifrarDatos(clave, datosPersonales{usuario.Nombre, usuario.Email, usuario.Telefono})
+		if err != nil {
+			return Evento{}, err
+		}
+		e.DatosPersonales[usuario.ID] = cifrado
+		usuario.Nombre, usuario.Email, usuario.Telefono = "", "", ""
+		e.Cambios.Usuarios[i] = usuario
+	}
+	if len(nuevas) > 0 {
+		if err := b.registrar(entradaDiario{ProximoID: b.proximoID, ClavesDatos: nuevas}); err != nil {
+			return Evento{}, err
+		}
+		maps.Copy(b.clavesDatos, nuevas)
+	}
+	return e, nil
+}
+
+// mostrarDatos retorna el evento con los datos personales descifrados. Los
+// de un usuario sin clave quedan como en su cuenta anonimizada. El evento
+// debe ser una copia
+func (b *Biblioteca) mostrarDatos(e Evento) Evento {
+	for i, usuario := range e.Cambios.Usuarios {
+		cifrado, ok := e.DatosPersonales[usuario.ID]
+		if !ok {
+			continue
+		}
+		datos, err := descifrarDatos(b.clavesDatos[usuario.ID], cifrado)
+		if err != nil {
+			datos = datosPersonales{Nombre: nombreAnonimizado(usuario.ID)}
+		}
+		usuario.Nombre, usuario.Email, usuario.Telefono = datos.Nombre, datos.Email, datos.Telefono
+		e.Cambios.Usuarios[i] = usuario
+	}
+	e.DatosPersonales = nil
+	return e
+}
+
+// cifrarDatos cifra datos con AES-GCM. El resultado es el nonce seguido del
+// texto cifrado, en base64
+func cifrarDatos(clave []byte, datos datosPersonales) (string, error) {
+	aead, err := nuevoAEAD(clave)
+	if err != nil {
+		return "", err
+	}
+	texto, err := json.Marshal(datos)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.RawStdEncoding.EncodeToString(aead.Seal(nonce, nonce, texto, nil)), nil
+}
+
+// descifrarDatos es la inversa de cifrarDatos. Falla si la clave no es la
+// que los cifró
+func descifrarDatos(clave []byte, cifrado string) (datosPersonales, error) {
+	aead, err := nuevoAEAD(clave)
+	if err != nil {
+		return datosPersonales{}, err
+	}
+	bytes, err := base64.RawStdEncoding.DecodeString(cifrado)
+	if err != nil {
+		return datosPersonales{}, err
+	}
+	if len(bytes) < aead.NonceSize() {
+		return datosPersonales{}, fmt.Errorf("Datos personales cifrados demasiado cortos")
+	}
+	nonce, bytes := bytes[:aead.NonceSize()], bytes[aead.NonceSize():]
+	texto, err := aead.Open(nil, nonce, bytes, nil)
+	if err != nil {
+		return datosPersonales{}, err
+	}
+	var datos datosPersonales
+	err = json.Unmarshal(texto, &datos)
+	return datos, err
+}
+
+func nuevoAEAD(clave []byte) (cipher.AEAD, error) {
+	bloque, err := aes.NewCipher(clave)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(bloque)
+}
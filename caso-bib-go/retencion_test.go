@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// nombresEnEventos retorna los nombres con que aparece el usuario id en los
+// eventos que lo cambiaron
+func nombresEnEventos(b *Biblioteca, id int) []string {
+	var nombres []string
+	for _, e := range b.Eventos(FiltroEventos{UsuarioID: id}) {
+		for _, usuario := range e.Cambios.Usuarios {
+			if usuario.ID == id {
+				nombres = append(nombres, usuario.Nombre)
+			}
+		}
+	}
+	return nombres
+}
+
+func TestAnonimizarNoReescribeLosEventos(t *testing.T) {
+	b, reloj := nuevaBibliotecaPrueba(t)
+	ruta := filepath.Join(t.TempDir(), "biblioteca.json")
+	if err := b.Guardar(ruta); err != nil {
+		t.Fatal(err)
+	}
+	rosa := registrarUsuarioPrueba(t, b, "rosa")
+	pedro := registrarUsuarioPrueba(t, b, "pedro")
+	if _, err := b.CerrarCuenta(Sistema, rosa.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	antes, err := os.ReadFile(ruta + extensionEventos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dato := range []string{"rosa", "pedro"} {
+		if bytes.Contains(antes, []byte(dato)) {
+			t.Errorf("el archivo de eventos guarda %q en claro", dato)
+		}
+	}
+	if got := nombresEnEventos(b, rosa.ID); !slices.Equal(got, []string{"rosa", "rosa"}) {
+		t.Errorf("antes de anonimizar los eventos de rosa la nombran %q", got)
+	}
+
+	reloj.Avanzar(731 * 24 * time.Hour)
+	n, err := b.AnonimizarCuentas(Sistema)
+	if err != nil || n != 1 {
+		t.Fatalf("AnonimizarCuentas = %d, %v; se esperaba 1", n, err)
+	}
+
+	despues, err := os.ReadFile(ruta + extensionEventos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(despues, antes) || len(despues) == len(antes) {
+		t.Error("anonimizar debe agregar un evento sin cambiar los anteriores")
+	}
+
+	anonimo := nombreAnonimizado(rosa.ID)
+	want := []string{anonimo, anonimo, anonimo}
+	if got := nombresEnEventos(b, rosa.ID); !slices.Equal(got, want) {
+		t.Errorf("después de anonimizar los eventos de rosa la nombran %q, se esperaba %q", got, want)
+	}
+	if got := nombresEnEventos(b, pedro.ID); !slices.Equal(got, []string{"pedro"}) {
+		t.Errorf("los eventos de pedro lo nombran %q", got)
+	}
+
+	cargada, err := Cargar(ruta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cargada.Cerrar()
+	if got := nombresEnEventos(cargada, rosa.ID); !slices.Equal(got, want) {
+		t.Errorf("después de Cargar los eventos de rosa la nombran %q", got)
+	}
+	if got := nombresEnEventos(cargada, pedro.ID); !slices.Equal(got, []string{"pedro"}) {
+		t.Errorf("después de Cargar los eventos de pedro lo nombran %q", got)
+	}
+
+	reconstruida, err := Reconstruir(b.Nombre, b.Direccion, b.Eventos(FiltroEventos{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := reconstruida.ListarUsuarios(), b.ListarUsuarios(); !slices.Equal(got, want) {
+		t.Errorf("Reconstruir llega a los usuarios\n%+v\nen vez de\n%+v", got, want)
+	}
+}
@@ -0,0 +1,525 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ==========================================
+// SUCURSALES Y TRASLADOS
+// ==========================================
+// Una biblioteca puede ser una red de sucursales que comparten catálogo y
+// usuarios. Cada ejemplar pertenece al fondo de una Sucursal y además está
+// en una, SucursalActual, que puede ser otra: se presta donde está y se
+// puede devolver en cualquier sucursal.
+//
+// Un ejemplar pasa de una sucursal a otra con un Traslado: se solicita, se
+// envía (el ejemplar queda en_transito) y se recibe en el destino, que pasa
+// a ser su sucursal y la de su fondo. Un ejemplar que queda disponible
+// fuera de su sucursal, por ejemplo porque se devolvió en otra, vuelve solo:
+// se crea un traslado ya enviado hacia su fondo.
+//
+// Mientras no haya sucursales todo funciona como una biblioteca de una sola
+// sede. La primera sucursal es la principal y se queda con todos los
+// ejemplares que ya existían; los ejemplares nuevos van a la principal si no
+// se indica otra.
+
+// Sucursal es una sede de la red
+type Sucursal struct {
+	Codigo    string `json:"codigo"`
+	Nombre    string `json:"nombre"`
+	Direccion string `json:"direccion,omitempty"`
+	Principal bool   `json:"principal,omitempty"`
+}
+
+// EstadoTraslado es el punto en el que está un traslado
+type EstadoTraslado string
+
+const (
+	TrasladoSolicitado EstadoTraslado = "solicitado"
+	TrasladoEnTransito EstadoTraslado = "en_transito"
+	TrasladoRecibido   EstadoTraslado = "recibido"
+	TrasladoCancelado  EstadoTraslado = "cancelado"
+)
+
+// Traslado lleva un ejemplar de la sucursal Origen a Destino. LibroID
+// repite el título del ejemplar, como en Prestamo
+type Traslado struct {
+	ID             int            `json:"id"`
+	EjemplarID     int            `json:"ejemplar_id"`
+	LibroID        int            `json:"libro_id"`
+	Origen         string         `json:"origen"`
+	Destino        string         `json:"destino"`
+	Estado         EstadoTraslado `json:"estado"`
+	Motivo         string         `json:"motivo,omitempty"`
+	FechaSolicitud time.Time      `json:"fecha_solicitud"`
+	FechaEnvio     time.Time      `json:"fecha_envio,omitzero"`
+	FechaRecepcion time.Time      `json:"fecha_recepcion,omitzero"`
+}
+
+// Abierto indica si el traslado todavía no se recibió ni se canceló
+func (t Traslado) Abierto() bool {
+	return t.Estado == TrasladoSolicitado || t.Estado == TrasladoEnTransito
+}
+
+// Paradero dice dónde está un ejemplar ahora. SucursalActual queda vacía
+// mientras el ejemplar está prestado o viajando; Destino es adonde viaja y
+// UsuarioID quién lo tiene prestado o apartado
+type Paradero struct {
+	EjemplarID     int            `json:"ejemplar_id"`
+	CodigoBarras   string         `json:"codigo_barras"`
+	Estado         EstadoEjemplar `json:"estado"`
+	Sucursal       string         `json:"sucursal,omitempty"`
+	SucursalActual string         `json:"sucursal_actual,omitempty"`
+	Destino        string         `json:"destino,omitempty"`
+	UsuarioID      int            `json:"usuario_id,omitempty"`
+	Desde          time.Time      `json:"desde,omitzero"`
+}
+
+// String describe el paradero en una línea
+func (p Paradero) String() string {
+	donde := ""
+	switch {
+	case p.Estado == EjemplarPrestado:
+		donde = fmt.Sprintf("prestado al usuario %d", p.UsuarioID)
+	case p.Estado == EjemplarEnTransito:
+		donde = fmt.Sprintf("en tránsito a %s", p.Destino)
+	case p.SucursalActual != "":
+		donde = fmt.Sprintf("en %s (%s)", p.SucursalActual, p.Estado)
+	default:
+		donde = string(p.Estado)
+	}
+	if p.Estado == EjemplarApartado {
+		donde += fmt.Sprintf(" para el usuario %d", p.UsuarioID)
+	}
+	if p.Sucursal != "" && p.Sucursal != p.SucursalActual {
+		donde += fmt.Sprintf(", fondo de %s", p.Sucursal)
+	}
+	return fmt.Sprintf("[%d] %s: %s", p.EjemplarID, p.CodigoBarras, donde)
+}
+
+// paradero arma el paradero de un ejemplar sin tomar el bloqueo
+func (b *Biblioteca) paradero(ejemplar Ejemplar) Paradero {
+	p := Paradero{
+		EjemplarID:     ejemplar.ID,
+		CodigoBarras:   ejemplar.CodigoBarras,
+		Estado:         ejemplar.Estado,
+		Sucursal:       ejemplar.Sucursal,
+		SucursalActual: ejemplar.SucursalActual,
+		Desde:          ejemplar.EstadoDesde,
+	}
+	switch ejemplar.Estado {
+	case EjemplarPrestado:
+		p.SucursalActual = ""
+		if prestamo, ok := b.prestamos.ActivoPorEjemplar(ejemplar.ID); ok {
+			p.UsuarioID = prestamo.UsuarioID
+		}
+	case EjemplarEnTransito:
+		p.SucursalActual = ""
+		if traslado, ok := b.trasladoAbierto(ejemplar.ID); ok {
+			p.Destino = traslado.Destino
+		}
+	case EjemplarApartado:
+		for _, reserva := range b.reservas.PendientesPorLibro(ejemplar.LibroID) {
+			if reserva.Estado == ReservaApartada && reserva.EjemplarID == ejemplar.ID {
+				p.UsuarioID = reserva.UsuarioID
+			}
+		}
+	}
+	return p
+}
+
+// ==========================================
+// SUCURSALES
+// ==========================================
+
+// AgregarSucursal suma una sucursal a la red. La primera es la principal y
+// se queda con todos los ejemplares que ya existían
+func (b *Biblioteca) AgregarSucursal(actor Actor, codigo, nombre, direccion string) (*Sucursal, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.autorizar(actor, PermisoAdministrar, 0); err != nil {
+		return nil, err
+	}
+	tx := b.iniciar(actor, EventoSucursalAgregada)
+	codigo = strings.TrimSpace(codigo)
+	nombre = strings.TrimSpace(nombre)
+	if codigo == "" || nombre == "" {
+		return nil, nuevoError(ErrDatosInvalidos, "Debe indicar el código y el nombre de la sucursal")
+	}
+	if strings.ContainsAny(codigo, " /") {
+		return nil, nuevoError(ErrDatosInvalidos, "El código de sucursal '%s' no puede tener espacios ni '/'", codigo)
+	}
+	if _, existe := tx.sucursal(codigo); existe {
+		return nil, nuevoError(ErrConflicto, "Ya existe una sucursal '%s'", codigo)
+	}
+
+	sucursal := Sucursal{
+		Codigo:    codigo,
+		Nombre:    nombre,
+		Direccion: strings.TrimSpace(direccion),
+		Principal: len(b.sucursales) == 0,
+	}
+	if sucursal.Principal {
+		for _, ejemplar := range b.ejemplares.Listar() {
+			ejemplar.Sucursal = codigo
+			ejemplar.SucursalActual = codigo
+			tx.guardarEjemplar(ejemplar)
+		}
+	}
+	tx.guardarSucursal(sucursal)
+	if err := tx.confirmar(); err != nil {
+		return nil, err
+	}
+	return &sucursal, nil
+}
+
+// ListarSucursales retorna las sucursales ordenadas por código
+func (b *Biblioteca) ListarSucursales() []Sucursal {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.listarSucursales()
+}
+
+// BuscarSucursal busca una sucursal por código
+func (b *Biblioteca) BuscarSucursal(codigo string) *Sucursal {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	sucursal, ok := b.sucursales[strings.TrimSpace(codigo)]
+	if !ok {
+		return nil
+	}
+	return &sucursal
+}
+
+// EjemplaresDeSucursal retorna el paradero de los ejemplares del fondo de
+// una sucursal y de los que están en ella aunque sean de otra. No incluye
+// los retirados
+func (b *Biblioteca) EjemplaresDeSucursal(codigo string) ([]Paradero, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	codigo = strings.TrimSpace(codigo)
+	if _, ok := b.sucursales[codigo]; !ok {
+		return nil, nuevoError(ErrNoEncontrado, "No existe una sucursal '%s'", codigo)
+	}
+	paraderos := make([]Paradero, 0)
+	for _, ejemplar := range b.ejemplares.Listar() {
+		if ejemplar.Estado == EjemplarRetirado {
+			continue
+		}
+		if ejemplar.Sucursal == codigo || ejemplar.SucursalActual == codigo {
+			paraderos = append(paraderos, b.paradero(ejemplar))
+		}
+	}
+	return paraderos, nil
+}
+
+// listarSucursales retorna las sucursales sin tomar el bloqueo
+func (b *Biblioteca) listarSucursales() []Sucursal {
+	sucursales := make([]Sucursal, 0, len(b.sucursales))
+	for _, codigo := range slices.Sorted(maps.Keys(b.sucursales)) {
+		sucursales = append(sucursales, b.sucursales[codigo])
+	}
+	return sucursales
+}
+
+// sucursalDeAlta decide a qué sucursal va un ejemplar nuevo: la indicada,
+// que debe existir, o la principal. Sin sucursales no va a ninguna
+func (tx *transaccion) sucursalDeAlta(codigo string) (string, error) {
+	codigo = strings.TrimSpace(codigo)
+	if codigo != "" {
+		if _, ok := tx.sucursal(codigo); !ok {
+			return "", nuevoError(ErrNoEncontrado, "No existe una sucursal '%s'", codigo)
+		}
+		return codigo, nil
+	}
+	for _, sucursal := range tx.b.sucursales {
+		if sucursal.Principal {
+			return sucursal.Codigo, nil
+		}
+	}
+	return "", nil
+}
+
+// ==========================================
+// TRASLADOS
+// ==========================================
+
+// SolicitarTraslado pide llevar un ejemplar de la sucursal en la que está a
+// destino. El ejemplar sale cuando se envía, así que puede pedirse aunque
+// ahora esté prestado o apartado
+func (b *Biblioteca) SolicitarTraslado(actor Actor, ejemplarID int, destino, motivo string) (*Traslado, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.autorizar(actor, PermisoCirculacion, 0); err != nil {
+		return nil, err
+	}
+	tx := b.iniciar(actor, EventoTrasladoSolicitado)
+	ejemplar, ok := tx.ejemplar(ejemplarID)
+	if !ok {
+		return nil, nuevoError(ErrNoEncontrado, "No existe un ejemplar con ID '%d'", ejemplarID)
+	}
+	destino = strings.TrimSpace(destino)
+	if _, ok := tx.sucursal(destino); !ok {
+		return nil, nuevoError(ErrNoEncontrado, "No existe una sucursal '%s'", destino)
+	}
+	if ejemplar.SucursalActual == destino {
+		return nil, nuevoError(ErrNoPermitido, "El ejemplar '%s' ya está en %s", ejemplar.CodigoBarras, destino)
+	}
+	if ejemplar.Estado == EjemplarRetirado || ejemplar.Estado == EjemplarPerdido {
+		return nil, nuevoError(ErrNoPermitido, "El ejemplar '%s' está %s", ejemplar.CodigoBarras, ejemplar.Estado)
+	}
+	if otro, ok := tx.trasladoAbiertoDe(ejemplarID); ok {
+		return nil, nuevoError(ErrConflicto, "El ejemplar '%s' ya tiene el traslado %d %s",
+			ejemplar.CodigoBarras, otro.ID, otro.Estado)
+	}
+
+	traslado := Traslado{
+		ID:             tx.nuevoID(),
+		EjemplarID:     ejemplar.ID,
+		LibroID:        ejemplar.LibroID,
+		Origen:         ejemplar.SucursalActual,
+		Destino:        destino,
+		Estado:         TrasladoSolicitado,
+		Motivo:         strings.TrimSpace(motivo),
+		FechaSolicitud: tx.ahora,
+	}
+	tx.guardarTraslado(traslado)
+	if err := tx.confirmar(); err != nil {
+		return nil, err
+	}
+	return &traslado, nil
+}
+
+// EnviarTraslado despacha un traslado solicitado: el ejemplar, que debe
+// estar disponible en el origen, queda en tránsito
+func (b *Biblioteca) EnviarTraslado(actor Actor, id int) (*Traslado, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.autorizar(actor, PermisoCirculacion, 0); err != nil {
+		return nil, err
+	}
+	tx := b.iniciar(actor, EventoTrasladoEnviado)
+	traslado, ok := tx.traslado(id)
+	if !ok {
+		return nil, nuevoError(ErrNoEncontrado, "No existe un traslado con ID '%d'", id)
+	}
+	if traslado.Estado != TrasladoSolicitado {
+		return nil, nuevoError(ErrNoPermitido, "El traslado %d ya está %s", id, traslado.Estado)
+	}
+	tx.vencerReservas(traslado.LibroID, tx.ahora)
+	ejemplar, _ := tx.ejemplar(traslado.EjemplarID)
+	if ejemplar.Estado != EjemplarDisponible {
+		return nil, nuevoError(ErrNoPermitido, "El ejemplar '%s' no está disponible para enviarlo (%s)",
+			ejemplar.CodigoBarras, ejemplar.Estado)
+	}
+	// Mientras se esperaba el envío el ejemplar pudo cambiar de sucursal
+	if ejemplar.SucursalActual == traslado.Destino {
+		return nil, nuevoError(ErrNoPermitido, "El ejemplar '%s' ya está en %s: cancele el traslado",
+			ejemplar.CodigoBarras, traslado.Destino)
+	}
+	traslado.Origen = ejemplar.SucursalActual
+	if err := tx.enviar(&traslado, &ejemplar); err != nil {
+		return nil, err
+	}
+	if err := tx.confirmar(); err != nil {
+		return nil, err
+	}
+	return &traslado, nil
+}
+
+// enviar pone en tránsito el ejemplar de un traslado y guarda los dos
+func (tx *transaccion) enviar(traslado *Traslado, ejemplar *Ejemplar) error {
+	motivo := fmt.Sprintf("traslado %d a %s", traslado.ID, traslado.Destino)
+	if ejemplar.EstadoDesde.Equal(tx.ahora) && ejemplar.Motivo != "" {
+		motivo = ejemplar.Motivo + "; " + motivo
+	}
+	if err := ejemplar.cambiarEstado(EjemplarEnTransito, tx.ahora, motivo); err != nil {
+		return err
+	}
+	traslado.Estado = TrasladoEnTransito
+	traslado.FechaEnvio = tx.ahora
+	tx.guardarTraslado(*traslado)
+	tx.guardarEjemplar(*ejemplar)
+	return nil
+}
+
+// RecibirTraslado registra la llegada de un ejemplar a destino. El destino
+// pasa a ser su sucursal y, si alguien espera el libro, queda apartado ahí
+func (b *Biblioteca) RecibirTraslado(actor Actor, id int) (*Traslado, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.autorizar(actor, PermisoCirculacion, 0); err != nil {
+		return nil, err
+	}
+	tx := b.iniciar(actor, EventoTrasladoRecibido)
+	traslado, ok := tx.traslado(id)
+	if !ok {
+		return nil, nuevoError(ErrNoEncontrado, "No existe un traslado con ID '%d'", id)
+	}
+	if traslado.Estado != TrasladoEnTransito {
+		return nil, nuevoError(ErrNoPermitido, "El traslado %d está %s, no en tránsito", id, traslado.Estado)
+	}
+	tx.vencerReservas(traslado.LibroID, tx.ahora)
+	ejemplar, _ := tx.ejemplar(traslado.EjemplarID)
+	ejemplar.Sucursal = traslado.Destino
+	ejemplar.SucursalActual = traslado.Destino
+	motivo := fmt.Sprintf("recibido en %s", traslado.Destino)
+	if err := ejemplar.cambiarEstado(EjemplarDisponible, tx.ahora, motivo); err != nil {
+		return nil, err
+	}
+	traslado.Estado = TrasladoRecibido
+	traslado.FechaRecepcion = tx.ahora
+	tx.guardarTraslado(traslado)
+	if err := tx.asignarEjemplar(ejemplar, tx.ahora); err != nil {
+		return nil, err
+	}
+	if err := tx.confirmar(); err != nil {
+		return nil, err
+	}
+	return &traslado, nil
+}
+
+// CancelarTraslado anula un traslado que todavía no se envió. Un ejemplar
+// que esperaba el envío fuera de su sucursal vuelve a ella
+func (b *Biblioteca) CancelarTraslado(actor Actor, id int) (*Traslado, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.autorizar(actor, PermisoCirculacion, 0); err != nil {
+		return nil, err
+	}
+	tx := b.iniciar(actor, EventoTrasladoCancelado)
+	traslado, ok := tx.traslado(id)
+	if !ok {
+		return nil, nuevoError(ErrNoEncontrado, "No existe un traslado con ID '%d'", id)
+	}
+	if traslado.Estado != TrasladoSolicitado {
+		return nil, nuevoError(ErrNoPermitido, "El traslado %d está %s: solo se cancela antes de enviarlo", id, traslado.Estado)
+	}
+	traslado.Estado = TrasladoCancelado
+	tx.guardarTraslado(traslado)
+	// Si el ejemplar esperaba el envío fuera de su sucursal, vuelve a ella
+	if ejemplar, _ := tx.ejemplar(traslado.EjemplarID); ejemplar.Estado == EjemplarDisponible {
+		if err := tx.volverAlFondo(&ejemplar); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.confirmar(); err != nil {
+		return nil, err
+	}
+	return &traslado, nil
+}
+
+// FiltroTraslados elige qué traslados listar. Los campos vacíos no filtran;
+// Sucursal acepta tanto el origen como el destino
+type FiltroTraslados struct {
+	Abiertos bool
+	Sucursal string
+}
+
+// ListarTraslados retorna los traslados que cumplen el filtro, por ID
+func (b *Biblioteca) ListarTraslados(filtro FiltroTraslados) []Traslado {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	traslados := make([]Traslado, 0)
+	for _, traslado := range b.listarTraslados() {
+		if filtro.Abiertos && !traslado.Abierto() {
+			continue
+		}
+		if filtro.Sucursal != "" && traslado.Origen != filtro.Sucursal && traslado.Destino != filtro.Sucursal {
+			continue
+		}
+		traslados = append(traslados, traslado)
+	}
+	return traslados
+}
+
+// BuscarTraslado busca un traslado por ID
+func (b *Biblioteca) BuscarTraslado(id int) *Traslado {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	traslado, ok := b.traslados[id]
+	if !ok {
+		return nil
+	}
+	return &traslado
+}
+
+// listarTraslados retorna los traslados por ID sin tomar el bloqueo
+func (b *Biblioteca) listarTraslados() []Traslado {
+	traslados := make([]Traslado, 0, len(b.traslados))
+	for _, id := range slices.Sorted(maps.Keys(b.traslados)) {
+		traslados = append(traslados, b.traslados[id])
+	}
+	return traslados
+}
+
+// trasladoAbierto retorna el traslado sin recibir ni cancelar de un
+// ejemplar; hay a lo sumo uno
+func (b *Biblioteca) trasladoAbierto(ejemplarID int) (Traslado, bool) {
+	for _, traslado := range b.traslados {
+		if traslado.EjemplarID == ejemplarID && traslado.Abierto() {
+			return traslado, true
+		}
+	}
+	return Traslado{}, false
+}
+
+// volverAlFondo manda a su sucursal un ejemplar que quedó disponible en
+// otra, con un traslado ya enviado. Un traslado solicitado del ejemplar se
+// respeta: lo llevará adonde se pidió cuando se envíe
+func (tx *transaccion) volverAlFondo(ejemplar *Ejemplar) error {
+	if ejemplar.Sucursal == "" || ejemplar.SucursalActual == "" || ejemplar.SucursalActual == ejemplar.Sucursal {
+		return nil
+	}
+	if _, ok := tx.trasladoAbiertoDe(ejemplar.ID); ok {
+		return nil
+	}
+	traslado := Traslado{
+		ID:             tx.nuevoID(),
+		EjemplarID:     ejemplar.ID,
+		LibroID:        ejemplar.LibroID,
+		Origen:         ejemplar.SucursalActual,
+		Destino:        ejemplar.Sucursal,
+		Motivo:         "vuelta al fondo",
+		FechaSolicitud: tx.ahora,
+	}
+	return tx.enviar(&traslado, ejemplar)
+}
+
+// cerrarTraslado cancela el traslado abierto de un ejemplar que se pierde o
+// se da de baja, haya salido o no
+func (tx *transaccion) cerrarTraslado(ejemplarID int) {
+	if traslado, ok := tx.trasladoAbiertoDe(ejemplarID); ok {
+		traslado.Estado = TrasladoCancelado
+		tx.guardarTraslado(traslado)
+	}
+}
+
+// trasladoAbiertoDe es trasladoAbierto tal como lo ve la transacción
+func (tx *transaccion) trasladoAbiertoDe(ejemplarID int) (Traslado, bool) {
+	for _, traslado := range tx.traslados {
+		if traslado.EjemplarID == ejemplarID && traslado.Abierto() {
+			return traslado, true
+		}
+	}
+	traslado, ok := tx.b.trasladoAbierto(ejemplarID)
+	if ok {
+		if propio, cambiado := tx.traslados[traslado.ID]; cambiado && !propio.Abierto() {
+			return Traslado{}, false
+		}
+	}
+	return traslado, ok
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// bibliotecaConSucursales crea una biblioteca con la sucursal principal
+// centro y la sucursal norte
+func bibliotecaConSucursales(t *testing.T) (*Biblioteca, *RelojFijo) {
+	t.Helper()
+	b, reloj := nuevaBibliotecaPrueba(t)
+	for _, codigo := range []string{"centro", "norte"} {
+		if _, err := b.AgregarSucursal(Sistema, codigo, "Sucursal "+codigo, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return b, reloj
+}
+
+// comprobarEjemplar compara el estado y las sucursales de un ejemplar
+func comprobarEjemplar(t *testing.T, b *Biblioteca, id int, estado EstadoEjemplar, sucursal, actual string) {
+	t.Helper()
+	verificar(t, b)
+	e := b.BuscarEjemplar(id)
+	if e.Estado != estado || e.Sucursal != sucursal || e.SucursalActual != actual {
+		t.Errorf("ejemplar %s, fondo %q, en %q; se esperaba %s, fondo %q, en %q",
+			e.Estado, e.Sucursal, e.SucursalActual, estado, sucursal, actual)
+	}
+}
+
+func TestTrasladoSolicitadoEnviadoYRecibido(t *testing.T) {
+	b, _ := bibliotecaConSucursales(t)
+	libro := agregarLibroPrueba(t, b, "Rayuela")
+	ejemplarID := primerEjemplar(t, b, libro.ID)
+	comprobarEjemplar(t, b, ejemplarID, EjemplarDisponible, "centro", "centro")
+
+	if _, err := b.SolicitarTraslado(Sistema, ejemplarID, "centro", ""); !errors.Is(err, ErrNoPermitido) {
+		t.Errorf("trasladar adonde ya está: err = %v, se esperaba ErrNoPermitido", err)
+	}
+	traslado, err := b.SolicitarTraslado(Sistema, ejemplarID, "norte", "pedido de norte")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Solicitado, el ejemplar sigue en el estante del origen
+	comprobarEjemplar(t, b, ejemplarID, EjemplarDisponible, "centro", "centro")
+	if traslado.Estado != TrasladoSolicitado || traslado.Origen != "centro" {
+		t.Errorf("traslado = %+v", traslado)
+	}
+	if _, err := b.SolicitarTraslado(Sistema, ejemplarID, "norte", ""); !errors.Is(err, ErrConflicto) {
+		t.Errorf("segundo traslado del ejemplar: err = %v, se esperaba ErrConflicto", err)
+	}
+	if _, err := b.RecibirTraslado(Sistema, traslado.ID); !errors.Is(err, ErrNoPermitido) {
+		t.Errorf("recibir sin enviar: err = %v, se esperaba ErrNoPermitido", err)
+	}
+
+	if _, err := b.EnviarTraslado(Sistema, traslado.ID); err != nil {
+		t.Fatal(err)
+	}
+	comprobarEjemplar(t, b, ejemplarID, EjemplarEnTransito, "centro", "centro")
+	if _, err := b.CancelarTraslado(Sistema, traslado.ID); !errors.Is(err, ErrNoPermitido) {
+		t.Errorf("cancelar un traslado enviado: err = %v, se esperaba ErrNoPermitido", err)
+	}
+	ana := registrarUsuarioPrueba(t, b, "ana")
+	if _, err := b.PrestarLibro(Sistema, libro.ID, ana.ID); !errors.Is(err, ErrNoPermitido) {
+		t.Errorf("prestar un ejemplar en tránsito: err = %v, se esperaba ErrNoPermitido", err)
+	}
+
+	recibido, err := b.RecibirTraslado(Sistema, traslado.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recibido.Estado != TrasladoRecibido {
+		t.Errorf("traslado en estado %s después de recibirlo", recibido.Estado)
+	}
+	// El destino pasa a ser su fondo
+	comprobarEjemplar(t, b, ejemplarID, EjemplarDisponible, "norte", "norte")
+	if abiertos := b.ListarTraslados(FiltroTraslados{Abiertos: true}); len(abiertos) != 0 {
+		t.Errorf("quedaron traslados abiertos: %+v", abiertos)
+	}
+}
+
+func TestTrasladoCancelado(t *testing.T) {
+	b, _ := bibliotecaConSucursales(t)
+	libro := agregarLibroPrueba(t, b, "Ficciones")
+	ejemplarID := primerEjemplar(t, b, libro.ID)
+	traslado, err := b.SolicitarTraslado(Sistema, ejemplarID, "norte", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancelado, err := b.CancelarTraslado(Sistema, traslado.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cancelado.Estado != TrasladoCancelado {
+		t.Errorf("traslado en estado %s después de cancelarlo", cancelado.Estado)
+	}
+	comprobarEjemplar(t, b, ejemplarID, EjemplarDisponible, "centro", "centro")
+	if _, err := b.EnviarTraslado(Sistema, traslado.ID); !errors.Is(err, ErrNoPermitido) {
+		t.Errorf("enviar un traslado cancelado: err = %v, se esperaba ErrNoPermitido", err)
+	}
+	// Cancelado, se puede pedir otro
+	if _, err := b.SolicitarTraslado(Sistema, ejemplarID, "norte", ""); err != nil {
+		t.Errorf("SolicitarTraslado después de cancelar: %v", err)
+	}
+	verificar(t, b)
+}
+
+func TestDevolucionEnOtraSucursalVuelveAlFondo(t *testing.T) {
+	b, _ := bibliotecaConSucursales(t)
+	libro := agregarLibroPrueba(t, b, "El Aleph")
+	ejemplarID := primerEjemplar(t, b, libro.ID)
+	ana := registrarUsuarioPrueba(t, b, "ana")
+	beto := registrarUsuarioPrueba(t, b, "beto")
+
+	// Devuelto en norte, vuelve solo a centro
+	if _, err := b.PrestarLibro(Sistema, libro.ID, ana.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.DevolverLibro(Sistema, libro.ID, "norte"); err != nil {
+		t.Fatal(err)
+	}
+	comprobarEjemplar(t, b, ejemplarID, EjemplarEnTransito, "centro", "norte")
+	abiertos := b.ListarTraslados(FiltroTraslados{Abiertos: true})
+	if len(abiertos) != 1 || abiertos[0].Origen != "norte" || abiertos[0].Destino != "centro" ||
+		abiertos[0].Estado != TrasladoEnTransito {
+		t.Fatalf("traslados abiertos = %+v; se esperaba uno en tránsito de norte a centro", abiertos)
+	}
+	if _, err := b.RecibirTraslado(Sistema, abiertos[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	comprobarEjemplar(t, b, ejemplarID, EjemplarDisponible, "centro", "centro")
+
+	// Si alguien lo espera, queda apartado donde se devolvió y vuelve
+	// recién cuando se libera
+	if _, err := b.PrestarLibro(Sistema, libro.ID, ana.ID); err != nil {
+		t.Fatal(err)
+	}
+	reserva := reservar(t, b, libro.ID, beto.ID)
+	if _, err := b.DevolverLibro(Sistema, libro.ID, "norte"); err != nil {
+		t.Fatal(err)
+	}
+	comprobarEjemplar(t, b, ejemplarID, EjemplarApartado, "centro", "norte")
+	if _, err := b.CancelarReserva(Sistema, reserva.ID); err != nil {
+		t.Fatal(err)
+	}
+	comprobarEjemplar(t, b, ejemplarID, EjemplarEnTransito, "centro", "norte")
+	if abiertos := b.ListarTraslados(FiltroTraslados{Abiertos: true, Sucursal: "centro"}); len(abiertos) != 1 ||
+		abiertos[0].Destino != "centro" {
+		t.Errorf("traslados abiertos = %+v; se esperaba la vuelta a centro", abiertos)
+	}
+}
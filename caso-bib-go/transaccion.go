@@ -0,0 +1,660 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+)
+
+// ==========================================
+// TRANSACCIONES
+// ==========================================
+// Una operación de la biblioteca puede cambiar varias entidades a la vez (un
+// préstamo cambia el ejemplar, el préstamo y el usuario). Esos cambios se
+// acumulan en una transaccion y se confirman juntos: o quedan todos, o no
+// queda ninguno.
+
+// transaccion acumula los cambios de una operación sin tocar los
+// repositorios hasta confirmar
+type transaccion struct {
+	b            *Biblioteca
+	libros       map[int]Libro
+	ejemplares   map[int]Ejemplar
+	usuarios     map[int]Usuario
+	prestamos    map[int]Prestamo
+	reservas     map[int]Reserva
+	credenciales map[string]Credencial
+	sucursales   map[string]Sucursal
+	traslados    map[int]Traslado
+	proximoID    int
+	ahora        time.Time // la hora de la operación, la misma para todos sus cambios
+	actor        Actor
+	tipo         TipoEvento // el evento que se registra al confirmar
+}
+
+// iniciar abre una transacción sobre la biblioteca para la operación tipo
+// que pide actor. Quien llama debe tener el bloqueo exclusivo hasta
+// confirmar o descartar la transacción.
+func (b *Biblioteca) iniciar(actor Actor, tipo TipoEvento) *transaccion {
+	return &transaccion{
+		b:            b,
+		actor:        actor,
+		tipo:         tipo,
+		libros:       make(map[int]Libro),
+		ejemplares:   make(map[int]Ejemplar),
+		usuarios:     make(map[int]Usuario),
+		prestamos:    make(map[int]Prestamo),
+		reservas:     make(map[int]Reserva),
+		credenciales: make(map[string]Credencial),
+		sucursales:   make(map[string]Sucursal),
+		traslados:    make(map[int]Traslado),
+		proximoID:    b.proximoID,
+		ahora:        b.Reloj.Ahora(),
+	}
+}
+
+// marcar guarda el estado actual de la transacción y retorna una función
+// que lo restaura, para descartar parte de los cambios sin descartar todos
+func (tx *transaccion) marcar() func() {
+	libros, ejemplares, usuarios := maps.Clone(tx.libros), maps.Clone(tx.ejemplares), maps.Clone(tx.usuarios)
+	prestamos, reservas, proximoID := maps.Clone(tx.prestamos), maps.Clone(tx.reservas), tx.proximoID
+	credenciales, sucursales, traslados := maps.Clone(tx.credenciales), maps.Clone(tx.sucursales), maps.Clone(tx.traslados)
+	return func() {
+		tx.libros, tx.ejemplares, tx.usuarios = libros, ejemplares, usuarios
+		tx.prestamos, tx.reservas, tx.proximoID = prestamos, reservas, proximoID
+		tx.credenciales, tx.sucursales, tx.traslados = credenciales, sucursales, traslados
+	}
+}
+
+// nuevoID reserva el siguiente ID; solo se consume si la transacción se
+// confirma
+func (tx *transaccion) nuevoID() int {
+	id := tx.proximoID
+	tx.proximoID++
+	return id
+}
+
+// libro retorna el libro tal como lo ve la transacción
+func (tx *transaccion) libro(id int) (Libro, bool) {
+	if libro, ok := tx.libros[id]; ok {
+		return libro, true
+	}
+	return tx.b.libros.PorID(id)
+}
+
+// ejemplar retorna el ejemplar tal como lo ve la transacción
+func (tx *transaccion) ejemplar(id int) (Ejemplar, bool) {
+	if ejemplar, ok := tx.ejemplares[id]; ok {
+		return ejemplar, true
+	}
+	return tx.b.ejemplares.PorID(id)
+}
+
+// ejemplaresDe retorna los ejemplares del libro, incluidos los agregados o
+// modificados en la transacción, ordenados por ID
+func (tx *transaccion) ejemplaresDe(libroID int) []Ejemplar {
+	var ejemplares []Ejemplar
+	for _, ejemplar := range tx.b.ejemplares.PorLibro(libroID) {
+		if _, modificado := tx.ejemplares[ejemplar.ID]; !modificado {
+			ejemplares = append(ejemplares, ejemplar)
+		}
+	}
+	for _, ejemplar := range tx.ejemplares {
+		if ejemplar.LibroID == libroID {
+			ejemplares = append(ejemplares, ejemplar)
+		}
+	}
+	slices.SortFunc(ejemplares, func(a, c Ejemplar) int { return a.ID - c.ID })
+	return ejemplares
+}
+
+// libroPorISBN busca un libro por ISBN teniendo en cuenta los libros
+// agregados o modificados en la transacción
+func (tx *transaccion) libroPorISBN(isbn ISBN) (Libro, bool) {
+	for _, libro := range tx.libros {
+		if libro.ISBN == isbn {
+			return libro, true
+		}
+	}
+	libro, ok := tx.b.libros.PorISBN(isbn)
+	if _, modificado := tx.libros[libro.ID]; !ok || modificado {
+		return Libro{}, false
+	}
+	return libro, true
+}
+
+// usuario retorna el usuario tal como lo ve la transacción
+func (tx *transaccion) usuario(id int) (Usuario, bool) {
+	if usuario, ok := tx.usuarios[id]; ok {
+		return usuario, true
+	}
+	return tx.b.usuarios.PorID(id)
+}
+
+// usuarioPorEmail busca un usuario por email teniendo en cuenta los
+// usuarios agregados o modificados en la transacción
+func (tx *transaccion) usuarioPorEmail(email string) (Usuario, bool) {
+	for _, usuario := range tx.usuarios {
+		if usuario.Email == email {
+			return usuario, true
+		}
+	}
+	usuario, ok := tx.b.usuarios.PorEmail(email)
+	if _, modificado := tx.usuarios[usuario.ID]; !ok || modificado {
+		return Usuario{}, false
+	}
+	return usuario, true
+}
+
+// prestamoActivo retorna el préstamo no devuelto del ejemplar, teniendo en
+// cuenta los préstamos modificados en la transacción
+func (tx *transaccion) prestamoActivo(ejemplarID int) (Prestamo, bool) {
+	for _, prestamo := range tx.prestamos {
+		if prestamo.EjemplarID == ejemplarID && !prestamo.Devuelto {
+			return prestamo, true
+		}
+	}
+	prestamo, ok := tx.b.prestamos.ActivoPorEjemplar(ejemplarID)
+	if !ok {
+		return Prestamo{}, false
+	}
+	if _, modificado := tx.prestamos[prestamo.ID]; modificado {
+		// Se devolvió dentro de esta transacción
+		return Prestamo{}, false
+	}
+	return prestamo, true
+}
+
+// reservasPendientes retorna las reservas en espera o apartadas del libro
+// tal como las ve la transacción, en orden de llegada
+func (tx *transaccion) reservasPendientes(libroID int) []Reserva {
+	return tx.pendientes(tx.b.reservas.PendientesPorLibro(libroID), func(r Reserva) bool {
+		return r.LibroID == libroID
+	})
+}
+
+// reservasDeUsuario retorna las reservas en espera o apartadas del usuario
+// tal como las ve la transacción, en orden de llegada
+func (tx *transaccion) reservasDeUsuario(usuarioID int) []Reserva {
+	return tx.pendientes(tx.b.reservas.PendientesPorUsuario(usuarioID), func(r Reserva) bool {
+		return r.UsuarioID == usuarioID
+	})
+}
+
+// pendientes combina las reservas guardadas con las modificadas en la
+// transacción que cumplen filtro, y deja solo las pendientes
+func (tx *transaccion) pendientes(guardadas []Reserva, filtro func(Reserva) bool) []Reserva {
+	var reservas []Reserva
+	for _, reserva := range guardadas {
+		if _, modificada := tx.reservas[reserva.ID]; !modificada {
+			reservas = append(reservas, reserva)
+		}
+	}
+	for _, reserva := range tx.reservas {
+		if filtro(reserva) && reserva.Pendiente() {
+			reservas = append(reservas, reserva)
+		}
+	}
+	slices.SortFunc(reservas, func(a, c Reserva) int { return a.ID - c.ID })
+	return reservas
+}
+
+func (tx *transaccion) guardarLibro(libro Libro) {
+	tx.libros[libro.ID] = libro
+}
+
+func (tx *transaccion) guardarEjemplar(ejemplar Ejemplar) {
+	tx.ejemplares[ejemplar.ID] = ejemplar
+}
+
+func (tx *transaccion) guardarUsuario(usuario Usuario) {
+	tx.usuarios[usuario.ID] = usuario
+}
+
+func (tx *transaccion) guardarPrestamo(prestamo Prestamo) {
+	tx.prestamos[prestamo.ID] = prestamo
+}
+
+func (tx *transaccion) guardarReserva(reserva Reserva) {
+	tx.reservas[reserva.ID] = reserva
+}
+
+// credencial retorna la credencial tal como la ve la transacción
+func (tx *transaccion) credencial(nombre string) (Credencial, bool) {
+	if credencial, ok := tx.credenciales[nombre]; ok {
+		return credencial, true
+	}
+	credencial, ok := tx.b.credenciales[nombre]
+	return credencial, ok
+}
+
+func (tx *transaccion) guardarCredencial(credencial Credencial) {
+	tx.credenciales[credencial.Nombre] = credencial
+}
+
+// sucursal retorna la sucursal tal como la ve la transacción
+func (tx *transaccion) sucursal(codigo string) (Sucursal, bool) {
+	if sucursal, ok := tx.sucursales[codigo]; ok {
+		return sucursal, true
+	}
+	sucursal, ok := tx.b.sucursales[codigo]
+	return sucursal, ok
+}
+
+func (tx *transaccion) guardarSucursal(sucursal Sucursal) {
+	tx.sucursales[sucursal.Codigo] = sucursal
+}
+
+// traslado retorna el traslado tal como lo ve la transacción
+func (tx *transaccion) traslado(id int) (Traslado, bool) {
+	if traslado, ok := tx.traslados[id]; ok {
+		return traslado, true
+	}
+	traslado, ok := tx.b.traslados[id]
+	return traslado, ok
+}
+
+func (tx *transaccion) guardarTraslado(traslado Traslado) {
+	tx.traslados[traslado.ID] = traslado
+}
+
+// entrada arma la entrada de diario con los cambios, ordenados por ID
+func (tx *transaccion) entrada() entradaDiario {
+	e := entradaDiario{ProximoID: tx.proximoID}
+	for _, id := range slices.Sorted(maps.Keys(tx.libros)) {
+		e.Libros = append(e.Libros, tx.libros[id])
+	}
+	for _, id := range slices.Sorted(maps.Keys(tx.ejemplares)) {
+		e.Ejemplares = append(e.Ejemplares, tx.ejemplares[id])
+	}
+	for _, id := range slices.Sorted(maps.Keys(tx.usuarios)) {
+		e.Usuarios = append(e.Usuarios, tx.usuarios[id])
+	}
+	for _, id := range slices.Sorted(maps.Keys(tx.prestamos)) {
+		e.Prestamos = append(e.Prestamos, tx.prestamos[id])
+	}
+	for _, id := range slices.Sorted(maps.Keys(tx.reservas)) {
+		e.Reservas = append(e.Reservas, tx.reservas[id])
+	}
+	for _, nombre := range slices.Sorted(maps.Keys(tx.credenciales)) {
+		e.Credenciales = append(e.Credenciales, tx.credenciales[nombre])
+	}
+	for _, codigo := range slices.Sorted(maps.Keys(tx.sucursales)) {
+		e.Sucursales = append(e.Sucursales, tx.sucursales[codigo])
+	}
+	for _, id := range slices.Sorted(maps.Keys(tx.traslados)) {
+		e.Traslados = append(e.Traslados, tx.traslados[id])
+	}
+	return e
+}
+
+// deshacer restaura una entidad a su valor anterior a la confirmación
+type deshacer func() error
+
+// confirmar guarda todos los cambios en los repositorios y en el diario, y
+// registra el evento de la operación si cambió algo. Si algún paso falla,
+// los repositorios vuelven al estado anterior y se retorna el error.
+func (tx *transaccion) confirmar() error {
+	b := tx.b
+	e := tx.entrada()
+	var pendientes []deshacer
+
+	revertir := func(causa error) error {
+		errs := []error{causa}
+		for i := len(pendientes) - 1; i >= 0; i-- {
+			if err := pendientes[i](); err != nil {
+				errs = append(errs, fmt.Errorf("No se pudo deshacer el cambio: %w", err))
+			}
+		}
+		return errors.Join(errs...)
+	}
+
+	for _, libro := range e.Libros {
+		anterior, existia := b.libros.PorID(libro.ID)
+		if err := b.libros.Guardar(libro); err != nil {
+			return revertir(err)
+		}
+		pendientes = append(pendientes, func() error {
+			if existia {
+				return b.libros.Guardar(anterior)
+			}
+			return b.libros.Eliminar(libro.ID)
+		})
+	}
+	for _, ejemplar := range e.Ejemplares {
+		anterior, existia := b.ejemplares.PorID(ejemplar.ID)
+		if err := b.ejemplares.Guardar(ejemplar); err != nil {
+			return revertir(err)
+		}
+		pendientes = append(pendientes, func() error {
+			if existia {
+				return b.ejemplares.Guardar(anterior)
+			}
+			return b.ejemplares.Eliminar(ejemplar.ID)
+		})
+	}
+	for _, usuario := range e.Usuarios {
+		anterior, existia := b.usuarios.PorID(usuario.ID)
+		if err := b.usuarios.Guardar(usuario); err != nil {
+			return revertir(err)
+		}
+		pendientes = append(pendientes, func() error {
+			if existia {
+				return b.usuarios.Guardar(anterior)
+			}
+			return b.usuarios.Eliminar(usuario.ID)
+		})
+	}
+	// Primero los préstamos y reservas que se cierran, para que los índices
+	// no vean dos préstamos abiertos del mismo ejemplar ni dos reservas
+	// pendientes del mismo usuario y libro
+	slices.SortStableFunc(e.Prestamos, func(a, c Prestamo) int {
+		return cerradasPrimero(a.Devuelto, c.Devuelto)
+	})
+	slices.SortStableFunc(e.Reservas, func(a, c Reserva) int {
+		return cerradasPrimero(!a.Pendiente(), !c.Pendiente())
+	})
+	for _, prestamo := range e.Prestamos {
+		anterior, existia := b.prestamos.PorID(prestamo.ID)
+		if err := b.prestamos.Guardar(prestamo); err != nil {
+			return revertir(err)
+		}
+		pendientes = append(pendientes, func() error {
+			if existia {
+				return b.prestamos.Guardar(anterior)
+			}
+			return b.prestamos.Eliminar(prestamo.ID)
+		})
+	}
+
+	for _, reserva := range e.Reservas {
+		anterior, existia := b.reservas.PorID(reserva.ID)
+		if err := b.reservas.Guardar(reserva); err != nil {
+			return revertir(err)
+		}
+		pendientes = append(pendientes, func() error {
+			if existia {
+				return b.reservas.Guardar(anterior)
+			}
+			return b.reservas.Eliminar(reserva.ID)
+		})
+	}
+
+	for _, credencial := range e.Credenciales {
+		anterior, existia := b.credenciales[credencial.Nombre]
+		b.credenciales[credencial.Nombre] = credencial
+		pendientes = append(pendientes, func() error {
+			if existia {
+				b.credenciales[credencial.Nombre] = anterior
+			} else {
+				delete(b.credenciales, credencial.Nombre)
+			}
+			return nil
+		})
+	}
+	for _, sucursal := range e.Sucursales {
+		anterior, existia := b.sucursales[sucursal.Codigo]
+		b.sucursales[sucursal.Codigo] = sucursal
+		pendientes = append(pendientes, func() error {
+			if existia {
+				b.sucursales[sucursal.Codigo] = anterior
+			} else {
+				delete(b.sucursales, sucursal.Codigo)
+			}
+			return nil
+		})
+	}
+	for _, traslado := range e.Traslados {
+		anterior, existia := b.traslados[traslado.ID]
+		b.traslados[traslado.ID] = traslado
+		pendientes = append(pendientes, func() error {
+			if existia {
+				b.traslados[traslado.ID] = anterior
+			} else {
+				delete(b.traslados, traslado.ID)
+			}
+			return nil
+		})
+	}
+
+	if b.ModoEstricto {
+		if err := b.verificarInvariantes(tx.proximoID); err != nil {
+			return revertir(fmt.Errorf("La operación rompe la consistencia de la biblioteca: %w", err))
+		}
+	}
+
+	// Solo se registra lo que ya quedó en los repositorios. El evento va
+	// antes que el diario, y si el diario falla se descarta junto con la
+	// operación: el registro de eventos no puede tener operaciones que no
+	// ocurrieron
+	marca, err := b.marcarEventos()
+	if err != nil {
+		return revertir(err)
+	}
+	pendientes = append(pendientes, func() error { return b.descartarEventos(marca) })
+	if !e.vacia() {
+		if err := b.agregarEvento(Evento{Fecha: tx.ahora, Tipo: tx.tipo, Actor: tx.actor, Cambios: e}); err != nil {
+			return revertir(err)
+		}
+	}
+	if err := b.registrar(e); err != nil {
+		return revertir(err)
+	}
+	b.proximoID = tx.proximoID
+	for _, libro := range e.Libros {
+		b.busqueda.indexar(libro)
+	}
+	return nil
+}
+
+// cerradasPrimero ordena primero las entidades cerradas
+func cerradasPrimero(a, c bool) int {
+	switch {
+	case a == c:
+		return 0
+	case a:
+		return -1
+	default:
+		return 1
+	}
+}
+
+// ==========================================
+// INVARIANTES
+// ==========================================
+
+// VerificarInvariantes revisa que el estado de la biblioteca sea coherente:
+//   - cada ejemplar tiene un estado conocido
+//   - un ejemplar está prestado si y solo si tiene un préstamo activo
+//   - ningún ejemplar tiene más de un préstamo activo
+//   - cada ejemplar apunta a un libro existente
+//   - cada préstamo apunta a un ejemplar y un usuario existentes, y su libro
+//     es el del ejemplar
+//   - un ejemplar está apartado si y solo si una reserva lo tiene apartado
+//   - un ejemplar está en tránsito si y solo si tiene un traslado en
+//     tránsito, y si hay sucursales sus dos sucursales existen
+//   - cada reserva apunta a un libro y un usuario existentes, y el ejemplar
+//     apartado es de ese libro
+//   - ningún libro con reservas en espera tiene ejemplares disponibles
+//   - el contador de préstamos activos de cada usuario es correcto y su
+//     deuda no es negativa
+//   - no hay ISBN, códigos de barras ni emails repetidos, y todos los IDs
+//     (también los de traslados) son menores al próximo
+//
+// Retorna nil si todo está bien, o un error con todas las violaciones.
+func (b *Biblioteca) VerificarInvariantes() error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.verificarInvariantes(b.proximoID)
+}
+
+func (b *Biblioteca) verificarInvariantes(proximoID int) error {
+	var errs []error
+
+	libros := make(map[int]Libro)
+	isbns := make(map[ISBN]int)
+	for _, libro := range b.libros.Listar() {
+		libros[libro.ID] = libro
+		if libro.ID >= proximoID {
+			errs = append(errs, fmt.Errorf("El libro %d tiene un ID mayor o igual al próximo (%d)", libro.ID, proximoID))
+		}
+		if otro, ok := isbns[libro.ISBN]; ok && libro.ISBN != "" {
+			errs = append(errs, fmt.Errorf("Los libros %d y %d comparten el ISBN '%s'", otro, libro.ID, libro.ISBN))
+		}
+		isbns[libro.ISBN] = libro.ID
+	}
+
+	ejemplares := make(map[int]Ejemplar)
+	codigos := make(map[string]int)
+	for _, ejemplar := range b.ejemplares.Listar() {
+		ejemplares[ejemplar.ID] = ejemplar
+		if ejemplar.ID >= proximoID {
+			errs = append(errs, fmt.Errorf("El ejemplar %d tiene un ID mayor o igual al próximo (%d)", ejemplar.ID, proximoID))
+		}
+		if _, ok := libros[ejemplar.LibroID]; !ok {
+			errs = append(errs, fmt.Errorf("El ejemplar %d apunta al libro inexistente %d", ejemplar.ID, ejemplar.LibroID))
+		}
+		if ejemplar.Estado.Validar() != nil {
+			errs = append(errs, fmt.Errorf("El ejemplar %d tiene el estado desconocido '%s'", ejemplar.ID, ejemplar.Estado))
+		}
+		if otro, ok := codigos[ejemplar.CodigoBarras]; ok && ejemplar.CodigoBarras != "" {
+			errs = append(errs, fmt.Errorf("Los ejemplares %d y %d comparten el código '%s'", otro, ejemplar.ID, ejemplar.CodigoBarras))
+		}
+		codigos[ejemplar.CodigoBarras] = ejemplar.ID
+	}
+
+	usuarios := make(map[int]Usuario)
+	emails := make(map[string]int)
+	for _, usuario := range b.usuarios.Listar() {
+		usuarios[usuario.ID] = usuario
+		if usuario.ID >= proximoID {
+			errs = append(errs, fmt.Errorf("El usuario %d tiene un ID mayor o igual al próximo (%d)", usuario.ID, proximoID))
+		}
+		if otro, ok := emails[usuario.Email]; ok && usuario.Email != "" {
+			errs = append(errs, fmt.Errorf("Los usuarios %d y %d comparten el email '%s'", otro, usuario.ID, usuario.Email))
+		}
+		emails[usuario.Email] = usuario.ID
+	}
+
+	activosPorEjemplar := make(map[int]int)
+	activosPorUsuario := make(map[int]int)
+	for _, prestamo := range b.prestamos.Listar() {
+		if prestamo.ID >= proximoID {
+			errs = append(errs, fmt.Errorf("El préstamo %d tiene un ID mayor o igual al próximo (%d)", prestamo.ID, proximoID))
+		}
+		if ejemplar, ok := ejemplares[prestamo.EjemplarID]; !ok {
+			errs = append(errs, fmt.Errorf("El préstamo %d apunta al ejemplar inexistente %d", prestamo.ID, prestamo.EjemplarID))
+		} else if ejemplar.LibroID != prestamo.LibroID {
+			errs = append(errs, fmt.Errorf("El préstamo %d figura con el libro %d pero su ejemplar es del libro %d",
+				prestamo.ID, prestamo.LibroID, ejemplar.LibroID))
+		}
+		if _, ok := usuarios[prestamo.UsuarioID]; !ok {
+			errs = append(errs, fmt.Errorf("El préstamo %d apunta al usuario inexistente %d", prestamo.ID, prestamo.UsuarioID))
+		}
+		if !prestamo.Devuelto {
+			activosPorEjemplar[prestamo.EjemplarID]++
+			activosPorUsuario[prestamo.UsuarioID]++
+		}
+	}
+
+	apartadosPorEjemplar := make(map[int]int)
+	enEsperaPorLibro := make(map[int]int)
+	for _, reserva := range b.reservas.Listar() {
+		if reserva.ID >= proximoID {
+			errs = append(errs, fmt.Errorf("La reserva %d tiene un ID mayor o igual al próximo (%d)", reserva.ID, proximoID))
+		}
+		if _, ok := libros[reserva.LibroID]; !ok {
+			errs = append(errs, fmt.Errorf("La reserva %d apunta al libro inexistente %d", reserva.ID, reserva.LibroID))
+		}
+		if _, ok := usuarios[reserva.UsuarioID]; !ok {
+			errs = append(errs, fmt.Errorf("La reserva %d apunta al usuario inexistente %d", reserva.ID, reserva.UsuarioID))
+		}
+		switch reserva.Estado {
+		case ReservaEnEspera:
+			enEsperaPorLibro[reserva.LibroID]++
+		case ReservaApartada:
+			apartadosPorEjemplar[reserva.EjemplarID]++
+			if ejemplar, ok := ejemplares[reserva.EjemplarID]; !ok {
+				errs = append(errs, fmt.Errorf("La reserva %d tiene apartado el ejemplar inexistente %d", reserva.ID, reserva.EjemplarID))
+			} else if ejemplar.LibroID != reserva.LibroID {
+				errs = append(errs, fmt.Errorf("La reserva %d es del libro %d pero tiene apartado un ejemplar del libro %d",
+					reserva.ID, reserva.LibroID, ejemplar.LibroID))
+			}
+		}
+	}
+
+	for _, id := range slices.Sorted(maps.Keys(ejemplares)) {
+		apartado := ejemplares[id].Estado == EjemplarApartado
+		switch apartados := apartadosPorEjemplar[id]; {
+		case apartados > 1:
+			errs = append(errs, fmt.Errorf("El ejemplar %d está apartado por %d reservas", id, apartados))
+		case apartado && apartados == 0:
+			errs = append(errs, fmt.Errorf("El ejemplar %d figura apartado pero ninguna reserva lo tiene", id))
+		case !apartado && apartados > 0:
+			errs = append(errs, fmt.Errorf("El ejemplar %d figura %s pero una reserva lo tiene apartado", id, ejemplares[id].Estado))
+		}
+		if ejemplares[id].Estado == EjemplarDisponible && enEsperaPorLibro[ejemplares[id].LibroID] > 0 {
+			errs = append(errs, fmt.Errorf("El ejemplar %d está disponible pero su libro tiene reservas en espera", id))
+		}
+	}
+
+	for _, id := range slices.Sorted(maps.Keys(ejemplares)) {
+		prestado := ejemplares[id].Estado == EjemplarPrestado
+		activos := activosPorEjemplar[id]
+		switch {
+		case activos > 1:
+			errs = append(errs, fmt.Errorf("El ejemplar %d tiene %d préstamos activos", id, activos))
+		case prestado && activos == 0:
+			errs = append(errs, fmt.Errorf("El ejemplar %d figura prestado pero no tiene préstamo activo", id))
+		case !prestado && activos > 0:
+			errs = append(errs, fmt.Errorf("El ejemplar %d figura %s pero tiene un préstamo activo", id, ejemplares[id].Estado))
+		}
+	}
+
+	enTransitoPorEjemplar := make(map[int]int)
+	for _, traslado := range b.listarTraslados() {
+		if traslado.ID >= proximoID {
+			errs = append(errs, fmt.Errorf("El traslado %d tiene un ID mayor o igual al próximo (%d)", traslado.ID, proximoID))
+		}
+		if _, ok := ejemplares[traslado.EjemplarID]; !ok {
+			errs = append(errs, fmt.Errorf("El traslado %d apunta al ejemplar inexistente %d", traslado.ID, traslado.EjemplarID))
+		}
+		if traslado.Estado == TrasladoEnTransito {
+			enTransitoPorEjemplar[traslado.EjemplarID]++
+		}
+	}
+	for _, id := range slices.Sorted(maps.Keys(ejemplares)) {
+		ejemplar := ejemplares[id]
+		enTransito := ejemplar.Estado == EjemplarEnTransito
+		switch traslados := enTransitoPorEjemplar[id]; {
+		case traslados > 1:
+			errs = append(errs, fmt.Errorf("El ejemplar %d tiene %d traslados en tránsito", id, traslados))
+		case enTransito && traslados == 0:
+			errs = append(errs, fmt.Errorf("El ejemplar %d figura en tránsito pero no tiene traslado en curso", id))
+		case !enTransito && traslados > 0:
+			errs = append(errs, fmt.Errorf("El ejemplar %d figura %s pero tiene un traslado en tránsito", id, ejemplar.Estado))
+		}
+		if len(b.sucursales) == 0 {
+			continue
+		}
+		for _, codigo := range []string{ejemplar.Sucursal, ejemplar.SucursalActual} {
+			if _, ok := b.sucursales[codigo]; !ok {
+				errs = append(errs, fmt.Errorf("El ejemplar %d apunta a la sucursal inexistente '%s'", id, codigo))
+			}
+		}
+	}
+
+	for _, id := range slices.Sorted(maps.Keys(usuarios)) {
+		if usuarios[id].Deuda < 0 {
+			errs = append(errs, fmt.Errorf("El usuario %d tiene deuda negativa (%.2f)", id, usuarios[id].Deuda))
+		}
+		if usuarios[id].PrestamosActivos != activosPorUsuario[id] {
+			errs = append(errs, fmt.Errorf("El usuario %d figura con %d préstamos activos pero tiene %d",
+				id, usuarios[id].PrestamosActivos, activosPorUsuario[id]))
+		}
+	}
+
+	return errors.Join(errs...)
+}
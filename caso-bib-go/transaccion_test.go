@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// prestamosQueFallan es un PrestamoRepo que falla al guardar mientras falla
+// sea true, para probar que la transacción revierte lo ya escrito
+type prestamosQueFallan struct {
+	PrestamoRepo
+	falla bool
+}
+
+var errDiscoLleno = errors.New("disco lleno")
+
+func (r *prestamosQueFallan) Guardar(prestamo Prestamo) error {
+	if r.falla {
+		return errDiscoLleno
+	}
+	return r.PrestamoRepo.Guardar(prestamo)
+}
+
+// estadoPrestamo es lo que un préstamo o devolución cambia junto
+type estadoPrestamo struct {
+	ejemplar  Ejemplar
+	usuario   Usuario
+	prestamos []Prestamo
+}
+
+func capturar(t *testing.T, b *Biblioteca, ejemplarID, usuarioID int) estadoPrestamo {
+	t.Helper()
+	return estadoPrestamo{
+		ejemplar:  *b.BuscarEjemplar(ejemplarID),
+		usuario:   *b.BuscarUsuario(usuarioID),
+		prestamos: b.ListarPrestamos(),
+	}
+}
+
+func TestPrestarYDevolverMantienenInvariantes(t *testing.T) {
+	b, _ := nuevaBibliotecaPrueba(t)
+	libro := agregarLibroPrueba(t, b, "Ficciones")
+	verificar(t, b)
+	ana := registrarUsuarioPrueba(t, b, "ana")
+	beto := registrarUsuarioPrueba(t, b, "beto")
+	verificar(t, b)
+
+	prestamo, err := b.PrestarLibro(Sistema, libro.ID, ana.ID)
+	if err != nil {
+		t.Fatalf("PrestarLibro: %v", err)
+	}
+	verificar(t, b)
+	if e := b.BuscarEjemplar(prestamo.EjemplarID); e.Estado != EjemplarPrestado {
+		t.Errorf("ejemplar en estado %q después de prestarlo", e.Estado)
+	}
+	if u := b.BuscarUsuario(ana.ID); u.PrestamosActivos != 1 {
+		t.Errorf("PrestamosActivos = %d, se esperaba 1", u.PrestamosActivos)
+	}
+
+	// Segundo préstamo del mismo ejemplar: falla y no cambia nada
+	antes := capturar(t, b, prestamo.EjemplarID, beto.ID)
+	if _, err := b.PrestarLibro(Sistema, libro.ID, beto.ID); !errors.Is(err, ErrNoPermitido) {
+		t.Fatalf("PrestarLibro de un libro prestado: err = %v, se esperaba ErrNoPermitido", err)
+	}
+	if _, err := b.PrestarEjemplar(Sistema, prestamo.EjemplarID, beto.ID); !errors.Is(err, ErrNoPermitido) {
+		t.Fatalf("PrestarEjemplar de un ejemplar prestado: err = %v, se esperaba ErrNoPermitido", err)
+	}
+	verificar(t, b)
+	compararEstado(t, antes, capturar(t, b, prestamo.EjemplarID, beto.ID))
+
+	devuelto, err := b.DevolverLibro(Sistema, libro.ID, "")
+	if err != nil {
+		t.Fatalf("DevolverLibro: %v", err)
+	}
+	verificar(t, b)
+	if !devuelto.Devuelto || devuelto.ID != prestamo.ID {
+		t.Errorf("DevolverLibro cerró %+v, se esperaba el préstamo %d", devuelto, prestamo.ID)
+	}
+	if e := b.BuscarEjemplar(prestamo.EjemplarID); e.Estado != EjemplarDisponible {
+		t.Errorf("ejemplar en estado %q después de devolverlo", e.Estado)
+	}
+	if u := b.BuscarUsuario(ana.ID); u.PrestamosActivos != 0 {
+		t.Errorf("PrestamosActivos = %d, se esperaba 0", u.PrestamosActivos)
+	}
+
+	// Devolver otra vez: falla y no cambia nada
+	antes = capturar(t, b, prestamo.EjemplarID, ana.ID)
+	if _, err := b.DevolverLibro(Sistema, libro.ID, ""); !errors.Is(err, ErrNoPermitido) {
+		t.Fatalf("DevolverLibro sin préstamo activo: err = %v, se esperaba ErrNoPermitido", err)
+	}
+	if _, err := b.DevolverEjemplar(Sistema, prestamo.EjemplarID, ""); !errors.Is(err, ErrNoPermitido) {
+		t.Fatalf("DevolverEjemplar sin préstamo activo: err = %v, se esperaba ErrNoPermitido", err)
+	}
+	verificar(t, b)
+	compararEstado(t, antes, capturar(t, b, prestamo.EjemplarID, ana.ID))
+}
+
+// Si un paso de la confirmación falla, lo ya escrito se deshace: el
+// ejemplar, el préstamo y el usuario quedan como estaban
+func TestTransaccionRevierteSiFallaUnPaso(t *testing.T) {
+	prestamos := &prestamosQueFallan{PrestamoRepo: NuevoPrestamoRepoMemoria()}
+	b := NuevaBibliotecaConRepos("Prueba", "", NuevoLibroRepoMemoria(), NuevoEjemplarRepoMemoria(),
+		NuevoUsuarioRepoMemoria(), prestamos, NuevoReservaRepoMemoria())
+	b.Reloj = NuevoRelojFijo(inicioPruebas)
+	b.ModoEstricto = true
+	libro := agregarLibroPrueba(t, b, "El Aleph")
+	ana := registrarUsuarioPrueba(t, b, "ana")
+	ejemplares, err := b.ListarEjemplares(libro.ID)
+	if err != nil {
+		t.Fatalf("ListarEjemplares: %v", err)
+	}
+	ejemplarID := ejemplares[0].ID
+
+	// Préstamo que falla al guardar el préstamo, después del ejemplar y el
+	// usuario
+	antes := capturar(t, b, ejemplarID, ana.ID)
+	prestamos.falla = true
+	if _, err := b.PrestarLibro(Sistema, libro.ID, ana.ID); !errors.Is(err, errDiscoLleno) {
+		t.Fatalf("PrestarLibro con el repositorio fallando: err = %v", err)
+	}
+	verificar(t, b)
+	compararEstado(t, antes, capturar(t, b, ejemplarID, ana.ID))
+
+	// Con el repositorio sano el mismo préstamo funciona
+	prestamos.falla = false
+	prestamo, err := b.PrestarLibro(Sistema, libro.ID, ana.ID)
+	if err != nil {
+		t.Fatalf("PrestarLibro: %v", err)
+	}
+	verificar(t, b)
+
+	// Devolución que falla: el préstamo sigue activo
+	antes = capturar(t, b, ejemplarID, ana.ID)
+	prestamos.falla = true
+	if _, err := b.DevolverEjemplar(Sistema, ejemplarID, ""); !errors.Is(err, errDiscoLleno) {
+		t.Fatalf("DevolverEjemplar con el repositorio fallando: err = %v", err)
+	}
+	verificar(t, b)
+	compararEstado(t, antes, capturar(t, b, ejemplarID, ana.ID))
+	if activos := prestamosActivos(b, libro.ID); activos != 1 {
+		t.Fatalf("%d préstamos activos después de la devolución fallida, se esperaba 1", activos)
+	}
+
+	prestamos.falla = false
+	if _, err := b.DevolverEjemplar(Sistema, ejemplarID, ""); err != nil {
+		t.Fatalf("DevolverEjemplar: %v", err)
+	}
+	verificar(t, b)
+	if p, _ := prestamos.PorID(prestamo.ID); !p.Devuelto {
+		t.Errorf("el préstamo %d sigue activo después de devolverlo", prestamo.ID)
+	}
+}
+
+// Si el diario falla después de agregar el evento, el evento se descarta
+// junto con la operación: el registro no muestra un préstamo que no ocurrió
+func TestTransaccionDescartaElEventoSiFallaElDiario(t *testing.T) {
+	b, _ := nuevaBibliotecaPrueba(t)
+	ruta := filepath.Join(t.TempDir(), "biblioteca.json")
+	if err := b.Guardar(ruta); err != nil {
+		t.Fatal(err)
+	}
+	libro := agregarLibroPrueba(t, b, "El Aleph")
+	ana := registrarUsuarioPrueba(t, b, "ana")
+	ejemplares, err := b.ListarEjemplares(libro.ID)
+	if err != nil {
+		t.Fatalf("ListarEjemplares: %v", err)
+	}
+	ejemplarID := ejemplares[0].ID
+	eventos := len(b.Eventos(FiltroEventos{}))
+	archivo, err := os.ReadFile(ruta + extensionEventos)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Con el archivo cerrado, toda escritura del diario falla
+	antes := capturar(t, b, ejemplarID, ana.ID)
+	b.diario.archivo.Close()
+	if _, err := b.PrestarLibro(Sistema, libro.ID, ana.ID); err == nil {
+		t.Fatal("PrestarLibro con el diario fallando no retornó error")
+	}
+	verificar(t, b)
+	compararEstado(t, antes, capturar(t, b, ejemplarID, ana.ID))
+
+	if n := len(b.Eventos(FiltroEventos{})); n != eventos {
+		t.Errorf("hay %d eventos después del préstamo revertido, se esperaban %d", n, eventos)
+	}
+	despues, err := os.ReadFile(ruta + extensionEventos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(despues, archivo) {
+		t.Errorf("el archivo de eventos cambió con el préstamo revertido:\n%s", despues[len(archivo):])
+	}
+	reconstruida, err := Reconstruir(b.Nombre, b.Direccion, b.Eventos(FiltroEventos{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if activos := prestamosActivos(reconstruida, libro.ID); activos != 0 {
+		t.Errorf("Reconstruir llega a %d préstamos activos, se esperaba 0", activos)
+	}
+}
+
+func compararEstado(t *testing.T, antes, despues estadoPrestamo) {
+	t.Helper()
+	if antes.ejemplar.Estado != despues.ejemplar.Estado {
+		t.Errorf("el ejemplar pasó de %q a %q", antes.ejemplar.Estado, despues.ejemplar.Estado)
+	}
+	if antes.usuario.PrestamosActivos != despues.usuario.PrestamosActivos || antes.usuario.Deuda != despues.usuario.Deuda {
+		t.Errorf("el usuario cambió: %+v, antes %+v", despues.usuario, antes.usuario)
+	}
+	if len(antes.prestamos) != len(despues.prestamos) {
+		t.Fatalf("hay %d préstamos, antes había %d", len(despues.prestamos), len(antes.prestamos))
+	}
+	for i := range antes.prestamos {
+		if antes.prestamos[i].ID != despues.prestamos[i].ID || antes.prestamos[i].Devuelto != despues.prestamos[i].Devuelto {
+			t.Errorf("el préstamo %d cambió: %+v, antes %+v", antes.prestamos[i].ID, despues.prestamos[i], antes.prestamos[i])
+		}
+	}
+}